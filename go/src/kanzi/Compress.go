@@ -0,0 +1,57 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi
+
+// One-shot compression and decompression of byte slices (append semantics).
+// The streams live in the kanzi/io package, which imports this package: the
+// functions are registered by kanzi/io when it is imported, so a program
+// calling them must import kanzi/io (a blank import is enough):
+//
+//	import _ "kanzi/io"
+//
+// The options are a *io.Options (or an io.Options), nil selects the
+// defaults. See io.Compress and io.Decompress.
+
+type oneShotFunc func(dst, src []byte, opts interface{}) ([]byte, error)
+
+var compressFunc, decompressFunc oneShotFunc
+
+// Called by the kanzi/io package
+func RegisterOneShot(compress, decompress func(dst, src []byte, opts interface{}) ([]byte, error)) {
+	compressFunc = compress
+	decompressFunc = decompress
+}
+
+// Compress src and append the compressed stream to dst. Return the extended
+// slice (dst is returned unchanged in case of error).
+func Compress(dst, src []byte, opts interface{}) ([]byte, error) {
+	if compressFunc == nil {
+		return dst, Errorf(ErrUnsupported, "No compressor registered (import kanzi/io)")
+	}
+
+	return compressFunc(dst, src, opts)
+}
+
+// Decompress the compressed stream in src and append the decompressed data
+// to dst. Return the extended slice (dst is returned unchanged in case of
+// error).
+func Decompress(dst, src []byte, opts interface{}) ([]byte, error) {
+	if decompressFunc == nil {
+		return dst, Errorf(ErrUnsupported, "No decompressor registered (import kanzi/io)")
+	}
+
+	return decompressFunc(dst, src, opts)
+}
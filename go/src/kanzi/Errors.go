@@ -0,0 +1,114 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors shared by all the packages. Every error returned (or
+// raised with panic) by the packages wraps exactly one of them, so the
+// callers can test the kind of failure with errors.Is (or Kind) instead of
+// matching the messages. The underlying cause (EG. an error returned by the
+// input stream) is wrapped too and can be extracted with errors.As.
+var (
+	// An argument is invalid (null buffer, size out of range, ...)
+	ErrInvalidParam = errors.New("invalid parameter")
+
+	// The data cannot be decoded: corrupted or truncated stream
+	ErrCorruptData = errors.New("corrupt data")
+
+	// Unknown codec or transform, or stream format version not supported
+	ErrUnsupported = errors.New("unsupported codec or format")
+
+	// The output buffer is too small to hold the result
+	ErrBufferTooSmall = errors.New("buffer too small")
+
+	// The stream has been closed
+	ErrClosed = errors.New("stream closed")
+
+	// The underlying stream (file, connection, ...) failed
+	ErrIO = errors.New("I/O failure")
+
+	// A decoding limit (output size, expansion ratio, block size) is exceeded
+	ErrLimitExceeded = errors.New("limit exceeded")
+
+	// Encrypted or signed data cannot be authenticated: wrong password or
+	// key, tampered data, missing signature
+	ErrAuthentication = errors.New("authentication failed")
+)
+
+var sentinelErrors = []error{ErrInvalidParam, ErrCorruptData, ErrUnsupported,
+	ErrBufferTooSmall, ErrClosed, ErrIO, ErrLimitExceeded, ErrAuthentication}
+
+// An error with a message that wraps a sentinel error (its kind). The
+// message is not modified by the kind.
+type Error struct {
+	kind error
+	err  error
+}
+
+// Return an error of the given kind with a message formatted like
+// fmt.Errorf: a %w verb wraps the cause.
+func Errorf(kind error, format string, args ...interface{}) error {
+	return &Error{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// Implement error interface
+func (this *Error) Error() string {
+	return this.err.Error()
+}
+
+// Return the kind and the formatted error (which wraps the cause, if any)
+func (this *Error) Unwrap() []error {
+	return []error{this.kind, this.err}
+}
+
+// Return the sentinel error wrapped by err or nil if there is none. If err
+// wraps several sentinel errors (EG. a corrupt block causing a codec to fail
+// on a small buffer), the outermost one is returned.
+func Kind(err error) error {
+	for err != nil {
+		for _, kind := range sentinelErrors {
+			if err == kind {
+				return kind
+			}
+		}
+
+		switch e := err.(type) {
+		case *Error:
+			return e.kind
+
+		case interface{ Unwrap() error }:
+			err = e.Unwrap()
+
+		case interface{ Unwrap() []error }:
+			for _, wrapped := range e.Unwrap() {
+				if kind := Kind(wrapped); kind != nil {
+					return kind
+				}
+			}
+
+			return nil
+
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
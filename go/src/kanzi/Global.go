@@ -15,10 +15,6 @@ limitations under the License.
 
 package kanzi
 
-import (
-	"errors"
-)
-
 const (
 	INFINITE_VALUE       = 0
 	PI_1024              = 3217
@@ -114,7 +110,7 @@ var COS_1024 = [256]int{
 // Return 1024 * 10 * log10(x)
 func Ten_log10(x int) (int, error) {
 	if x <= 0 {
-		return x, errors.New("Cannot calculate log of a negative or null value")
+		return x, Errorf(ErrInvalidParam, "Cannot calculate log of a negative or null value")
 	}
 
 	if x < 100 {
@@ -171,7 +167,7 @@ func Cos(rad1024 int) int {
 // Max error is around 0.1%
 func Log2(x int) (int, error) {
 	if x <= 0 {
-		return x, errors.New("Cannot calculate log of a negative or null value")
+		return x, Errorf(ErrInvalidParam, "Cannot calculate log of a negative or null value")
 	}
 
 	if x < 512 {
@@ -319,7 +315,7 @@ var SQRT = [...]int{
 // Return 1024*sqrt(x) with a precision higher than 0.1%
 func sqrt(x int) (int, error) {
 	if x < 0 {
-		return x, errors.New("Cannot calculate sqrt of a negative value")
+		return x, Errorf(ErrInvalidParam, "Cannot calculate sqrt of a negative value")
 	}
 
 	if x <= 1 {
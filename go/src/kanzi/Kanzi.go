@@ -137,6 +137,13 @@ type Sizeable interface {
 	SetSize(sz uint) bool
 }
 
+// A Resettable object (EG. an entropy codec or a predictor) can be reused to
+// process a new block without new memory allocations. After a call to Reset,
+// it behaves like a newly created instance.
+type Resettable interface {
+	Reset()
+}
+
 func SameIntSlices(slice1, slice2 []int, checkLengths bool) bool {
 	if slice2 == nil {
 		return slice1 == nil
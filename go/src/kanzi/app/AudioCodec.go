@@ -0,0 +1,235 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"kanzi/audio"
+	"kanzi/io"
+	"os"
+	"time"
+)
+
+// Encode PCM WAV files with the lossless audio codec and decode them back
+// to WAV files.
+
+func printHelp() {
+	printOut("AudioCodec encode -input=<audio.wav> -output=<fileName> [-block=<samples>] [-order=<0..32>] [-overwrite]", true)
+	printOut("  encode a PCM WAV file (the output is decoded and checked)", true)
+	printOut("AudioCodec decode -input=<fileName> -output=<audio.wav> [-overwrite]", true)
+	printOut("  decode an encoded file to a PCM WAV file", true)
+	printOut("", true)
+	printOut("EG. go run AudioCodec.go encode -input=music.wav -output=music.kau", true)
+	printOut("    go run AudioCodec.go decode -input=music.kau -output=music2.wav", true)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printHelp()
+		os.Exit(io.ERR_MISSING_FILENAME)
+	}
+
+	var code int
+
+	switch os.Args[1] {
+	case "encode":
+		code = encode(os.Args[2:])
+
+	case "decode":
+		code = decode(os.Args[2:])
+
+	case "-help", "--help", "help":
+		printHelp()
+
+	default:
+		fmt.Printf("Unknown command: %v\n", os.Args[1])
+		printHelp()
+		code = io.ERR_MISSING_FILENAME
+	}
+
+	os.Exit(code)
+}
+
+func encode(args []string) int {
+	flags := flag.NewFlagSet("encode", flag.ExitOnError)
+	var inputName = flags.String("input", "", "mandatory name of the PCM WAV file")
+	var outputName = flags.String("output", "", "mandatory name of the encoded file")
+	var blockSize = flags.Int("block", audio.DEFAULT_AUDIO_BLOCK_SIZE, "number of samples per channel in a frame")
+	var order = flags.Int("order", audio.DEFAULT_LPC_ORDER, "maximum LPC order (0 for the fixed predictors only)")
+	var overwrite = flags.Bool("overwrite", false, "overwrite the output file if it already exists")
+	flags.Parse(args)
+
+	if len(*inputName) == 0 || len(*outputName) == 0 {
+		fmt.Printf("Missing input or output file name, exiting ...\n")
+		return io.ERR_MISSING_FILENAME
+	}
+
+	encoder, err := audio.NewAudioEncoder(*blockSize, *order)
+
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return io.ERR_INVALID_CODEC
+	}
+
+	file, err := os.Open(*inputName)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file '%v': %v\n", *inputName, err)
+		return io.ERR_OPEN_FILE
+	}
+
+	input, err := audio.ReadWAV(file)
+	file.Close()
+
+	if err != nil {
+		fmt.Printf("Cannot read audio '%v': %v\n", *inputName, err)
+		return io.ERR_INVALID_FILE
+	}
+
+	before := time.Now()
+	data, err := encoder.Encode(input)
+
+	if err != nil {
+		fmt.Printf("Cannot encode audio: %v\n", err)
+		return io.ERR_PROCESS_BLOCK
+	}
+
+	delta := time.Since(before)
+
+	// Check the round trip before writing the output
+	decoder, _ := audio.NewAudioDecoder()
+	decoded, err := decoder.Decode(data)
+
+	if err != nil || sameSamples(input, decoded) == false {
+		fmt.Printf("Cannot decode audio: %v\n", err)
+		return io.ERR_PROCESS_BLOCK
+	}
+
+	if code := writeFile(*outputName, data, *overwrite); code != 0 {
+		return code
+	}
+
+	rawSize := input.Length() * input.Channels() * input.BitsPerSample / 8
+	fmt.Printf("Encoded %d samples (%d channel(s), %d bits, %d Hz) in %d ms\n", input.Length(),
+		input.Channels(), input.BitsPerSample, input.SampleRate, delta.Milliseconds())
+	fmt.Printf("Output size: %d bytes (%.2f%% of %d bytes)\n", len(data),
+		100*float64(len(data))/float64(max(rawSize, 1)), rawSize)
+	return 0
+}
+
+func decode(args []string) int {
+	flags := flag.NewFlagSet("decode", flag.ExitOnError)
+	var inputName = flags.String("input", "", "mandatory name of the encoded file")
+	var outputName = flags.String("output", "", "mandatory name of the PCM WAV file")
+	var overwrite = flags.Bool("overwrite", false, "overwrite the output file if it already exists")
+	flags.Parse(args)
+
+	if len(*inputName) == 0 || len(*outputName) == 0 {
+		fmt.Printf("Missing input or output file name, exiting ...\n")
+		return io.ERR_MISSING_FILENAME
+	}
+
+	data, err := os.ReadFile(*inputName)
+
+	if err != nil {
+		fmt.Printf("Cannot read input file '%v': %v\n", *inputName, err)
+		return io.ERR_OPEN_FILE
+	}
+
+	before := time.Now()
+	decoder, _ := audio.NewAudioDecoder()
+	decoded, err := decoder.Decode(data)
+
+	if err != nil {
+		fmt.Printf("Cannot decode audio: %v\n", err)
+		return io.ERR_INVALID_FILE
+	}
+
+	delta := time.Since(before)
+	var buf bytes.Buffer
+
+	if err = audio.WriteWAV(&buf, decoded); err != nil {
+		fmt.Printf("Cannot write audio: %v\n", err)
+		return io.ERR_PROCESS_BLOCK
+	}
+
+	if code := writeFile(*outputName, buf.Bytes(), *overwrite); code != 0 {
+		return code
+	}
+
+	fmt.Printf("Decoded %d samples (%d channel(s), %d bits, %d Hz) from %d bytes in %d ms\n",
+		decoded.Length(), decoded.Channels(), decoded.BitsPerSample, decoded.SampleRate, len(data),
+		delta.Milliseconds())
+	return 0
+}
+
+func sameSamples(a, b *audio.Audio) bool {
+	if a.Channels() != b.Channels() || a.Length() != b.Length() {
+		return false
+	}
+
+	for c := range a.Samples {
+		for i, v := range a.Samples[c] {
+			if b.Samples[c][i] != v {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func writeFile(fileName string, data []byte, overwrite bool) int {
+	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	if overwrite == false {
+		mode |= os.O_EXCL
+	}
+
+	file, err := os.OpenFile(fileName, mode, 0644)
+
+	if err != nil {
+		if os.IsExist(err) {
+			fmt.Printf("The output file '%v' exists and the 'overwrite' command ", fileName)
+			fmt.Println("line option has not been provided")
+			return io.ERR_OVERWRITE_FILE
+		}
+
+		fmt.Printf("Cannot open output file '%v' for writing: %v\n", fileName, err)
+		return io.ERR_CREATE_FILE
+	}
+
+	_, err = file.Write(data)
+
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+
+	if err != nil {
+		fmt.Printf("Cannot write output file '%v': %v\n", fileName, err)
+		return io.ERR_WRITE_FILE
+	}
+
+	return 0
+}
+
+func printOut(msg string, print bool) {
+	if print == true {
+		fmt.Println(msg)
+	}
+}
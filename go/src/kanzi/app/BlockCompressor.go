@@ -17,11 +17,13 @@ package main
 
 import (
 	"container/list"
+	"crypto/ed25519"
 	"flag"
 	"fmt"
 	"kanzi"
 	"kanzi/io"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -38,12 +40,20 @@ type BlockCompressor struct {
 	silent       bool
 	overwrite    bool
 	checksum     bool
+	checksumType byte
 	inputName    string
 	outputName   string
 	entropyCodec string
 	transform    string
 	blockSize    uint
 	jobs         uint
+	password     []byte
+	signingKey   ed25519.PrivateKey
+	redundancy   uint
+	syncMarkers  bool
+	noName       bool
+	contentType  string
+	tags         tagFlags
 	listeners    *list.List
 }
 
@@ -59,9 +69,19 @@ func NewBlockCompressor() (*BlockCompressor, error) {
 	var outputName = flag.String("output", "", "optional name of the output file (defaults to <input.knz>), or 'none' for dry-run")
 	var blockSize = flag.String("block", "1048576", "size of the input blocks, multiple of 8, max 512 MB (depends on transform), min 1KB, default 1MB")
 	var entropy = flag.String("entropy", "Huffman", "entropy codec to use [None|Huffman*|ANS|Range|PAQ|FPAQ|CM]")
-	var function = flag.String("transform", "BWT+MTF", "transform to use [None|BWT|BWTS|Snappy|LZ4|RLT]")
+	var function = flag.String("transform", "BWT+MTF", "transform to use [None|BWT*|BWTS|Snappy|LZ4|RLT|Predict|Shuffle|Exe]")
 	var cksum = flag.Bool("checksum", false, "enable block checksum")
+	var cksumType = flag.String("checksum-type", "", "block checksum algorithm [XXHASH32*|XXHASH64|CRC32C|SHA256], implies 'checksum'")
 	var tasks = flag.Int("jobs", 1, "number of concurrent jobs")
+	var password = flag.String("password", "", "encrypt the blocks with a key derived from the password")
+	var keyFile = flag.String("keyfile", "", "encrypt the blocks with a key derived from the content of the file")
+	var signKey = flag.String("sign-key", "", "sign the output with the private key in the file")
+	var redundancy = flag.Uint("redundancy", 0, "add Reed-Solomon parity (in percent of the data) to repair damaged files")
+	var syncMarkers = flag.Bool("sync-markers", false, "write a sync marker before each block (see BlockDecompressor -salvage)")
+	var noName = flag.Bool("no-name", false, "do not store the name and the modification time of the input file")
+	var contentType = flag.String("content-type", "", "store the content type (EG. a MIME type) in the header")
+	tags := make(tagFlags)
+	flag.Var(tags, "tag", "store a user tag in the header (key=value, repeatable)")
 
 	// Parse
 	flag.Parse()
@@ -75,11 +95,30 @@ func NewBlockCompressor() (*BlockCompressor, error) {
 		printOut("-output=<outputName> : optional name of the output file (defaults to <input.knz>) or 'none' for dry-run", true)
 		printOut("-block=<size>        : size of the input blocks, multiple of 8, max 512 MB (depends on transform), min 1KB, default 1MB", true)
 		printOut("-entropy=<codec>     : entropy codec to use [None|Huffman*|ANS|Range|PAQ|FPAQ|CM]", true)
-		printOut("-transform=<codec>   : transform to use [None|BWT*|BWTS|Snappy|LZ4|RLT]", true)
+		printOut("-transform=<codec>   : transform to use [None|BWT*|BWTS|Snappy|LZ4|RLT|Predict|Shuffle|Exe]", true)
+		printOut("                       Predict: lossless pixel prediction for raw images (layout guessed)", true)
+		printOut("                       or Predict+W,S,C for width, stride in bytes (0 if no padding), channels", true)
+		printOut("                       Shuffle: byte shuffle and delta filter for binary records (width guessed)", true)
+		printOut("                       Exe: absolute branch targets in x86 and ARM64 code (code blocks detected)", true)
+		printOut("                       Shuffle and Exe can be followed by LZ4, Snappy, RLT, BWT+MTF or BWTS+MTF", true)
+		printOut("                       EG: Shuffle+LZ4 or Exe+BWT+MTF", true)
 		printOut("                       for BWT(S), an optional GST can be provided: [MTF|RANK|TIMESTAMP]", true)
 		printOut("                       EG: BWT+RANK or BWTS+MTF (default is BWT+MTF)", true)
 		printOut("-checksum            : enable block checksum", true)
+		printOut("-checksum-type=<type>: block checksum algorithm [XXHASH32*|XXHASH64|CRC32C|SHA256], implies 'checksum'", true)
+		printOut("                       CRC32C is the fastest (hardware), SHA256 detects deliberate modifications", true)
 		printOut("-jobs=<jobs>         : number of concurrent jobs", true)
+		printOut("-password=<password> : encrypt the blocks (AES-256-GCM) with a key derived from the password", true)
+		printOut("-keyfile=<fileName>  : encrypt the blocks with a key derived from the content of the file", true)
+		printOut("                       (safer than -password: the command line is visible to other users)", true)
+		printOut("-sign-key=<fileName> : sign the output with the private key in the file (see BlockSigner)", true)
+		printOut("-redundancy=<pct>    : add Reed-Solomon parity (1 to 100 percent of the data) so that damaged", true)
+		printOut("                       files can be decoded or repaired (see BlockDecompressor -repair)", true)
+		printOut("-sync-markers        : write a sync marker before each block, so that the blocks following", true)
+		printOut("                       a damaged block can be recovered (see BlockDecompressor -salvage)", true)
+		printOut("-no-name             : do not store the name and the modification time of the input file", true)
+		printOut("-content-type=<type> : store the content type (EG. a MIME type) in the header", true)
+		printOut("-tag=<key=value>     : store a user tag in the header (repeatable)", true)
 		printOut("", true)
 		printOut("EG. go run BlockCompressor -input=foo.txt -output=foo.knz -overwrite -transform=BWT+MTF -block=4m -entropy=FPAQ -verbose -jobs=4", true)
 		os.Exit(0)
@@ -128,7 +167,50 @@ func NewBlockCompressor() (*BlockCompressor, error) {
 	this.entropyCodec = strings.ToUpper(*entropy)
 	this.transform = strings.ToUpper(*function)
 	this.checksum = *cksum
+
+	if this.checksum == true {
+		this.checksumType = io.CHECKSUM_XXHASH32
+	}
+
+	if len(*cksumType) > 0 {
+		if this.checksumType, err = io.GetChecksumType(*cksumType); err != nil {
+			fmt.Printf("Invalid checksum type provided on command line: %v\n", *cksumType)
+			os.Exit(io.ERR_INVALID_CODEC)
+		}
+
+		this.checksum = this.checksumType != io.CHECKSUM_NONE
+	}
+
 	this.jobs = uint(*tasks)
+
+	if this.password, err = readPassword(*password, *keyFile); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(io.ERR_MISSING_PASSWORD)
+	}
+
+	if len(*signKey) > 0 {
+		key, err := os.ReadFile(*signKey)
+
+		if err == nil {
+			this.signingKey, err = io.ParsePrivateKey(key)
+		}
+
+		if err != nil {
+			fmt.Printf("Cannot read private key file '%v': %v\n", *signKey, err)
+			os.Exit(io.ERR_OPEN_FILE)
+		}
+	}
+
+	if *redundancy > io.MAX_REDUNDANCY {
+		fmt.Printf("Invalid redundancy provided on command line: %v (must be at most %v)\n", *redundancy, io.MAX_REDUNDANCY)
+		os.Exit(io.ERR_INVALID_CODEC)
+	}
+
+	this.redundancy = *redundancy
+	this.syncMarkers = *syncMarkers
+	this.noName = *noName
+	this.contentType = *contentType
+	this.tags = tags
 	this.listeners = list.New()
 
 	if this.verbose == true {
@@ -139,6 +221,52 @@ func NewBlockCompressor() (*BlockCompressor, error) {
 	return this, nil
 }
 
+// User tags provided on the command line (-tag=key=value, repeatable)
+type tagFlags map[string]string
+
+func (this tagFlags) String() string {
+	return fmt.Sprintf("%v", map[string]string(this))
+}
+
+func (this tagFlags) Set(value string) error {
+	idx := strings.IndexByte(value, '=')
+
+	if idx <= 0 {
+		return fmt.Errorf("invalid tag %q: key=value expected", value)
+	}
+
+	this[value[0:idx]] = value[idx+1:]
+	return nil
+}
+
+// Return the password provided on the command line or read from the key file
+// (nil if none)
+func readPassword(password, keyFile string) ([]byte, error) {
+	if len(password) > 0 && len(keyFile) > 0 {
+		return nil, fmt.Errorf("Both 'password' and 'keyfile' options were provided")
+	}
+
+	if len(keyFile) > 0 {
+		key, err := os.ReadFile(keyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read key file '%v': %v", keyFile, err)
+		}
+
+		if len(key) == 0 {
+			return nil, fmt.Errorf("The key file '%v' is empty", keyFile)
+		}
+
+		return key, nil
+	}
+
+	if len(password) > 0 {
+		return []byte(password), nil
+	}
+
+	return nil, nil
+}
+
 func (this *BlockCompressor) AddListener(bl io.BlockListener) bool {
 	if bl == nil {
 		return false
@@ -195,7 +323,15 @@ func (this *BlockCompressor) call() (int, uint64) {
 	printOut(msg, this.verbose)
 	msg = fmt.Sprintf("Overwrite set to %t", this.overwrite)
 	printOut(msg, this.verbose)
-	msg = fmt.Sprintf("Checksum set to %t", this.checksum)
+	msg = fmt.Sprintf("Checksum set to %s", io.GetChecksumName(this.checksumType))
+	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Encryption set to %t", this.password != nil)
+	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Signature set to %t", this.signingKey != nil)
+	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Redundancy set to %d%%", this.redundancy)
+	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Sync markers set to %t", this.syncMarkers)
 	printOut(msg, this.verbose)
 	w1 := "no"
 
@@ -279,6 +415,42 @@ func (this *BlockCompressor) call() (int, uint64) {
 	}
 
 	defer cos.Close()
+
+	if this.checksum == true {
+		if err := cos.SetChecksumType(this.checksumType); err != nil {
+			fmt.Printf("Cannot set the checksum type: %v\n", err)
+			return io.ERR_CREATE_COMPRESSOR, written
+		}
+	}
+
+	if this.password != nil {
+		if err := cos.SetPassword(this.password); err != nil {
+			fmt.Printf("Cannot set the password: %v\n", err)
+			return io.ERR_CREATE_COMPRESSOR, written
+		}
+	}
+
+	if this.signingKey != nil {
+		if err := cos.SetSigningKey(this.signingKey); err != nil {
+			fmt.Printf("Cannot set the signing key: %v\n", err)
+			return io.ERR_CREATE_COMPRESSOR, written
+		}
+	}
+
+	if this.redundancy > 0 {
+		if err := cos.SetRedundancy(this.redundancy); err != nil {
+			fmt.Printf("Cannot set the redundancy: %v\n", err)
+			return io.ERR_CREATE_COMPRESSOR, written
+		}
+	}
+
+	if this.syncMarkers == true {
+		if err := cos.SetSyncMarkers(true); err != nil {
+			fmt.Printf("Cannot enable the sync markers: %v\n", err)
+			return io.ERR_CREATE_COMPRESSOR, written
+		}
+	}
+
 	input, err := os.Open(this.inputName)
 
 	if err != nil {
@@ -288,6 +460,23 @@ func (this *BlockCompressor) call() (int, uint64) {
 
 	defer input.Close()
 
+	// Store the name and the modification time of the input file (like gzip)
+	metadata := &io.Metadata{ContentType: this.contentType, Tags: this.tags}
+
+	if this.noName == false {
+		if info, err := input.Stat(); err == nil {
+			metadata.Name = filepath.Base(this.inputName)
+			metadata.ModTime = info.ModTime()
+		}
+	}
+
+	if len(metadata.Name) > 0 || len(metadata.ContentType) > 0 || len(metadata.Tags) > 0 {
+		if err := cos.SetMetadata(metadata); err != nil {
+			fmt.Printf("Cannot set the metadata: %v\n", err)
+			return io.ERR_CREATE_COMPRESSOR, written
+		}
+	}
+
 	for e := this.listeners.Front(); e != nil; e = e.Next() {
 		cos.AddListener(e.Value.(io.BlockListener))
 	}
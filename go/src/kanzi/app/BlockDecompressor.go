@@ -16,13 +16,17 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
 	"container/list"
+	"crypto/ed25519"
 	"flag"
 	"fmt"
 	"kanzi"
 	"kanzi/io"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -32,13 +36,20 @@ const (
 )
 
 type BlockDecompressor struct {
-	verbose    bool
-	silent     bool
-	overwrite  bool
-	inputName  string
-	outputName string
-	jobs       uint
-	listeners  *list.List
+	verbose       bool
+	silent        bool
+	overwrite     bool
+	inputName     string
+	outputName    string
+	jobs          uint
+	limits        io.DecodingLimits
+	password      []byte
+	verifyKey     ed25519.PublicKey
+	repair        bool
+	salvage       int
+	noName        bool
+	defaultOutput bool // no output file name provided: the original name can be used
+	listeners     *list.List
 }
 
 func NewBlockDecompressor() (*BlockDecompressor, error) {
@@ -52,6 +63,16 @@ func NewBlockDecompressor() (*BlockDecompressor, error) {
 	var inputName = flag.String("input", "", "mandatory name of the input file to decode")
 	var outputName = flag.String("output", "", "optional name of the output file or 'none' for dry-run")
 	var tasks = flag.Int("jobs", 1, "number of concurrent jobs")
+	var maxOutput = flag.String("max-output", "0", "maximum size of the decoded data (K, M or G suffix), 0 means no limit")
+	var maxRatio = flag.Uint("max-ratio", 0, "maximum ratio of decoded size to compressed size, 0 means no limit")
+	var maxBlock = flag.String("max-block", "0", "maximum block size declared in the stream (K, M or G suffix), 0 means no limit")
+	var password = flag.String("password", "", "password of an encrypted stream")
+	var keyFile = flag.String("keyfile", "", "name of the file containing the key of an encrypted stream")
+	var verifyKey = flag.String("verify-key", "", "reject the input if it is not signed with the private key matching the public key in the file")
+	var repair = flag.Bool("repair", false, "rebuild the damaged parts of the input file (see BlockCompressor -redundancy), no decoding")
+	var salvage = flag.Bool("salvage", false, "skip the damaged blocks instead of stopping, report the lost byte ranges")
+	var zeroFill = flag.Bool("zero-fill", false, "replace the damaged blocks with zeros, implies 'salvage'")
+	var noName = flag.Bool("no-name", false, "do not restore the original name and modification time stored in the input file")
 
 	// Parse
 	flag.Parse()
@@ -64,6 +85,22 @@ func NewBlockDecompressor() (*BlockDecompressor, error) {
 		printOut("-input=<inputName>   : mandatory name of the input file to decode", true)
 		printOut("-output=<outputName> : optional name of the output file or 'none' for dry-run", true)
 		printOut("-jobs=<jobs>         : number of concurrent jobs", true)
+		printOut("-max-output=<size>   : maximum size of the decoded data (K, M or G suffix), 0 means no limit", true)
+		printOut("-max-ratio=<ratio>   : maximum ratio of decoded size to compressed size, 0 means no limit", true)
+		printOut("-max-block=<size>    : maximum block size declared in the stream (K, M or G suffix), 0 means no limit", true)
+		printOut("-password=<password> : password of an encrypted stream", true)
+		printOut("-keyfile=<fileName>  : name of the file containing the key of an encrypted stream", true)
+		printOut("-verify-key=<file>   : reject the input if it is not signed with the private key matching", true)
+		printOut("                       the public key in the file (see BlockSigner)", true)
+		printOut("-repair              : rebuild the damaged parts of the input file (see BlockCompressor -redundancy)", true)
+		printOut("                       and write the repaired file (defaults to <input.repaired>), no decoding", true)
+		printOut("-salvage             : skip the damaged blocks instead of stopping and report the lost byte", true)
+		printOut("                       ranges (see BlockCompressor -sync-markers)", true)
+		printOut("-zero-fill           : replace the damaged blocks with zeros, implies 'salvage'", true)
+		printOut("-no-name             : do not restore the original name (used when no output file name is", true)
+		printOut("                       provided) and modification time stored in the input file", true)
+		printOut("", true)
+		printOut("Use the limits to decode untrusted data (EG. decompression bombs)", true)
 		printOut("", true)
 		printOut("EG. go run BlockDecompressor -input=foo.knz -overwrite -verbose -jobs=2", true)
 		os.Exit(0)
@@ -83,6 +120,12 @@ func NewBlockDecompressor() (*BlockDecompressor, error) {
 		printOut("Warning: the input file name does not end with the .KNZ extension", true)
 	}
 
+	this.defaultOutput = len(*outputName) == 0 && *repair == false
+
+	if len(*outputName) == 0 && *repair == true {
+		*outputName = *inputName + ".repaired"
+	}
+
 	if len(*outputName) == 0 {
 		if strings.HasSuffix(*inputName, ".knz") == false {
 			*outputName = *inputName + ".tmp"
@@ -96,7 +139,52 @@ func NewBlockDecompressor() (*BlockDecompressor, error) {
 	this.inputName = *inputName
 	this.outputName = *outputName
 	this.overwrite = *overwrite
+	this.repair = *repair
+	this.noName = *noName
+	this.salvage = io.SALVAGE_OFF
+
+	if *zeroFill == true {
+		this.salvage = io.SALVAGE_ZERO_FILL
+	} else if *salvage == true {
+		this.salvage = io.SALVAGE_SKIP
+	}
+
 	this.jobs = uint(*tasks)
+	this.limits.MaxRatio = *maxRatio
+	var err error
+
+	if this.limits.MaxOutputSize, err = parseSize(*maxOutput); err != nil {
+		fmt.Printf("Invalid maximum output size provided on command line: %v\n", *maxOutput)
+		os.Exit(io.ERR_OUTPUT_LIMIT)
+	}
+
+	maxBlockSize, err := parseSize(*maxBlock)
+
+	if err != nil || maxBlockSize > io.MAX_BITSTREAM_BLOCK_SIZE {
+		fmt.Printf("Invalid maximum block size provided on command line: %v\n", *maxBlock)
+		os.Exit(io.ERR_BLOCK_SIZE)
+	}
+
+	this.limits.MaxBlockSize = uint(maxBlockSize)
+
+	if this.password, err = readPassword(*password, *keyFile); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(io.ERR_MISSING_PASSWORD)
+	}
+
+	if len(*verifyKey) > 0 {
+		key, err := os.ReadFile(*verifyKey)
+
+		if err == nil {
+			this.verifyKey, err = io.ParsePublicKey(key)
+		}
+
+		if err != nil {
+			fmt.Printf("Cannot read public key file '%v': %v\n", *verifyKey, err)
+			os.Exit(io.ERR_OPEN_FILE)
+		}
+	}
+
 	this.listeners = list.New()
 
 	if this.verbose == true {
@@ -107,6 +195,55 @@ func NewBlockDecompressor() (*BlockDecompressor, error) {
 	return this, nil
 }
 
+// Return the password provided on the command line or read from the key file
+// (nil if none)
+func readPassword(password, keyFile string) ([]byte, error) {
+	if len(password) > 0 && len(keyFile) > 0 {
+		return nil, fmt.Errorf("Both 'password' and 'keyfile' options were provided")
+	}
+
+	if len(keyFile) > 0 {
+		key, err := os.ReadFile(keyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read key file '%v': %v", keyFile, err)
+		}
+
+		if len(key) == 0 {
+			return nil, fmt.Errorf("The key file '%v' is empty", keyFile)
+		}
+
+		return key, nil
+	}
+
+	if len(password) > 0 {
+		return []byte(password), nil
+	}
+
+	return nil, nil
+}
+
+// Parse a size with an optional K, M or G suffix
+func parseSize(str string) (uint64, error) {
+	str = strings.ToUpper(str)
+	scale := uint64(1)
+
+	if strings.HasSuffix(str, "K") {
+		scale = 1024
+	} else if strings.HasSuffix(str, "M") {
+		scale = 1024 * 1024
+	} else if strings.HasSuffix(str, "G") {
+		scale = 1024 * 1024 * 1024
+	}
+
+	if scale > 1 {
+		str = str[0 : len(str)-1]
+	}
+
+	size, err := strconv.ParseUint(str, 10, 64)
+	return scale * size, err
+}
+
 func (this *BlockDecompressor) AddListener(bl io.BlockListener) bool {
 	if bl == nil {
 		return false
@@ -169,35 +306,25 @@ func (this *BlockDecompressor) call() (int, uint64) {
 
 	msg = fmt.Sprintf("Using %d job%s", this.jobs, prefix)
 	printOut(msg, this.verbose)
-	var output kanzi.OutputStream
-
-	if strings.ToUpper(this.outputName) == "NONE" {
-		output, _ = io.NewNullOutputStream()
-	} else {
-		var err error
-		output, err = os.OpenFile(this.outputName, os.O_RDWR, 666)
+	msg = fmt.Sprintf("Limits set to %d bytes (output), %d (ratio), %d bytes (block size)",
+		this.limits.MaxOutputSize, this.limits.MaxRatio, this.limits.MaxBlockSize)
+	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Signature check set to %t", this.verifyKey != nil)
+	printOut(msg, this.verbose)
+	msg = fmt.Sprintf("Salvage set to %t (zero fill: %t)", this.salvage != io.SALVAGE_OFF, this.salvage == io.SALVAGE_ZERO_FILL)
+	printOut(msg, this.verbose)
 
-		if err == nil {
-			// File exists
-			if this.overwrite == false {
-				fmt.Printf("The output file '%v' exists and the 'overwrite' command ", this.outputName)
-				fmt.Println("line option has not been provided")
-				output.Close()
-				return io.ERR_OVERWRITE_FILE, 0
-			}
-		} else {
-			// File does not exist, create
-			output, err = os.Create(this.outputName)
+	if this.repair == true {
+		return this.repairInput(), 0
+	}
 
-			if err != nil {
-				fmt.Printf("Cannot open output file '%v' for writing: %v\n", this.outputName, err)
-				return io.ERR_CREATE_FILE, 0
-			}
+	if this.verifyKey != nil {
+		// Check the signature before creating the output file
+		if code := this.verifySignature(); code != 0 {
+			return code, 0
 		}
 	}
 
-	defer output.Close()
-
 	// Decode
 	read := uint64(0)
 	printOut("Decoding ...", !this.silent)
@@ -234,10 +361,86 @@ func (this *BlockDecompressor) call() (int, uint64) {
 		}
 	}
 
+	cis.SetLimits(this.limits)
+
+	if err := cis.SetSalvageMode(this.salvage); err != nil {
+		fmt.Printf("Cannot set the salvage mode: %v\n", err)
+		return io.ERR_CREATE_DECOMPRESSOR, read
+	}
+
+	if this.password != nil {
+		if err := cis.SetPassword(this.password); err != nil {
+			fmt.Printf("Cannot set the password: %v\n", err)
+			return io.ERR_CREATE_DECOMPRESSOR, read
+		}
+	}
+
+	if this.verifyKey != nil {
+		// Check the signature again (the input may have been modified)
+		if err := cis.SetVerifyKey(this.verifyKey); err != nil {
+			fmt.Printf("Cannot set the public key: %v\n", err)
+			return io.ERR_CREATE_DECOMPRESSOR, read
+		}
+	}
+
 	for e := this.listeners.Front(); e != nil; e = e.Next() {
 		cis.AddListener(e.Value.(io.BlockListener))
 	}
 
+	// Read the header before creating the output file: the original name
+	// may be stored in it
+	if err := cis.ReadHeader(); err != nil {
+		if ioerr, isIOErr := err.(*io.IOError); isIOErr == true {
+			fmt.Printf("%s\n", ioerr.Message())
+			return ioerr.ErrorCode(), read
+		}
+
+		fmt.Printf("Cannot read the header: %v\n", err)
+		return io.ERR_READ_FILE, read
+	}
+
+	metadata := cis.GetMetadata()
+
+	if this.noName == true {
+		metadata = nil
+	}
+
+	if metadata != nil && this.defaultOutput == true {
+		if name := this.originalName(metadata.Name); len(name) > 0 {
+			this.outputName = name
+			printOut("Output file name set to '"+this.outputName+"' (original name)", this.verbose)
+		}
+	}
+
+	var output kanzi.OutputStream
+
+	if strings.ToUpper(this.outputName) == "NONE" {
+		output, _ = io.NewNullOutputStream()
+	} else {
+		var err error
+		output, err = os.OpenFile(this.outputName, os.O_RDWR, 666)
+
+		if err == nil {
+			// File exists
+			if this.overwrite == false {
+				fmt.Printf("The output file '%v' exists and the 'overwrite' command ", this.outputName)
+				fmt.Println("line option has not been provided")
+				output.Close()
+				return io.ERR_OVERWRITE_FILE, read
+			}
+		} else {
+			// File does not exist, create
+			output, err = os.Create(this.outputName)
+
+			if err != nil {
+				fmt.Printf("Cannot open output file '%v' for writing: %v\n", this.outputName, err)
+				return io.ERR_CREATE_FILE, read
+			}
+		}
+	}
+
+	defer output.Close()
+
 	buffer := make([]byte, DECOMP_DEFAULT_BUFFER_SIZE)
 	decoded := len(buffer)
 	before := time.Now()
@@ -247,6 +450,13 @@ func (this *BlockDecompressor) call() (int, uint64) {
 		if decoded, err = cis.Read(buffer); err != nil {
 			if ioerr, isIOErr := err.(*io.IOError); isIOErr == true {
 				fmt.Printf("%s\n", ioerr.Message())
+
+				if (ioerr.ErrorCode() == io.ERR_AUTHENTICATION || ioerr.ErrorCode() == io.ERR_SIGNATURE) &&
+					this.salvage == io.SALVAGE_OFF && output != nil {
+					// Do not leave data that could not be authenticated
+					this.removeOutput(output)
+				}
+
 				return ioerr.ErrorCode(), read
 			} else {
 				fmt.Printf("An unexpected condition happened. Exiting ...\n%v\n", err)
@@ -273,9 +483,21 @@ func (this *BlockDecompressor) call() (int, uint64) {
 		return io.ERR_PROCESS_BLOCK, read
 	}
 
+	if metadata != nil && metadata.ModTime.IsZero() == false && strings.ToUpper(this.outputName) != "NONE" {
+		// Restore the modification time of the original file
+		if err := os.Chtimes(this.outputName, metadata.ModTime, metadata.ModTime); err != nil {
+			fmt.Printf("Warning: cannot set the modification time of '%v': %v\n", this.outputName, err)
+		}
+	}
+
 	after := time.Now()
 	delta := after.Sub(before).Nanoseconds() / 1000000 // convert to ms
 
+	if repaired := cis.GetRepaired(); repaired > 0 {
+		msg = fmt.Sprintf("Warning: %d damaged shards of the input file have been rebuilt (see -repair)", repaired)
+		printOut(msg, true)
+	}
+
 	printOut("", !this.silent)
 	msg = fmt.Sprintf("Decoding:          %d ms", delta)
 	printOut(msg, !this.silent)
@@ -290,7 +512,150 @@ func (this *BlockDecompressor) call() (int, uint64) {
 	}
 
 	printOut("", !this.silent)
-	return 0, cis.GetRead()
+	lost := cis.GetLostRanges()
+
+	if len(lost) == 0 {
+		return 0, cis.GetRead()
+	}
+
+	// Salvage mode: report the damaged blocks
+	for _, r := range lost {
+		if r.Blocks == 0 {
+			msg = fmt.Sprintf("Lost: block %d to the end of the stream (bytes %d to the end): %v",
+				r.FirstBlock, r.Offset, r.Err)
+		} else {
+			msg = fmt.Sprintf("Lost: %d block(s) from block %d (bytes %d to %d): %v",
+				r.Blocks, r.FirstBlock, r.Offset, r.Offset+r.Length-1, r.Err)
+		}
+
+		printOut(msg, true)
+	}
+
+	msg = fmt.Sprintf("Warning: %d damaged range(s), the output file is incomplete", len(lost))
+
+	if this.salvage == io.SALVAGE_ZERO_FILL {
+		msg = fmt.Sprintf("Warning: %d damaged range(s) replaced with zeros", len(lost))
+	}
+
+	printOut(msg, true)
+	return io.ERR_PROCESS_BLOCK, cis.GetRead()
+}
+
+// Return the path of the output file with the original name stored in the
+// header (in the directory of the input file) or "" if the name cannot be
+// used safely
+func (this *BlockDecompressor) originalName(name string) string {
+	if len(name) == 0 || name != filepath.Base(name) || name == "." || name == ".." {
+		return ""
+	}
+
+	path := filepath.Join(filepath.Dir(this.inputName), name)
+
+	if filepath.Clean(path) == filepath.Clean(this.inputName) {
+		// Never overwrite the input file
+		return ""
+	}
+
+	return path
+}
+
+// Check the embedded signature of the input file. Return 0 or an error code.
+func (this *BlockDecompressor) verifySignature() int {
+	input, err := os.Open(this.inputName)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file '%v': %v\n", this.inputName, err)
+		return io.ERR_OPEN_FILE
+	}
+
+	defer input.Close()
+
+	if err = io.VerifyEmbedded(input, this.verifyKey); err != nil {
+		if ioerr, isIOErr := err.(*io.IOError); isIOErr == true {
+			fmt.Printf("%s\n", ioerr.Message())
+			return ioerr.ErrorCode()
+		}
+
+		fmt.Printf("%v\n", err)
+		return io.ERR_SIGNATURE
+	}
+
+	printOut("Valid signature", this.verbose)
+	return 0
+}
+
+// Write the repaired input file. Return 0 or an error code.
+func (this *BlockDecompressor) repairInput() int {
+	if strings.ToUpper(this.outputName) == "NONE" {
+		fmt.Println("An output file is required to repair the input file")
+		return io.ERR_MISSING_FILENAME
+	}
+
+	input, err := os.Open(this.inputName)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file '%v': %v\n", this.inputName, err)
+		return io.ERR_OPEN_FILE
+	}
+
+	defer input.Close()
+	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	if this.overwrite == false {
+		mode |= os.O_EXCL
+	}
+
+	output, err := os.OpenFile(this.outputName, mode, 0666)
+
+	if err != nil {
+		if os.IsExist(err) {
+			fmt.Printf("The output file '%v' exists and the 'overwrite' command ", this.outputName)
+			fmt.Println("line option has not been provided")
+			return io.ERR_OVERWRITE_FILE
+		}
+
+		fmt.Printf("Cannot open output file '%v' for writing: %v\n", this.outputName, err)
+		return io.ERR_CREATE_FILE
+	}
+
+	printOut("Repairing ...", !this.silent)
+	writer := bufio.NewWriter(output)
+	repaired, err := io.Repair(bufio.NewReader(input), writer)
+
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	if err2 := output.Close(); err == nil {
+		err = err2
+	}
+
+	if err != nil {
+		os.Remove(this.outputName)
+
+		if ioerr, isIOErr := err.(*io.IOError); isIOErr == true {
+			fmt.Printf("%s\n", ioerr.Message())
+			return ioerr.ErrorCode()
+		}
+
+		fmt.Printf("Cannot write output file '%v': %v\n", this.outputName, err)
+		return io.ERR_WRITE_FILE
+	}
+
+	msg := fmt.Sprintf("%d damaged shards rebuilt, repaired file written to '%v'", repaired, this.outputName)
+	printOut(msg, !this.silent)
+	return 0
+}
+
+// Close and delete the output file (if any)
+func (this *BlockDecompressor) removeOutput(output kanzi.OutputStream) {
+	if file, isFile := output.(*os.File); isFile == true {
+		file.Close()
+
+		if err := os.Remove(this.outputName); err == nil {
+			printOut("The output file has been deleted", true)
+		}
+	}
 }
 
 func printOut(msg string, print bool) {
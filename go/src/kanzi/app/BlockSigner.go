@@ -0,0 +1,269 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"kanzi/io"
+	"os"
+)
+
+// Generate Ed25519 key pairs, sign files (detached signatures) and check
+// detached or embedded signatures (see BlockCompressor -sign-key).
+
+func printHelp() {
+	printOut("BlockSigner keygen -output=<name> [-overwrite]", true)
+	printOut("  create the private key <name>.key and the public key <name>.pub", true)
+	printOut("BlockSigner sign -input=<fileName> -key=<name.key> [-output=<fileName.sig>] [-overwrite]", true)
+	printOut("  create the detached signature of the input file (defaults to <input.sig>)", true)
+	printOut("BlockSigner verify -input=<fileName> -key=<name.pub> [-signature=<fileName.sig>]", true)
+	printOut("  check the detached signature of the input file or, if no signature file", true)
+	printOut("  is provided, the signature embedded in the compressed input file", true)
+	printOut("", true)
+	printOut("EG. go run BlockSigner.go keygen -output=build", true)
+	printOut("    go run BlockCompressor.go -input=foo.txt -sign-key=build.key", true)
+	printOut("    go run BlockSigner.go verify -input=foo.txt.knz -key=build.pub", true)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printHelp()
+		os.Exit(io.ERR_MISSING_FILENAME)
+	}
+
+	var code int
+
+	switch os.Args[1] {
+	case "keygen":
+		code = keygen(os.Args[2:])
+
+	case "sign":
+		code = sign(os.Args[2:])
+
+	case "verify":
+		code = verify(os.Args[2:])
+
+	case "-help", "--help", "help":
+		printHelp()
+
+	default:
+		fmt.Printf("Unknown command: %v\n", os.Args[1])
+		printHelp()
+		code = io.ERR_MISSING_FILENAME
+	}
+
+	os.Exit(code)
+}
+
+func keygen(args []string) int {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	var outputName = flags.String("output", "", "mandatory name of the key files (without extension)")
+	var overwrite = flags.Bool("overwrite", false, "overwrite the key files if they already exist")
+	flags.Parse(args)
+
+	if len(*outputName) == 0 {
+		fmt.Printf("Missing output file name, exiting ...\n")
+		return io.ERR_MISSING_FILENAME
+	}
+
+	publicKey, privateKey, err := io.GenerateSigningKey()
+
+	if err != nil {
+		fmt.Printf("Cannot generate the key pair: %v\n", err)
+		return io.ERR_CREATE_CODEC
+	}
+
+	// The private key is saved with its seed
+	if code := writeHex(*outputName+".key", privateKey.Seed(), 0600, *overwrite); code != 0 {
+		return code
+	}
+
+	if code := writeHex(*outputName+".pub", publicKey, 0644, *overwrite); code != 0 {
+		return code
+	}
+
+	printOut("Private key written to '"+*outputName+".key' (keep it secret)", true)
+	printOut("Public key written to '"+*outputName+".pub'", true)
+	return 0
+}
+
+func sign(args []string) int {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	var inputName = flags.String("input", "", "mandatory name of the file to sign")
+	var keyName = flags.String("key", "", "mandatory name of the private key file")
+	var outputName = flags.String("output", "", "optional name of the signature file (defaults to <input.sig>)")
+	var overwrite = flags.Bool("overwrite", false, "overwrite the signature file if it already exists")
+	flags.Parse(args)
+
+	if len(*inputName) == 0 || len(*keyName) == 0 {
+		fmt.Printf("Missing input or key file name, exiting ...\n")
+		return io.ERR_MISSING_FILENAME
+	}
+
+	if len(*outputName) == 0 {
+		*outputName = *inputName + ".sig"
+	}
+
+	data, err := os.ReadFile(*keyName)
+
+	if err != nil {
+		fmt.Printf("Cannot read private key file '%v': %v\n", *keyName, err)
+		return io.ERR_OPEN_FILE
+	}
+
+	key, err := io.ParsePrivateKey(data)
+
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return io.ERR_INVALID_FILE
+	}
+
+	input, err := os.Open(*inputName)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file '%v': %v\n", *inputName, err)
+		return io.ERR_OPEN_FILE
+	}
+
+	defer input.Close()
+	signature, err := io.SignDetached(input, key)
+
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return io.ERR_READ_FILE
+	}
+
+	if code := writeHex(*outputName, signature, 0644, *overwrite); code != 0 {
+		return code
+	}
+
+	printOut("Signature written to '"+*outputName+"'", true)
+	return 0
+}
+
+func verify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	var inputName = flags.String("input", "", "mandatory name of the file to check")
+	var keyName = flags.String("key", "", "mandatory name of the public key file")
+	var signatureName = flags.String("signature", "", "name of the detached signature file (none: embedded signature)")
+	flags.Parse(args)
+
+	if len(*inputName) == 0 || len(*keyName) == 0 {
+		fmt.Printf("Missing input or key file name, exiting ...\n")
+		return io.ERR_MISSING_FILENAME
+	}
+
+	data, err := os.ReadFile(*keyName)
+
+	if err != nil {
+		fmt.Printf("Cannot read public key file '%v': %v\n", *keyName, err)
+		return io.ERR_OPEN_FILE
+	}
+
+	key, err := io.ParsePublicKey(data)
+
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return io.ERR_INVALID_FILE
+	}
+
+	input, err := os.Open(*inputName)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file '%v': %v\n", *inputName, err)
+		return io.ERR_OPEN_FILE
+	}
+
+	defer input.Close()
+
+	if len(*signatureName) == 0 {
+		err = io.VerifyEmbedded(input, key)
+	} else {
+		data, err = os.ReadFile(*signatureName)
+
+		if err != nil {
+			fmt.Printf("Cannot read signature file '%v': %v\n", *signatureName, err)
+			return io.ERR_OPEN_FILE
+		}
+
+		var signature []byte
+		signature, err = hex.DecodeString(string(bytes.TrimSpace(data)))
+
+		if err != nil || len(signature) != io.SIGNATURE_SIZE {
+			fmt.Printf("Invalid signature file '%v'\n", *signatureName)
+			return io.ERR_INVALID_FILE
+		}
+
+		err = io.VerifyDetached(input, key, signature)
+	}
+
+	if err != nil {
+		if ioerr, isIOErr := err.(*io.IOError); isIOErr == true {
+			fmt.Printf("%s\n", ioerr.Message())
+			return ioerr.ErrorCode()
+		}
+
+		fmt.Printf("%v\n", err)
+		return io.ERR_SIGNATURE
+	}
+
+	printOut("Valid signature", true)
+	return 0
+}
+
+// Write the data as hexadecimal text
+func writeHex(fileName string, data []byte, perm os.FileMode, overwrite bool) int {
+	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	if overwrite == false {
+		mode |= os.O_EXCL
+	}
+
+	file, err := os.OpenFile(fileName, mode, perm)
+
+	if err != nil {
+		if os.IsExist(err) {
+			fmt.Printf("The output file '%v' exists and the 'overwrite' command ", fileName)
+			fmt.Println("line option has not been provided")
+			return io.ERR_OVERWRITE_FILE
+		}
+
+		fmt.Printf("Cannot open output file '%v' for writing: %v\n", fileName, err)
+		return io.ERR_CREATE_FILE
+	}
+
+	_, err = fmt.Fprintf(file, "%s\n", hex.EncodeToString(data))
+
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+
+	if err != nil {
+		fmt.Printf("Cannot write output file '%v': %v\n", fileName, err)
+		return io.ERR_WRITE_FILE
+	}
+
+	return 0
+}
+
+func printOut(msg string, print bool) {
+	if print == true {
+		fmt.Println(msg)
+	}
+}
@@ -0,0 +1,393 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	kimage "kanzi/image"
+	"kanzi/io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Encode PGM/PPM images with the image codecs (progressive wavelet, lossless
+// wavelet, DCT or baseline JPEG),
+// decode them (or a prefix of a progressive image) and compare images.
+
+func printHelp() {
+	printOut("ImageCodec encode -input=<image.ppm> -output=<fileName> [-format=wavelet|lossless|dct|jpeg]", true)
+	printOut("           [-bpp=<bits per pixel>] [-quality=<1..100>] [-coder=range|expgolomb] [-optimize] [-overwrite]", true)
+	printOut("  encode a PGM or PPM image: at a target bitrate (wavelet, lossless) or quality (dct, jpeg)", true)
+	printOut("ImageCodec decode -input=<fileName> -output=<image.ppm> [-bpp=<bits per pixel>|-bytes=<size>] [-overwrite]", true)
+	printOut("  decode an image, or only a prefix of a progressive (wavelet, lossless) image", true)
+	printOut("ImageCodec compare -input=<image.ppm> -reference=<image.ppm>", true)
+	printOut("  print the PSNR of an image compared to the reference image", true)
+	printOut("", true)
+	printOut("EG. go run ImageCodec.go encode -input=lena.ppm -output=lena.kwv -bpp=1", true)
+	printOut("    go run ImageCodec.go decode -input=lena.kwv -output=lena_0.25.ppm -bpp=0.25", true)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printHelp()
+		os.Exit(io.ERR_MISSING_FILENAME)
+	}
+
+	var code int
+
+	switch os.Args[1] {
+	case "encode":
+		code = encode(os.Args[2:])
+
+	case "decode":
+		code = decode(os.Args[2:])
+
+	case "compare":
+		code = compare(os.Args[2:])
+
+	case "-help", "--help", "help":
+		printHelp()
+
+	default:
+		fmt.Printf("Unknown command: %v\n", os.Args[1])
+		printHelp()
+		code = io.ERR_MISSING_FILENAME
+	}
+
+	os.Exit(code)
+}
+
+func encode(args []string) int {
+	flags := flag.NewFlagSet("encode", flag.ExitOnError)
+	var inputName = flags.String("input", "", "mandatory name of the PGM or PPM image")
+	var outputName = flags.String("output", "", "mandatory name of the encoded image")
+	var format = flags.String("format", "wavelet", "wavelet (progressive), lossless (progressive), dct or jpeg")
+	var bpp = flags.Float64("bpp", 0, "target bitrate in bits per pixel (wavelet, lossless, 0 for all the bit planes)")
+	var quality = flags.Int("quality", kimage.DEFAULT_DCT_QUALITY, "quality in [1..100] (dct, jpeg)")
+	var coderName = flags.String("coder", "range", "coefficient coder: range or expgolomb (dct)")
+	var optimize = flags.Bool("optimize", true, "Huffman tables optimized for the image, or standard tables (jpeg)")
+	var overwrite = flags.Bool("overwrite", false, "overwrite the output file if it already exists")
+	flags.Parse(args)
+
+	if len(*inputName) == 0 || len(*outputName) == 0 {
+		fmt.Printf("Missing input or output file name, exiting ...\n")
+		return io.ERR_MISSING_FILENAME
+	}
+
+	img, code := readImage(*inputName)
+
+	if code != 0 {
+		return code
+	}
+
+	var data []byte
+	var err error
+	before := time.Now()
+
+	switch strings.ToLower(*format) {
+	case "wavelet", "lossless":
+		if *bpp < 0 {
+			fmt.Printf("Invalid bitrate: %v\n", *bpp)
+			return io.ERR_INVALID_CODEC
+		}
+
+		encoder, _ := kimage.NewWaveletEncoder()
+		encoder.SetLossless(strings.ToLower(*format) == "lossless")
+		data, err = encoder.Encode(img, int(*bpp*float64(img.Width*img.Height)/8))
+
+	case "dct":
+		coder, err2 := kimage.GetCoderType(*coderName)
+
+		if err2 != nil {
+			fmt.Printf("%v\n", err2)
+			return io.ERR_INVALID_CODEC
+		}
+
+		encoder, err2 := kimage.NewDCTEncoder(*quality, coder)
+
+		if err2 != nil {
+			fmt.Printf("%v\n", err2)
+			return io.ERR_INVALID_CODEC
+		}
+
+		data, err = encoder.Encode(img)
+
+	case "jpeg":
+		encoder, err2 := kimage.NewJPEGEncoder(*quality, *optimize)
+
+		if err2 != nil {
+			fmt.Printf("%v\n", err2)
+			return io.ERR_INVALID_CODEC
+		}
+
+		data, err = encoder.Encode(img)
+
+	default:
+		fmt.Printf("Unknown image format: %v\n", *format)
+		return io.ERR_INVALID_CODEC
+	}
+
+	if err != nil {
+		fmt.Printf("Cannot encode image: %v\n", err)
+		return io.ERR_PROCESS_BLOCK
+	}
+
+	delta := time.Since(before)
+
+	if code := writeFile(*outputName, data, *overwrite); code != 0 {
+		return code
+	}
+
+	fmt.Printf("Encoded %dx%d image (%d channel(s)) in %d ms\n", img.Width, img.Height, img.Channels,
+		delta.Milliseconds())
+	fmt.Printf("Output size: %d bytes (%.3f bpp)\n", len(data), float64(8*len(data))/float64(img.Width*img.Height))
+
+	// Report the distortion of the encoded image
+	decoded, err := decodeImage(data)
+
+	if err != nil {
+		fmt.Printf("Cannot decode image: %v\n", err)
+		return io.ERR_PROCESS_BLOCK
+	}
+
+	if report, err := kimage.Compare(img, decoded); err == nil {
+		fmt.Printf("%v\n", report)
+	}
+
+	return 0
+}
+
+func decode(args []string) int {
+	flags := flag.NewFlagSet("decode", flag.ExitOnError)
+	var inputName = flags.String("input", "", "mandatory name of the encoded image")
+	var outputName = flags.String("output", "", "mandatory name of the PGM or PPM image")
+	var bpp = flags.Float64("bpp", 0, "decode a prefix of a progressive image (bits per pixel)")
+	var size = flags.Int("bytes", 0, "decode a prefix of a progressive image (size in bytes)")
+	var overwrite = flags.Bool("overwrite", false, "overwrite the output file if it already exists")
+	flags.Parse(args)
+
+	if len(*inputName) == 0 || len(*outputName) == 0 {
+		fmt.Printf("Missing input or output file name, exiting ...\n")
+		return io.ERR_MISSING_FILENAME
+	}
+
+	data, err := os.ReadFile(*inputName)
+
+	if err != nil {
+		fmt.Printf("Cannot read input file '%v': %v\n", *inputName, err)
+		return io.ERR_OPEN_FILE
+	}
+
+	if *size < 0 || *bpp < 0 {
+		fmt.Printf("Invalid prefix size\n")
+		return io.ERR_INVALID_CODEC
+	}
+
+	if *size > 0 || *bpp > 0 {
+		if kimage.IsWaveletImage(data) == false {
+			fmt.Printf("Only the prefixes of progressive (wavelet) images can be decoded\n")
+			return io.ERR_INVALID_CODEC
+		}
+
+		if *bpp > 0 {
+			width, height, err := kimage.GetImageSize(data)
+
+			if err != nil {
+				fmt.Printf("Cannot decode image: %v\n", err)
+				return io.ERR_INVALID_FILE
+			}
+
+			*size = int(*bpp * float64(width*height) / 8)
+		}
+
+		if *size < len(data) {
+			data = data[0:*size]
+		}
+	}
+
+	before := time.Now()
+	img, err := decodeImage(data)
+
+	if err != nil {
+		fmt.Printf("Cannot decode image: %v\n", err)
+		return io.ERR_INVALID_FILE
+	}
+
+	delta := time.Since(before)
+	var buf bytes.Buffer
+	kimage.WritePNM(&buf, img)
+
+	if code := writeFile(*outputName, buf.Bytes(), *overwrite); code != 0 {
+		return code
+	}
+
+	fmt.Printf("Decoded %dx%d image (%d channel(s)) from %d bytes (%.3f bpp) in %d ms\n", img.Width, img.Height,
+		img.Channels, len(data), float64(8*len(data))/float64(img.Width*img.Height), delta.Milliseconds())
+	return 0
+}
+
+func compare(args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	var inputName = flags.String("input", "", "mandatory name of the PGM or PPM image")
+	var refName = flags.String("reference", "", "mandatory name of the reference PGM or PPM image")
+	flags.Parse(args)
+
+	if len(*inputName) == 0 || len(*refName) == 0 {
+		fmt.Printf("Missing input or reference file name, exiting ...\n")
+		return io.ERR_MISSING_FILENAME
+	}
+
+	img, code := readImage(*inputName)
+
+	if code != 0 {
+		return code
+	}
+
+	ref, code := readImage(*refName)
+
+	if code != 0 {
+		return code
+	}
+
+	report, err := kimage.Compare(ref, img)
+
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return io.ERR_INVALID_CODEC
+	}
+
+	fmt.Printf("%v\n", report)
+	return 0
+}
+
+// Decode an image of any format
+func decodeImage(data []byte) (*kimage.Image, error) {
+	if len(data) >= 2 && data[0] == 0xFF && data[1] == kimage.JPEG_SOI {
+		return decodeJPEG(data)
+	}
+
+	if kimage.IsDCTImage(data) == true {
+		decoder, _ := kimage.NewDCTDecoder()
+		return decoder.Decode(data)
+	}
+
+	decoder, _ := kimage.NewWaveletDecoder()
+	return decoder.Decode(data)
+}
+
+// Decode a JPEG image with the standard library
+func decodeJPEG(data []byte) (*kimage.Image, error) {
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := decoded.Bounds()
+	channels := 3
+
+	if _, isGray := decoded.(*image.Gray); isGray == true {
+		channels = 1
+	}
+
+	img, err := kimage.NewImage(bounds.Dx(), bounds.Dy(), channels)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			idx := (y*img.Width + x) * channels
+			pixel := decoded.At(bounds.Min.X+x, bounds.Min.Y+y)
+
+			if channels == 1 {
+				img.Pix[idx] = color.GrayModel.Convert(pixel).(color.Gray).Y
+				continue
+			}
+
+			rgb := color.RGBAModel.Convert(pixel).(color.RGBA)
+			img.Pix[idx], img.Pix[idx+1], img.Pix[idx+2] = rgb.R, rgb.G, rgb.B
+		}
+	}
+
+	return img, nil
+}
+
+func readImage(fileName string) (*kimage.Image, int) {
+	file, err := os.Open(fileName)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file '%v': %v\n", fileName, err)
+		return nil, io.ERR_OPEN_FILE
+	}
+
+	defer file.Close()
+	img, err := kimage.ReadPNM(file)
+
+	if err != nil {
+		fmt.Printf("Cannot read image '%v': %v\n", fileName, err)
+		return nil, io.ERR_INVALID_FILE
+	}
+
+	return img, 0
+}
+
+func writeFile(fileName string, data []byte, overwrite bool) int {
+	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+	if overwrite == false {
+		mode |= os.O_EXCL
+	}
+
+	file, err := os.OpenFile(fileName, mode, 0644)
+
+	if err != nil {
+		if os.IsExist(err) {
+			fmt.Printf("The output file '%v' exists and the 'overwrite' command ", fileName)
+			fmt.Println("line option has not been provided")
+			return io.ERR_OVERWRITE_FILE
+		}
+
+		fmt.Printf("Cannot open output file '%v' for writing: %v\n", fileName, err)
+		return io.ERR_CREATE_FILE
+	}
+
+	_, err = file.Write(data)
+
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+
+	if err != nil {
+		fmt.Printf("Cannot write output file '%v': %v\n", fileName, err)
+		return io.ERR_WRITE_FILE
+	}
+
+	return 0
+}
+
+func printOut(msg string, print bool) {
+	if print == true {
+		fmt.Println(msg)
+	}
+}
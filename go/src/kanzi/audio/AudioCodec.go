@@ -0,0 +1,787 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"math/bits"
+)
+
+// Lossless audio codec. The audio is split into frames of blockSize samples
+// per channel. For stereo audio, the channels of each frame are decorrelated
+// (left/side, right/side or mid/side when it is cheaper). Each channel of a
+// frame (subframe) is coded as a constant, verbatim or predicted by a
+// LPCPredictor: the coefficients are either the fixed polynomial ones (order
+// 0 to 4) or computed from the samples and quantized, the best order is
+// selected from the estimated size. The residuals are Rice coded by a
+// RiceGolombEncoder, in 2^n partitions with their own parameter (or raw
+// values for the escape parameter), the partition order is selected from the
+// estimated size too.
+//
+// Format (big endian):
+// magic (32 bits), version (8 bits), channels (8 bits), bits per sample (8
+// bits), sample rate (32 bits), block size (16 bits), samples per channel (64
+// bits), CRC-16 of the header (16 bits), then the frames: payload size (32
+// bits), payload, CRC-16 of the payload (16 bits).
+// Frame payload: channel mode (4 bits) then the subframes: type (2 bits),
+// - constant: value (bps bits)
+// - verbatim: values (bps bits each)
+// - LPC: order (6 bits), precision and shift (4 bits each, if order > 0),
+//   coefficients (precision bits each), warm-up samples (bps bits each),
+//   partition order (4 bits) and the partitions: Rice parameter (5 bits),
+//   values or escape width (6 bits) and raw values.
+// The side channel uses one more bit per sample than the audio.
+// The CRC-16 is the one of FLAC (polynomial 0x8005, initial value 0).
+
+const (
+	AUDIO_MAGIC              = 0x4B415544 // "KAUD"
+	AUDIO_VERSION            = 1
+	DEFAULT_AUDIO_BLOCK_SIZE = 4096
+	MIN_AUDIO_BLOCK_SIZE     = 16
+	MAX_AUDIO_BLOCK_SIZE     = 65535
+	DEFAULT_LPC_ORDER        = 12
+	AUDIO_LPC_PRECISION      = 14
+	MAX_PARTITION_ORDER      = 8
+
+	CHANNEL_INDEPENDENT = 0
+	CHANNEL_LEFT_SIDE   = 1
+	CHANNEL_RIGHT_SIDE  = 2
+	CHANNEL_MID_SIDE    = 3
+
+	SUBFRAME_CONSTANT = 0
+	SUBFRAME_VERBATIM = 1
+	SUBFRAME_LPC      = 2
+
+	audioHeaderSize = 23
+	riceEscape      = 31
+)
+
+var crc16Table = makeCRC16Table()
+
+func makeCRC16Table() [256]uint16 {
+	var table [256]uint16
+
+	for i := range table {
+		crc := uint16(i) << 8
+
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+
+		table[i] = crc
+	}
+
+	return table
+}
+
+func CRC16(data []byte) uint16 {
+	crc := uint16(0)
+
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+
+	return crc
+}
+
+// Statistics of a partition of residuals
+type partitionStats struct {
+	sum     uint64 // sum of the magnitudes
+	count   int
+	nonZero int
+	width   uint // bits of the largest value (with sign)
+}
+
+func (this *partitionStats) merge(other *partitionStats) {
+	this.sum += other.sum
+	this.count += other.count
+	this.nonZero += other.nonZero
+	this.width = max(this.width, other.width)
+}
+
+// Return the best Rice parameter (or riceEscape) and the estimated size
+func (this *partitionStats) parameter() (uint, int) {
+	param, cost := uint(riceEscape), 5+6+this.count*int(this.width)
+
+	for k := uint(0); k < riceEscape; k++ {
+		c := 5 + this.count*int(k+1) + int(this.sum>>k) + this.nonZero
+
+		if c < cost {
+			param, cost = k, c
+		} else if param != riceEscape {
+			break
+		}
+	}
+
+	return param, cost
+}
+
+type partitioning struct {
+	order  uint
+	params []uint
+	widths []uint // of the escaped partitions
+}
+
+// A coded channel of a frame
+type subframe struct {
+	kind      int
+	bps       uint
+	samples   []int
+	coefs     []int
+	shift     uint
+	precision uint
+	residual  []int
+	parts     partitioning
+	size      int // estimated size in bits
+}
+
+type AudioEncoder struct {
+	blockSize int
+	maxOrder  int
+	predictor *LPCPredictor
+	buffer    []int
+}
+
+// Create an encoder with the number of samples per frame and the maximum
+// order of the linear prediction (0 for the fixed predictors only)
+func NewAudioEncoder(blockSize, maxOrder int) (*AudioEncoder, error) {
+	if blockSize < MIN_AUDIO_BLOCK_SIZE || blockSize > MAX_AUDIO_BLOCK_SIZE {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid block size: %d (must be in [%d..%d])",
+			blockSize, MIN_AUDIO_BLOCK_SIZE, MAX_AUDIO_BLOCK_SIZE)
+	}
+
+	if maxOrder < 0 || maxOrder > MAX_LPC_ORDER {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid LPC order: %d (must be in [0..%d])",
+			maxOrder, MAX_LPC_ORDER)
+	}
+
+	this := &AudioEncoder{blockSize: blockSize, maxOrder: maxOrder}
+	this.predictor, _ = NewLPCPredictor(nil, 0)
+	return this, nil
+}
+
+func (this *AudioEncoder) Encode(audio *Audio) (res []byte, err error) {
+	if err = audio.check(); err != nil {
+		return nil, err
+	}
+
+	minVal, maxVal := -1<<(audio.BitsPerSample-1), 1<<(audio.BitsPerSample-1)-1
+
+	for c := range audio.Samples {
+		for _, v := range audio.Samples[c] {
+			if v < minVal || v > maxVal {
+				return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid sample: %d (must fit in %d bits)",
+					v, audio.BitsPerSample)
+			}
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = kanzi.Errorf(kanzi.ErrIO, "Cannot encode audio: %v", r)
+		}
+	}()
+
+	res = binary.BigEndian.AppendUint32(nil, AUDIO_MAGIC)
+	res = append(res, AUDIO_VERSION, byte(audio.Channels()), byte(audio.BitsPerSample))
+	res = binary.BigEndian.AppendUint32(res, uint32(audio.SampleRate))
+	res = binary.BigEndian.AppendUint16(res, uint16(this.blockSize))
+	res = binary.BigEndian.AppendUint64(res, uint64(audio.Length()))
+	res = binary.BigEndian.AppendUint16(res, CRC16(res))
+
+	for start := 0; start < audio.Length(); start += this.blockSize {
+		end := min(start+this.blockSize, audio.Length())
+		payload, err := this.encodeFrame(audio, start, end)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = binary.BigEndian.AppendUint32(res, uint32(len(payload)))
+		res = append(res, payload...)
+		res = binary.BigEndian.AppendUint16(res, CRC16(payload))
+	}
+
+	return res, nil
+}
+
+func (this *AudioEncoder) encodeFrame(audio *Audio, start, end int) ([]byte, error) {
+	bps := uint(audio.BitsPerSample)
+	subframes := make([]*subframe, audio.Channels())
+	mode := CHANNEL_INDEPENDENT
+
+	for c := range subframes {
+		subframes[c] = this.analyze(audio.Samples[c][start:end], bps)
+	}
+
+	if len(subframes) == 2 {
+		left, right := audio.Samples[0][start:end], audio.Samples[1][start:end]
+		mid := make([]int, end-start)
+		side := make([]int, end-start)
+
+		for i := range mid {
+			mid[i] = (left[i] + right[i]) >> 1
+			side[i] = left[i] - right[i]
+		}
+
+		sideFrame := this.analyze(side, bps+1)
+		midFrame := this.analyze(mid, bps)
+		candidates := [][2]*subframe{
+			{subframes[0], subframes[1]},
+			{subframes[0], sideFrame},
+			{sideFrame, subframes[1]},
+			{midFrame, sideFrame},
+		}
+
+		best := subframes[0].size + subframes[1].size
+
+		for m := CHANNEL_LEFT_SIDE; m <= CHANNEL_MID_SIDE; m++ {
+			if size := candidates[m][0].size + candidates[m][1].size; size < best {
+				mode, best = m, size
+			}
+		}
+
+		subframes = candidates[mode][:]
+	}
+
+	bs := &bufferStream{}
+	obs, err := bitstream.NewDefaultOutputBitStream(bs, 16384)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rice, err := entropy.NewRiceGolombEncoder(obs, true, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	obs.WriteBits(uint64(mode), 4)
+
+	for _, sf := range subframes {
+		this.writeSubframe(obs, rice, sf)
+	}
+
+	if _, err = obs.Close(); err != nil {
+		return nil, err
+	}
+
+	return bs.Bytes(), nil
+}
+
+// Select the cheapest coding of the samples
+func (this *AudioEncoder) analyze(samples []int, bps uint) *subframe {
+	n := len(samples)
+	res := &subframe{kind: SUBFRAME_CONSTANT, bps: bps, samples: samples, size: 2 + int(bps)}
+	constant := true
+
+	for _, v := range samples {
+		if v != samples[0] {
+			constant = false
+			break
+		}
+	}
+
+	if constant == true {
+		return res
+	}
+
+	res.kind = SUBFRAME_VERBATIM
+	res.size = 2 + n*int(bps)
+	type candidate struct {
+		coefs []int
+		shift uint
+	}
+
+	candidates := make([]candidate, 0, MAX_FIXED_ORDER+1+this.maxOrder)
+
+	for order := 0; order <= MAX_FIXED_ORDER && order < n; order++ {
+		candidates = append(candidates, candidate{coefs: fixedCoefficients[order]})
+	}
+
+	for _, lpc := range ComputeLPC(samples, this.maxOrder) {
+		coefs, shift := QuantizeLPC(lpc, AUDIO_LPC_PRECISION)
+		candidates = append(candidates, candidate{coefs: coefs, shift: shift})
+	}
+
+	if len(this.buffer) < n {
+		this.buffer = make([]int, n)
+	}
+
+	residual := this.buffer[0:n]
+
+	for _, cand := range candidates {
+		coefs, shift := cand.coefs, cand.shift
+
+		if err := this.predictor.SetCoefficients(coefs, shift); err != nil {
+			continue
+		}
+
+		if _, _, err := this.predictor.Forward(samples, residual); err != nil {
+			continue
+		}
+
+		order := len(coefs)
+		parts, size := choosePartitions(residual, order)
+		precision := this.predictor.Precision()
+		size += 2 + 6 + order*int(bps)
+
+		if order > 0 {
+			size += 8 + order*int(precision)
+		}
+
+		if size < res.size {
+			res.kind = SUBFRAME_LPC
+			res.size = size
+			res.coefs = append(res.coefs[:0], coefs...)
+			res.shift = shift
+			res.precision = precision
+			res.parts = parts
+			res.residual = append(res.residual[:0], residual...)
+		}
+	}
+
+	return res
+}
+
+// Select the partition order with the smallest estimated size
+func choosePartitions(residual []int, order int) (partitioning, int) {
+	n := len(residual)
+	maxOrder := uint(0)
+
+	for maxOrder < MAX_PARTITION_ORDER && n%(2<<maxOrder) == 0 && n>>(maxOrder+1) > order {
+		maxOrder++
+	}
+
+	stats := make([]partitionStats, 1<<maxOrder)
+	size := n >> maxOrder
+
+	for i := order; i < n; i++ {
+		v := residual[i]
+		st := &stats[i/size]
+		st.count++
+
+		if v != 0 {
+			st.nonZero++
+			st.width = max(st.width, uint(bits.Len64(uint64(v^(v>>63))))+1)
+		}
+
+		if v < 0 {
+			v = -v
+		}
+
+		st.sum += uint64(v)
+	}
+
+	var best partitioning
+	bestSize := -1
+
+	for po := int(maxOrder); po >= 0; po-- {
+		if po < int(maxOrder) {
+			for i := 0; i < 1<<uint(po); i++ {
+				stats[2*i].merge(&stats[2*i+1])
+				stats[i] = stats[2*i]
+			}
+		}
+
+		parts := partitioning{order: uint(po), params: make([]uint, 1<<uint(po))}
+		total := 4
+
+		for i := range parts.params {
+			param, cost := stats[i].parameter()
+			parts.params[i] = param
+			total += cost
+
+			if param == riceEscape {
+				parts.widths = append(parts.widths, stats[i].width)
+			}
+		}
+
+		if bestSize < 0 || total < bestSize {
+			best, bestSize = parts, total
+		}
+	}
+
+	return best, bestSize
+}
+
+func (this *AudioEncoder) writeSubframe(obs kanzi.OutputBitStream, rice *entropy.RiceGolombEncoder, sf *subframe) {
+	obs.WriteBits(uint64(sf.kind), 2)
+
+	switch sf.kind {
+	case SUBFRAME_CONSTANT:
+		writeSigned(obs, sf.samples[0], sf.bps)
+
+	case SUBFRAME_VERBATIM:
+		for _, v := range sf.samples {
+			writeSigned(obs, v, sf.bps)
+		}
+
+	case SUBFRAME_LPC:
+		order := len(sf.coefs)
+		obs.WriteBits(uint64(order), 6)
+
+		if order > 0 {
+			obs.WriteBits(uint64(sf.precision), 4)
+			obs.WriteBits(uint64(sf.shift), 4)
+
+			for _, c := range sf.coefs {
+				writeSigned(obs, c, sf.precision)
+			}
+		}
+
+		for _, v := range sf.samples[0:order] {
+			writeSigned(obs, v, sf.bps)
+		}
+
+		n := len(sf.residual)
+		size := n >> sf.parts.order
+		obs.WriteBits(uint64(sf.parts.order), 4)
+		escaped := sf.parts.widths
+
+		for p, param := range sf.parts.params {
+			values := sf.residual[max(p*size, order) : (p+1)*size]
+			obs.WriteBits(uint64(param), 5)
+
+			if param == riceEscape {
+				width := escaped[0]
+				escaped = escaped[1:]
+				obs.WriteBits(uint64(width), 6)
+
+				if width > 0 {
+					for _, v := range values {
+						writeSigned(obs, v, width)
+					}
+				}
+
+				continue
+			}
+
+			rice.SetLogBase(param)
+
+			for _, v := range values {
+				rice.EncodeInt(v)
+			}
+		}
+	}
+}
+
+func writeSigned(obs kanzi.OutputBitStream, val int, width uint) {
+	obs.WriteBits(uint64(val)&(0xFFFFFFFFFFFFFFFF>>(64-width)), width)
+}
+
+func readSigned(ibs kanzi.InputBitStream, width uint) int {
+	return int(ibs.ReadBits(width)<<(64-width)) >> (64 - width)
+}
+
+type AudioDecoder struct {
+	predictor *LPCPredictor
+}
+
+func NewAudioDecoder() (*AudioDecoder, error) {
+	this := new(AudioDecoder)
+	this.predictor, _ = NewLPCPredictor(nil, 0)
+	return this, nil
+}
+
+// Return true if the data starts like an output of AudioEncoder
+func IsAudioStream(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data) == AUDIO_MAGIC
+}
+
+func (this *AudioDecoder) Decode(data []byte) (audio *Audio, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			audio = nil
+			err = kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: %v", r)
+		}
+	}()
+
+	if len(data) < audioHeaderSize {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: truncated header")
+	}
+
+	if IsAudioStream(data) == false {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: not an audio stream")
+	}
+
+	if data[4] != AUDIO_VERSION {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported audio stream version: %d", data[4])
+	}
+
+	if CRC16(data[0:audioHeaderSize-2]) != binary.BigEndian.Uint16(data[audioHeaderSize-2:]) {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: header checksum mismatch")
+	}
+
+	channels := int(data[5])
+	bps := int(data[6])
+	sampleRate := int(binary.BigEndian.Uint32(data[7:]))
+	blockSize := int(binary.BigEndian.Uint16(data[11:]))
+	length := binary.BigEndian.Uint64(data[13:])
+
+	if blockSize < MIN_AUDIO_BLOCK_SIZE {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: invalid block size: %d", blockSize)
+	}
+
+	// Each frame takes at least 7 bytes
+	if length > uint64(blockSize)*uint64(len(data)/7) {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: invalid length: %d", length)
+	}
+
+	if audio, err = NewAudio(channels, bps, sampleRate, int(length)); err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: %v", err)
+	}
+
+	buffers := make([][]int, channels)
+
+	for c := range buffers {
+		buffers[c] = make([]int, blockSize)
+	}
+
+	pos := audioHeaderSize
+
+	for start := 0; start < int(length); start += blockSize {
+		if pos+4 > len(data) {
+			return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: truncated frame")
+		}
+
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+
+		if size > len(data)-pos-2 {
+			return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: truncated frame")
+		}
+
+		payload := data[pos : pos+size]
+		pos += size
+
+		if CRC16(payload) != binary.BigEndian.Uint16(data[pos:]) {
+			return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: checksum mismatch in the frame at sample %d", start)
+		}
+
+		pos += 2
+		end := min(start+blockSize, int(length))
+
+		if err = this.decodeFrame(payload, audio, start, end, buffers); err != nil {
+			return nil, err
+		}
+	}
+
+	if pos != len(data) {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: %d extra bytes", len(data)-pos)
+	}
+
+	return audio, nil
+}
+
+func (this *AudioDecoder) decodeFrame(payload []byte, audio *Audio, start, end int, buffers [][]int) error {
+	ibs, err := bitstream.NewDefaultInputBitStream(&readerStream{bytes.NewReader(payload)}, 16384)
+
+	if err != nil {
+		return err
+	}
+
+	rice, err := entropy.NewRiceGolombDecoder(ibs, true, 1)
+
+	if err != nil {
+		return err
+	}
+
+	mode := int(ibs.ReadBits(4))
+
+	if mode > CHANNEL_MID_SIDE || (mode != CHANNEL_INDEPENDENT && audio.Channels() != 2) {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: invalid channel mode: %d", mode)
+	}
+
+	n := end - start
+
+	for c := range buffers {
+		bps := uint(audio.BitsPerSample)
+
+		// Side channel
+		if (mode == CHANNEL_RIGHT_SIDE && c == 0) || (mode != CHANNEL_INDEPENDENT && mode != CHANNEL_RIGHT_SIDE && c == 1) {
+			bps++
+		}
+
+		if err := this.decodeSubframe(ibs, rice, buffers[c][0:n], bps); err != nil {
+			return err
+		}
+	}
+
+	for c := range buffers {
+		copy(audio.Samples[c][start:end], buffers[c][0:n])
+	}
+
+	if mode != CHANNEL_INDEPENDENT {
+		left, right := audio.Samples[0][start:end], audio.Samples[1][start:end]
+
+		for i := range left {
+			switch mode {
+			case CHANNEL_LEFT_SIDE:
+				right[i] = left[i] - right[i]
+
+			case CHANNEL_RIGHT_SIDE:
+				left[i] += right[i]
+
+			case CHANNEL_MID_SIDE:
+				mid, side := left[i]<<1|right[i]&1, right[i]
+				left[i] = (mid + side) >> 1
+				right[i] = (mid - side) >> 1
+			}
+		}
+
+		limit := 1 << (audio.BitsPerSample - 1)
+
+		for i := range left {
+			if left[i] < -limit || left[i] >= limit || right[i] < -limit || right[i] >= limit {
+				return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: invalid sample")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (this *AudioDecoder) decodeSubframe(ibs kanzi.InputBitStream, rice *entropy.RiceGolombDecoder, dst []int, bps uint) error {
+	n := len(dst)
+
+	switch kind := int(ibs.ReadBits(2)); kind {
+	case SUBFRAME_CONSTANT:
+		val := readSigned(ibs, bps)
+
+		for i := range dst {
+			dst[i] = val
+		}
+
+		return nil
+
+	case SUBFRAME_VERBATIM:
+		for i := range dst {
+			dst[i] = readSigned(ibs, bps)
+		}
+
+		return nil
+
+	case SUBFRAME_LPC:
+		// Decoded below
+
+	default:
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: invalid subframe type: %d", kind)
+	}
+
+	order := int(ibs.ReadBits(6))
+
+	if order > MAX_LPC_ORDER || order >= n {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: invalid predictor order: %d", order)
+	}
+
+	coefs := make([]int, order)
+	shift := uint(0)
+
+	if order > 0 {
+		precision := uint(ibs.ReadBits(4))
+		shift = uint(ibs.ReadBits(4))
+
+		if precision == 0 {
+			return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: invalid coefficient precision")
+		}
+
+		for i := range coefs {
+			coefs[i] = readSigned(ibs, precision)
+		}
+	}
+
+	if err := this.predictor.SetCoefficients(coefs, shift); err != nil {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: %v", err)
+	}
+
+	residual := make([]int, n)
+
+	for i := 0; i < order; i++ {
+		residual[i] = readSigned(ibs, bps)
+	}
+
+	po := uint(ibs.ReadBits(4))
+
+	if po > MAX_PARTITION_ORDER || n%(1<<po) != 0 || n>>po < order {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: invalid partition order: %d", po)
+	}
+
+	size := n >> po
+
+	for p := 0; p < 1<<po; p++ {
+		values := residual[max(p*size, order) : (p+1)*size]
+		param := uint(ibs.ReadBits(5))
+
+		if param == riceEscape {
+			width := uint(ibs.ReadBits(6))
+
+			if width > 0 {
+				for i := range values {
+					values[i] = readSigned(ibs, width)
+				}
+			}
+
+			continue
+		}
+
+		rice.SetLogBase(param)
+
+		for i := range values {
+			values[i] = rice.DecodeInt()
+		}
+	}
+
+	if _, _, err := this.predictor.Inverse(residual, dst); err != nil {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: %v", err)
+	}
+
+	limit := 1 << (bps - 1)
+
+	for _, v := range dst {
+		if v < -limit || v >= limit {
+			return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid audio data: invalid sample")
+		}
+	}
+
+	return nil
+}
+
+type bufferStream struct {
+	bytes.Buffer
+}
+
+func (this *bufferStream) Close() error {
+	return nil
+}
+
+type readerStream struct {
+	*bytes.Reader
+}
+
+func (this *readerStream) Close() error {
+	return nil
+}
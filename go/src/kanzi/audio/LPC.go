@@ -0,0 +1,308 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audio
+
+import (
+	"kanzi"
+	"math"
+)
+
+const (
+	MAX_LPC_ORDER     = 32
+	MAX_LPC_PRECISION = 15 // bits of the quantized coefficients (with sign)
+	MAX_LPC_SHIFT     = 15
+	MAX_FIXED_ORDER   = 4
+)
+
+// Coefficients of the fixed polynomial predictors (order 0 to 4)
+var fixedCoefficients = [MAX_FIXED_ORDER + 1][]int{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+// Linear predictor with integer coefficients: each sample is predicted by
+// (sum of coefs[j]*sample[i-1-j]) >> shift. Forward replaces the samples
+// (except the first 'order' ones, the warm-up samples) by the prediction
+// residuals, Inverse rebuilds the samples exactly.
+// Implement kanzi.IntFunction.
+type LPCPredictor struct {
+	coefs []int
+	shift uint
+}
+
+func NewLPCPredictor(coefs []int, shift uint) (*LPCPredictor, error) {
+	this := new(LPCPredictor)
+
+	if err := this.SetCoefficients(coefs, shift); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Create a predictor with the fixed polynomial coefficients of the order
+func NewFixedPredictor(order int) (*LPCPredictor, error) {
+	if order < 0 || order > MAX_FIXED_ORDER {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid fixed predictor order: %d (must be in [0..%d])",
+			order, MAX_FIXED_ORDER)
+	}
+
+	return NewLPCPredictor(fixedCoefficients[order], 0)
+}
+
+func (this *LPCPredictor) SetCoefficients(coefs []int, shift uint) error {
+	if len(coefs) > MAX_LPC_ORDER {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid predictor order: %d (must be at most %d)",
+			len(coefs), MAX_LPC_ORDER)
+	}
+
+	if shift > MAX_LPC_SHIFT {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid coefficient shift: %d (must be at most %d)",
+			shift, MAX_LPC_SHIFT)
+	}
+
+	limit := 1 << (MAX_LPC_PRECISION - 1)
+
+	for _, c := range coefs {
+		if c < -limit || c >= limit {
+			return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid coefficient: %d (must fit in %d bits)",
+				c, MAX_LPC_PRECISION)
+		}
+	}
+
+	this.coefs = append(this.coefs[:0], coefs...)
+	this.shift = shift
+	return nil
+}
+
+func (this *LPCPredictor) Coefficients() []int {
+	return this.coefs
+}
+
+func (this *LPCPredictor) Shift() uint {
+	return this.shift
+}
+
+func (this *LPCPredictor) Order() int {
+	return len(this.coefs)
+}
+
+// Return the number of bits (with sign) required by the largest coefficient
+func (this *LPCPredictor) Precision() uint {
+	precision := uint(1)
+
+	for _, c := range this.coefs {
+		for c < -(1<<(precision-1)) || c >= 1<<(precision-1) {
+			precision++
+		}
+	}
+
+	return precision
+}
+
+func (this *LPCPredictor) check(src, dst []int) error {
+	if src == nil || dst == nil {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null buffer")
+	}
+
+	if len(dst) < len(src) {
+		return kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
+	}
+
+	if len(src) < len(this.coefs) {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Not enough samples: %d (order %d)", len(src), len(this.coefs))
+	}
+
+	return nil
+}
+
+func (this *LPCPredictor) Forward(src, dst []int) (uint, uint, error) {
+	if err := this.check(src, dst); err != nil {
+		return 0, 0, err
+	}
+
+	if kanzi.SameIntSlices(src, dst, false) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
+	}
+
+	order := len(this.coefs)
+	copy(dst, src[0:order])
+
+	for i := order; i < len(src); i++ {
+		sum := 0
+		prev := src[i-order : i]
+
+		for j, c := range this.coefs {
+			sum += c * prev[order-1-j]
+		}
+
+		dst[i] = src[i] - sum>>this.shift
+	}
+
+	return uint(len(src)), uint(len(src)), nil
+}
+
+func (this *LPCPredictor) Inverse(src, dst []int) (uint, uint, error) {
+	if err := this.check(src, dst); err != nil {
+		return 0, 0, err
+	}
+
+	order := len(this.coefs)
+	copy(dst, src[0:order])
+
+	for i := order; i < len(src); i++ {
+		sum := 0
+		prev := dst[i-order : i]
+
+		for j, c := range this.coefs {
+			sum += c * prev[order-1-j]
+		}
+
+		dst[i] = src[i] + sum>>this.shift
+	}
+
+	return uint(len(src)), uint(len(src)), nil
+}
+
+func (this LPCPredictor) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
+
+// Compute the linear prediction coefficients of all the orders up to
+// maxOrder (Tukey window, autocorrelation and Levinson-Durbin recursion).
+// Return nil if the signal is null.
+func ComputeLPC(samples []int, maxOrder int) [][]float64 {
+	n := len(samples)
+
+	if maxOrder >= n {
+		maxOrder = n - 1
+	}
+
+	if maxOrder < 1 {
+		return nil
+	}
+
+	// Tukey window (cosine tapered edges, half of the samples)
+	data := make([]float64, n)
+	taper := n / 4
+
+	for i, s := range samples {
+		w := 1.0
+
+		if i < taper {
+			w = 0.5 * (1 - math.Cos(math.Pi*float64(i)/float64(taper)))
+		} else if i >= n-taper {
+			w = 0.5 * (1 - math.Cos(math.Pi*float64(n-1-i)/float64(taper)))
+		}
+
+		data[i] = float64(s) * w
+	}
+
+	autoc := make([]float64, maxOrder+1)
+
+	for lag := range autoc {
+		sum := 0.0
+
+		for i := lag; i < n; i++ {
+			sum += data[i] * data[i-lag]
+		}
+
+		autoc[lag] = sum
+	}
+
+	if autoc[0] == 0 {
+		return nil
+	}
+
+	res := make([][]float64, maxOrder)
+	lpc := make([]float64, maxOrder)
+	prev := make([]float64, maxOrder)
+	err := autoc[0]
+
+	for i := 0; i < maxOrder; i++ {
+		acc := autoc[i+1]
+
+		for j := 0; j < i; j++ {
+			acc -= lpc[j] * autoc[i-j]
+		}
+
+		k := acc / err
+		copy(prev, lpc[0:i])
+
+		for j := 0; j < i; j++ {
+			lpc[j] = prev[j] - k*prev[i-1-j]
+		}
+
+		lpc[i] = k
+		err *= 1 - k*k
+		res[i] = append([]float64(nil), lpc[0:i+1]...)
+
+		if err <= 0 {
+			// Perfect prediction: no higher order
+			return res[0 : i+1]
+		}
+	}
+
+	return res
+}
+
+// Quantize the coefficients with the given precision (bits with sign).
+// Return the integer coefficients and the shift.
+func QuantizeLPC(lpc []float64, precision uint) ([]int, uint) {
+	res := make([]int, len(lpc))
+	maxCoef := 0.0
+
+	for _, c := range lpc {
+		maxCoef = math.Max(maxCoef, math.Abs(c))
+	}
+
+	if maxCoef == 0 {
+		return res, 0
+	}
+
+	limit := 1<<(precision-1) - 1
+	_, exp := math.Frexp(maxCoef) // maxCoef < 2^exp
+	shift := int(precision) - 1 - exp
+
+	if shift > MAX_LPC_SHIFT {
+		shift = MAX_LPC_SHIFT
+	} else if shift < 0 {
+		shift = 0
+	}
+
+	// Round with error feedback
+	scale := float64(int(1) << uint(shift))
+	err := 0.0
+
+	for i, c := range lpc {
+		val := c*scale + err
+		q := int(math.Round(val))
+
+		if q > limit {
+			q = limit
+		} else if q < -limit {
+			q = -limit
+		}
+
+		res[i] = q
+		err = val - float64(q)
+	}
+
+	return res, uint(shift)
+}
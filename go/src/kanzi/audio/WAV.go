@@ -0,0 +1,261 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"kanzi"
+)
+
+// Errors wrap kanzi.ErrInvalidParam (invalid audio), kanzi.ErrUnsupported
+// (unsupported WAV variant) or kanzi.ErrCorruptData (invalid WAV data). The
+// errors of the underlying readers and writers are returned as is.
+
+const (
+	MAX_AUDIO_CHANNELS = 8
+	MAX_SAMPLE_RATE    = 1 << 20
+
+	wavFormatPCM        = 1
+	wavFormatExtensible = 0xFFFE
+)
+
+// PCM audio: one slice of samples per channel. The samples are signed
+// (8 bit WAV samples are unsigned in the files).
+type Audio struct {
+	SampleRate    int
+	BitsPerSample int // 8, 16, 24 or 32
+	Samples       [][]int
+}
+
+func NewAudio(channels, bitsPerSample, sampleRate, length int) (*Audio, error) {
+	this := &Audio{SampleRate: sampleRate, BitsPerSample: bitsPerSample, Samples: make([][]int, channels)}
+
+	for c := range this.Samples {
+		this.Samples[c] = make([]int, length)
+	}
+
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+func (this *Audio) Channels() int {
+	return len(this.Samples)
+}
+
+// Return the number of samples per channel
+func (this *Audio) Length() int {
+	if len(this.Samples) == 0 {
+		return 0
+	}
+
+	return len(this.Samples[0])
+}
+
+func (this *Audio) check() error {
+	if len(this.Samples) < 1 || len(this.Samples) > MAX_AUDIO_CHANNELS {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of channels: %d (must be in [1..%d])",
+			len(this.Samples), MAX_AUDIO_CHANNELS)
+	}
+
+	if bps := this.BitsPerSample; bps != 8 && bps != 16 && bps != 24 && bps != 32 {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of bits per sample: %d (must be 8, 16, 24 or 32)", bps)
+	}
+
+	if this.SampleRate < 1 || this.SampleRate > MAX_SAMPLE_RATE {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid sample rate: %d", this.SampleRate)
+	}
+
+	for c := range this.Samples {
+		if len(this.Samples[c]) != len(this.Samples[0]) {
+			return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid audio: the channels have different lengths")
+		}
+	}
+
+	return nil
+}
+
+// Read a PCM WAV file (the chunks other than 'fmt ' and 'data' are skipped)
+func ReadWAV(r io.Reader) (*Audio, error) {
+	br := bufio.NewReader(r)
+	var header [12]byte
+
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV header: %v", err)
+	}
+
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV header: not a RIFF/WAVE file")
+	}
+
+	var format []byte
+
+	for {
+		var chunk [8]byte
+
+		if _, err := io.ReadFull(br, chunk[:]); err != nil {
+			return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV data: missing data chunk (%v)", err)
+		}
+
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			if size < 16 || size > 1024 {
+				return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV format chunk size: %d", size)
+			}
+
+			format = make([]byte, size+size&1)
+
+			if _, err := io.ReadFull(br, format); err != nil {
+				return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV format chunk: %v", err)
+			}
+
+		case "data":
+			if format == nil {
+				return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV data: the data chunk precedes the format chunk")
+			}
+
+			return readWAVSamples(br, format, size)
+
+		default:
+			// Skip the chunk (padded to an even size)
+			if _, err := io.CopyN(io.Discard, br, size+size&1); err != nil {
+				return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV chunk: %v", err)
+			}
+		}
+	}
+}
+
+func readWAVSamples(r io.Reader, format []byte, size int64) (*Audio, error) {
+	tag := binary.LittleEndian.Uint16(format[0:])
+	channels := int(binary.LittleEndian.Uint16(format[2:]))
+	sampleRate := int(binary.LittleEndian.Uint32(format[4:]))
+	blockAlign := int(binary.LittleEndian.Uint16(format[12:]))
+	bps := int(binary.LittleEndian.Uint16(format[14:]))
+
+	if tag == wavFormatExtensible && len(format) >= 26 {
+		// The sub format starts with the format tag
+		tag = binary.LittleEndian.Uint16(format[24:])
+	}
+
+	if tag != wavFormatPCM {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported WAV format: %d (only PCM is supported)", tag)
+	}
+
+	if channels < 1 || channels > MAX_AUDIO_CHANNELS || (bps != 8 && bps != 16 && bps != 24 && bps != 32) {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported WAV format: %d channel(s), %d bits per sample",
+			channels, bps)
+	}
+
+	if blockAlign != channels*bps/8 || size%int64(blockAlign) != 0 {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV data: invalid block alignment")
+	}
+
+	data := make([]byte, size)
+
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV data: %v", err)
+	}
+
+	length := int(size) / blockAlign
+	audio, err := NewAudio(channels, bps, sampleRate, length)
+
+	if err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid WAV data: %v", err)
+	}
+
+	width := bps / 8
+	n := 0
+
+	for i := 0; i < length; i++ {
+		for c := 0; c < channels; c++ {
+			audio.Samples[c][i] = decodeSample(data[n:n+width], bps)
+			n += width
+		}
+	}
+
+	return audio, nil
+}
+
+// Little endian samples, unsigned for 8 bits and signed otherwise
+func decodeSample(buf []byte, bps int) int {
+	switch bps {
+	case 8:
+		return int(buf[0]) - 128
+
+	case 16:
+		return int(int16(binary.LittleEndian.Uint16(buf)))
+
+	case 24:
+		return int(int32(uint32(buf[0])<<8|uint32(buf[1])<<16|uint32(buf[2])<<24) >> 8)
+
+	default:
+		return int(int32(binary.LittleEndian.Uint32(buf)))
+	}
+}
+
+// Write a canonical PCM WAV file (format chunk and data chunk only)
+func WriteWAV(w io.Writer, audio *Audio) error {
+	if err := audio.check(); err != nil {
+		return err
+	}
+
+	channels := audio.Channels()
+	width := audio.BitsPerSample / 8
+	size := audio.Length() * channels * width
+
+	if int64(size)+36 > 0xFFFFFFFF {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid audio: too many samples for a WAV file")
+	}
+
+	buf := make([]byte, 44+size)
+	copy(buf[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(36+size))
+	copy(buf[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)
+	binary.LittleEndian.PutUint16(buf[20:], wavFormatPCM)
+	binary.LittleEndian.PutUint16(buf[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(buf[24:], uint32(audio.SampleRate))
+	binary.LittleEndian.PutUint32(buf[28:], uint32(audio.SampleRate*channels*width))
+	binary.LittleEndian.PutUint16(buf[32:], uint16(channels*width))
+	binary.LittleEndian.PutUint16(buf[34:], uint16(audio.BitsPerSample))
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(size))
+	n := 44
+
+	for i := 0; i < audio.Length(); i++ {
+		for c := 0; c < channels; c++ {
+			val := audio.Samples[c][i]
+
+			if width == 1 {
+				val += 128
+			}
+
+			for j := 0; j < width; j++ {
+				buf[n] = byte(val >> (8 * uint(j)))
+				n++
+			}
+		}
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
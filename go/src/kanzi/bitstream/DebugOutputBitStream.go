@@ -16,7 +16,6 @@ limitations under the License.
 package bitstream
 
 import (
-	"errors"
 	"fmt"
 	"io"
 	"kanzi"
@@ -34,11 +33,11 @@ type DebugOutputBitStream struct {
 
 func NewDebugOutputBitStream(obs kanzi.OutputBitStream, writer io.Writer) (*DebugOutputBitStream, error) {
 	if obs == nil {
-		return nil, errors.New("The delegate cannot be null")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The delegate cannot be null")
 	}
 
 	if writer == nil {
-		return nil, errors.New("The writer cannot be null")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The writer cannot be null")
 	}
 
 	this := new(DebugOutputBitStream)
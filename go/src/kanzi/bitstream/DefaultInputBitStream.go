@@ -16,11 +16,17 @@ limitations under the License.
 package bitstream
 
 import (
-	"errors"
-	"fmt"
+	"io"
 	"kanzi"
 )
 
+// Errors returned (or raised with panic) by the bitstreams wrap one of the
+// sentinel errors of the kanzi package:
+// - kanzi.ErrInvalidParam: invalid argument (null stream, buffer size, bit count)
+// - kanzi.ErrClosed: the bitstream is closed
+// - kanzi.ErrCorruptData: more bits are read than the input stream contains
+// - kanzi.ErrIO: the underlying stream failed (its error is wrapped)
+
 type DefaultInputBitStream struct {
 	closed      bool
 	read        uint64
@@ -34,19 +40,19 @@ type DefaultInputBitStream struct {
 
 func NewDefaultInputBitStream(stream kanzi.InputStream, bufferSize uint) (*DefaultInputBitStream, error) {
 	if stream == nil {
-		return nil, errors.New("Invalid null input stream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null input stream parameter")
 	}
 
 	if bufferSize < 1024 {
-		return nil, errors.New("Invalid buffer size parameter (must be at least 1024 bytes)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid buffer size parameter (must be at least 1024 bytes)")
 	}
 
 	if bufferSize > 1<<29 {
-		return nil, errors.New("Invalid buffer size parameter (must be at most 536870912 bytes)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid buffer size parameter (must be at most 536870912 bytes)")
 	}
 
 	if bufferSize&7 != 0 {
-		return nil, errors.New("Invalid buffer size (must be a multiple of 8)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid buffer size (must be a multiple of 8)")
 	}
 
 	this := new(DefaultInputBitStream)
@@ -70,7 +76,7 @@ func (this *DefaultInputBitStream) ReadBit() int {
 
 func (this *DefaultInputBitStream) ReadBits(count uint) uint64 {
 	if count == 0 || count > 64 {
-		panic(fmt.Errorf("Invalid count: %v (must be in [1..64])", count))
+		panic(kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid count: %v (must be in [1..64])", count))
 	}
 
 	var res uint64
@@ -81,6 +87,16 @@ func (this *DefaultInputBitStream) ReadBits(count uint) uint64 {
 
 		if this.bitIndex == 63 {
 			this.pullCurrent()
+
+			if count > this.bitIndex+1 {
+				// Short read from the underlying stream: consume all the bits
+				// available and fetch the rest from the next read
+				avail := this.bitIndex + 1
+				res = this.current & (0xFFFFFFFFFFFFFFFF >> (64 - avail))
+				this.bitIndex = 63
+				return (res << (count - avail)) | this.ReadBits(count-avail)
+			}
+
 			shift += (this.bitIndex - 63) // adjust if bitIndex != 63 (end of stream)
 		}
 
@@ -90,8 +106,24 @@ func (this *DefaultInputBitStream) ReadBits(count uint) uint64 {
 		// Not enough spots available in 'current'
 		remaining := count - this.bitIndex - 1
 		res = this.current & (0xFFFFFFFFFFFFFFFF >> (63 - this.bitIndex))
-		res <<= remaining
 		this.pullCurrent()
+
+		if remaining >= this.bitIndex+1 {
+			// Short read from the underlying stream: consume all the bits
+			// available and fetch the rest (if any) from the next read
+			avail := this.bitIndex + 1
+			res = (res << avail) | (this.current & (0xFFFFFFFFFFFFFFFF >> (64 - avail)))
+			this.bitIndex = 63
+			remaining -= avail
+
+			if remaining == 0 {
+				return res
+			}
+
+			return (res << remaining) | this.ReadBits(remaining)
+		}
+
+		res <<= remaining
 		this.bitIndex -= remaining
 		res |= (this.current >> (this.bitIndex + 1))
 	}
@@ -101,7 +133,7 @@ func (this *DefaultInputBitStream) ReadBits(count uint) uint64 {
 
 func (this *DefaultInputBitStream) readFromInputStream(count int) (int, error) {
 	if this.Closed() {
-		return 0, errors.New("Stream closed")
+		return 0, kanzi.Errorf(kanzi.ErrClosed, "Stream closed")
 	}
 
 	this.read += uint64((this.maxPosition + 1) << 3)
@@ -114,12 +146,18 @@ func (this *DefaultInputBitStream) readFromInputStream(count int) (int, error) {
 		this.maxPosition = size - 1
 	}
 
-	if err != nil {
-		return size, err
+	// Some readers return the last bytes along with io.EOF
+	if err != nil && (err != io.EOF || size <= 0) {
+		if err == io.EOF {
+			// More data is expected: the stream is truncated
+			return size, kanzi.Errorf(kanzi.ErrCorruptData, "%w", err)
+		}
+
+		return size, wrapIOError(err)
 	}
 
 	if size <= 0 {
-		return size, errors.New("No more data to read in the bitstream")
+		return size, kanzi.Errorf(kanzi.ErrCorruptData, "No more data to read in the bitstream")
 	}
 
 	return size, nil
@@ -127,7 +165,7 @@ func (this *DefaultInputBitStream) readFromInputStream(count int) (int, error) {
 
 func (this *DefaultInputBitStream) HasMoreToRead() (bool, error) {
 	if this.Closed() {
-		return false, errors.New("Stream closed")
+		return false, kanzi.Errorf(kanzi.ErrClosed, "Stream closed")
 	}
 
 	if this.position < this.maxPosition || this.bitIndex != 63 {
@@ -182,20 +220,52 @@ func (this *DefaultInputBitStream) Close() (bool, error) {
 	}
 
 	this.closed = true
-	this.read += uint64(63)
+	this.read -= uint64((this.bitIndex + 1) & 63) // adjust for method Read()
 
 	// Reset fields to force a readFromInputStream() and trigger an error
 	// on ReadBit() or ReadBits()
 	this.bitIndex = 63
 	this.maxPosition = -1
-	return true, this.is.Close()
+	if err := this.is.Close(); err != nil {
+		return true, wrapIOError(err)
+	}
+
+	return true, nil
+}
+
+// Make the bitstream read from another input stream, as if it was a new
+// bitstream. The buffer is reused and the pending bits are discarded.
+func (this *DefaultInputBitStream) Reset(stream kanzi.InputStream) error {
+	if stream == nil {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null input stream parameter")
+	}
+
+	this.is = stream
+	this.closed = false
+	this.read = 0
+	this.position = 0
+	this.bitIndex = 63
+	this.maxPosition = -1
+	this.current = 0
+	return nil
 }
 
 // Return number of bits read so far
 func (this *DefaultInputBitStream) Read() uint64 {
-	return this.read + uint64(this.position)<<3 - uint64(this.bitIndex)
+	// bitIndex = 63 means that all the bits in 'current' have been consumed
+	return this.read + uint64(this.position)<<3 - uint64((this.bitIndex+1)&63)
 }
 
 func (this *DefaultInputBitStream) Closed() bool {
 	return this.closed
 }
+
+// Wrap an error of the underlying stream with kanzi.ErrIO (unless it already
+// wraps one of the sentinel errors)
+func wrapIOError(err error) error {
+	if kanzi.Kind(err) != nil {
+		return err
+	}
+
+	return kanzi.Errorf(kanzi.ErrIO, "%w", err)
+}
@@ -16,8 +16,6 @@ limitations under the License.
 package bitstream
 
 import (
-	"errors"
-	"fmt"
 	"kanzi"
 )
 
@@ -33,19 +31,19 @@ type DefaultOutputBitStream struct {
 
 func NewDefaultOutputBitStream(stream kanzi.OutputStream, bufferSize uint) (*DefaultOutputBitStream, error) {
 	if stream == nil {
-		return nil, errors.New("Invalid null output stream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null output stream parameter")
 	}
 
 	if bufferSize < 1024 {
-		return nil, errors.New("Invalid buffer size parameter (must be at least 1024 bytes)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid buffer size parameter (must be at least 1024 bytes)")
 	}
 
 	if bufferSize > 1<<29 {
-		return nil, errors.New("Invalid buffer size parameter (must be at most 536870912 bytes)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid buffer size parameter (must be at most 536870912 bytes)")
 	}
 
 	if bufferSize&7 != 0 {
-		return nil, errors.New("Invalid buffer size (must be a multiple of 8)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid buffer size (must be a multiple of 8)")
 	}
 
 	this := new(DefaultOutputBitStream)
@@ -75,7 +73,7 @@ func (this *DefaultOutputBitStream) WriteBits(value uint64, count uint) uint {
 	}
 
 	if count > 64 {
-		panic(fmt.Errorf("Invalid length: %v (must be in [1..64])", count))
+		panic(kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid length: %v (must be in [1..64])", count))
 	}
 
 	value &= (0xFFFFFFFFFFFFFFFF >> (64 - count))
@@ -125,12 +123,12 @@ func (this *DefaultOutputBitStream) pushCurrent() {
 // Write buffer into underlying stream
 func (this *DefaultOutputBitStream) flush() error {
 	if this.Closed() {
-		return errors.New("Stream closed")
+		return kanzi.Errorf(kanzi.ErrClosed, "Stream closed")
 	}
 
 	if this.position > 0 {
 		if _, err := this.os.Write(this.buffer[0:this.position]); err != nil {
-			return err
+			return wrapIOError(err)
 		}
 
 		this.written += (uint64(this.position) << 3)
@@ -140,6 +138,39 @@ func (this *DefaultOutputBitStream) flush() error {
 	return nil
 }
 
+// Pad the stream with zero bits up to the next byte boundary and write all
+// pending bytes to the underlying stream.
+func (this *DefaultOutputBitStream) Flush() error {
+	if this.Closed() {
+		return kanzi.Errorf(kanzi.ErrClosed, "Stream closed")
+	}
+
+	savedBitIndex := this.bitIndex
+	savedPosition := this.position
+	savedCurrent := this.current
+
+	// Move the bytes of 'current' (the last one may be incomplete) to the buffer
+	size := int((63-this.bitIndex)+7) >> 3
+
+	for i := 0; i < size; i++ {
+		this.buffer[this.position+i] = byte(this.current >> uint(56-8*i))
+	}
+
+	this.position += size
+	this.bitIndex = 63
+	this.current = 0
+
+	if err := this.flush(); err != nil {
+		// Revert fields to allow subsequent attempts in case of transient failure
+		this.bitIndex = savedBitIndex
+		this.position = savedPosition
+		this.current = savedCurrent
+		return err
+	}
+
+	return nil
+}
+
 func (this *DefaultOutputBitStream) Close() (bool, error) {
 	if this.Closed() {
 		return true, nil
@@ -163,7 +194,7 @@ func (this *DefaultOutputBitStream) Close() (bool, error) {
 	}
 
 	if err := this.os.Close(); err != nil {
-		return false, err
+		return false, wrapIOError(err)
 	}
 
 	this.closed = true
@@ -172,11 +203,28 @@ func (this *DefaultOutputBitStream) Close() (bool, error) {
 	// Reset fields to force a flush() and trigger an error
 	// on WriteBit() or WriteBits()
 	this.bitIndex = -1
-	this.buffer = make([]byte, 8)
+	this.buffer = this.buffer[0:8]
 	this.written -= 64 // adjust for method Written()
 	return true, nil
 }
 
+// Make the bitstream write to another output stream, as if it was a new
+// bitstream. The buffer is reused and the pending bits are discarded.
+func (this *DefaultOutputBitStream) Reset(stream kanzi.OutputStream) error {
+	if stream == nil {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null output stream parameter")
+	}
+
+	this.os = stream
+	this.buffer = this.buffer[0:cap(this.buffer)]
+	this.closed = false
+	this.written = 0
+	this.position = 0
+	this.bitIndex = 63
+	this.current = 0
+	return nil
+}
+
 // Return number of bits written so far
 func (this *DefaultOutputBitStream) Written() uint64 {
 	// Number of bits flushed + bytes written in memory + bits written in memory
@@ -16,8 +16,6 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
-	"fmt"
 	"kanzi"
 )
 
@@ -51,11 +49,11 @@ type ANSRangeEncoder struct {
 // The default chunk size is 65536 bytes.
 func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if len(args) > 2 {
-		return nil, errors.New("At most one chunk size and one log range can be provided")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "At most one chunk size and one log range can be provided")
 	}
 
 	chkSize := DEFAULT_ANS_CHUNK_SIZE
@@ -67,15 +65,15 @@ func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncode
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at least 1024")
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at most 2^30")
 	}
 
 	if logRange < 8 || logRange > 16 {
-		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..16])", logRange)
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid range parameter: %v (must be in [8..16])", logRange)
 	}
 
 	this := new(ANSRangeEncoder)
@@ -93,7 +91,7 @@ func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncode
 
 func (this *ANSRangeEncoder) updateFrequencies(frequencies []int, size int, lr uint) (int, error) {
 	if frequencies == nil || len(frequencies) != 256 {
-		return 0, errors.New("Invalid frequencies parameter")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid frequencies parameter")
 	}
 
 	alphabetSize, err := this.eu.NormalizeFrequencies(frequencies, this.alphabet, size, 1<<lr)
@@ -168,7 +166,7 @@ func (this *ANSRangeEncoder) encodeHeader(alphabetSize int, alphabet []byte, fre
 // Dynamically compute the frequencies for every chunk of data in the block
 func (this *ANSRangeEncoder) Encode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null block parameter")
 	}
 
 	if len(block) == 0 {
@@ -255,6 +253,11 @@ func (this *ANSRangeEncoder) Encode(block []byte) (int, error) {
 func (this *ANSRangeEncoder) Dispose() {
 }
 
+// Implement kanzi.Resettable interface. The statistics are computed for
+// each chunk, so the tables and buffer are just kept.
+func (this *ANSRangeEncoder) Reset() {
+}
+
 func (this *ANSRangeEncoder) BitStream() kanzi.OutputBitStream {
 	return this.bitstream
 }
@@ -276,11 +279,11 @@ type ANSRangeDecoder struct {
 // The default chunk size is 65536 bytes.
 func NewANSRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*ANSRangeDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if len(args) > 1 {
-		return nil, errors.New("At most one chunk size can be provided")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "At most one chunk size can be provided")
 	}
 
 	chkSize := DEFAULT_ANS_CHUNK_SIZE
@@ -290,11 +293,11 @@ func NewANSRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*ANSRangeDecoder
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at least 1024")
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at most 2^30")
 	}
 
 	this := new(ANSRangeDecoder)
@@ -349,7 +352,7 @@ func (this *ANSRangeDecoder) decodeHeader(frequencies []int) (int, uint, error)
 			val := int(this.bitstream.ReadBits(logMax))
 
 			if val <= 0 || val >= scale {
-				error := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", val, this.alphabet[j])
+				error := kanzi.Errorf(kanzi.ErrCorruptData, "Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", val, this.alphabet[j])
 				return alphabetSize, logRange, error
 			}
 
@@ -362,7 +365,7 @@ func (this *ANSRangeDecoder) decodeHeader(frequencies []int) (int, uint, error)
 	frequencies[this.alphabet[0]] = scale - sum
 
 	if frequencies[this.alphabet[0]] <= 0 || frequencies[this.alphabet[0]] > 1<<logRange {
-		error := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", frequencies[this.alphabet[0]], this.alphabet[0])
+		error := kanzi.Errorf(kanzi.ErrCorruptData, "Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", frequencies[this.alphabet[0]], this.alphabet[0])
 		return alphabetSize, logRange, error
 	}
 
@@ -386,7 +389,7 @@ func (this *ANSRangeDecoder) decodeHeader(frequencies []int) (int, uint, error)
 
 func (this *ANSRangeDecoder) Decode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null block parameter")
 	}
 
 	if len(block) == 0 {
@@ -445,3 +448,8 @@ func (this *ANSRangeDecoder) BitStream() kanzi.InputBitStream {
 
 func (this *ANSRangeDecoder) Dispose() {
 }
+
+// Implement kanzi.Resettable interface. The statistics are read for each
+// chunk, so the tables are just kept.
+func (this *ANSRangeDecoder) Reset() {
+}
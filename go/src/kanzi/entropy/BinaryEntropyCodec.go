@@ -16,7 +16,6 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
 	"kanzi"
 )
 
@@ -46,11 +45,11 @@ type BinaryEntropyEncoder struct {
 
 func NewBinaryEntropyEncoder(bs kanzi.OutputBitStream, predictor Predictor) (*BinaryEntropyEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if predictor == nil {
-		return nil, errors.New("Invalid null predictor parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null predictor parameter")
 	}
 
 	this := new(BinaryEntropyEncoder)
@@ -91,6 +90,12 @@ func (this *BinaryEntropyEncoder) encodeBit(bit byte) {
 	}
 }
 
+// Encode one bit. Used by the coders that set the context of the predictor
+// before each bit (EG. the bit plane coder of the image package).
+func (this *BinaryEntropyEncoder) EncodeBit(bit byte) {
+	this.encodeBit(bit & 1)
+}
+
 func (this *BinaryEntropyEncoder) Encode(block []byte) (int, error) {
 	for i := range block {
 		this.encodeByte(block[i])
@@ -118,6 +123,14 @@ func (this *BinaryEntropyEncoder) Dispose() {
 	this.bitstream.WriteBits(this.low|MASK_0_24, 56)
 }
 
+// Implement kanzi.Resettable interface (the predictor must be resettable)
+func (this *BinaryEntropyEncoder) Reset() {
+	this.low = 0
+	this.high = BINARY_ENTROPY_TOP
+	this.disposed = false
+	this.predictor.(kanzi.Resettable).Reset()
+}
+
 type BinaryEntropyDecoder struct {
 	predictor   Predictor
 	low         uint64
@@ -129,11 +142,11 @@ type BinaryEntropyDecoder struct {
 
 func NewBinaryEntropyDecoder(bs kanzi.InputBitStream, predictor Predictor) (*BinaryEntropyDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if predictor == nil {
-		return nil, errors.New("Invalid null predictor parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null predictor parameter")
 	}
 
 	// Defer stream reading. We are creating the object, we should not do any I/O
@@ -194,6 +207,15 @@ func (this *BinaryEntropyDecoder) decodeBit() byte {
 	return bit
 }
 
+// Decode one bit (see BinaryEntropyEncoder.EncodeBit)
+func (this *BinaryEntropyDecoder) DecodeBit() byte {
+	if this.initialized == false {
+		this.Initialize()
+	}
+
+	return this.decodeBit()
+}
+
 func (this *BinaryEntropyDecoder) read() {
 	this.low = this.low << 32
 	this.high = (this.high << 32) | MASK_0_32
@@ -222,3 +244,12 @@ func (this *BinaryEntropyDecoder) BitStream() kanzi.InputBitStream {
 
 func (this *BinaryEntropyDecoder) Dispose() {
 }
+
+// Implement kanzi.Resettable interface (the predictor must be resettable)
+func (this *BinaryEntropyDecoder) Reset() {
+	this.low = 0
+	this.high = BINARY_ENTROPY_TOP
+	this.current = 0
+	this.initialized = false
+	this.predictor.(kanzi.Resettable).Reset()
+}
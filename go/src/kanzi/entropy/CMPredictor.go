@@ -37,10 +37,6 @@ type CMPredictor struct {
 
 func NewCMPredictor() (*CMPredictor, error) {
 	this := new(CMPredictor)
-	this.bpos = 7
-	this.ctx = 1
-	this.run = 1
-	this.idx = 8
 	this.counter0 = make([]int, 256)
 	this.counter1 = make([][]int, 256)
 	this.counter2 = make([][][]int, 2)
@@ -48,10 +44,26 @@ func NewCMPredictor() (*CMPredictor, error) {
 	this.counter2[1] = make([][]int, 256)
 
 	for i := 0; i < 256; i++ {
-		this.counter0[i] = 32768
 		this.counter1[i] = make([]int, 256)
 		this.counter2[0][i] = make([]int, 17)
 		this.counter2[1][i] = make([]int, 17)
+	}
+
+	this.Reset()
+	return this, nil
+}
+
+// Implement kanzi.Resettable interface
+func (this *CMPredictor) Reset() {
+	this.c1 = 0
+	this.c2 = 0
+	this.bpos = 7
+	this.ctx = 1
+	this.run = 1
+	this.idx = 8
+
+	for i := 0; i < 256; i++ {
+		this.counter0[i] = 32768
 
 		for j := 0; j < 256; j++ {
 			this.counter1[i][j] = 32768
@@ -65,8 +77,6 @@ func NewCMPredictor() (*CMPredictor, error) {
 		this.counter2[0][i][16] = 15 << 12
 		this.counter2[1][i][16] = 15 << 12
 	}
-
-	return this, nil
 }
 
 // Update the probability model
@@ -16,11 +16,17 @@ limitations under the License.
 package entropy
 
 import (
-	"fmt"
 	"kanzi"
 	"strings"
 )
 
+// Errors returned (or raised with panic while coding) by the entropy codecs
+// wrap one of the sentinel errors of the kanzi package:
+// - kanzi.ErrInvalidParam: invalid argument (null bitstream, chunk size, ...)
+// - kanzi.ErrUnsupported: unknown codec type or name, symbol that cannot be coded
+// - kanzi.ErrCorruptData: invalid frequencies or codes found by a decoder
+// The errors of the bitstreams (EG. kanzi.ErrIO) are propagated.
+
 const (
 	NONE_TYPE    = byte(0) // No compression
 	HUFFMAN_TYPE = byte(1) // Huffman
@@ -59,7 +65,7 @@ func NewEntropyDecoder(ibs kanzi.InputBitStream, entropyType byte) (kanzi.Entrop
 		return NewNullEntropyDecoder(ibs)
 
 	default:
-		return nil, fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType)
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported entropy codec type: '%c'", entropyType)
 	}
 }
 
@@ -91,7 +97,7 @@ func NewEntropyEncoder(obs kanzi.OutputBitStream, entropyType byte) (kanzi.Entro
 		return NewNullEntropyEncoder(obs)
 
 	default:
-		return nil, fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType)
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported entropy codec type: '%c'", entropyType)
 	}
 }
 
@@ -120,7 +126,7 @@ func GetEntropyCodecName(entropyType byte) string {
 		return "NONE"
 
 	default:
-		panic(fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType))
+		panic(kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported entropy codec type: '%c'", entropyType))
 	}
 }
 
@@ -149,6 +155,6 @@ func GetEntropyCodecType(entropyName string) byte {
 		return NONE_TYPE
 
 	default:
-		panic(fmt.Errorf("Unsupported entropy codec type: '%s'", entropyName))
+		panic(kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported entropy codec type: '%s'", entropyName))
 	}
 }
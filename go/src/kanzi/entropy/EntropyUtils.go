@@ -17,7 +17,6 @@ package entropy
 
 import (
 	"container/heap"
-	"fmt"
 	"kanzi"
 )
 
@@ -111,6 +110,8 @@ func (this *FreqSortPriorityQueue) Pop() interface{} {
 type EntropyUtils struct {
 	ranks  []byte
 	errors []int
+	data   []FreqSortData // reused to normalize the frequencies of each chunk
+	queue  FreqSortPriorityQueue
 }
 
 func NewEntropyUtils() (*EntropyUtils, error) {
@@ -316,7 +317,7 @@ func (this *EntropyUtils) NormalizeFrequencies(freqs []int, alphabet []byte, cou
 	}
 
 	if scale < 1<<8 || scale > 1<<16 {
-		return 0, fmt.Errorf("Invalid range parameter: %v (must be in [256..65536])", scale)
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid range parameter: %v (must be in [256..65536])", scale)
 	}
 
 	alphabetSize := 0
@@ -398,18 +399,24 @@ func (this *EntropyUtils) NormalizeFrequencies(freqs []int, alphabet []byte, cou
 			inc = 1
 		}
 
-		queue := make(FreqSortPriorityQueue, 0)
+		if len(this.data) < 256 {
+			this.data = make([]FreqSortData, 256)
+		}
+
+		this.queue = this.queue[:0]
+		queue := &this.queue
 
 		// Create sorted queue of present symbols (except those with 'quantum frequency')
 		for i := 0; i < alphabetSize; i++ {
 			if errors[alphabet[i]] >= 0 {
-				heap.Push(&queue, &FreqSortData{errors: errors, frequencies: freqs, symbol: alphabet[i]})
+				this.data[i] = FreqSortData{errors: errors, frequencies: freqs, symbol: alphabet[i]}
+				heap.Push(queue, &this.data[i])
 			}
 		}
 
-		for sum != 0 && len(queue) > 0 {
+		for sum != 0 && len(*queue) > 0 {
 			// Remove symbol with highest error
-			fsd := heap.Pop(&queue).(*FreqSortData)
+			fsd := heap.Pop(queue).(*FreqSortData)
 
 			// Do not zero out any frequency
 			if freqs[fsd.symbol] == -inc {
@@ -420,7 +427,7 @@ func (this *EntropyUtils) NormalizeFrequencies(freqs []int, alphabet []byte, cou
 			freqs[fsd.symbol] += inc
 			errors[fsd.symbol] -= scale
 			sum += inc
-			heap.Push(&queue, fsd)
+			heap.Push(queue, fsd)
 		}
 	}
 
@@ -16,8 +16,8 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
 	"kanzi"
+	"math/bits"
 )
 
 type ExpGolombEncoder struct {
@@ -30,7 +30,7 @@ type ExpGolombEncoder struct {
 // Example: -1 is better compressed as int8 (1 followed by -) than as byte (-1 & 255 = 255)
 func NewExpGolombEncoder(bs kanzi.OutputBitStream, sgn bool) (*ExpGolombEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	this := new(ExpGolombEncoder)
@@ -98,6 +98,38 @@ func (this *ExpGolombEncoder) EncodeByte(val byte) {
 	this.bitstream.WriteBits(emit, n)
 }
 
+// Encode a 64 bit integer: magnitude (as for the bytes) then sign if the
+// encoder is signed and the value not 0.
+func (this *ExpGolombEncoder) EncodeInt(val int) {
+	emit := uint64(val)
+
+	if this.signed == true && val < 0 {
+		emit = uint64(-val)
+	}
+
+	emit++
+
+	if emit == 0 {
+		// Unsigned value 2^64-1: 64 zeros then the 65 bits of 2^64
+		this.bitstream.WriteBits(0, 64)
+		this.bitstream.WriteBit(1)
+		this.bitstream.WriteBits(0, 64)
+	} else {
+		n := uint(bits.Len64(emit))
+
+		// Leading zeros (at most 63), written in 32 bit chunks
+		for z := n - 1; z > 0; z -= min(z, 32) {
+			this.bitstream.WriteBits(0, min(z, 32))
+		}
+
+		this.bitstream.WriteBits(emit, n)
+	}
+
+	if this.signed == true && val != 0 {
+		this.bitstream.WriteBit(int(uint64(val) >> 63))
+	}
+}
+
 func (this *ExpGolombEncoder) BitStream() kanzi.OutputBitStream {
 	return this.bitstream
 }
@@ -118,7 +150,7 @@ type ExpGolombDecoder struct {
 // If sgn is true, the extracted value is treated as an int8
 func NewExpGolombDecoder(bs kanzi.InputBitStream, sgn bool) (*ExpGolombDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	this := new(ExpGolombDecoder)
@@ -168,6 +200,36 @@ func (this *ExpGolombDecoder) DecodeByte() byte {
 	return byte((1 << log2) - 1 + val)
 }
 
+// Decode an integer written by ExpGolombEncoder.EncodeInt
+func (this *ExpGolombDecoder) DecodeInt() int {
+	log2 := uint(0)
+
+	for this.bitstream.ReadBit() == 0 {
+		if log2++; log2 > 64 {
+			panic(kanzi.Errorf(kanzi.ErrCorruptData, "Invalid Exp-Golomb code"))
+		}
+	}
+
+	var res uint64
+
+	if log2 == 64 {
+		// 2^64 + suffix - 1
+		res = this.bitstream.ReadBits(64) - 1
+	} else {
+		res = 1<<log2 - 1
+
+		if log2 > 0 {
+			res += this.bitstream.ReadBits(log2)
+		}
+	}
+
+	if this.signed == true && res != 0 && this.bitstream.ReadBit() == 1 {
+		return -int(res)
+	}
+
+	return int(res)
+}
+
 func (this *ExpGolombDecoder) BitStream() kanzi.InputBitStream {
 	return this.bitstream
 }
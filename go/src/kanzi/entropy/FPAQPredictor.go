@@ -29,12 +29,21 @@ type FPAQPredictor struct {
 
 func NewFPAQPredictor() (*FPAQPredictor, error) {
 	this := new(FPAQPredictor)
-	this.ctxIdx = 2
 	this.states = make([]uint, 512)
-	this.prediction = 2048
+	this.Reset()
 	return this, nil
 }
 
+// Implement kanzi.Resettable interface
+func (this *FPAQPredictor) Reset() {
+	this.ctxIdx = 2
+	this.prediction = 2048
+
+	for i := range this.states {
+		this.states[i] = 0
+	}
+}
+
 // Update the probability model
 func (this *FPAQPredictor) Update(bit byte) {
 	// Find the number of registered 0 & 1 given the previous bits (in this.ctxIdx)
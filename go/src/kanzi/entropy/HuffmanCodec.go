@@ -17,8 +17,6 @@ package entropy
 
 import (
 	"container/heap"
-	"errors"
-	"fmt"
 	"kanzi"
 	"sort"
 )
@@ -111,8 +109,11 @@ func (this *HuffmanPriorityQueue) Pop() interface{} {
 	return node
 }
 
-// Return the number of codes generated
-func generateCanonicalCodes(sizes []byte, codes []uint, ranks []byte) int {
+// Assign canonical codes to the symbols in ranks, given their code sizes:
+// the ranks are sorted by increasing size then increasing symbol and the
+// codes are consecutive integers in this order (as in JPEG or DEFLATE).
+// Return the number of codes generated (-1 if a size is larger than 24).
+func GenerateCanonicalCodes(sizes []byte, codes []uint, ranks []byte) int {
 	count := len(ranks)
 
 	// Sort by increasing size (first key) and increasing value (second key)
@@ -152,6 +153,9 @@ type HuffmanEncoder struct {
 	sizes     []byte
 	ranks     []byte
 	chunkSize int
+	nodes     []HuffmanNode // reused to build the tree of each chunk
+	queue     HuffmanPriorityQueue
+	egenc     *ExpGolombEncoder
 }
 
 // The chunk size indicates how many bytes are encoded (per block) before
@@ -162,11 +166,11 @@ type HuffmanEncoder struct {
 // The default chunk size is 65536 bytes.
 func NewHuffmanEncoder(bs kanzi.OutputBitStream, args ...uint) (*HuffmanEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if len(args) > 1 {
-		return nil, errors.New("At most one chunk size can be provided")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "At most one chunk size can be provided")
 	}
 
 	chkSize := DEFAULT_HUFFMAN_CHUNK_SIZE
@@ -176,11 +180,11 @@ func NewHuffmanEncoder(bs kanzi.OutputBitStream, args ...uint) (*HuffmanEncoder,
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at least 1024")
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at most 2^30")
 	}
 
 	this := new(HuffmanEncoder)
@@ -190,35 +194,49 @@ func NewHuffmanEncoder(bs kanzi.OutputBitStream, args ...uint) (*HuffmanEncoder,
 	this.sizes = make([]byte, 256)
 	this.ranks = make([]byte, 256)
 	this.chunkSize = int(chkSize)
+	this.nodes = make([]HuffmanNode, 0, 512)
+	this.queue = make(HuffmanPriorityQueue, 0, 256)
+	this.egenc, _ = NewExpGolombEncoder(bs, true)
+	this.Reset()
+	return this, nil
+}
 
+// Implement kanzi.Resettable interface
+func (this *HuffmanEncoder) Reset() {
 	// Default frequencies, sizes and codes
 	for i := 0; i < 256; i++ {
 		this.buffer[i] = 1
 		this.sizes[i] = 8
 		this.codes[i] = uint(i)
 	}
+}
 
-	return this, nil
+// Return a node from the pool (2*256-1 nodes at most per tree)
+func (this *HuffmanEncoder) newNode(symbol byte, weight uint, left, right *HuffmanNode) *HuffmanNode {
+	this.nodes = append(this.nodes, HuffmanNode{symbol: symbol, weight: weight, left: left, right: right})
+	return &this.nodes[len(this.nodes)-1]
 }
 
-func createTreeFromFrequencies(frequencies []uint, sizes_ []byte, ranks []byte) error {
+func (this *HuffmanEncoder) createTreeFromFrequencies(frequencies []uint, sizes_ []byte, ranks []byte) error {
 	// Create Huffman tree of (present) symbols
-	queue := make(HuffmanPriorityQueue, 0)
+	this.nodes = this.nodes[:0]
+	this.queue = this.queue[:0]
+	queue := &this.queue
 
 	for i := range ranks {
-		heap.Push(&queue, &HuffmanNode{symbol: ranks[i], weight: frequencies[ranks[i]]})
+		heap.Push(queue, this.newNode(ranks[i], frequencies[ranks[i]], nil, nil))
 	}
 
 	for queue.Len() > 1 {
 		// Extract 2 minimum nodes, merge them and enqueue result
-		lNode := heap.Pop(&queue).(*HuffmanNode)
-		rNode := heap.Pop(&queue).(*HuffmanNode)
+		lNode := heap.Pop(queue).(*HuffmanNode)
+		rNode := heap.Pop(queue).(*HuffmanNode)
 
 		// Setting the symbol is critical to resolve ties during node sorting !
-		heap.Push(&queue, &HuffmanNode{weight: lNode.weight + rNode.weight, left: lNode, right: rNode, symbol: lNode.symbol})
+		heap.Push(queue, this.newNode(lNode.symbol, lNode.weight+rNode.weight, lNode, rNode))
 	}
 
-	rootNode := heap.Pop(&queue).(*HuffmanNode)
+	rootNode := heap.Pop(queue).(*HuffmanNode)
 	var err error
 
 	if len(ranks) == 1 {
@@ -234,7 +252,7 @@ func createTreeFromFrequencies(frequencies []uint, sizes_ []byte, ranks []byte)
 func fillSizes(node *HuffmanNode, depth uint, sizes_ []byte) error {
 	if node.left == nil && node.right == nil {
 		if depth > 24 {
-			return fmt.Errorf("Cannot code symbol '%v'", node.symbol&0xFF)
+			return kanzi.Errorf(kanzi.ErrUnsupported, "Cannot code symbol '%v'", node.symbol&0xFF)
 		}
 
 		sizes_[node.symbol] = byte(depth)
@@ -255,7 +273,7 @@ func fillSizes(node *HuffmanNode, depth uint, sizes_ []byte) error {
 // Rebuild Huffman tree
 func (this *HuffmanEncoder) UpdateFrequencies(frequencies []uint) error {
 	if frequencies == nil || len(frequencies) != 256 {
-		return errors.New("Invalid frequencies parameter")
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid frequencies parameter")
 	}
 
 	alphabetSize := 0
@@ -271,7 +289,7 @@ func (this *HuffmanEncoder) UpdateFrequencies(frequencies []uint) error {
 	}
 
 	// Create tree from frequencies
-	err := createTreeFromFrequencies(frequencies, this.sizes, this.ranks[0:alphabetSize])
+	err := this.createTreeFromFrequencies(frequencies, this.sizes, this.ranks[0:alphabetSize])
 
 	if err != nil {
 		return err
@@ -281,12 +299,7 @@ func (this *HuffmanEncoder) UpdateFrequencies(frequencies []uint) error {
 
 	// Transmit code lengths only, frequencies and codes do not matter
 	// Unary encode the length difference
-	egenc, err := NewExpGolombEncoder(this.bitstream, true)
-
-	if err != nil {
-		return err
-	}
-
+	egenc := this.egenc
 	prevSize := byte(2)
 
 	for i := 0; i < alphabetSize; i++ {
@@ -296,7 +309,7 @@ func (this *HuffmanEncoder) UpdateFrequencies(frequencies []uint) error {
 	}
 
 	// Create canonical codes (reorders ranks)
-	generateCanonicalCodes(this.sizes, this.codes, this.ranks[0:alphabetSize])
+	GenerateCanonicalCodes(this.sizes, this.codes, this.ranks[0:alphabetSize])
 
 	// Pack size and code (size <= 24 bits)
 	for i := 0; i < alphabetSize; i++ {
@@ -310,7 +323,7 @@ func (this *HuffmanEncoder) UpdateFrequencies(frequencies []uint) error {
 // Dynamically compute the frequencies for every chunk of data in the block
 func (this *HuffmanEncoder) Encode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null block parameter")
 	}
 
 	if len(block) == 0 {
@@ -376,6 +389,7 @@ type HuffmanDecoder struct {
 	state      uint64 // holds bits read from bitstream
 	bits       uint   // hold number of unused bits in 'state'
 	minCodeLen int8
+	egdec      *ExpGolombDecoder
 }
 
 // The chunk size indicates how many bytes are encoded (per block) before
@@ -386,11 +400,11 @@ type HuffmanDecoder struct {
 // The default chunk size is 65536 bytes.
 func NewHuffmanDecoder(bs kanzi.InputBitStream, args ...uint) (*HuffmanDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if len(args) > 1 {
-		return nil, errors.New("At most one chunk size can be provided")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "At most one chunk size can be provided")
 	}
 
 	chkSize := DEFAULT_HUFFMAN_CHUNK_SIZE
@@ -400,11 +414,11 @@ func NewHuffmanDecoder(bs kanzi.InputBitStream, args ...uint) (*HuffmanDecoder,
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at least 1024")
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at most 2^30")
 	}
 
 	this := new(HuffmanDecoder)
@@ -416,6 +430,15 @@ func NewHuffmanDecoder(bs kanzi.InputBitStream, args ...uint) (*HuffmanDecoder,
 	this.sdTable = make([]uint, 256)
 	this.sdtIndexes = make([]int, 24)
 	this.chunkSize = int(chkSize)
+	this.egdec, _ = NewExpGolombDecoder(bs, true)
+	this.Reset()
+	return this, nil
+}
+
+// Implement kanzi.Resettable interface
+func (this *HuffmanDecoder) Reset() {
+	this.state = 0
+	this.bits = 0
 	this.minCodeLen = 8
 
 	// Default lengths & canonical codes
@@ -423,8 +446,6 @@ func NewHuffmanDecoder(bs kanzi.InputBitStream, args ...uint) (*HuffmanDecoder,
 		this.sizes[i] = 8
 		this.codes[i] = uint(i)
 	}
-
-	return this, nil
 }
 
 func (this *HuffmanDecoder) ReadLengths() (int, error) {
@@ -434,12 +455,7 @@ func (this *HuffmanDecoder) ReadLengths() (int, error) {
 		return 0, err
 	}
 
-	egdec, err := NewExpGolombDecoder(this.bitstream, true)
-
-	if err != nil {
-		return 0, err
-	}
-
+	egdec := this.egdec
 	var currSize int8
 	this.minCodeLen = 24 // max code length
 	prevSize := int8(2)
@@ -451,12 +467,12 @@ func (this *HuffmanDecoder) ReadLengths() (int, error) {
 		currSize = int8(egdec.DecodeByte()) + prevSize
 
 		if currSize < 0 {
-			return 0, fmt.Errorf("Invalid bitstream: incorrect size %v for Huffman symbol %v", currSize, i)
+			return 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid bitstream: incorrect size %v for Huffman symbol %v", currSize, i)
 		}
 
 		if currSize != 0 {
 			if currSize > 24 {
-				return 0, fmt.Errorf("Invalid bitstream: incorrect size %v for Huffman symbol %v", currSize, i)
+				return 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid bitstream: incorrect size %v for Huffman symbol %v", currSize, i)
 			}
 
 			if this.minCodeLen > currSize {
@@ -473,7 +489,7 @@ func (this *HuffmanDecoder) ReadLengths() (int, error) {
 	}
 
 	// Create canonical codes
-	generateCanonicalCodes(this.sizes, this.codes, this.ranks[0:count])
+	GenerateCanonicalCodes(this.sizes, this.codes, this.ranks[0:count])
 
 	// Build decoding tables
 	this.buildDecodingTables(count)
@@ -535,7 +551,7 @@ func (this *HuffmanDecoder) buildDecodingTables(count int) {
 // Use fastDecodeByte until the near end of chunk or block.
 func (this *HuffmanDecoder) Decode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null block parameter")
 	}
 
 	if len(block) == 0 {
@@ -615,7 +631,7 @@ func (this *HuffmanDecoder) slowDecodeByte(code int, codeLen uint) byte {
 		}
 	}
 
-	panic(errors.New("Invalid bitstream: incorrect Huffman code"))
+	panic(kanzi.Errorf(kanzi.ErrCorruptData, "Invalid bitstream: incorrect Huffman code"))
 }
 
 // 64 bits must be available in the bitstream
@@ -64,6 +64,10 @@ func (this *NullEntropyEncoder) BitStream() kanzi.OutputBitStream {
 func (this *NullEntropyEncoder) Dispose() {
 }
 
+// Implement kanzi.Resettable interface (stateless)
+func (this *NullEntropyEncoder) Reset() {
+}
+
 type NullEntropyDecoder struct {
 	bitstream kanzi.InputBitStream
 }
@@ -106,3 +110,7 @@ func (this *NullEntropyDecoder) BitStream() kanzi.InputBitStream {
 
 func (this *NullEntropyDecoder) Dispose() {
 }
+
+// Implement kanzi.Resettable interface (stateless)
+func (this *NullEntropyDecoder) Reset() {
+}
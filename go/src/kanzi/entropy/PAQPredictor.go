@@ -258,6 +258,25 @@ func NewPAQPredictor() (*PAQPredictor, error) {
 	return this, err
 }
 
+// Implement kanzi.Resettable interface
+func (this *PAQPredictor) Reset() {
+	this.pr = 2048
+	this.c0 = 1
+	this.c4 = 0
+	this.bpos = 7
+	this.run = 0
+	this.runCtx = 0
+
+	for i := range this.states {
+		this.states[i] = 0
+	}
+
+	this.sm.reset()
+	this.apm2.reset()
+	this.apm3.reset()
+	this.apm4.reset()
+}
+
 // Update the probability model
 func (this *PAQPredictor) Update(bit byte) {
 	y := int(bit)
@@ -366,10 +385,15 @@ func initStateMapData() []int {
 func newStateMap() (*StateMap, error) {
 	this := new(StateMap)
 	this.data = make([]int, 256)
-	copy(this.data, STATEMAP_DATA)
+	this.reset()
 	return this, nil
 }
 
+func (this *StateMap) reset() {
+	this.ctx = 0
+	copy(this.data, STATEMAP_DATA)
+}
+
 func (this *StateMap) get(bit int, cx int) int {
 	this.data[this.ctx] += (((bit << 16) - this.data[this.ctx] + 128) >> 8)
 	this.ctx = cx
@@ -394,21 +418,20 @@ type AdaptiveProbMap struct {
 func newAdaptiveProbMap(n uint) (*AdaptiveProbMap, error) {
 	this := new(AdaptiveProbMap)
 	this.data = make([]int, n*33)
-	k := 0
-
-	for i := uint(0); i < n; i++ {
-		for j := 0; j < 33; j++ {
-			if i == 0 {
-				this.data[k+j] = int(squash((j-16)<<7) << 4)
-			} else {
-				this.data[k+j] = this.data[j]
-			}
-		}
+	this.reset()
+	return this, nil
+}
+
+func (this *AdaptiveProbMap) reset() {
+	this.index = 0
 
-		k += 33
+	for j := 0; j < 33; j++ {
+		this.data[j] = int(squash((j-16)<<7) << 4)
 	}
 
-	return this, nil
+	for k := 33; k < len(this.data); k += 33 {
+		copy(this.data[k:k+33], this.data[0:33])
+	}
 }
 
 func (this *AdaptiveProbMap) get(bit int, pr int, ctx uint, rate uint) int {
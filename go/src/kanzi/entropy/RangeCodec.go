@@ -16,8 +16,6 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
-	"fmt"
 	"kanzi"
 )
 
@@ -50,11 +48,11 @@ type RangeEncoder struct {
 // The default chunk size is 65536 bytes.
 func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if len(args) > 2 {
-		return nil, errors.New("At most one chunk size and one log range can be provided")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "At most one chunk size and one log range can be provided")
 	}
 
 	chkSize := DEFAULT_RANGE_CHUNK_SIZE
@@ -66,15 +64,15 @@ func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, err
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at least 1024")
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at most 2^30")
 	}
 
 	if logRange < 8 || logRange > 16 {
-		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..16])", logRange)
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid range parameter: %v (must be in [8..16])", logRange)
 	}
 
 	this := new(RangeEncoder)
@@ -91,7 +89,7 @@ func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, err
 
 func (this *RangeEncoder) updateFrequencies(frequencies []int, size int, lr uint) (int, error) {
 	if frequencies == nil || len(frequencies) != 256 {
-		return 0, errors.New("Invalid frequencies parameter")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid frequencies parameter")
 	}
 
 	alphabetSize, err := this.eu.NormalizeFrequencies(frequencies, this.alphabet, size, 1<<lr)
@@ -169,7 +167,7 @@ func (this *RangeEncoder) encodeHeader(alphabetSize int, alphabet []byte, freque
 
 func (this *RangeEncoder) Encode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null block parameter")
 	}
 
 	if len(block) == 0 {
@@ -261,6 +259,13 @@ func (this *RangeEncoder) BitStream() kanzi.OutputBitStream {
 func (this *RangeEncoder) Dispose() {
 }
 
+// Implement kanzi.Resettable interface. The statistics are computed for
+// each chunk, so the tables are just kept.
+func (this *RangeEncoder) Reset() {
+	this.low = 0
+	this.range_ = TOP_RANGE
+}
+
 type RangeDecoder struct {
 	code      uint64
 	low       uint64
@@ -282,11 +287,11 @@ type RangeDecoder struct {
 // The default chunk size is 65536 bytes.
 func NewRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*RangeDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if len(args) > 1 {
-		return nil, errors.New("At most one chunk size can be provided")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "At most one chunk size can be provided")
 	}
 
 	chkSize := DEFAULT_RANGE_CHUNK_SIZE
@@ -296,11 +301,11 @@ func NewRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*RangeDecoder, erro
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at least 1024")
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The chunk size must be at most 2^30")
 	}
 
 	this := new(RangeDecoder)
@@ -354,7 +359,7 @@ func (this *RangeDecoder) decodeHeader(frequencies []int) (int, uint, error) {
 			val := int(this.bitstream.ReadBits(logMax))
 
 			if val <= 0 || val >= 1<<logRange {
-				error := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", val, this.alphabet[j])
+				error := kanzi.Errorf(kanzi.ErrCorruptData, "Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", val, this.alphabet[j])
 				return alphabetSize, logRange, error
 			}
 
@@ -367,7 +372,7 @@ func (this *RangeDecoder) decodeHeader(frequencies []int) (int, uint, error) {
 	frequencies[this.alphabet[0]] = (1 << logRange) - sum
 
 	if frequencies[this.alphabet[0]] <= 0 || frequencies[this.alphabet[0]] > 1<<logRange {
-		error := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", frequencies[this.alphabet[0]], this.alphabet[0])
+		error := kanzi.Errorf(kanzi.ErrCorruptData, "Invalid bitstream: incorrect frequency %v  for symbol '%v' in ANS range decoder", frequencies[this.alphabet[0]], this.alphabet[0])
 		return alphabetSize, logRange, error
 	}
 
@@ -394,7 +399,7 @@ func (this *RangeDecoder) decodeHeader(frequencies []int) (int, uint, error) {
 // Reset frequency stats for each chunk of data in the block
 func (this *RangeDecoder) Decode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null block parameter")
 	}
 
 	end := len(block)
@@ -466,3 +471,11 @@ func (this *RangeDecoder) BitStream() kanzi.InputBitStream {
 
 func (this *RangeDecoder) Dispose() {
 }
+
+// Implement kanzi.Resettable interface. The statistics are read for each
+// chunk, so the tables are just kept.
+func (this *RangeDecoder) Reset() {
+	this.code = 0
+	this.low = 0
+	this.range_ = TOP_RANGE
+}
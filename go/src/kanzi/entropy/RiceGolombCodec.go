@@ -16,8 +16,6 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
-	"fmt"
 	"kanzi"
 )
 
@@ -33,11 +31,11 @@ type RiceGolombEncoder struct {
 // Example: -1 is better compressed as int8 (1 followed by -) than as byte (-1 & 255 = 255)
 func NewRiceGolombEncoder(bs kanzi.OutputBitStream, sgn bool, logBase uint) (*RiceGolombEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if logBase <= 0 || logBase >= 8 {
-		return nil, fmt.Errorf("Invalid logBase '%v' value (must be in [1..7])", logBase)
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid logBase '%v' value (must be in [1..7])", logBase)
 	}
 
 	this := new(RiceGolombEncoder)
@@ -83,6 +81,46 @@ func (this *RiceGolombEncoder) EncodeByte(val byte) {
 	this.bitstream.WriteBits(emit, n)
 }
 
+// Change the Rice parameter. The integer values (see EncodeInt) can use
+// parameters in [0..30], the bytes parameters in [1..7].
+func (this *RiceGolombEncoder) SetLogBase(logBase uint) error {
+	if logBase > 30 {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid logBase '%v' value (must be in [0..30])", logBase)
+	}
+
+	this.logBase = uint64(logBase)
+	this.base = uint64(1 << logBase)
+	return nil
+}
+
+// Encode an integer: magnitude (unary quotient, binary remainder) then sign
+// if the encoder is signed and the value not 0.
+func (this *RiceGolombEncoder) EncodeInt(val int) {
+	emit := uint64(val)
+
+	if val < 0 {
+		emit = uint64(-val)
+	}
+
+	q := emit >> this.logBase
+
+	// Long quotients are written in chunks (at most 64 bits per write)
+	for q > 32 {
+		this.bitstream.WriteBits(0, 32)
+		q -= 32
+	}
+
+	this.bitstream.WriteBits(1, uint(q+1))
+
+	if this.logBase > 0 {
+		this.bitstream.WriteBits(emit&(this.base-1), uint(this.logBase))
+	}
+
+	if this.signed == true && val != 0 {
+		this.bitstream.WriteBit(int(uint(val) >> 63))
+	}
+}
+
 func (this *RiceGolombEncoder) BitStream() kanzi.OutputBitStream {
 	return this.bitstream
 }
@@ -104,11 +142,11 @@ type RiceGolombDecoder struct {
 // If sgn is true, the extracted value is treated as an int8
 func NewRiceGolombDecoder(bs kanzi.InputBitStream, sgn bool, logBase uint) (*RiceGolombDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
 	}
 
 	if logBase <= 0 || logBase >= 8 {
-		return nil, errors.New("Invalid logBase value (must be in [1..7])")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid logBase value (must be in [1..7])")
 	}
 
 	this := new(RiceGolombDecoder)
@@ -146,6 +184,39 @@ func (this *RiceGolombDecoder) DecodeByte() byte {
 	return byte(res)
 }
 
+// Change the Rice parameter (see RiceGolombEncoder.SetLogBase)
+func (this *RiceGolombDecoder) SetLogBase(logBase uint) error {
+	if logBase > 30 {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid logBase '%v' value (must be in [0..30])", logBase)
+	}
+
+	this.logBase = logBase
+	return nil
+}
+
+// Decode an integer written by RiceGolombEncoder.EncodeInt
+func (this *RiceGolombDecoder) DecodeInt() int {
+	q := 0
+
+	// quotient is unary encoded
+	for this.bitstream.ReadBit() == 0 {
+		q++
+	}
+
+	res := q << this.logBase
+
+	// remainder is binary encoded
+	if this.logBase > 0 {
+		res |= int(this.bitstream.ReadBits(this.logBase))
+	}
+
+	if res != 0 && this.signed == true && this.bitstream.ReadBit() == 1 {
+		return -res
+	}
+
+	return res
+}
+
 func (this *RiceGolombDecoder) BitStream() kanzi.InputBitStream {
 	return this.bitstream
 }
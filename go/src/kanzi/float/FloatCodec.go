@@ -0,0 +1,304 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package float
+
+import (
+	"kanzi"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// Lossless codecs of float64 values, working on the bits of the values (the
+// output is bit exact, NaN payloads included).
+// GORILLA_PREDICTOR: the value is XORed with the previous one. A zero XOR is
+// coded with a 0 bit. Otherwise, a 1 bit is followed either by a 0 bit and
+// the meaningful bits of the XOR, if they fit in the window (leading and
+// trailing zeros) of the previous XOR, or by a 1 bit, the number of leading
+// zeros (5 bits), the number of meaningful bits minus one (6 bits) and the
+// meaningful bits (new window).
+// FPC_PREDICTOR: 2 predictions are computed from hash tables: FCM (value that
+// followed the same recent history of values) and DFCM (previous value plus
+// the delta that followed the same recent history of deltas). The value is
+// XORed with the best prediction and coded as a selector (1 bit), the number
+// of leading zero bytes of the XOR (4 bits) and the remaining bytes.
+
+const (
+	GORILLA_PREDICTOR      = 0
+	FPC_PREDICTOR          = 1
+	DEFAULT_FPC_TABLE_BITS = 16
+	MAX_FPC_TABLE_BITS     = 24
+)
+
+// Return the predictor matching the name (case insensitive)
+func GetPredictorType(name string) (int, error) {
+	switch strings.ToUpper(name) {
+	case "GORILLA":
+		return GORILLA_PREDICTOR, nil
+
+	case "FPC":
+		return FPC_PREDICTOR, nil
+
+	default:
+		return -1, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported float predictor: '%s'", name)
+	}
+}
+
+func GetPredictorName(predictor int) string {
+	switch predictor {
+	case GORILLA_PREDICTOR:
+		return "GORILLA"
+
+	case FPC_PREDICTOR:
+		return "FPC"
+
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// State of the predictors, identical in the encoder and the decoder
+type floatPredictor struct {
+	predictor int
+	first     bool
+	prev      uint64 // previous value
+	leading   uint   // window of the previous XOR (Gorilla)
+	trailing  uint
+	fcm       []uint64
+	dfcm      []uint64
+	fcmHash   uint64
+	dfcmHash  uint64
+	mask      uint64
+}
+
+func newFloatPredictor(predictor int, tableBits uint) (*floatPredictor, error) {
+	if predictor != GORILLA_PREDICTOR && predictor != FPC_PREDICTOR {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported float predictor: %d", predictor)
+	}
+
+	this := &floatPredictor{predictor: predictor, first: true, leading: 64}
+
+	if predictor == FPC_PREDICTOR {
+		if tableBits < 1 || tableBits > MAX_FPC_TABLE_BITS {
+			return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid FPC table size: %d bits (must be in [1..%d])",
+				tableBits, MAX_FPC_TABLE_BITS)
+		}
+
+		this.fcm = make([]uint64, 1<<tableBits)
+		this.dfcm = make([]uint64, 1<<tableBits)
+		this.mask = 1<<tableBits - 1
+	}
+
+	return this, nil
+}
+
+// Return the FCM and DFCM predictions
+func (this *floatPredictor) predictions() (uint64, uint64) {
+	return this.fcm[this.fcmHash], this.dfcm[this.dfcmHash] + this.prev
+}
+
+func (this *floatPredictor) update(val uint64) {
+	if this.predictor == FPC_PREDICTOR {
+		delta := val - this.prev
+		this.fcm[this.fcmHash] = val
+		this.fcmHash = ((this.fcmHash << 6) ^ (val >> 48)) & this.mask
+		this.dfcm[this.dfcmHash] = delta
+		this.dfcmHash = ((this.dfcmHash << 2) ^ (delta >> 40)) & this.mask
+	}
+
+	this.prev = val
+	this.first = false
+}
+
+type FloatEncoder struct {
+	bitstream kanzi.OutputBitStream
+	state     *floatPredictor
+}
+
+// Create an encoder writing to the bitstream. The table size (log2) is only
+// used by the FPC predictor.
+func NewFloatEncoder(bs kanzi.OutputBitStream, predictor int, tableBits uint) (*FloatEncoder, error) {
+	if bs == nil {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
+	}
+
+	state, err := newFloatPredictor(predictor, tableBits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FloatEncoder{bitstream: bs, state: state}, nil
+}
+
+func (this *FloatEncoder) BitStream() kanzi.OutputBitStream {
+	return this.bitstream
+}
+
+func (this *FloatEncoder) EncodeFloat(val float64) {
+	this.EncodeBits(math.Float64bits(val))
+}
+
+// Encode the bits of a float64 value (see math.Float64bits)
+func (this *FloatEncoder) EncodeBits(val uint64) {
+	st := this.state
+
+	if st.predictor == FPC_PREDICTOR {
+		fcm, dfcm := st.predictions()
+		xor, selector := val^fcm, 0
+
+		if x := val ^ dfcm; x < xor {
+			// More leading zeros
+			xor, selector = x, 1
+		}
+
+		zeroBytes := uint(bits.LeadingZeros64(xor)) >> 3
+		this.bitstream.WriteBits(uint64(selector<<4)|uint64(zeroBytes), 5)
+
+		if zeroBytes < 8 {
+			this.bitstream.WriteBits(xor, 64-8*zeroBytes)
+		}
+
+		st.update(val)
+		return
+	}
+
+	if st.first == true {
+		this.bitstream.WriteBits(val, 64)
+		st.update(val)
+		return
+	}
+
+	xor := val ^ st.prev
+	st.update(val)
+
+	if xor == 0 {
+		this.bitstream.WriteBit(0)
+		return
+	}
+
+	leading := min(uint(bits.LeadingZeros64(xor)), 31)
+	trailing := uint(bits.TrailingZeros64(xor))
+
+	if leading >= st.leading && trailing >= st.trailing {
+		// Same window as the previous XOR
+		this.bitstream.WriteBits(2, 2)
+		this.bitstream.WriteBits(xor>>st.trailing, 64-st.leading-st.trailing)
+		return
+	}
+
+	size := 64 - leading - trailing
+	this.bitstream.WriteBits(3, 2)
+	this.bitstream.WriteBits(uint64(leading<<6|(size-1)), 11)
+	this.bitstream.WriteBits(xor>>trailing, size)
+	st.leading, st.trailing = leading, trailing
+}
+
+func (this *FloatEncoder) Encode(values []float64) {
+	for _, v := range values {
+		this.EncodeBits(math.Float64bits(v))
+	}
+}
+
+type FloatDecoder struct {
+	bitstream kanzi.InputBitStream
+	state     *floatPredictor
+}
+
+// Create a decoder with the parameters of the encoder
+func NewFloatDecoder(bs kanzi.InputBitStream, predictor int, tableBits uint) (*FloatDecoder, error) {
+	if bs == nil {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null bitstream parameter")
+	}
+
+	state, err := newFloatPredictor(predictor, tableBits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FloatDecoder{bitstream: bs, state: state}, nil
+}
+
+func (this *FloatDecoder) BitStream() kanzi.InputBitStream {
+	return this.bitstream
+}
+
+func (this *FloatDecoder) DecodeFloat() float64 {
+	return math.Float64frombits(this.DecodeBits())
+}
+
+// Decode the bits of a float64 value (see math.Float64frombits)
+func (this *FloatDecoder) DecodeBits() uint64 {
+	st := this.state
+	var val uint64
+
+	if st.predictor == FPC_PREDICTOR {
+		header := uint(this.bitstream.ReadBits(5))
+		zeroBytes := header & 0x0F
+
+		if zeroBytes > 8 {
+			panic(kanzi.Errorf(kanzi.ErrCorruptData, "Invalid float data: %d leading zero bytes", zeroBytes))
+		}
+
+		if zeroBytes < 8 {
+			val = this.bitstream.ReadBits(64 - 8*zeroBytes)
+		}
+
+		fcm, dfcm := st.predictions()
+
+		if header>>4 == 0 {
+			val ^= fcm
+		} else {
+			val ^= dfcm
+		}
+
+		st.update(val)
+		return val
+	}
+
+	if st.first == true {
+		val = this.bitstream.ReadBits(64)
+		st.update(val)
+		return val
+	}
+
+	val = st.prev
+
+	if this.bitstream.ReadBit() == 1 {
+		if this.bitstream.ReadBit() == 1 {
+			header := uint(this.bitstream.ReadBits(11))
+			st.leading, st.trailing = header>>6, 64-header>>6-(header&0x3F+1)
+
+			if st.trailing > 63 {
+				panic(kanzi.Errorf(kanzi.ErrCorruptData, "Invalid float data: invalid window"))
+			}
+		} else if st.leading == 64 {
+			panic(kanzi.Errorf(kanzi.ErrCorruptData, "Invalid float data: no previous window"))
+		}
+
+		val ^= this.bitstream.ReadBits(64-st.leading-st.trailing) << st.trailing
+	}
+
+	st.update(val)
+	return val
+}
+
+func (this *FloatDecoder) Decode(values []float64) {
+	for i := range values {
+		values[i] = math.Float64frombits(this.DecodeBits())
+	}
+}
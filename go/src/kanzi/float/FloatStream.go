@@ -0,0 +1,441 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package float
+
+import (
+	"bytes"
+	"io"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/integer"
+)
+
+// Streams of float64 values and time series (timestamps and values).
+// Format of a float stream (big endian): magic (32 bits), version (8 bits),
+// predictor (4 bits), FPC table size (5 bits), then chunks of values: number
+// of values (16 bits), coded values. A chunk of 0 values ends the stream.
+// The state of the predictors is kept from one chunk to the next one.
+// The timestamps of a time series are written to a companion stream, an
+// integer column with delta-of-delta coding.
+
+const (
+	FLOAT_MAGIC         = 0x4B464C54 // "KFLT"
+	FLOAT_VERSION       = 1
+	MAX_FLOAT_CHUNK     = 65535
+	TIMESTAMP_TRANSFORM = "DELTA2+ZIGZAG"
+	TIMESTAMP_CODER     = "RICE"
+)
+
+// Options of the float writers. A nil value selects the defaults.
+type Options struct {
+	Predictor string // GORILLA or FPC (default: GORILLA)
+	TableBits uint   // size (log2) of the FPC tables (default: 16)
+}
+
+func (this *Options) withDefaults() Options {
+	var opts Options
+
+	if this != nil {
+		opts = *this
+	}
+
+	if opts.Predictor == "" {
+		opts.Predictor = "GORILLA"
+	}
+
+	if opts.TableBits == 0 {
+		opts.TableBits = DEFAULT_FPC_TABLE_BITS
+	}
+
+	return opts
+}
+
+// Write a stream of float64 values. The underlying stream is closed by Close.
+type FloatWriter struct {
+	obs     kanzi.OutputBitStream
+	encoder *FloatEncoder
+	written uint64
+	closed  bool
+}
+
+func NewFloatWriter(os kanzi.OutputStream, opts *Options) (*FloatWriter, error) {
+	if os == nil {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null output stream parameter")
+	}
+
+	o := opts.withDefaults()
+	predictor, err := GetPredictorType(o.Predictor)
+
+	if err != nil {
+		return nil, err
+	}
+
+	obs, err := bitstream.NewDefaultOutputBitStream(os, 65536)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, err := NewFloatEncoder(obs, predictor, o.TableBits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The header stays in the buffer of the bitstream: no error
+	obs.WriteBits(FLOAT_MAGIC, 32)
+	obs.WriteBits(FLOAT_VERSION, 8)
+	obs.WriteBits(uint64(predictor), 4)
+	obs.WriteBits(uint64(o.TableBits), 5)
+	return &FloatWriter{obs: obs, encoder: encoder}, nil
+}
+
+// Return the number of values written so far
+func (this *FloatWriter) Written() uint64 {
+	return this.written
+}
+
+func (this *FloatWriter) Write(values []float64) (n int, err error) {
+	if this.closed == true {
+		return 0, kanzi.Errorf(kanzi.ErrClosed, "Float writer closed")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = kanzi.Errorf(kanzi.ErrIO, "Cannot write float stream: %v", r)
+		}
+	}()
+
+	for n < len(values) {
+		chunk := values[n:min(n+MAX_FLOAT_CHUNK, len(values))]
+		this.obs.WriteBits(uint64(len(chunk)), 16)
+		this.encoder.Encode(chunk)
+		n += len(chunk)
+		this.written += uint64(len(chunk))
+	}
+
+	return n, nil
+}
+
+// Write the end of the stream and close the underlying stream
+func (this *FloatWriter) Close() (err error) {
+	if this.closed == true {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = kanzi.Errorf(kanzi.ErrIO, "Cannot write float stream: %v", r)
+		}
+	}()
+
+	this.obs.WriteBits(0, 16)
+
+	if _, err = this.obs.Close(); err != nil {
+		return err
+	}
+
+	this.closed = true
+	return nil
+}
+
+// Read a stream written by a FloatWriter
+type FloatReader struct {
+	decoder   *FloatDecoder
+	remaining int // values left in the current chunk
+	eos       bool
+}
+
+func NewFloatReader(is kanzi.InputStream) (this *FloatReader, err error) {
+	if is == nil {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null input stream parameter")
+	}
+
+	ibs, err := bitstream.NewDefaultInputBitStream(is, 65536)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			this = nil
+			err = kanzi.Errorf(kanzi.ErrCorruptData, "Invalid float stream header: %v", r)
+		}
+	}()
+
+	if ibs.ReadBits(32) != FLOAT_MAGIC {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid float stream: invalid magic number")
+	}
+
+	if version := ibs.ReadBits(8); version != FLOAT_VERSION {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported float stream version: %d", version)
+	}
+
+	predictor := int(ibs.ReadBits(4))
+	tableBits := uint(ibs.ReadBits(5))
+	decoder, err := NewFloatDecoder(ibs, predictor, tableBits)
+
+	if err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid float stream header: %v", err)
+	}
+
+	return &FloatReader{decoder: decoder}, nil
+}
+
+// Read values into the slice. Return io.EOF after the last value.
+func (this *FloatReader) Read(values []float64) (n int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = kanzi.Errorf(kanzi.ErrCorruptData, "Invalid float stream: %v", r)
+		}
+	}()
+
+	ibs := this.decoder.BitStream()
+
+	for n < len(values) && this.eos == false {
+		if this.remaining == 0 {
+			if this.remaining = int(ibs.ReadBits(16)); this.remaining == 0 {
+				this.eos = true
+			}
+
+			continue
+		}
+
+		k := min(this.remaining, len(values)-n)
+		this.decoder.Decode(values[n : n+k])
+		this.remaining -= k
+		n += k
+	}
+
+	if n == 0 && len(values) > 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// Write a time series: the values to a float stream and the timestamps to
+// the companion integer column
+type SeriesWriter struct {
+	values     *FloatWriter
+	timestamps *integer.ColumnWriter
+}
+
+func NewSeriesWriter(values, timestamps kanzi.OutputStream, opts *Options) (*SeriesWriter, error) {
+	vw, err := NewFloatWriter(values, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tw, err := integer.NewColumnWriter(timestamps, &integer.Options{Transform: TIMESTAMP_TRANSFORM, Coder: TIMESTAMP_CODER})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SeriesWriter{values: vw, timestamps: tw}, nil
+}
+
+func (this *SeriesWriter) Write(timestamps []int, values []float64) (int, error) {
+	if len(timestamps) != len(values) {
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Different numbers of timestamps and values: %d and %d",
+			len(timestamps), len(values))
+	}
+
+	if _, err := this.timestamps.Write(timestamps); err != nil {
+		return 0, err
+	}
+
+	return this.values.Write(values)
+}
+
+// Close both streams
+func (this *SeriesWriter) Close() error {
+	err := this.timestamps.Close()
+
+	if err2 := this.values.Close(); err == nil {
+		err = err2
+	}
+
+	return err
+}
+
+// Read a time series written by a SeriesWriter
+type SeriesReader struct {
+	values     *FloatReader
+	timestamps *integer.ColumnReader
+}
+
+func NewSeriesReader(values, timestamps kanzi.InputStream) (*SeriesReader, error) {
+	vr, err := NewFloatReader(values)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := integer.NewColumnReader(timestamps)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SeriesReader{values: vr, timestamps: tr}, nil
+}
+
+// Read points into the slices (of the same length). Return io.EOF after the
+// last point.
+func (this *SeriesReader) Read(timestamps []int, values []float64) (int, error) {
+	if len(timestamps) != len(values) {
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Different numbers of timestamps and values: %d and %d",
+			len(timestamps), len(values))
+	}
+
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	n, err := this.timestamps.Read(timestamps)
+
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	m, err2 := this.values.Read(values[0:max(n, 1)])
+
+	if err2 != nil && err2 != io.EOF {
+		return 0, err2
+	}
+
+	if n == 0 && err == io.EOF && err2 == io.EOF {
+		return 0, io.EOF
+	}
+
+	if m != n {
+		return 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid time series: different numbers of timestamps and values")
+	}
+
+	return n, nil
+}
+
+// Compress the values and append the float stream to dst. Return the
+// extended slice (dst is returned unchanged in case of error).
+func CompressFloats(dst []byte, src []float64, opts *Options) ([]byte, error) {
+	bs := &bufferStream{}
+	writer, err := NewFloatWriter(bs, opts)
+
+	if err != nil {
+		return dst, err
+	}
+
+	if _, err = writer.Write(src); err != nil {
+		return dst, err
+	}
+
+	if err = writer.Close(); err != nil {
+		return dst, err
+	}
+
+	return append(dst, bs.Bytes()...), nil
+}
+
+// Decompress the float stream and append the values to dst. Return the
+// extended slice (dst is returned unchanged in case of error).
+func DecompressFloats(dst []float64, src []byte) ([]float64, error) {
+	reader, err := NewFloatReader(&readerStream{bytes.NewReader(src)})
+
+	if err != nil {
+		return dst, err
+	}
+
+	res := dst
+	buf := make([]float64, 4096)
+
+	for {
+		n, err := reader.Read(buf)
+		res = append(res, buf[0:n]...)
+
+		if err == io.EOF {
+			return res, nil
+		}
+
+		if err != nil {
+			return dst, err
+		}
+	}
+}
+
+// Compress a time series: return the float stream of the values and the
+// integer column of the timestamps
+func CompressSeries(timestamps []int, values []float64, opts *Options) ([]byte, []byte, error) {
+	if len(timestamps) != len(values) {
+		return nil, nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Different numbers of timestamps and values: %d and %d",
+			len(timestamps), len(values))
+	}
+
+	valueData, err := CompressFloats(nil, values, opts)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timeData, err := integer.CompressInts(nil, timestamps, &integer.Options{Transform: TIMESTAMP_TRANSFORM,
+		Coder: TIMESTAMP_CODER})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return valueData, timeData, nil
+}
+
+// Decompress a time series compressed by CompressSeries
+func DecompressSeries(valueData, timeData []byte) ([]int, []float64, error) {
+	values, err := DecompressFloats(nil, valueData)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timestamps, err := integer.DecompressInts(nil, timeData)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(timestamps) != len(values) {
+		return nil, nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid time series: different numbers of timestamps and values")
+	}
+
+	return timestamps, values, nil
+}
+
+type bufferStream struct {
+	bytes.Buffer
+}
+
+func (this *bufferStream) Close() error {
+	return nil
+}
+
+type readerStream struct {
+	*bytes.Reader
+}
+
+func (this *readerStream) Close() error {
+	return nil
+}
@@ -16,8 +16,6 @@ limitations under the License.
 package function
 
 import (
-	"errors"
-	"fmt"
 	"kanzi"
 	"kanzi/transform"
 )
@@ -50,6 +48,8 @@ const (
 
 type BWTBlockCodec struct {
 	transform kanzi.ByteTransform
+	gst       kanzi.ByteTransform // reused from block to block
+	zrlt      *ZRLT
 	mode      int
 	size      uint
 	isBWT     bool
@@ -59,19 +59,19 @@ type BWTBlockCodec struct {
 // Transform and ZRLT, else a raw transform is performed.
 func NewBWTBlockCodec(tr interface{}, mode int, blockSize uint) (*BWTBlockCodec, error) {
 	if tr == nil {
-		return nil, errors.New("Invalid null transform parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null transform parameter")
 	}
 
 	if _, isTransform := tr.(kanzi.ByteTransform); isTransform == false {
-		return nil, errors.New("The transform must implement the ByteTransform interface")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The transform must implement the ByteTransform interface")
 	}
 
 	if _, isSizeable := tr.(kanzi.Sizeable); isSizeable == false {
-		return nil, errors.New("The transform must implement the Sizeable interface")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The transform must implement the Sizeable interface")
 	}
 
 	if mode != GST_MODE_RAW && mode != GST_MODE_MTF && mode != GST_MODE_RANK && mode != GST_MODE_TIMESTAMP {
-		return nil, errors.New("Invalid GST mode parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid GST mode parameter")
 	}
 
 	_, isBWT := tr.(*transform.BWT)
@@ -89,24 +89,49 @@ func NewBWTBlockCodec(tr interface{}, mode int, blockSize uint) (*BWTBlockCodec,
 			transformName = "BWTS"
 		}
 
-		errMsg := fmt.Sprintf("The max block size for the %v is %d", transformName, this.maxBlockSize())
-		return nil, errors.New(errMsg)
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The max block size for the %v is %d", transformName, this.maxBlockSize())
 	}
 
 	return this, nil
 }
 
+// Create the GST on first use, then reuse it with the new block size
 func (this *BWTBlockCodec) createGST(blockSize uint) (kanzi.ByteTransform, error) {
 	// SBRT can perform MTFT but the dedicated class is faster
 	if this.mode == GST_MODE_RAW {
 		return nil, nil
 	}
 
+	if this.gst != nil {
+		this.gst.(kanzi.Sizeable).SetSize(blockSize)
+		return this.gst, nil
+	}
+
+	var err error
+
 	if this.mode == GST_MODE_MTF {
-		return transform.NewMTFT(blockSize)
+		this.gst, err = transform.NewMTFT(blockSize)
+	} else {
+		this.gst, err = transform.NewSBRT(this.mode, blockSize)
+	}
+
+	if err != nil {
+		this.gst = nil
+	}
+
+	return this.gst, err
+}
+
+// Create the ZRLT on first use, then reuse it with the new block size
+func (this *BWTBlockCodec) createZRLT(blockSize uint) (*ZRLT, error) {
+	if this.zrlt != nil {
+		this.zrlt.SetSize(blockSize)
+		return this.zrlt, nil
 	}
 
-	return transform.NewSBRT(this.mode, blockSize)
+	var err error
+	this.zrlt, err = NewZRLT(blockSize)
+	return this.zrlt, err
 }
 
 func (this *BWTBlockCodec) maxBlockSize() uint {
@@ -136,15 +161,15 @@ func (this *BWTBlockCodec) SetSize(sz uint) bool {
 // may be modified. If the compression failed, the input data is returned unmodified.
 func (this *BWTBlockCodec) Forward(src, dst []byte) (uint, uint, error) {
 	if src == nil {
-		return 0, 0, errors.New("Input buffer cannot be null")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input buffer cannot be null")
 	}
 
 	if dst == nil {
-		return 0, 0, errors.New("Output buffer cannot be null")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Output buffer cannot be null")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
 	}
 
 	blockSize := this.size
@@ -153,12 +178,10 @@ func (this *BWTBlockCodec) Forward(src, dst []byte) (uint, uint, error) {
 		blockSize = uint(len(src))
 
 		if blockSize > this.maxBlockSize() {
-			errMsg := fmt.Sprintf("Block size is %v, max value is %v", blockSize, this.maxBlockSize())
-			return 0, 0, errors.New(errMsg)
+			return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Block size is %v, max value is %v", blockSize, this.maxBlockSize())
 		}
 	} else if blockSize > uint(len(src)) {
-		errMsg := fmt.Sprintf("Block size is %v, input buffer length is %v", blockSize, len(src))
-		return 0, 0, errors.New(errMsg)
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Block size is %v, input buffer length is %v", blockSize, len(src))
 	}
 
 	this.transform.(kanzi.Sizeable).SetSize(blockSize)
@@ -191,7 +214,7 @@ func (this *BWTBlockCodec) Forward(src, dst []byte) (uint, uint, error) {
 
 		gst.Forward(dst, src)
 
-		if ZRLT, err := NewZRLT(blockSize); err == nil {
+		if ZRLT, err := this.createZRLT(blockSize); err == nil {
 			// Apply Zero Run Length Encoding
 			iIdx, oIdx, err = ZRLT.Forward(src, dst[headerSizeBytes:])
 
@@ -247,7 +270,7 @@ func (this *BWTBlockCodec) Inverse(src, dst []byte) (uint, uint, error) {
 		headerSizeBytes = 1 + ((blockMode >> 6) & 0x03)
 
 		if compressedLength < headerSizeBytes {
-			return 0, 0, errors.New("Invalid compressed length in stream")
+			return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid compressed length in stream")
 		}
 
 		if compressedLength == 0 {
@@ -268,13 +291,12 @@ func (this *BWTBlockCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if blockSize > this.maxBlockSize() {
-		errMsg := fmt.Sprintf("Block size is %v, max value is %v", blockSize, this.maxBlockSize())
-		return 0, 0, errors.New(errMsg)
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Block size is %v, max value is %v", blockSize, this.maxBlockSize())
 	}
 
 	if this.mode != GST_MODE_RAW {
 		// Apply Zero Run Length Decoding
-		ZRLT, err := NewZRLT(compressedLength)
+		ZRLT, err := this.createZRLT(compressedLength)
 
 		if err != nil {
 			return 0, 0, err
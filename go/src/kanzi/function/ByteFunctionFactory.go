@@ -16,12 +16,20 @@ limitations under the License.
 package function
 
 import (
-	"fmt"
 	"kanzi"
 	"kanzi/transform"
+	"strconv"
 	"strings"
 )
 
+// Errors returned (or raised with panic) by the byte functions wrap one of
+// the sentinel errors of the kanzi package:
+// - kanzi.ErrInvalidParam: invalid argument (null or identical buffers, block size, ...)
+// - kanzi.ErrUnsupported: unknown function type or name
+// - kanzi.ErrBufferTooSmall: the output buffer cannot hold the result
+// - kanzi.ErrCorruptData: invalid data found by an inverse function (the
+//   error of the underlying decoder, if any, is wrapped)
+
 const (
 	// Transform: 4 lsb
 	NULL_TRANSFORM_TYPE = byte(0)
@@ -30,8 +38,18 @@ const (
 	LZ4_TYPE            = byte(3)
 	SNAPPY_TYPE         = byte(4)
 	RLT_TYPE            = byte(5)
+	PREDICT_TYPE        = byte(6)
+	SHUFFLE_TYPE        = byte(7)
+	EXE_TYPE            = byte(8)
 
 	// GST: 3 msb
+	// SHUFFLE, EXE: the 3 msb contain the type of the next stage (only the 5
+	// lsb are kept in the stream header: the filters read the next stage
+	// from the blocks)
+	// PREDICT: the image layout can follow the name (PREDICT+W,S,C for the
+	// width in pixels, the stride in bytes, 0 if the rows are not padded, and
+	// the number of channels). It is not part of the type: the predictor
+	// writes the layout in the header of each block.
 )
 
 func NewByteFunction(size uint, functionType byte) (kanzi.ByteFunction, error) {
@@ -46,6 +64,15 @@ func NewByteFunction(size uint, functionType byte) (kanzi.ByteFunction, error) {
 	case RLT_TYPE:
 		return NewRLT(size, 3)
 
+	case PREDICT_TYPE:
+		return NewImagePredictor(size, 0, 0, 0) // guess the image layout
+
+	case SHUFFLE_TYPE:
+		return NewShuffleFilter(size, 0, 0, getFilterStage(functionType)) // guess the element width
+
+	case EXE_TYPE:
+		return NewExeFilter(size, EXE_DETECT, getFilterStage(functionType))
+
 	case BWT_TYPE:
 		bwt, err := transform.NewBWT(size)
 
@@ -68,8 +95,38 @@ func NewByteFunction(size uint, functionType byte) (kanzi.ByteFunction, error) {
 		return NewNullFunction(size)
 
 	default:
-		return nil, fmt.Errorf("Unsupported function type: '%c'", functionType)
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported function type: '%c'", functionType)
+	}
+}
+
+// Create a function of the given type with the parameters found in its name
+// (see GetByteFunctionArgs). The inverse functions do not need them.
+func NewByteFunctionWithArgs(size uint, functionType byte, args []uint) (kanzi.ByteFunction, error) {
+	if functionType&0x0F == PREDICT_TYPE && len(args) == 3 {
+		return NewImagePredictor(size, args[0], args[1], args[2])
+	}
+
+	return NewByteFunction(size, functionType)
+}
+
+// Return the type of the stage after a filter (shuffle, executable). The BWT
+// and BWTS stages use the MTF.
+func getFilterStage(functionType byte) byte {
+	next := functionType >> 5
+
+	if next == BWT_TYPE || next == BWTS_TYPE {
+		next |= GST_MODE_MTF << 4
 	}
+
+	return next
+}
+
+func getFilterStageName(functionType byte) string {
+	if next := getFilterStage(functionType); next != NULL_TRANSFORM_TYPE {
+		return "+" + GetByteFunctionName(next)
+	}
+
+	return ""
 }
 
 func getGSTType(args string) byte {
@@ -90,7 +147,7 @@ func getGSTType(args string) byte {
 		return GST_MODE_RAW
 
 	default:
-		panic(fmt.Errorf("Unknown GST type: '%v'", args))
+		panic(kanzi.Errorf(kanzi.ErrUnsupported, "Unknown GST type: '%v'", args))
 	}
 }
 
@@ -109,7 +166,7 @@ func getGSTName(gstType int) string {
 		return ""
 
 	default:
-		panic(fmt.Errorf("Unknown GST type: '%v'", gstType))
+		panic(kanzi.Errorf(kanzi.ErrUnsupported, "Unknown GST type: '%v'", gstType))
 	}
 }
 
@@ -125,6 +182,15 @@ func GetByteFunctionName(functionType byte) string {
 	case RLT_TYPE:
 		return "RLT"
 
+	case PREDICT_TYPE:
+		return "PREDICT"
+
+	case SHUFFLE_TYPE:
+		return "SHUFFLE" + getFilterStageName(functionType)
+
+	case EXE_TYPE:
+		return "EXE" + getFilterStageName(functionType)
+
 	case BWT_TYPE:
 		gstName := getGSTName(int(functionType) >> 4)
 
@@ -147,14 +213,67 @@ func GetByteFunctionName(functionType byte) string {
 		return "NONE"
 
 	default:
-		panic(fmt.Errorf("Unsupported function type: '%c'", functionType))
+		panic(kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported function type: '%c'", functionType))
+	}
+}
+
+// Return the parameters following the name of the function (the image layout
+// of PREDICT+W,S,C), nil if there are none. Panic if they are invalid.
+func GetByteFunctionArgs(functionName string) []uint {
+	functionName = strings.ToUpper(functionName)
+
+	if strings.HasPrefix(functionName, "PREDICT+") == false {
+		return nil
+	}
+
+	tokens := strings.Split(functionName[len("PREDICT+"):], ",")
+
+	if len(tokens) != 3 {
+		panic(kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid image layout: '%s' (expected PREDICT+W,S,C)", functionName))
+	}
+
+	args := make([]uint, len(tokens))
+
+	for i, token := range tokens {
+		val, err := strconv.ParseUint(strings.TrimSpace(token), 10, 32)
+
+		if err != nil {
+			panic(kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid image layout: '%s' (expected PREDICT+W,S,C)", functionName))
+		}
+
+		args[i] = uint(val)
+	}
+
+	if _, err := NewImagePredictor(0, args[0], args[1], args[2]); err != nil {
+		panic(err)
 	}
+
+	return args
 }
 
 func GetByteFunctionType(functionName string) byte {
 	args := ""
 	functionName = strings.ToUpper(functionName)
 
+	if strings.HasPrefix(functionName, "PREDICT+") {
+		// Check the image layout
+		GetByteFunctionArgs(functionName)
+		return PREDICT_TYPE
+	}
+
+	for _, filter := range []string{"SHUFFLE", "EXE"} {
+		if strings.HasPrefix(functionName, filter+"+") {
+			// The stage after the filter
+			next := GetByteFunctionType(functionName[len(filter)+1:])
+
+			if isFilterStage(next) == false || getFilterStage(next<<5) != next {
+				panic(kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported stage after the filter: '%s'", functionName))
+			}
+
+			return (next << 5) | GetByteFunctionType(filter)
+		}
+	}
+
 	if strings.HasPrefix(functionName, "BWT") {
 		tokens := strings.Split(functionName, "+")
 
@@ -175,6 +294,15 @@ func GetByteFunctionType(functionName string) byte {
 	case "RLT":
 		return RLT_TYPE
 
+	case "PREDICT":
+		return PREDICT_TYPE
+
+	case "SHUFFLE":
+		return SHUFFLE_TYPE
+
+	case "EXE":
+		return EXE_TYPE
+
 	case "BWT":
 		gst := getGSTType(args)
 		return byte((gst << 4) | BWT_TYPE)
@@ -187,6 +315,6 @@ func GetByteFunctionType(functionName string) byte {
 		return NULL_TRANSFORM_TYPE
 
 	default:
-		panic(fmt.Errorf("Unsupported function type: '%s'", functionName))
+		panic(kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported function type: '%s'", functionName))
 	}
 }
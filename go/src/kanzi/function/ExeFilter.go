@@ -0,0 +1,328 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"encoding/binary"
+	"kanzi"
+)
+
+// Filter for executable code: the relative targets of the x86 calls and
+// jumps (E8 and E9 opcodes) and of the ARM64 branches with link (BL) are
+// replaced by absolute targets (from the start of the block). All the calls
+// to a function then share the same bytes, which helps LZ4 and the BWT.
+// The filter is applied only if the block looks like x86 or ARM64 code (see
+// detectCode), else the bytes are copied.
+// x86: as in zpaq, only the 24 lsb of the displacements with a most
+// significant byte of 0x00 or 0xFF (near targets) are converted. No escape
+// is needed: the forward filter runs backwards and the inverse forwards, so
+// that both test the same bytes.
+// ARM64: the 26 bit immediates of the BL instructions (aligned on 4 bytes)
+// are converted.
+// The filter is meant to be followed by another stage (BWT, LZ4, ...): it
+// can run it on the filtered bytes, so that both form a single function.
+//
+// Output: mode (8 bits, EXE_NONE, EXE_X86 or EXE_ARM64), type of the next
+// stage (8 bits), then the filtered bytes (processed by the next stage, if
+// any).
+
+const (
+	EXE_NONE        = 0 // not code: copy
+	EXE_X86         = 1
+	EXE_ARM64       = 2
+	EXE_DETECT      = 3 // guess the mode from each block (not written)
+	EXE_HEADER_SIZE = 2
+	exeMinDetect    = 256 // smaller blocks are copied
+	exeX86Threshold = 256 // at least one near call or jump per 256 bytes
+	exeARMThreshold = 128 // at least one near BL per 128 instructions
+)
+
+type ExeFilter struct {
+	size     uint
+	mode     int
+	nextType byte
+	stage    filterStage
+}
+
+// Create a filter for the given mode (EXE_DETECT to guess the mode from
+// each block), followed by the function of type nextType (NULL_TRANSFORM_TYPE
+// for none)
+func NewExeFilter(sz uint, mode int, nextType byte) (*ExeFilter, error) {
+	if mode < EXE_NONE || mode > EXE_DETECT {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid executable filter mode: %d", mode)
+	}
+
+	if isFilterStage(nextType) == false {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid stage after the executable filter: %d", nextType)
+	}
+
+	this := new(ExeFilter)
+	this.size = sz
+	this.mode = mode
+	this.nextType = nextType
+
+	if nextType != NULL_TRANSFORM_TYPE {
+		if _, err := this.stage.get(nextType, sz); err != nil {
+			return nil, err
+		}
+	}
+
+	return this, nil
+}
+
+func (this *ExeFilter) Size() uint {
+	return this.size
+}
+
+func (this *ExeFilter) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *ExeFilter) Mode() int {
+	return this.mode
+}
+
+func (this *ExeFilter) NextType() byte {
+	return this.nextType
+}
+
+func (this *ExeFilter) Forward(src, dst []byte) (uint, uint, error) {
+	if err := checkPredictorBuffers(src, dst); err != nil {
+		return 0, 0, err
+	}
+
+	count := len(src)
+
+	if this.size > 0 {
+		count = int(this.size)
+
+		if count > len(src) {
+			return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Source buffer too small")
+		}
+	}
+
+	mode := this.mode
+
+	if mode == EXE_DETECT {
+		mode = detectCode(src[0:count])
+	}
+
+	if len(dst) < EXE_HEADER_SIZE {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
+	}
+
+	dst[0] = byte(mode)
+	dst[1] = this.nextType
+	var filtered []byte
+
+	if this.nextType == NULL_TRANSFORM_TYPE {
+		if len(dst) < EXE_HEADER_SIZE+count {
+			return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
+		}
+
+		filtered = dst[EXE_HEADER_SIZE : EXE_HEADER_SIZE+count]
+	} else {
+		filtered = this.stage.filterBuffer(count)
+	}
+
+	copy(filtered, src[0:count])
+
+	switch mode {
+	case EXE_X86:
+		forwardX86(filtered)
+
+	case EXE_ARM64:
+		filterARM64(filtered, true)
+	}
+
+	if this.nextType == NULL_TRANSFORM_TYPE {
+		return uint(count), uint(EXE_HEADER_SIZE + count), nil
+	}
+
+	oIdx, err := this.stage.forward(this.nextType, filtered, dst[EXE_HEADER_SIZE:])
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint(count), EXE_HEADER_SIZE + oIdx, nil
+}
+
+func (this *ExeFilter) Inverse(src, dst []byte) (uint, uint, error) {
+	if err := checkPredictorBuffers(src, dst); err != nil {
+		return 0, 0, err
+	}
+
+	length := len(src)
+
+	if this.size > 0 {
+		length = int(this.size)
+
+		if length > len(src) {
+			return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Source buffer too small")
+		}
+	}
+
+	if length < EXE_HEADER_SIZE {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid executable filter data: truncated header")
+	}
+
+	mode, nextType := int(src[0]), src[1]
+
+	if mode != EXE_NONE && mode != EXE_X86 && mode != EXE_ARM64 {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid executable filter data: unknown mode %d", mode)
+	}
+
+	if isFilterStage(nextType) == false {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid executable filter data: invalid next stage %d", nextType)
+	}
+
+	data := src[EXE_HEADER_SIZE:length]
+
+	if nextType != NULL_TRANSFORM_TYPE {
+		var err error
+
+		if data, err = this.stage.inverse(nextType, src[EXE_HEADER_SIZE:], len(data), len(dst)); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if len(dst) < len(data) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
+	}
+
+	unfiltered := dst[0:len(data)]
+	copy(unfiltered, data)
+
+	switch mode {
+	case EXE_X86:
+		inverseX86(unfiltered)
+
+	case EXE_ARM64:
+		filterARM64(unfiltered, false)
+	}
+
+	return uint(length), uint(len(data)), nil
+}
+
+// Near call or jump: E8 or E9 opcode and displacement in [-2^24..2^24[
+func isX86Branch(buf []byte, i int) bool {
+	return buf[i]&0xFE == 0xE8 && (buf[i+4] == 0x00 || buf[i+4] == 0xFF)
+}
+
+func forwardX86(buf []byte) {
+	for i := len(buf) - 5; i >= 0; i-- {
+		if isX86Branch(buf, i) == true {
+			// Target: address of the next instruction + displacement
+			addr := uint32(buf[i+1]) | uint32(buf[i+2])<<8 | uint32(buf[i+3])<<16
+			addr += uint32(i + 5)
+			buf[i+1] = byte(addr)
+			buf[i+2] = byte(addr >> 8)
+			buf[i+3] = byte(addr >> 16)
+		}
+	}
+}
+
+func inverseX86(buf []byte) {
+	for i := 0; i <= len(buf)-5; i++ {
+		if isX86Branch(buf, i) == true {
+			addr := uint32(buf[i+1]) | uint32(buf[i+2])<<8 | uint32(buf[i+3])<<16
+			addr -= uint32(i + 5)
+			buf[i+1] = byte(addr)
+			buf[i+2] = byte(addr >> 8)
+			buf[i+3] = byte(addr >> 16)
+		}
+	}
+}
+
+// BL instruction: opcode 100101 in the 6 msb, then the offset in words
+func isARM64Call(insn uint32) bool {
+	return insn>>26 == 0x25
+}
+
+func filterARM64(buf []byte, forward bool) {
+	for i := 0; i <= len(buf)-4; i += 4 {
+		insn := binary.LittleEndian.Uint32(buf[i:])
+
+		if isARM64Call(insn) == false {
+			continue
+		}
+
+		pc := uint32(i >> 2)
+
+		if forward == true {
+			insn += pc
+		} else {
+			insn -= pc
+		}
+
+		binary.LittleEndian.PutUint32(buf[i:], 0x94000000|(insn&0x03FFFFFF))
+	}
+}
+
+// Return the mode of the block: the near branches of the architecture must
+// be frequent (they are rare in other data)
+func detectCode(block []byte) int {
+	if len(block) < exeMinDetect {
+		return EXE_NONE
+	}
+
+	x86, arm := 0, 0
+
+	for i := 0; i <= len(block)-5; i++ {
+		if isX86Branch(block, i) == true {
+			x86++
+		}
+	}
+
+	for i := 0; i <= len(block)-4; i += 4 {
+		insn := binary.LittleEndian.Uint32(block[i:])
+
+		// Offset in [-2^18..2^18[ instructions
+		if isARM64Call(insn) == true && ((insn>>18)&0xFF == 0x00 || (insn>>18)&0xFF == 0xFF) {
+			arm++
+		}
+	}
+
+	// Compare the frequencies with the thresholds
+	x86Score := x86 * exeX86Threshold
+	armScore := arm * exeARMThreshold * 4
+
+	if max(x86Score, armScore) < len(block) {
+		return EXE_NONE
+	}
+
+	if x86Score >= armScore {
+		return EXE_X86
+	}
+
+	return EXE_ARM64
+}
+
+func (this ExeFilter) MaxEncodedLen(srcLen int) int {
+	if this.nextType == NULL_TRANSFORM_TYPE {
+		return EXE_HEADER_SIZE + srcLen
+	}
+
+	res := this.stage.maxEncodedLen(srcLen)
+
+	if res < 0 {
+		return -1
+	}
+
+	return EXE_HEADER_SIZE + res
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"kanzi"
+)
+
+// Stage run on the output of a filter, so that both form a single function.
+// Only the transforms and codecs can follow a filter. The filters write the
+// type of the stage in each block: the inverse does not depend on the
+// function type of the stream.
+type filterStage struct {
+	next     kanzi.ByteFunction // reused from block to block
+	nextType byte               // type of the instance
+	buffer   []byte             // filtered bytes
+	input    []byte
+}
+
+func isFilterStage(functionType byte) bool {
+	switch functionType & 0x0F {
+	case NULL_TRANSFORM_TYPE, LZ4_TYPE, SNAPPY_TYPE, RLT_TYPE:
+		return functionType>>4 == 0
+
+	case BWT_TYPE, BWTS_TYPE:
+		return int(functionType>>4) <= GST_MODE_TIMESTAMP
+
+	default:
+		return false
+	}
+}
+
+// Return the stage for a block of the given size. The previous instance is
+// reused if possible.
+func (this *filterStage) get(functionType byte, size uint) (kanzi.ByteFunction, error) {
+	if this.next != nil && this.nextType == functionType {
+		if s, isSizeable := this.next.(kanzi.Sizeable); isSizeable == true && s.SetSize(size) == true {
+			return this.next, nil
+		}
+	}
+
+	next, err := NewByteFunction(size, functionType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.next = next
+	this.nextType = functionType
+	return next, nil
+}
+
+// Return a buffer for the filtered bytes of a block
+func (this *filterStage) filterBuffer(count int) []byte {
+	if len(this.buffer) < count {
+		this.buffer = make([]byte, count)
+	}
+
+	return this.buffer[0:count]
+}
+
+// Run the stage on the filtered bytes, return the size of the output
+func (this *filterStage) forward(functionType byte, filtered, dst []byte) (uint, error) {
+	next, err := this.get(functionType, uint(len(filtered)))
+
+	if err != nil {
+		return 0, err
+	}
+
+	iIdx, oIdx, err := next.Forward(filtered, dst)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// The stage must consume the whole block: the filter cannot undo a part
+	if iIdx != uint(len(filtered)) {
+		return 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small: %d of %d filtered bytes processed",
+			iIdx, len(filtered))
+	}
+
+	return oIdx, nil
+}
+
+// Undo the stage on the first 'length' bytes of src, return the filtered
+// bytes (not larger than the output of the filter)
+func (this *filterStage) inverse(functionType byte, src []byte, length, maxLength int) ([]byte, error) {
+	next, err := this.get(functionType, uint(length))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The BWT uses its input buffer to store the intermediate results
+	if len(src) < maxLength {
+		if len(this.input) < maxLength {
+			this.input = make([]byte, maxLength)
+		}
+
+		copy(this.input, src[0:length])
+		src = this.input[0:maxLength]
+	}
+
+	_, oIdx, err := next.Inverse(src, this.filterBuffer(maxLength))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return this.buffer[0:oIdx], nil
+}
+
+func (this filterStage) maxEncodedLen(srcLen int) int {
+	if this.next == nil {
+		return srcLen
+	}
+
+	return this.next.MaxEncodedLen(srcLen)
+}
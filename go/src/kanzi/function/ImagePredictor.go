@@ -0,0 +1,442 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"encoding/binary"
+	"kanzi"
+)
+
+// Lossless predictor for raw pixel data (LOCO-I and PNG style). The block is
+// a sequence of rows of 'stride' bytes, each row starting with 'width' pixels
+// of 'channels' interleaved bytes (the rest of the row is padding). Each byte
+// is replaced by the difference (modulo 256) with a prediction computed from
+// the left, upper and upper left samples of the same channel. The predictor
+// is selected for each row: the one producing the smallest residuals wins.
+// The padding bytes are predicted by the previous byte.
+// If the layout is not provided (all zero), the number of channels and the
+// stride are guessed from the block (the width is then stride/channels).
+//
+// Output: channels (8 bits), stride (32 bits), width (32 bits), one predictor
+// per row (8 bits each), then the residuals (as many bytes as the input).
+
+const (
+	PREDICT_NONE     = 0 // no prediction
+	PREDICT_LEFT     = 1 // PNG sub
+	PREDICT_UP       = 2 // PNG up
+	PREDICT_AVERAGE  = 3 // PNG average
+	PREDICT_PAETH    = 4 // PNG paeth
+	PREDICT_MED      = 5 // median edge detector (LOCO-I)
+	PREDICT_GRADIENT = 6 // clamped gradient: left + up - upper left
+	PREDICTORS       = 7
+
+	PREDICT_HEADER_SIZE  = 9
+	PREDICT_MAX_CHANNELS = 16
+	PREDICT_MAX_STRIDE   = 1 << 24
+	predictSamples       = 2048 // number of samples to guess the layout
+	predictMaxGuess      = 16384
+)
+
+type ImagePredictor struct {
+	size     uint
+	width    uint // in pixels
+	stride   uint // in bytes
+	channels uint
+	costs    [PREDICTORS]int
+}
+
+// Create a predictor for the given layout (all zero to guess the layout)
+func NewImagePredictor(sz, width, stride, channels uint) (*ImagePredictor, error) {
+	if width != 0 || stride != 0 || channels != 0 {
+		if channels < 1 || channels > PREDICT_MAX_CHANNELS {
+			return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of channels: %d (must be in [1..%d])",
+				channels, PREDICT_MAX_CHANNELS)
+		}
+
+		if stride == 0 {
+			stride = width * channels
+		}
+
+		if width < 1 || stride > PREDICT_MAX_STRIDE || stride < width*channels {
+			return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid image layout: width %d, stride %d, %d channel(s)",
+				width, stride, channels)
+		}
+	}
+
+	this := new(ImagePredictor)
+	this.size = sz
+	this.width = width
+	this.stride = stride
+	this.channels = channels
+	return this, nil
+}
+
+func (this *ImagePredictor) Size() uint {
+	return this.size
+}
+
+func (this *ImagePredictor) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *ImagePredictor) Width() uint {
+	return this.width
+}
+
+func (this *ImagePredictor) Stride() uint {
+	return this.stride
+}
+
+func (this *ImagePredictor) Channels() uint {
+	return this.channels
+}
+
+func checkPredictorBuffers(src, dst []byte) error {
+	if src == nil {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
+	}
+
+	if dst == nil {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
+	}
+
+	if kanzi.SameByteSlices(src, dst, false) {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
+	}
+
+	return nil
+}
+
+func (this *ImagePredictor) Forward(src, dst []byte) (uint, uint, error) {
+	if err := checkPredictorBuffers(src, dst); err != nil {
+		return 0, 0, err
+	}
+
+	count := len(src)
+
+	if this.size > 0 {
+		count = int(this.size)
+
+		if count > len(src) {
+			return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Source buffer too small")
+		}
+	}
+
+	width, stride, channels := this.width, this.stride, this.channels
+
+	if channels == 0 {
+		stride, channels = guessLayout(src[0:count])
+		width = stride / channels
+	}
+
+	rows := (count + int(stride) - 1) / int(stride)
+
+	if len(dst) < PREDICT_HEADER_SIZE+rows+count {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
+	}
+
+	dst[0] = byte(channels)
+	binary.BigEndian.PutUint32(dst[1:], uint32(stride))
+	binary.BigEndian.PutUint32(dst[5:], uint32(width))
+	modes := dst[PREDICT_HEADER_SIZE : PREDICT_HEADER_SIZE+rows]
+	residuals := dst[PREDICT_HEADER_SIZE+rows:]
+	st := int(stride)
+	ch := int(channels)
+	pixels := int(width * channels)
+
+	for r := 0; r < rows; r++ {
+		start := r * st
+		end := start + st
+
+		if end > count {
+			end = count
+		}
+
+		mode := this.selectPredictor(src, start, end, st, ch, pixels)
+		modes[r] = byte(mode)
+
+		for i := start; i < end; i++ {
+			residuals[i] = src[i] - predict(src, mode, i, start, st, ch, pixels)
+		}
+	}
+
+	return uint(count), uint(PREDICT_HEADER_SIZE + rows + count), nil
+}
+
+// Return the predictor with the smallest sum of absolute residuals for the row
+func (this *ImagePredictor) selectPredictor(src []byte, start, end, stride, channels, pixels int) int {
+	costs := this.costs[:]
+
+	for i := range costs {
+		costs[i] = 0
+	}
+
+	if end > start+pixels {
+		end = start + pixels
+	}
+
+	for i := start; i < end; i++ {
+		for mode := range costs {
+			res := int8(src[i] - predict(src, mode, i, start, stride, channels, pixels))
+
+			if res < 0 {
+				costs[mode] -= int(res)
+			} else {
+				costs[mode] += int(res)
+			}
+		}
+	}
+
+	best := 0
+
+	for mode := range costs {
+		if costs[mode] < costs[best] {
+			best = mode
+		}
+	}
+
+	return best
+}
+
+// Return the prediction of the byte at index i (in the row starting at index
+// start). The missing neighbors (first row, first pixel) are 0.
+func predict(buf []byte, mode, i, start, stride, channels, pixels int) byte {
+	if i-start >= pixels {
+		// Padding
+		if i > start {
+			return buf[i-1]
+		}
+
+		return 0
+	}
+
+	a, b, c := 0, 0, 0 // left, up, upper left
+
+	if i-start >= channels {
+		a = int(buf[i-channels])
+
+		if start > 0 {
+			c = int(buf[i-stride-channels])
+		}
+	}
+
+	if start > 0 {
+		b = int(buf[i-stride])
+	}
+
+	switch mode {
+	case PREDICT_LEFT:
+		return byte(a)
+
+	case PREDICT_UP:
+		return byte(b)
+
+	case PREDICT_AVERAGE:
+		return byte((a + b) >> 1)
+
+	case PREDICT_PAETH:
+		p := a + b - c
+		pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+
+		if pa <= pb && pa <= pc {
+			return byte(a)
+		}
+
+		if pb <= pc {
+			return byte(b)
+		}
+
+		return byte(c)
+
+	case PREDICT_MED:
+		if c >= a && c >= b {
+			return byte(min(a, b))
+		}
+
+		if c <= a && c <= b {
+			return byte(max(a, b))
+		}
+
+		return byte(a + b - c)
+
+	case PREDICT_GRADIENT:
+		p := a + b - c
+
+		if p < 0 {
+			return 0
+		}
+
+		if p > 255 {
+			return 255
+		}
+
+		return byte(p)
+
+	default:
+		return 0
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+// Guess the number of channels (1 to 4) and the stride of the block from the
+// differences between samples at a few distances
+func guessLayout(block []byte) (uint, uint) {
+	count := len(block)
+
+	if count < 64 {
+		return uint(max(count, 1)), 1
+	}
+
+	channels := 1
+	minCost := -1
+
+	for ch := 1; ch <= 4; ch++ {
+		if cost := distanceCost(block, ch, ch); minCost < 0 || cost*8 < minCost*7 {
+			// A larger number of channels must be clearly better
+			minCost = cost
+			channels = ch
+		}
+	}
+
+	// The distance between rows stands out: its cost must be well below the
+	// average cost of the candidates. The multiples of the stride are also
+	// good candidates: prefer a divisor of the best one if it is close.
+	stride := count
+	minStride := 8 * channels
+	maxStride := min(count/4, predictMaxGuess)
+	var costs []int
+	best := -1
+	total := 0
+
+	for s := minStride; s <= maxStride; s += channels {
+		cost := distanceCost(block, s, maxStride)
+		costs = append(costs, cost)
+		total += cost
+
+		if best < 0 || cost < costs[best] {
+			best = len(costs) - 1
+		}
+	}
+
+	if len(costs) > 1 && costs[best]*4*len(costs) < total*3 {
+		stride = minStride + best*channels
+
+		for k := 4; k >= 2; k-- {
+			s := stride / k
+
+			if s >= minStride && s%channels == 0 && s*k == stride && costs[(s-minStride)/channels]*16 <= costs[best]*17 {
+				stride = s
+				break
+			}
+		}
+	}
+
+	return uint(stride), uint(channels)
+}
+
+// Return the sum of the absolute differences between samples at the given
+// distance (at most predictSamples samples after the first 'skip' bytes)
+func distanceCost(block []byte, dist, skip int) int {
+	step := max((len(block)-skip)/predictSamples, 1)
+	cost := 0
+
+	for i := skip; i < len(block); i += step {
+		cost += abs(int(block[i]) - int(block[i-dist]))
+	}
+
+	return cost
+}
+
+func (this *ImagePredictor) Inverse(src, dst []byte) (uint, uint, error) {
+	if err := checkPredictorBuffers(src, dst); err != nil {
+		return 0, 0, err
+	}
+
+	length := len(src)
+
+	if this.size > 0 {
+		length = int(this.size)
+
+		if length > len(src) {
+			return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Source buffer too small")
+		}
+	}
+
+	if length < PREDICT_HEADER_SIZE {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid predictor data: truncated header")
+	}
+
+	ch := int(src[0])
+	st := int(binary.BigEndian.Uint32(src[1:]))
+	width := int(binary.BigEndian.Uint32(src[5:]))
+
+	if ch < 1 || ch > PREDICT_MAX_CHANNELS || st < 1 || st > PREDICT_MAX_STRIDE || width > st/ch {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid predictor data: invalid layout")
+	}
+
+	// total = count + ceil(count/stride)
+	total := length - PREDICT_HEADER_SIZE
+	rows := (total + st) / (st + 1)
+	count := total - rows
+
+	if (count+st-1)/st != rows {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid predictor data: invalid length")
+	}
+
+	if len(dst) < count {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
+	}
+
+	modes := src[PREDICT_HEADER_SIZE : PREDICT_HEADER_SIZE+rows]
+	residuals := src[PREDICT_HEADER_SIZE+rows : length]
+	pixels := width * ch
+
+	for r := 0; r < rows; r++ {
+		mode := int(modes[r])
+
+		if mode >= PREDICTORS {
+			return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid predictor data: unknown predictor %d", mode)
+		}
+
+		start := r * st
+		end := min(start+st, count)
+
+		for i := start; i < end; i++ {
+			dst[i] = residuals[i] + predict(dst, mode, i, start, st, ch, pixels)
+		}
+	}
+
+	return uint(length), uint(count), nil
+}
+
+func (this ImagePredictor) MaxEncodedLen(srcLen int) int {
+	stride := int(this.stride)
+
+	if this.channels == 0 {
+		// Guessed layout: the rows have at least 8 bytes
+		stride = 8
+	}
+
+	return PREDICT_HEADER_SIZE + srcLen + (srcLen+stride-1)/stride
+}
@@ -16,8 +16,6 @@ limitations under the License.
 package function
 
 import (
-	"errors"
-	"fmt"
 	"kanzi"
 	"unsafe"
 )
@@ -149,15 +147,15 @@ func emitLiterals(src []byte, dst []byte, runLen int, last bool) (int, int, int)
 
 func (this *LZ4Codec) Forward(src, dst []byte) (uint, uint, error) {
 	if src == nil {
-		return uint(0), uint(0), errors.New("Invalid null source buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
 	}
 
 	if dst == nil {
-		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
 	}
 
 	count := int(this.size)
@@ -167,7 +165,7 @@ func (this *LZ4Codec) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if n := this.MaxEncodedLen(count); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Output buffer is too small - size: %d, required %d", len(dst), n)
 	}
 
 	if count < MIN_LENGTH {
@@ -305,15 +303,15 @@ func readInt(array []byte, srcIdx int) uint32 {
 
 func (this *LZ4Codec) Inverse(src, dst []byte) (uint, uint, error) {
 	if src == nil {
-		return uint(0), uint(0), errors.New("Invalid null source buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
 	}
 
 	if dst == nil {
-		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
 	}
 
 	count := int(this.size)
@@ -344,7 +342,7 @@ func (this *LZ4Codec) Inverse(src, dst []byte) (uint, uint, error) {
 			srcIdx++
 
 			if length > MAX_LENGTH {
-				return 0, 0, fmt.Errorf("Invalid length decoded: %d", length)
+				return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid length decoded: %d", length)
 			}
 		}
 
@@ -378,7 +376,7 @@ func (this *LZ4Codec) Inverse(src, dst []byte) (uint, uint, error) {
 			srcIdx++
 
 			if length > MAX_LENGTH {
-				return 0, 0, fmt.Errorf("Invalid length decoded: %d", length)
+				return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid length decoded: %d", length)
 			}
 		}
 
@@ -16,7 +16,6 @@ limitations under the License.
 package function
 
 import (
-	"errors"
 	"kanzi"
 )
 
@@ -34,13 +33,18 @@ func (this *NullFunction) Size() uint {
 	return this.size
 }
 
+func (this *NullFunction) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
 func doCopy(src, dst []byte, sz uint) (uint, uint, error) {
 	if src == nil {
-		return uint(0), uint(0), errors.New("Invalid null source buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
 	}
 
 	if dst == nil {
-		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
 	}
 
 	length := len(src)
@@ -49,12 +53,12 @@ func doCopy(src, dst []byte, sz uint) (uint, uint, error) {
 		length = int(sz)
 
 		if length > len(src) {
-			return uint(0), uint(0), errors.New("Source buffer too small")
+			return uint(0), uint(0), kanzi.Errorf(kanzi.ErrBufferTooSmall, "Source buffer too small")
 		}
 	}
 
 	if length > len(dst) {
-		return uint(0), uint(0), errors.New("Destination buffer too small")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) == false {
@@ -23,7 +23,6 @@ package function
 //   output: 0x10 0x11 0x11 0x17 0x13 0x13 0x13 0x05 0x12 0x12 0x80 0xA0 0x14
 
 import (
-	"errors"
 	"kanzi"
 )
 
@@ -40,11 +39,11 @@ type RLT struct {
 
 func NewRLT(sz, threshold uint) (*RLT, error) {
 	if threshold < 2 {
-		return nil, errors.New("Invalid run threshold parameter (must be at least 2)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid run threshold parameter (must be at least 2)")
 	}
 
 	if threshold > 256 {
-		return nil, errors.New("Invalid run threshold parameter (must be at most 256)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid run threshold parameter (must be at most 256)")
 	}
 
 	this := new(RLT)
@@ -57,21 +56,26 @@ func (this *RLT) Size() uint {
 	return this.size
 }
 
+func (this *RLT) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
 func (this *RLT) RunTheshold() uint {
 	return this.runThreshold
 }
 
 func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 	if src == nil {
-		return uint(0), uint(0), errors.New("Invalid null source buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
 	}
 
 	if dst == nil {
-		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
 	}
 
 	srcEnd := this.size
@@ -152,15 +156,15 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 
 func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 	if src == nil {
-		return uint(0), uint(0), errors.New("Invalid null source buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
 	}
 
 	if dst == nil {
-		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
 	}
 
 	srcEnd := this.size
@@ -0,0 +1,336 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	"kanzi"
+	"math"
+)
+
+// Byte shuffle and delta filter for arrays of fixed width binary records
+// (structs, integer or float columns, ...), as done by Blosc. The bytes of
+// the elements are transposed by significance: the first byte of every
+// element, then the second byte of every element, and so on. Optionally,
+// each byte is replaced by the difference (modulo 256) with the same byte of
+// the element 'stride' positions before. The bytes beyond the last complete
+// element are copied as is.
+// The filter is meant to be followed by another stage (BWT, LZ4, ...): it
+// can run it on the filtered bytes, so that both form a single function.
+// If the width is 0, the width and the delta stride are guessed from each
+// block.
+//
+// Output: width (8 bits), stride (8 bits, 0 for no delta), type of the next
+// stage (8 bits), then the filtered bytes (processed by the next stage, if
+// any).
+
+const (
+	SHUFFLE_HEADER_SIZE = 3
+	SHUFFLE_MAX_WIDTH   = 16
+	SHUFFLE_MAX_STRIDE  = 255
+	shuffleSamples      = 4096 // number of samples to guess the width
+	shuffleMinGuess     = 64
+)
+
+type ShuffleFilter struct {
+	size     uint
+	width    uint
+	stride   uint
+	nextType byte
+	stage    filterStage
+}
+
+// Create a filter for elements of the given width (0 to guess the width
+// and the stride), followed by the function of type nextType (NULL_TRANSFORM_TYPE
+// for none)
+func NewShuffleFilter(sz, width, stride uint, nextType byte) (*ShuffleFilter, error) {
+	if width > SHUFFLE_MAX_WIDTH {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid element width: %d (must be in [1..%d], 0 to guess)",
+			width, SHUFFLE_MAX_WIDTH)
+	}
+
+	if stride > SHUFFLE_MAX_STRIDE {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid delta stride: %d (must be at most %d)",
+			stride, SHUFFLE_MAX_STRIDE)
+	}
+
+	if isFilterStage(nextType) == false {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid stage after the shuffle filter: %d", nextType)
+	}
+
+	this := new(ShuffleFilter)
+	this.size = sz
+	this.width = width
+	this.stride = stride
+	this.nextType = nextType
+
+	if nextType != NULL_TRANSFORM_TYPE {
+		if _, err := this.stage.get(nextType, sz); err != nil {
+			return nil, err
+		}
+	}
+
+	return this, nil
+}
+
+func (this *ShuffleFilter) Size() uint {
+	return this.size
+}
+
+func (this *ShuffleFilter) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+func (this *ShuffleFilter) Width() uint {
+	return this.width
+}
+
+func (this *ShuffleFilter) Stride() uint {
+	return this.stride
+}
+
+func (this *ShuffleFilter) NextType() byte {
+	return this.nextType
+}
+
+func (this *ShuffleFilter) Forward(src, dst []byte) (uint, uint, error) {
+	if err := checkPredictorBuffers(src, dst); err != nil {
+		return 0, 0, err
+	}
+
+	count := len(src)
+
+	if this.size > 0 {
+		count = int(this.size)
+
+		if count > len(src) {
+			return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Source buffer too small")
+		}
+	}
+
+	width, stride := this.width, this.stride
+
+	if width == 0 {
+		width, stride = guessShuffle(src[0:count])
+	}
+
+	if len(dst) < SHUFFLE_HEADER_SIZE {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
+	}
+
+	dst[0] = byte(width)
+	dst[1] = byte(stride)
+	dst[2] = this.nextType
+
+	if this.nextType == NULL_TRANSFORM_TYPE {
+		if len(dst) < SHUFFLE_HEADER_SIZE+count {
+			return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
+		}
+
+		shuffle(src[0:count], dst[SHUFFLE_HEADER_SIZE:], int(width), int(stride))
+		return uint(count), uint(SHUFFLE_HEADER_SIZE + count), nil
+	}
+
+	filtered := this.stage.filterBuffer(count)
+	shuffle(src[0:count], filtered, int(width), int(stride))
+	oIdx, err := this.stage.forward(this.nextType, filtered, dst[SHUFFLE_HEADER_SIZE:])
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint(count), SHUFFLE_HEADER_SIZE + oIdx, nil
+}
+
+func (this *ShuffleFilter) Inverse(src, dst []byte) (uint, uint, error) {
+	if err := checkPredictorBuffers(src, dst); err != nil {
+		return 0, 0, err
+	}
+
+	length := len(src)
+
+	if this.size > 0 {
+		length = int(this.size)
+
+		if length > len(src) {
+			return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Source buffer too small")
+		}
+	}
+
+	if length < SHUFFLE_HEADER_SIZE {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid shuffle data: truncated header")
+	}
+
+	width, stride, nextType := int(src[0]), int(src[1]), src[2]
+
+	if width < 1 || width > SHUFFLE_MAX_WIDTH {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid shuffle data: invalid element width %d", width)
+	}
+
+	if isFilterStage(nextType) == false {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid shuffle data: invalid next stage %d", nextType)
+	}
+
+	data := src[SHUFFLE_HEADER_SIZE:length]
+
+	if nextType != NULL_TRANSFORM_TYPE {
+		var err error
+
+		if data, err = this.stage.inverse(nextType, src[SHUFFLE_HEADER_SIZE:], len(data), len(dst)); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if len(dst) < len(data) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Destination buffer too small")
+	}
+
+	unshuffle(data, dst, width, stride)
+	return uint(length), uint(len(data)), nil
+}
+
+// Apply the delta coding and transpose the bytes of the elements
+func shuffle(src, dst []byte, width, stride int) {
+	n := len(src) / width
+	end := n * width
+
+	if stride == 0 || n <= stride {
+		stride = n
+	}
+
+	// Element by element: the planes are written sequentially
+	for i := 0; i < stride; i++ {
+		for j, b := range src[i*width : i*width+width] {
+			dst[j*n+i] = b
+		}
+	}
+
+	dist := stride * width
+
+	for i := stride; i < n; i++ {
+		prev := src[i*width-dist : i*width-dist+width]
+
+		for j, b := range src[i*width : i*width+width] {
+			dst[j*n+i] = b - prev[j]
+		}
+	}
+
+	copy(dst[end:], src[end:])
+}
+
+// Transpose the bytes back, then undo the delta coding. The delta coding
+// of a plane is also the delta coding of the elements at the same distance.
+func unshuffle(src, dst []byte, width, stride int) {
+	n := len(src) / width
+	end := n * width
+
+	if width == 1 {
+		copy(dst, src[0:end])
+	} else {
+		// Element by element: the planes are read sequentially
+		for i := 0; i < n; i++ {
+			elt := dst[i*width : i*width+width]
+
+			for j := range elt {
+				elt[j] = src[j*n+i]
+			}
+		}
+	}
+
+	if stride > 0 && n > stride {
+		dist := stride * width
+
+		for i := dist; i < end; i++ {
+			dst[i] += dst[i-dist]
+		}
+	}
+
+	copy(dst[end:], src[end:len(src)])
+}
+
+// Guess the width of the elements: the one for which the differences between
+// bytes of consecutive elements have the lowest entropy (small widths are
+// preferred, the filter is disabled with a width of 1). Apply the delta
+// coding if it clearly reduces the entropy.
+func guessShuffle(block []byte) (uint, uint) {
+	count := len(block)
+
+	if count < shuffleMinGuess {
+		return 1, 0
+	}
+
+	var freqs [SHUFFLE_MAX_WIDTH + 1][256]int
+	step := max((count-SHUFFLE_MAX_WIDTH)/shuffleSamples, 1)
+	total := 0
+
+	for i := SHUFFLE_MAX_WIDTH; i < count; i += step {
+		b := block[i]
+		freqs[0][b]++
+
+		for w := 1; w <= SHUFFLE_MAX_WIDTH; w++ {
+			freqs[w][b-block[i-w]]++
+		}
+
+		total++
+	}
+
+	width := 1
+	costs := make([]float64, SHUFFLE_MAX_WIDTH+1)
+
+	for w := range costs {
+		costs[w] = entropyCost(freqs[w][:], total)
+
+		// A width must be clearly better than the bytes alone
+		if w > 1 && costs[w]*32 < costs[width]*31 && costs[w]*10 < costs[1]*9 {
+			width = w
+		}
+	}
+
+	stride := uint(0)
+
+	if costs[width]*10 < costs[0]*9 {
+		stride = 1
+	}
+
+	return uint(width), stride
+}
+
+// Return the size in bits of the symbols with an order 0 entropy coder
+func entropyCost(freqs []int, total int) float64 {
+	res := 0.0
+
+	for _, f := range freqs {
+		if f > 0 {
+			res += float64(f) * math.Log2(float64(total)/float64(f))
+		}
+	}
+
+	return res
+}
+
+func (this ShuffleFilter) MaxEncodedLen(srcLen int) int {
+	if this.nextType == NULL_TRANSFORM_TYPE {
+		return SHUFFLE_HEADER_SIZE + srcLen
+	}
+
+	res := this.stage.maxEncodedLen(srcLen)
+
+	if res < 0 {
+		return -1
+	}
+
+	return SHUFFLE_HEADER_SIZE + res
+}
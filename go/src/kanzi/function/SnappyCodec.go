@@ -21,8 +21,6 @@ package function
 
 import (
 	"code.google.com/p/snappy-go/snappy"
-	"errors"
-	"fmt"
 	"kanzi"
 )
 
@@ -47,15 +45,15 @@ func (this *SnappyCodec) SetSize(sz uint) bool {
 
 func (this *SnappyCodec) Forward(src, dst []byte) (uint, uint, error) {
 	if src == nil {
-		return uint(0), uint(0), errors.New("Invalid null source buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
 	}
 
 	if dst == nil {
-		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
 	}
 
 	count := this.size
@@ -65,13 +63,13 @@ func (this *SnappyCodec) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if n := snappy.MaxEncodedLen(int(count)); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Output buffer is too small - size: %d, required %d", len(dst), n)
 	}
 
 	res, err := snappy.Encode(dst, src[0:count])
 
 	if err != nil {
-		return 0, 0, fmt.Errorf("Encoding error: %v", err)
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Encoding error: %w", err)
 	}
 
 	return count, uint(len(res)), nil
@@ -79,15 +77,15 @@ func (this *SnappyCodec) Forward(src, dst []byte) (uint, uint, error) {
 
 func (this *SnappyCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	if src == nil {
-		return uint(0), uint(0), errors.New("Invalid null source buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
 	}
 
 	if dst == nil {
-		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
 	}
 
 	count := this.size
@@ -99,13 +97,13 @@ func (this *SnappyCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	res, err := snappy.Decode(dst, src[0:count])
 
 	if err != nil {
-		return 0, 0, fmt.Errorf("Decoding error: %v", err)
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Decoding error: %w", err)
 	}
 
 	if len(res) > len(dst) {
 		// Encode returns a newly allocated slice if the provided 'dst' array is too small.
 		// There is no way to return this new slice, so treat it as an error
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(res), len(dst))
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Output buffer is too small - size: %d, required %d", len(res), len(dst))
 	}
 
 	return count, uint(len(res)), nil
@@ -16,7 +16,6 @@ limitations under the License.
 package function
 
 import (
-	"errors"
 	"kanzi"
 )
 
@@ -44,17 +43,22 @@ func (this *ZRLT) Size() uint {
 	return this.size
 }
 
+func (this *ZRLT) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
 func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 	if src == nil {
-		return uint(0), uint(0), errors.New("Invalid null source buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
 	}
 
 	if dst == nil {
-		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
 	}
 
 	srcEnd := this.size
@@ -122,7 +126,7 @@ func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if srcIdx != srcEnd || runLength != 1 {
-		return srcIdx, dstIdx, errors.New("Output buffer is too small")
+		return srcIdx, dstIdx, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Output buffer is too small")
 	}
 
 	return srcIdx, dstIdx, nil
@@ -130,15 +134,15 @@ func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 
 func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 	if src == nil {
-		return uint(0), uint(0), errors.New("Invalid null source buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null source buffer")
 	}
 
 	if dst == nil {
-		return uint(0), uint(0), errors.New("Invalid null destination buffer")
+		return uint(0), uint(0), kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null destination buffer")
 	}
 
 	if kanzi.SameByteSlices(src, dst, false) {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
 	}
 
 	srcEnd := this.size
@@ -205,7 +209,7 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 	end := dstIdx + uint(runLength) - 1
 
 	if end > dstEnd {
-		return srcIdx, dstIdx, errors.New("Output buffer is too small")
+		return srcIdx, dstIdx, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Output buffer is too small")
 	}
 
 	for dstIdx < end {
@@ -214,7 +218,7 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if srcIdx < srcEnd {
-		return srcIdx, dstIdx, errors.New("Output buffer is too small")
+		return srcIdx, dstIdx, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Output buffer is too small")
 	}
 
 	return srcIdx, dstIdx, nil
@@ -0,0 +1,241 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package http
+
+import (
+	"bufio"
+	"io"
+	"kanzi"
+	"kanzi/entropy"
+	"kanzi/function"
+	kio "kanzi/io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Middleware serving HTTP responses with 'Content-Encoding: kanzi' to the
+// clients that accept it (see 'Accept-Encoding'). Request bodies sent with
+// 'Content-Encoding: kanzi' are decoded transparently before reaching the
+// wrapped handler.
+// Errors are IOErrors of the kanzi/io package (see kio.IOError), except for
+// invalid parameters (kanzi.ErrInvalidParam, kanzi.ErrUnsupported).
+// The responses to upgrade requests (EG. websockets) are not compressed and
+// the connection can be hijacked (see http.Hijacker) until the compression
+// of the response has started.
+
+const (
+	CONTENT_ENCODING   = "kanzi"
+	DEFAULT_BLOCK_SIZE = 64 * 1024
+)
+
+type CompressionHandler struct {
+	next         http.Handler
+	entropyCodec string
+	functionType string
+	blockSize    uint
+}
+
+func NewCompressionHandler(next http.Handler, entropyCodec string, functionType string,
+	blockSize uint) (*CompressionHandler, error) {
+	if next == nil {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null handler parameter")
+	}
+
+	if err := checkCodecs(entropyCodec, functionType); err != nil {
+		return nil, err
+	}
+
+	this := new(CompressionHandler)
+	this.next = next
+	this.entropyCodec = entropyCodec
+	this.functionType = functionType
+	this.blockSize = blockSize
+	return this, nil
+}
+
+// The codec factories panic on unknown names
+func checkCodecs(entropyCodec, functionType string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = kio.PanicIOError(r, kio.ERR_INVALID_CODEC)
+		}
+	}()
+
+	entropy.GetEntropyCodecType(entropyCodec)
+	function.GetByteFunctionType(functionType)
+	return nil
+}
+
+// Return true if the 'Accept-Encoding' header value contains 'kanzi' with
+// a non zero quality
+func AcceptsKanzi(acceptEncoding string) bool {
+	for _, token := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(token, ";")
+
+		if strings.ToLower(strings.TrimSpace(params[0])) != CONTENT_ENCODING {
+			continue
+		}
+
+		for _, p := range params[1:] {
+			p = strings.Replace(p, " ", "", -1)
+
+			if strings.HasPrefix(p, "q=0") && strings.Trim(p[3:], ".0") == "" {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (this *CompressionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.ToLower(r.Header.Get("Content-Encoding")) == CONTENT_ENCODING && r.Body != nil {
+		// Decode the request body
+		r.Body = NewDecompressingReader(r.Body)
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if r.Method == "HEAD" || r.Header.Get("Upgrade") != "" ||
+		AcceptsKanzi(r.Header.Get("Accept-Encoding")) == false {
+		this.next.ServeHTTP(w, r)
+		return
+	}
+
+	cw := &compressedResponseWriter{ResponseWriter: w, handler: this}
+	served := false
+
+	defer func() {
+		// Always release the stream. If the end of the response cannot be
+		// sent, abort it: the client must not see a complete response.
+		// A panic of the wrapped handler is left as is.
+		if err := cw.close(); err != nil && served == true {
+			panic(http.ErrAbortHandler)
+		}
+	}()
+
+	this.next.ServeHTTP(cw, r)
+	served = true
+}
+
+// Used to prevent the compressed stream from closing the response
+type responseStream struct {
+	writer io.Writer
+}
+
+func (this responseStream) Write(b []byte) (int, error) {
+	return this.writer.Write(b)
+}
+
+func (this responseStream) Close() error {
+	return nil
+}
+
+type compressedResponseWriter struct {
+	http.ResponseWriter
+	handler     *CompressionHandler
+	cos         *kio.CompressedOutputStream
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (this *compressedResponseWriter) WriteHeader(status int) {
+	if this.wroteHeader == true || this.hijacked == true {
+		return
+	}
+
+	this.wroteHeader = true
+	h := this.Header()
+
+	// No body or body already encoded => no compression
+	if status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" {
+		cos, err := kio.NewCompressedOutputStream(this.handler.entropyCodec, this.handler.functionType,
+			responseStream{writer: this.ResponseWriter}, this.handler.blockSize, false, nil, 1)
+
+		if err == nil {
+			this.cos = cos
+			h.Set("Content-Encoding", CONTENT_ENCODING)
+			h.Del("Content-Length")
+		}
+	}
+
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *compressedResponseWriter) Write(b []byte) (int, error) {
+	if this.wroteHeader == false {
+		if this.Header().Get("Content-Type") == "" {
+			// Sniff before the content is compressed
+			this.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+
+		this.WriteHeader(http.StatusOK)
+	}
+
+	if this.cos == nil {
+		return this.ResponseWriter.Write(b)
+	}
+
+	return this.cos.Write(b)
+}
+
+// Implement http.Flusher: send the data compressed so far to the client
+func (this *compressedResponseWriter) Flush() {
+	if this.cos != nil {
+		this.cos.Flush()
+	}
+
+	if f, isFlusher := this.ResponseWriter.(http.Flusher); isFlusher == true {
+		f.Flush()
+	}
+}
+
+// Implement http.Hijacker: the connection can be taken over by the wrapped
+// handler unless a compressed response has been started
+func (this *compressedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if this.cos != nil {
+		return nil, nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Cannot hijack the connection of a compressed response")
+	}
+
+	hj, isHijacker := this.ResponseWriter.(http.Hijacker)
+
+	if isHijacker == false {
+		return nil, nil, kanzi.Errorf(kanzi.ErrUnsupported, "The response writer does not support hijacking")
+	}
+
+	conn, rw, err := hj.Hijack()
+
+	if err == nil {
+		this.hijacked = true
+	}
+
+	return conn, rw, err
+}
+
+func (this *compressedResponseWriter) close() error {
+	if this.cos == nil {
+		return nil
+	}
+
+	return this.cos.Close()
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package http
+
+import (
+	"io"
+	kio "kanzi/io"
+	"net/http"
+	"strings"
+)
+
+// An http.RoundTripper advertising 'Accept-Encoding: kanzi', decoding the
+// kanzi encoded response bodies and optionally compressing the request bodies.
+
+type Transport struct {
+	base             http.RoundTripper
+	entropyCodec     string
+	functionType     string
+	blockSize        uint
+	compressRequests bool
+}
+
+// If base is nil, http.DefaultTransport is used
+func NewTransport(base http.RoundTripper, entropyCodec string, functionType string,
+	blockSize uint, compressRequests bool) (*Transport, error) {
+	if err := checkCodecs(entropyCodec, functionType); err != nil {
+		return nil, err
+	}
+
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	this := new(Transport)
+	this.base = base
+	this.entropyCodec = entropyCodec
+	this.functionType = functionType
+	this.blockSize = blockSize
+	this.compressRequests = compressRequests
+	return this, nil
+}
+
+func (this *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request
+	req2 := req.Clone(req.Context())
+
+	if req2.Header.Get("Accept-Encoding") == "" {
+		req2.Header.Set("Accept-Encoding", CONTENT_ENCODING)
+	}
+
+	if this.compressRequests == true && req.Body != nil && req.Body != http.NoBody &&
+		req2.Header.Get("Content-Encoding") == "" {
+		pr, pw := io.Pipe()
+		cos, err := kio.NewCompressedOutputStream(this.entropyCodec, this.functionType,
+			pw, this.blockSize, false, nil, 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		go compressBody(cos, req.Body, pw)
+		req2.Body = pr
+		req2.GetBody = nil
+		req2.ContentLength = -1
+		req2.Header.Del("Content-Length")
+		req2.Header.Set("Content-Encoding", CONTENT_ENCODING)
+	}
+
+	resp, err := this.base.RoundTrip(req2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(resp.Header.Get("Content-Encoding")) == CONTENT_ENCODING {
+		resp.Body = NewDecompressingReader(resp.Body)
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+
+	return resp, nil
+}
+
+func compressBody(cos *kio.CompressedOutputStream, body io.ReadCloser, pw *io.PipeWriter) {
+	defer body.Close()
+
+	if _, err := io.Copy(cos, body); err != nil {
+		pw.CloseWithError(err)
+		return
+	}
+
+	// Closing the compressed stream closes the pipe
+	if err := cos.Close(); err != nil {
+		pw.CloseWithError(err)
+	}
+}
+
+// An io.ReadCloser decoding a kanzi compressed stream
+type DecompressingReader struct {
+	body io.ReadCloser
+	cis  *kio.CompressedInputStream
+	err  error
+}
+
+// The body is closed by DecompressingReader.Close only (before the compressed
+// stream, to unblock a read in flight)
+type bodyStream struct {
+	io.Reader
+}
+
+func (this bodyStream) Close() error {
+	return nil
+}
+
+func NewDecompressingReader(body io.ReadCloser) *DecompressingReader {
+	this := new(DecompressingReader)
+	this.body = body
+	this.cis, this.err = kio.NewCompressedInputStream(bodyStream{body}, nil, 1)
+	return this
+}
+
+func (this *DecompressingReader) Read(b []byte) (n int, err error) {
+	if this.err != nil {
+		return 0, this.err
+	}
+
+	// Reading the header may panic
+	defer func() {
+		if r := recover(); r != nil {
+			if e, isErr := r.(error); isErr == true {
+				this.err = e
+			} else {
+				this.err = kio.PanicIOError(r, kio.ERR_READ_FILE)
+			}
+
+			n, err = 0, this.err
+		}
+	}()
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if this.cis.Available() == 0 {
+		// Decode the next block
+		if n, err = this.cis.Read(b[0:1]); err != nil {
+			this.err = err
+			return 0, err
+		}
+
+		if n <= 0 {
+			// End of stream
+			this.err = io.EOF
+			return 0, io.EOF
+		}
+	}
+
+	// Return what is available without decoding more blocks (so that the
+	// data flushed by the server is delivered without delay)
+	remaining := this.cis.Available()
+
+	if remaining > len(b)-n {
+		remaining = len(b) - n
+	}
+
+	if remaining > 0 {
+		read, err := this.cis.Read(b[n : n+remaining])
+
+		if read > 0 {
+			n += read
+		}
+
+		if err != nil {
+			this.err = err
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (this *DecompressingReader) Close() error {
+	// Closing the body first makes a pending read of the body return, the
+	// compressed stream does not wait for it
+	err := this.body.Close()
+
+	if this.cis != nil {
+		if err2 := this.cis.Close(); err == nil {
+			err = err2
+		}
+	}
+
+	return err
+}
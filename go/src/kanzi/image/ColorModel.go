@@ -0,0 +1,162 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+// Color conversion between RGB and YCbCr (JFIF, full range) with 16 bit fixed
+// point arithmetic. The conversion is not reversible but it is deterministic:
+// all decoders produce the same RGB values.
+// The lossless codecs use the reversible color transform of JPEG 2000 instead.
+
+func clamp255(val int) int {
+	if val < 0 {
+		return 0
+	}
+
+	if val > 255 {
+		return 255
+	}
+
+	return val
+}
+
+func RGBToYCbCr(r, g, b int) (int, int, int) {
+	y := (19595*r + 38470*g + 7471*b + 32768) >> 16
+	cb := ((-11059*r - 21709*g + 32768*b + 32768) >> 16) + 128
+	cr := ((32768*r - 27439*g - 5329*b + 32768) >> 16) + 128
+	return y, clamp255(cb), clamp255(cr)
+}
+
+func YCbCrToRGB(y, cb, cr int) (int, int, int) {
+	cb -= 128
+	cr -= 128
+	r := y + ((91881*cr + 32768) >> 16)
+	g := y - ((22554*cb + 46802*cr - 32768) >> 16)
+	b := y + ((116130*cb + 32768) >> 16)
+	return clamp255(r), clamp255(g), clamp255(b)
+}
+
+// Split the image into planes (Y, Cb, Cr for RGB images) of the given
+// dimensions. The planes are padded by replicating the last column and row.
+func toPlanes(img *Image, width, height int) [][]int {
+	planes := make([][]int, img.Channels)
+
+	for c := range planes {
+		planes[c] = make([]int, width*height)
+	}
+
+	for y := 0; y < height; y++ {
+		sy := y
+
+		if sy >= img.Height {
+			sy = img.Height - 1
+		}
+
+		for x := 0; x < width; x++ {
+			sx := x
+
+			if sx >= img.Width {
+				sx = img.Width - 1
+			}
+
+			idx := (sy*img.Width + sx) * img.Channels
+
+			if img.Channels == 1 {
+				planes[0][y*width+x] = int(img.Pix[idx])
+				continue
+			}
+
+			lum, cb, cr := RGBToYCbCr(int(img.Pix[idx]), int(img.Pix[idx+1]), int(img.Pix[idx+2]))
+			planes[0][y*width+x] = lum
+			planes[1][y*width+x] = cb
+			planes[2][y*width+x] = cr
+		}
+	}
+
+	return planes
+}
+
+// Merge the planes (of the given width) into the image, dropping the padding
+func fromPlanes(planes [][]int, width int, img *Image) {
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			idx := (y*img.Width + x) * img.Channels
+			src := y*width + x
+
+			if img.Channels == 1 {
+				img.Pix[idx] = byte(clamp255(planes[0][src]))
+				continue
+			}
+
+			r, g, b := YCbCrToRGB(clamp255(planes[0][src]), clamp255(planes[1][src]), clamp255(planes[2][src]))
+			img.Pix[idx] = byte(r)
+			img.Pix[idx+1] = byte(g)
+			img.Pix[idx+2] = byte(b)
+		}
+	}
+}
+
+// Reversible color transform (integer, exact inverse)
+func RGBToRCT(r, g, b int) (int, int, int) {
+	return (r + 2*g + b) >> 2, b - g, r - g
+}
+
+func RCTToRGB(y, cb, cr int) (int, int, int) {
+	g := y - ((cb + cr) >> 2)
+	return cr + g, g, cb + g
+}
+
+// Split the image into centered planes (Y, Cb, Cr of the reversible color
+// transform for RGB images) without padding
+func toReversiblePlanes(img *Image) [][]int {
+	planes := make([][]int, img.Channels)
+
+	for c := range planes {
+		planes[c] = make([]int, img.Width*img.Height)
+	}
+
+	for i := range planes[0] {
+		idx := i * img.Channels
+
+		if img.Channels == 1 {
+			planes[0][i] = int(img.Pix[idx]) - 128
+			continue
+		}
+
+		lum, cb, cr := RGBToRCT(int(img.Pix[idx]), int(img.Pix[idx+1]), int(img.Pix[idx+2]))
+		planes[0][i] = lum - 128
+		planes[1][i] = cb
+		planes[2][i] = cr
+	}
+
+	return planes
+}
+
+// Merge the planes created by toReversiblePlanes into the image
+func fromReversiblePlanes(planes [][]int, img *Image) {
+	for i := range planes[0] {
+		idx := i * img.Channels
+
+		if img.Channels == 1 {
+			img.Pix[idx] = byte(clamp255(planes[0][i] + 128))
+			continue
+		}
+
+		r, g, b := RCTToRGB(planes[0][i]+128, planes[1][i], planes[2][i])
+		img.Pix[idx] = byte(clamp255(r))
+		img.Pix[idx+1] = byte(clamp255(g))
+		img.Pix[idx+2] = byte(clamp255(b))
+	}
+}
@@ -0,0 +1,704 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/transform"
+	"math/bits"
+)
+
+// Lossy image codec based on the integer DCTs of the transform package.
+// RGB images are converted to YCbCr, then each plane is cut into macroblocks
+// (32x32 by default) and each macroblock is split (quadtree) into square
+// blocks of 4x4 to 32x32 samples. The encoder selects the split that
+// minimizes a rate/distortion cost. The coefficients of a block are quantized
+// (the steps depend on the quality and on the frequency), scanned in zigzag
+// order and coded as a DC difference (with the DC of the previous block) and
+// pairs of level and run of zeros, ended by a 0 level. The decoder only uses
+// integer arithmetic: the reconstruction is bit exact.
+//
+// Format (big endian):
+// magic (32 bits), version (8 bits), width-1 (16 bits), height-1 (16 bits),
+// channels (8 bits), quality (8 bits), coefficient coder (8 bits), log2 of the
+// min and max block sizes (4 bits each), then the coded streams (block split
+// flags, DC differences, levels and runs).
+
+const (
+	DCT_IMAGE_MAGIC     = 0x4B444354 // "KDCT"
+	DCT_IMAGE_VERSION   = 1
+	MIN_DCT_BLOCK       = 4
+	MAX_DCT_BLOCK       = 32
+	DEFAULT_DCT_QUALITY = 75
+
+	dctModes  = 0
+	dctDCs    = 1
+	dctLevels = 2
+	dctRuns   = 3
+
+	dctMaxRatio = 1 << 21 // max samples per byte (a flat image takes about 50 bytes)
+)
+
+var dctSignedStreams = []bool{false, true, true, false}
+
+// Quantization steps (in orthonormal units) at quality 50: base + slope * f
+// where f is the normalized frequency (u+v)/N
+var dctBaseSteps = [2]int{16, 24}
+var dctSlopeSteps = [2]int{48, 64}
+
+// State shared by the encoder and the decoder
+type dctCodec struct {
+	quality int
+	minLog  uint // log2 of the min block size
+	maxLog  uint // log2 of the max block size
+	dcts    [4]kanzi.IntTransform
+	zigzags [4][]int
+	steps   [4][2][]int // per block size and plane type (luma, chroma), natural order
+	coefs   []int
+	block   []int
+}
+
+func newDCTCodec() (*dctCodec, error) {
+	this := new(dctCodec)
+	var err error
+
+	if this.dcts[0], err = transform.NewDCT4(); err != nil {
+		return nil, err
+	}
+
+	if this.dcts[1], err = transform.NewDCT8(); err != nil {
+		return nil, err
+	}
+
+	if this.dcts[2], err = transform.NewDCT16(); err != nil {
+		return nil, err
+	}
+
+	if this.dcts[3], err = transform.NewDCT32(); err != nil {
+		return nil, err
+	}
+
+	for i := range this.zigzags {
+		this.zigzags[i] = zigzag(MIN_DCT_BLOCK << uint(i))
+	}
+
+	this.minLog = 2
+	this.maxLog = 5
+	this.coefs = make([]int, MAX_DCT_BLOCK*MAX_DCT_BLOCK)
+	this.block = make([]int, MAX_DCT_BLOCK*MAX_DCT_BLOCK)
+	return this, nil
+}
+
+// Return the zigzag scan order of a block of size dim x dim
+func zigzag(dim int) []int {
+	res := make([]int, dim*dim)
+	n := 0
+
+	for d := 0; d < 2*dim-1; d++ {
+		start := d
+
+		if start >= dim {
+			start = dim - 1
+		}
+
+		for i := start; i >= 0 && d-i < dim; i-- {
+			if d&1 == 0 {
+				// Up and right
+				res[n] = i*dim + d - i
+			} else {
+				// Down and left
+				res[n] = (d-i)*dim + i
+			}
+
+			n++
+		}
+	}
+
+	return res
+}
+
+// Scale factor of the quantization steps in percent (as in libjpeg)
+func qualityScale(quality int) int {
+	if quality < 50 {
+		return 5000 / quality
+	}
+
+	return 200 - 2*quality
+}
+
+func (this *dctCodec) setQuality(quality int) {
+	this.quality = quality
+	scale := qualityScale(quality)
+
+	for i := range this.steps {
+		dim := MIN_DCT_BLOCK << uint(i)
+
+		for t := range this.steps[i] {
+			steps := make([]int, dim*dim)
+
+			for u := 0; u < dim; u++ {
+				for v := 0; v < dim; v++ {
+					// The DCT output is the orthonormal DCT scaled by 256/dim
+					step := scale * (dctBaseSteps[t]*dim + dctSlopeSteps[t]*(u+v)) * 256 / (100 * dim * dim)
+
+					if step < 1 {
+						step = 1
+					}
+
+					steps[u*dim+v] = step
+				}
+			}
+
+			this.steps[i][t] = steps
+		}
+	}
+}
+
+// Dequantize the levels (zigzag order), apply the inverse DCT and store the
+// samples of the block in the plane
+func (this *dctCodec) reconstruct(levels []int, sizeLog uint, chroma int, plane []int, stride, x, y int) {
+	idx := sizeLog - 2
+	dim := 1 << sizeLog
+	steps := this.steps[idx][chroma]
+	coefs := this.coefs[0 : dim*dim]
+	block := this.block[0 : dim*dim]
+
+	for k, zz := range this.zigzags[idx] {
+		coefs[zz] = levels[k] * steps[zz]
+	}
+
+	this.dcts[idx].Inverse(coefs, block)
+
+	for i := 0; i < dim; i++ {
+		row := plane[(y+i)*stride+x : (y+i)*stride+x+dim]
+
+		for j := range row {
+			row[j] = clamp255(block[i*dim+j] + 128)
+		}
+	}
+}
+
+// Return the DC level predicted from the DC value of the previous block
+func (this *dctCodec) predictDC(pred int, sizeLog uint, chroma int) int {
+	return roundDiv(pred, this.steps[sizeLog-2][chroma][0])
+}
+
+func roundDiv(val, div int) int {
+	if val < 0 {
+		return -((-val + div>>1) / div)
+	}
+
+	return (val + div>>1) / div
+}
+
+// Return true if the data starts like an output of DCTEncoder
+func IsDCTImage(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data) == DCT_IMAGE_MAGIC
+}
+
+func paddedSize(dim int, sizeLog uint) int {
+	return ((dim + 1<<sizeLog - 1) >> sizeLog) << sizeLog
+}
+
+type DCTEncoder struct {
+	codec   *dctCodec
+	coder   int
+	lambdas [2]float64 // rate/distortion tradeoff per plane type
+	recon   *Image
+	counts  [4]int // number of blocks per size
+	levels  []int
+	streams [][]byte
+	plane   []int // reconstructed plane
+}
+
+// Create an encoder with a quality in [1..100] and a coefficient coder
+// (RANGE_CODER or EXPGOLOMB_CODER)
+func NewDCTEncoder(quality int, coder int) (*DCTEncoder, error) {
+	if quality < 1 || quality > 100 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid quality: %d (must be in [1..100])", quality)
+	}
+
+	if coder != RANGE_CODER && coder != EXPGOLOMB_CODER {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid coefficient coder: %d", coder)
+	}
+
+	codec, err := newDCTCodec()
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(DCTEncoder)
+	this.codec = codec
+	this.coder = coder
+	this.codec.setQuality(quality)
+	this.levels = make([]int, MAX_DCT_BLOCK*MAX_DCT_BLOCK)
+	scale := qualityScale(quality)
+
+	for t := range this.lambdas {
+		// Lagrange multiplier (per bit) derived from the DC quantization step
+		step := float64(dctBaseSteps[t]*scale) / 100
+
+		if step < 1 {
+			step = 1
+		}
+
+		this.lambdas[t] = 0.12 * step * step
+	}
+
+	return this, nil
+}
+
+// Restrict the block sizes (powers of 2 in [4..32]), EG. 8 and 8 to disable
+// the adaptive block size selection
+func (this *DCTEncoder) SetBlockSizes(minSize, maxSize int) error {
+	if minSize < MIN_DCT_BLOCK || maxSize > MAX_DCT_BLOCK || minSize > maxSize ||
+		minSize&(minSize-1) != 0 || maxSize&(maxSize-1) != 0 {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid block sizes: %d and %d (must be powers of 2 in [%d..%d])",
+			minSize, maxSize, MIN_DCT_BLOCK, MAX_DCT_BLOCK)
+	}
+
+	this.codec.minLog = uint(bits.Len(uint(minSize)) - 1)
+	this.codec.maxLog = uint(bits.Len(uint(maxSize)) - 1)
+	return nil
+}
+
+// Return the image decoded from the last output of Encode (identical to the
+// output of DCTDecoder)
+func (this *DCTEncoder) Reconstruction() *Image {
+	return this.recon
+}
+
+// Return the number of blocks of the given size selected by the last call to
+// Encode
+func (this *DCTEncoder) BlockCount(size int) int {
+	for i := range this.counts {
+		if size == MIN_DCT_BLOCK<<uint(i) {
+			return this.counts[i]
+		}
+	}
+
+	return 0
+}
+
+func (this *DCTEncoder) Encode(img *Image) (res []byte, err error) {
+	if err = img.check(); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = kanzi.Errorf(kanzi.ErrIO, "Cannot encode image: %v", r)
+		}
+	}()
+
+	codec := this.codec
+	width := paddedSize(img.Width, codec.maxLog)
+	height := paddedSize(img.Height, codec.maxLog)
+	planes := toPlanes(img, width, height)
+	recons := make([][]int, len(planes))
+	this.streams = make([][]byte, len(dctSignedStreams))
+	this.counts = [4]int{}
+	mbSize := 1 << codec.maxLog
+
+	for c := range planes {
+		chroma := 0
+
+		if c > 0 {
+			chroma = 1
+		}
+
+		recons[c] = make([]int, width*height)
+		this.plane = recons[c]
+		pred := 0
+
+		for y := 0; y < height; y += mbSize {
+			for x := 0; x < width; x += mbSize {
+				flags, _, _ := this.search(planes[c], width, x, y, codec.maxLog, chroma, pred)
+				this.emit(planes[c], width, x, y, codec.maxLog, chroma, &pred, flags)
+			}
+		}
+	}
+
+	if this.recon, err = NewImage(img.Width, img.Height, img.Channels); err != nil {
+		return nil, err
+	}
+
+	fromPlanes(recons, width, this.recon)
+	this.plane = nil
+
+	// Write the header and the streams
+	bs := &bufferStream{}
+	obs, err := bitstream.NewDefaultOutputBitStream(bs, 65536)
+
+	if err != nil {
+		return nil, err
+	}
+
+	obs.WriteBits(DCT_IMAGE_MAGIC, 32)
+	obs.WriteBits(DCT_IMAGE_VERSION, 8)
+	obs.WriteBits(uint64(img.Width-1), 16)
+	obs.WriteBits(uint64(img.Height-1), 16)
+	obs.WriteBits(uint64(img.Channels), 8)
+	obs.WriteBits(uint64(codec.quality), 8)
+	obs.WriteBits(uint64(this.coder), 8)
+	obs.WriteBits(uint64(codec.minLog), 4)
+	obs.WriteBits(uint64(codec.maxLog), 4)
+
+	if err = writeStreams(obs, this.coder, this.streams, dctSignedStreams); err != nil {
+		return nil, err
+	}
+
+	if _, err = obs.Close(); err != nil {
+		return nil, err
+	}
+
+	this.streams = nil
+	return bs.Bytes(), nil
+}
+
+// Transform and quantize a block, return the levels in zigzag order
+func (this *DCTEncoder) quantize(plane []int, stride, x, y int, sizeLog uint, chroma int) []int {
+	codec := this.codec
+	idx := sizeLog - 2
+	dim := 1 << sizeLog
+	steps := codec.steps[idx][chroma]
+	block := codec.block[0 : dim*dim]
+	coefs := codec.coefs[0 : dim*dim]
+	levels := this.levels[0 : dim*dim]
+
+	for i := 0; i < dim; i++ {
+		for j := 0; j < dim; j++ {
+			block[i*dim+j] = plane[(y+i)*stride+x+j] - 128
+		}
+	}
+
+	codec.dcts[idx].Forward(block, coefs)
+	levels[0] = roundDiv(coefs[0], steps[0])
+
+	for k := 1; k < len(levels); k++ {
+		zz := codec.zigzags[idx][k]
+		c := coefs[zz]
+
+		// Dead zone quantizer (rounding offset of 3/8)
+		if c < 0 {
+			levels[k] = -((-c*8 + steps[zz]*3) / (steps[zz] * 8))
+		} else {
+			levels[k] = (c*8 + steps[zz]*3) / (steps[zz] * 8)
+		}
+
+		if levels[k] > MAX_VALUE {
+			levels[k] = MAX_VALUE
+		} else if levels[k] < -MAX_VALUE {
+			levels[k] = -MAX_VALUE
+		}
+	}
+
+	return levels
+}
+
+// Estimate the number of bits of a leaf block (Exp-Golomb code lengths)
+func estimateBits(levels []int, dcDiff int) int {
+	res := signedBits(dcDiff) + 1 // and end of block
+	last := 0
+
+	for k := 1; k < len(levels); k++ {
+		if levels[k] != 0 {
+			res += signedBits(levels[k]) + 2*bits.Len(uint(k-last)) - 1
+			last = k
+		}
+	}
+
+	return res
+}
+
+func signedBits(val int) int {
+	if val == 0 {
+		return 1
+	}
+
+	if val < 0 {
+		val = -val
+	}
+
+	return 2 * bits.Len(uint(val+1))
+}
+
+// Find the split of the block that minimizes the rate/distortion cost. Return
+// the split flags (depth first), the cost and the DC value of the last block.
+func (this *DCTEncoder) search(plane []int, stride, x, y int, sizeLog uint, chroma int, pred int) ([]byte, float64, int) {
+	codec := this.codec
+	dim := 1 << sizeLog
+	levels := this.quantize(plane, stride, x, y, sizeLog, chroma)
+	dcDiff := levels[0] - codec.predictDC(pred, sizeLog, chroma)
+	rate := estimateBits(levels, dcDiff)
+	leafPred := levels[0] * codec.steps[sizeLog-2][chroma][0]
+	codec.reconstruct(levels, sizeLog, chroma, this.plane, stride, x, y)
+	dist := 0
+
+	for i := y; i < y+dim; i++ {
+		for j := x; j < x+dim; j++ {
+			d := plane[i*stride+j] - this.plane[i*stride+j]
+			dist += d * d
+		}
+	}
+
+	if sizeLog == codec.minLog {
+		return nil, float64(dist) + this.lambdas[chroma]*float64(rate), leafPred
+	}
+
+	leafCost := float64(dist) + this.lambdas[chroma]*float64(rate+1)
+	splitFlags := []byte{1}
+	splitCost := this.lambdas[chroma]
+	half := dim >> 1
+
+	for i := 0; i < 4; i++ {
+		flags, cost, p := this.search(plane, stride, x+(i&1)*half, y+(i>>1)*half, sizeLog-1, chroma, pred)
+		splitFlags = append(splitFlags, flags...)
+		splitCost += cost
+		pred = p
+
+		if splitCost >= leafCost {
+			break
+		}
+	}
+
+	if splitCost < leafCost {
+		return splitFlags, splitCost, pred
+	}
+
+	return []byte{0}, leafCost, leafPred
+}
+
+// Code the block (split with the given flags) and reconstruct it
+func (this *DCTEncoder) emit(plane []int, stride, x, y int, sizeLog uint, chroma int, pred *int, flags []byte) []byte {
+	codec := this.codec
+
+	if sizeLog > codec.minLog {
+		flag := flags[0]
+		flags = flags[1:]
+		this.streams[dctModes] = append(this.streams[dctModes], flag)
+
+		if flag == 1 {
+			half := 1 << (sizeLog - 1)
+
+			for i := 0; i < 4; i++ {
+				flags = this.emit(plane, stride, x+(i&1)*half, y+(i>>1)*half, sizeLog-1, chroma, pred, flags)
+			}
+
+			return flags
+		}
+	}
+
+	levels := this.quantize(plane, stride, x, y, sizeLog, chroma)
+	this.streams[dctDCs] = appendValue(this.streams[dctDCs], levels[0]-codec.predictDC(*pred, sizeLog, chroma))
+	last := 0
+
+	for k := 1; k < len(levels); k++ {
+		if levels[k] != 0 {
+			this.streams[dctLevels] = appendValue(this.streams[dctLevels], levels[k])
+			this.streams[dctRuns] = appendRun(this.streams[dctRuns], k-last-1)
+			last = k
+		}
+	}
+
+	// End of block
+	this.streams[dctLevels] = appendValue(this.streams[dctLevels], 0)
+	*pred = levels[0] * codec.steps[sizeLog-2][chroma][0]
+	codec.reconstruct(levels, sizeLog, chroma, this.plane, stride, x, y)
+	this.counts[sizeLog-2]++
+	return flags
+}
+
+type DCTDecoder struct {
+	codec   *dctCodec
+	levels  []int
+	streams []*byteStream
+}
+
+func NewDCTDecoder() (*DCTDecoder, error) {
+	codec, err := newDCTCodec()
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(DCTDecoder)
+	this.codec = codec
+	this.levels = make([]int, MAX_DCT_BLOCK*MAX_DCT_BLOCK)
+	return this, nil
+}
+
+func (this *DCTDecoder) Decode(data []byte) (img *Image, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			img = nil
+			err = panicError(r)
+		}
+	}()
+
+	ibs, err := bitstream.NewDefaultInputBitStream(&readerStream{bytes.NewReader(data)}, 65536)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ibs.ReadBits(32) != DCT_IMAGE_MAGIC {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: not a DCT image")
+	}
+
+	if version := ibs.ReadBits(8); version != DCT_IMAGE_VERSION {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported DCT image version: %d", version)
+	}
+
+	width := int(ibs.ReadBits(16)) + 1
+	height := int(ibs.ReadBits(16)) + 1
+	channels := int(ibs.ReadBits(8))
+	quality := int(ibs.ReadBits(8))
+	coder := int(ibs.ReadBits(8))
+	codec := this.codec
+	codec.minLog = uint(ibs.ReadBits(4))
+	codec.maxLog = uint(ibs.ReadBits(4))
+
+	if quality < 1 || quality > 100 || (coder != RANGE_CODER && coder != EXPGOLOMB_CODER) ||
+		codec.minLog < 2 || codec.maxLog > 5 || codec.minLog > codec.maxLog {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: invalid header")
+	}
+
+	if err = checkDecodedSize(width, height, channels, len(data), dctMaxRatio); err != nil {
+		return nil, err
+	}
+
+	if img, err = NewImage(width, height, channels); err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: %v", err)
+	}
+
+	codec.setQuality(quality)
+	width = paddedSize(width, codec.maxLog)
+	height = paddedSize(height, codec.maxLog)
+
+	// At most one escaped value and one run per sample
+	if this.streams, err = readStreams(ibs, coder, dctSignedStreams, 5*width*height*channels+16); err != nil {
+		return nil, err
+	}
+
+	planes := make([][]int, channels)
+	mbSize := 1 << codec.maxLog
+
+	for c := range planes {
+		chroma := 0
+
+		if c > 0 {
+			chroma = 1
+		}
+
+		planes[c] = make([]int, width*height)
+		pred := 0
+
+		for y := 0; y < height; y += mbSize {
+			for x := 0; x < width; x += mbSize {
+				if err = this.decodeBlock(planes[c], width, x, y, codec.maxLog, chroma, &pred); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for i, s := range this.streams {
+		if s.pos != len(s.buf) {
+			return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: %d bytes left in stream %d", len(s.buf)-s.pos, i)
+		}
+	}
+
+	this.streams = nil
+	fromPlanes(planes, width, img)
+	return img, nil
+}
+
+func (this *DCTDecoder) decodeBlock(plane []int, stride, x, y int, sizeLog uint, chroma int, pred *int) error {
+	codec := this.codec
+
+	if sizeLog > codec.minLog {
+		flag, err := this.streams[dctModes].readByte()
+
+		if err != nil {
+			return err
+		}
+
+		if flag > 1 {
+			return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: invalid block mode %d", flag)
+		}
+
+		if flag == 1 {
+			half := 1 << (sizeLog - 1)
+
+			for i := 0; i < 4; i++ {
+				if err := this.decodeBlock(plane, stride, x+(i&1)*half, y+(i>>1)*half, sizeLog-1, chroma, pred); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	dim := 1 << sizeLog
+	levels := this.levels[0 : dim*dim]
+
+	for i := range levels {
+		levels[i] = 0
+	}
+
+	dcDiff, err := this.streams[dctDCs].readValue()
+
+	if err != nil {
+		return err
+	}
+
+	levels[0] = codec.predictDC(*pred, sizeLog, chroma) + dcDiff
+
+	for k := 0; ; {
+		level, err := this.streams[dctLevels].readValue()
+
+		if err != nil {
+			return err
+		}
+
+		if level == 0 {
+			break
+		}
+
+		run, err := this.streams[dctRuns].readRun()
+
+		if err != nil {
+			return err
+		}
+
+		if k += run + 1; k >= len(levels) {
+			return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: invalid run length")
+		}
+
+		levels[k] = level
+	}
+
+	*pred = levels[0] * codec.steps[sizeLog-2][chroma][0]
+	codec.reconstruct(levels, sizeLog, chroma, plane, stride, x, y)
+	return nil
+}
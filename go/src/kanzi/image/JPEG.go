@@ -0,0 +1,586 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"kanzi"
+	"kanzi/entropy"
+	"kanzi/transform"
+	"math/bits"
+)
+
+// Baseline JPEG (JFIF) encoder. The samples (Y, Cb, Cr with 4:2:0 chroma
+// subsampling for RGB images) are transformed by DCT8 and quantized with the
+// tables of the JPEG specification (Annex K) scaled by the quality (as in
+// libjpeg). The coefficients are coded with the standard Huffman tables or
+// with tables optimized for the image (2 passes). The output can be decoded
+// by any JPEG decoder.
+
+const (
+	JPEG_SOI  = 0xD8 // markers
+	JPEG_EOI  = 0xD9
+	JPEG_APP0 = 0xE0
+	JPEG_DQT  = 0xDB
+	JPEG_SOF0 = 0xC0
+	JPEG_DHT  = 0xC4
+	JPEG_SOS  = 0xDA
+
+	DEFAULT_JPEG_QUALITY = 75
+	JPEG_MAX_CODE_SIZE   = 16
+	jpegMaxAC            = 1023 // largest AC level of baseline JPEG (size 10)
+)
+
+// Quantization tables (natural order)
+var jpegLuminanceQuant = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+var jpegChrominanceQuant = [64]int{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
+
+// Standard Huffman tables: number of codes of each size (1 to 16), then the
+// symbols
+var jpegStandardTables = [4][]byte{
+	// DC luminance
+	{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0,
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	// AC luminance
+	{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7D,
+		0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+		0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xA1, 0x08, 0x23, 0x42, 0xB1, 0xC1, 0x15, 0x52, 0xD1, 0xF0,
+		0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0A, 0x16, 0x17, 0x18, 0x19, 0x1A, 0x25, 0x26, 0x27, 0x28,
+		0x29, 0x2A, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3A, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+		0x4A, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5A, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+		0x6A, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7A, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+		0x8A, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9A, 0xA2, 0xA3, 0xA4, 0xA5, 0xA6, 0xA7,
+		0xA8, 0xA9, 0xAA, 0xB2, 0xB3, 0xB4, 0xB5, 0xB6, 0xB7, 0xB8, 0xB9, 0xBA, 0xC2, 0xC3, 0xC4, 0xC5,
+		0xC6, 0xC7, 0xC8, 0xC9, 0xCA, 0xD2, 0xD3, 0xD4, 0xD5, 0xD6, 0xD7, 0xD8, 0xD9, 0xDA, 0xE1, 0xE2,
+		0xE3, 0xE4, 0xE5, 0xE6, 0xE7, 0xE8, 0xE9, 0xEA, 0xF1, 0xF2, 0xF3, 0xF4, 0xF5, 0xF6, 0xF7, 0xF8,
+		0xF9, 0xFA},
+	// DC chrominance
+	{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0,
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	// AC chrominance
+	{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77,
+		0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+		0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xA1, 0xB1, 0xC1, 0x09, 0x23, 0x33, 0x52, 0xF0,
+		0x15, 0x62, 0x72, 0xD1, 0x0A, 0x16, 0x24, 0x34, 0xE1, 0x25, 0xF1, 0x17, 0x18, 0x19, 0x1A, 0x26,
+		0x27, 0x28, 0x29, 0x2A, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3A, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+		0x49, 0x4A, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5A, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+		0x69, 0x6A, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7A, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+		0x88, 0x89, 0x8A, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9A, 0xA2, 0xA3, 0xA4, 0xA5,
+		0xA6, 0xA7, 0xA8, 0xA9, 0xAA, 0xB2, 0xB3, 0xB4, 0xB5, 0xB6, 0xB7, 0xB8, 0xB9, 0xBA, 0xC2, 0xC3,
+		0xC4, 0xC5, 0xC6, 0xC7, 0xC8, 0xC9, 0xCA, 0xD2, 0xD3, 0xD4, 0xD5, 0xD6, 0xD7, 0xD8, 0xD9, 0xDA,
+		0xE2, 0xE3, 0xE4, 0xE5, 0xE6, 0xE7, 0xE8, 0xE9, 0xEA, 0xF2, 0xF3, 0xF4, 0xF5, 0xF6, 0xF7, 0xF8,
+		0xF9, 0xFA},
+}
+
+// Huffman table in canonical order
+type jpegHuffmanTable struct {
+	sizes [256]byte
+	codes [256]uint
+	ranks []byte // symbols sorted by code size then value
+}
+
+// Build the table from the code sizes of the symbols (0: absent)
+func newJPEGHuffmanTable(sizes []byte) (*jpegHuffmanTable, error) {
+	this := new(jpegHuffmanTable)
+
+	for s, size := range sizes {
+		if size != 0 {
+			this.sizes[s] = size
+			this.ranks = append(this.ranks, byte(s))
+		}
+	}
+
+	if len(this.ranks) == 0 || entropy.GenerateCanonicalCodes(this.sizes[:], this.codes[:], this.ranks) < 0 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid Huffman code sizes")
+	}
+
+	return this, nil
+}
+
+// Expand a standard table (sizes then symbols) into code sizes
+func standardCodeSizes(table []byte) []byte {
+	sizes := make([]byte, 256)
+	n := 16
+
+	for i := 0; i < 16; i++ {
+		for j := 0; j < int(table[i]); j++ {
+			sizes[table[n]] = byte(i + 1)
+			n++
+		}
+	}
+
+	return sizes
+}
+
+// Compute the optimal code sizes (at most 16 bits) from the symbol
+// frequencies (JPEG specification, Annex K.2). A reserved symbol makes sure
+// that no code is made of 1 bits only.
+func optimalCodeSizes(freqs []int) []byte {
+	var freq [257]int
+	var codeSize [257]int
+	var others [257]int
+	copy(freq[:], freqs)
+	freq[256] = 1
+
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		// Find the 2 least frequent symbols (the largest index wins ties)
+		c1, c2 := -1, -1
+
+		for i := range freq {
+			if freq[i] == 0 {
+				continue
+			}
+
+			if c1 < 0 || freq[i] <= freq[c1] {
+				c2 = c1
+				c1 = i
+			} else if c2 < 0 || freq[i] <= freq[c2] {
+				c2 = i
+			}
+		}
+
+		if c2 < 0 {
+			break
+		}
+
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+
+		for codeSize[c1]++; others[c1] >= 0; codeSize[c1]++ {
+			c1 = others[c1]
+		}
+
+		others[c1] = c2
+
+		for codeSize[c2]++; others[c2] >= 0; codeSize[c2]++ {
+			c2 = others[c2]
+		}
+	}
+
+	// Count the codes of each size and limit the size to 16 bits
+	var counts [33]int
+
+	for _, size := range codeSize {
+		if size > 0 {
+			counts[min(size, 32)]++
+		}
+	}
+
+	for i := 32; i > JPEG_MAX_CODE_SIZE; i-- {
+		for counts[i] > 0 {
+			j := i - 2
+
+			for counts[j] == 0 {
+				j--
+			}
+
+			counts[i] -= 2
+			counts[i-1]++
+			counts[j+1] += 2
+			counts[j]--
+		}
+	}
+
+	// Remove the reserved code (one of the longest codes)
+	i := JPEG_MAX_CODE_SIZE
+
+	for counts[i] == 0 {
+		i--
+	}
+
+	counts[i]--
+
+	// Assign the sizes to the symbols in order of decreasing frequency
+	sizes := make([]byte, 256)
+	size := 1
+
+	for s := 1; s <= 32; s++ {
+		for sym := 0; sym < 256; sym++ {
+			if min(codeSize[sym], 32) != s {
+				continue
+			}
+
+			for counts[size] == 0 {
+				size++
+			}
+
+			sizes[sym] = byte(size)
+			counts[size]--
+		}
+	}
+
+	return sizes
+}
+
+// Bit writer with byte stuffing (a 0 byte follows each 0xFF byte)
+type jpegBitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (this *jpegBitWriter) writeBits(val uint, size uint) {
+	this.acc = this.acc<<size | uint64(val&(1<<size-1))
+	this.bits += size
+
+	for this.bits >= 8 {
+		this.bits -= 8
+		b := byte(this.acc >> this.bits)
+		this.buf = append(this.buf, b)
+
+		if b == 0xFF {
+			this.buf = append(this.buf, 0)
+		}
+	}
+
+	this.acc &= 1<<this.bits - 1
+}
+
+// Pad the last byte with 1 bits
+func (this *jpegBitWriter) flush() {
+	if this.bits > 0 {
+		this.writeBits(0x7F, 8-this.bits)
+	}
+}
+
+type JPEGEncoder struct {
+	quality  int
+	optimize bool
+	quant    [2][64]int // natural order
+	dct      *transform.DCT8
+	zigzag   []int
+	tables   [4]*jpegHuffmanTable // DC, AC (luminance) then DC, AC (chrominance)
+	freqs    [4][]int
+	counting bool
+	writer   jpegBitWriter
+}
+
+// Create an encoder with a quality in [1..100]. If optimize is true, the
+// Huffman tables are computed for each image, otherwise the standard tables
+// are used.
+func NewJPEGEncoder(quality int, optimize bool) (*JPEGEncoder, error) {
+	if quality < 1 || quality > 100 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid quality: %d (must be in [1..100])", quality)
+	}
+
+	this := new(JPEGEncoder)
+	this.quality = quality
+	this.optimize = optimize
+	this.zigzag = zigzag(8)
+	var err error
+
+	if this.dct, err = transform.NewDCT8(); err != nil {
+		return nil, err
+	}
+
+	scale := qualityScale(quality)
+
+	for i := 0; i < 64; i++ {
+		this.quant[0][i] = max(1, min(255, (jpegLuminanceQuant[i]*scale+50)/100))
+		this.quant[1][i] = max(1, min(255, (jpegChrominanceQuant[i]*scale+50)/100))
+	}
+
+	for i := range this.freqs {
+		this.freqs[i] = make([]int, 256)
+	}
+
+	return this, nil
+}
+
+// Encode the image into a JFIF file
+func (this *JPEGEncoder) Encode(img *Image) ([]byte, error) {
+	if err := img.check(); err != nil {
+		return nil, err
+	}
+
+	// Minimum coded unit: 16x16 pixels with subsampled chroma, 8x8 otherwise
+	mcuSize := 8
+
+	if img.Channels == 3 {
+		mcuSize = 16
+	}
+
+	width := paddedSize(img.Width, uint(bits.Len(uint(mcuSize))-1))
+	height := paddedSize(img.Height, uint(bits.Len(uint(mcuSize))-1))
+	planes := toPlanes(img, width, height)
+	strides := make([]int, len(planes))
+	strides[0] = width
+
+	for c := 1; c < len(planes); c++ {
+		planes[c] = downsample(planes[c], width, height)
+		strides[c] = width / 2
+	}
+
+	// Quantize all the blocks in scan order (MCU by MCU)
+	var blocks [][64]int
+	var comps []int
+
+	for my := 0; my < height; my += mcuSize {
+		for mx := 0; mx < width; mx += mcuSize {
+			for y := my; y < my+mcuSize; y += 8 {
+				for x := mx; x < mx+mcuSize; x += 8 {
+					blocks = append(blocks, this.quantize(planes[0], strides[0], x, y, 0))
+					comps = append(comps, 0)
+				}
+			}
+
+			for c := 1; c < len(planes); c++ {
+				blocks = append(blocks, this.quantize(planes[c], strides[c], mx/2, my/2, 1))
+				comps = append(comps, c)
+			}
+		}
+	}
+
+	// Build the Huffman tables (first pass to count the symbols if optimized)
+	tables := 2 * min(img.Channels, 2)
+
+	if this.optimize == true {
+		for t := range this.freqs {
+			for i := range this.freqs[t] {
+				this.freqs[t][i] = 0
+			}
+		}
+
+		this.counting = true
+		this.encodeBlocks(blocks, comps)
+		this.counting = false
+	}
+
+	for t := 0; t < tables; t++ {
+		sizes := standardCodeSizes(jpegStandardTables[t])
+
+		if this.optimize == true {
+			sizes = optimalCodeSizes(this.freqs[t])
+		}
+
+		var err error
+
+		if this.tables[t], err = newJPEGHuffmanTable(sizes); err != nil {
+			return nil, err
+		}
+	}
+
+	this.writer = jpegBitWriter{buf: make([]byte, 0, width*height/4)}
+	this.writeHeaders(img)
+	this.encodeBlocks(blocks, comps)
+	this.writer.flush()
+	res := append(this.writer.buf, 0xFF, JPEG_EOI)
+	this.writer.buf = nil
+	return res, nil
+}
+
+// Average the 2x2 blocks of samples
+func downsample(plane []int, width, height int) []int {
+	res := make([]int, width*height/4)
+	bias := 1
+
+	for y := 0; y < height; y += 2 {
+		for x := 0; x < width; x += 2 {
+			i := y*width + x
+			res[(y/2)*(width/2)+x/2] = (plane[i] + plane[i+1] + plane[i+width] + plane[i+width+1] + bias) >> 2
+			bias ^= 3 // alternate 1 and 2 to avoid a rounding bias
+		}
+	}
+
+	return res
+}
+
+// Return the quantized coefficients of the 8x8 block in zigzag order
+func (this *JPEGEncoder) quantize(plane []int, stride, x, y, chroma int) [64]int {
+	var block, coefs [64]int
+	var res [64]int
+
+	for j := 0; j < 8; j++ {
+		for i := 0; i < 8; i++ {
+			block[j*8+i] = plane[(y+j)*stride+x+i] - 128
+		}
+	}
+
+	this.dct.Forward(block[:], coefs[:])
+
+	for k, pos := range this.zigzag {
+		// The DCT output is the orthonormal DCT (JPEG FDCT) scaled by 32
+		level := roundDiv(coefs[pos], 32*this.quant[chroma][pos])
+
+		if k > 0 {
+			level = max(-jpegMaxAC, min(jpegMaxAC, level))
+		}
+
+		res[k] = level
+	}
+
+	return res
+}
+
+// Emit (or count) the Huffman symbols of all the blocks
+func (this *JPEGEncoder) encodeBlocks(blocks [][64]int, comps []int) {
+	var preds [3]int
+
+	for b := range blocks {
+		c := comps[b]
+		t := 0
+
+		if c > 0 {
+			t = 2
+		}
+
+		block := &blocks[b]
+		diff := block[0] - preds[c]
+		preds[c] = block[0]
+		this.encodeValue(t, 0, diff)
+		run := 0
+
+		for k := 1; k < 64; k++ {
+			if block[k] == 0 {
+				run++
+				continue
+			}
+
+			for run > 15 {
+				this.encodeSymbol(t+1, 0xF0) // ZRL
+				run -= 16
+			}
+
+			this.encodeValue(t+1, run, block[k])
+			run = 0
+		}
+
+		if run > 0 {
+			this.encodeSymbol(t+1, 0x00) // EOB
+		}
+	}
+}
+
+// Emit the symbol (run and size of the value) then the bits of the value
+func (this *JPEGEncoder) encodeValue(table, run, val int) {
+	abs := val
+
+	if val < 0 {
+		abs = -val
+		val--
+	}
+
+	size := uint(bits.Len(uint(abs)))
+	this.encodeSymbol(table, byte(run<<4)|byte(size))
+
+	if this.counting == false && size > 0 {
+		this.writer.writeBits(uint(val), size)
+	}
+}
+
+func (this *JPEGEncoder) encodeSymbol(table int, symbol byte) {
+	if this.counting == true {
+		this.freqs[table][symbol]++
+		return
+	}
+
+	t := this.tables[table]
+	this.writer.writeBits(t.codes[symbol], uint(t.sizes[symbol]))
+}
+
+func (this *JPEGEncoder) writeMarker(marker byte, payload []byte) {
+	length := len(payload) + 2
+	this.writer.buf = append(this.writer.buf, 0xFF, marker, byte(length>>8), byte(length))
+	this.writer.buf = append(this.writer.buf, payload...)
+}
+
+func (this *JPEGEncoder) writeHeaders(img *Image) {
+	this.writer.buf = append(this.writer.buf, 0xFF, JPEG_SOI)
+
+	// JFIF 1.01, no units, aspect ratio 1:1, no thumbnail
+	this.writeMarker(JPEG_APP0, []byte{'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0})
+
+	// Quantization tables (zigzag order)
+	tables := min(img.Channels, 2)
+	var payload []byte
+
+	for t := 0; t < tables; t++ {
+		payload = append(payload, byte(t))
+
+		for _, pos := range this.zigzag {
+			payload = append(payload, byte(this.quant[t][pos]))
+		}
+	}
+
+	this.writeMarker(JPEG_DQT, payload)
+
+	// Frame: 8 bit samples, components (Y sampled 2x2 if chroma is subsampled)
+	payload = []byte{8, byte(img.Height >> 8), byte(img.Height), byte(img.Width >> 8), byte(img.Width),
+		byte(img.Channels)}
+
+	for c := 0; c < img.Channels; c++ {
+		sampling := byte(0x11)
+
+		if c == 0 && img.Channels == 3 {
+			sampling = 0x22
+		}
+
+		payload = append(payload, byte(c+1), sampling, byte(min(c, 1)))
+	}
+
+	this.writeMarker(JPEG_SOF0, payload)
+
+	// Huffman tables: class (0: DC, 1: AC) and index
+	payload = payload[:0]
+
+	for t := 0; t < 2*tables; t++ {
+		table := this.tables[t]
+		payload = append(payload, byte((t&1)<<4|t>>1))
+		var counts [JPEG_MAX_CODE_SIZE]byte
+
+		for _, s := range table.ranks {
+			counts[table.sizes[s]-1]++
+		}
+
+		payload = append(payload, counts[:]...)
+		payload = append(payload, table.ranks...)
+	}
+
+	this.writeMarker(JPEG_DHT, payload)
+
+	// Scan: all the components, spectral selection 0..63
+	payload = []byte{byte(img.Channels)}
+
+	for c := 0; c < img.Channels; c++ {
+		t := byte(min(c, 1))
+		payload = append(payload, byte(c+1), t<<4|t)
+	}
+
+	payload = append(payload, 0, 63, 0)
+	this.writeMarker(JPEG_SOS, payload)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"fmt"
+	"kanzi"
+	"math"
+	"strings"
+)
+
+// Distortion between an original image and its reconstruction
+type Report struct {
+	MSE         float64   // mean squared error over all samples
+	PSNR        float64   // in dB, +Inf for identical images
+	ChannelMSE  []float64 // per channel (R, G, B or gray)
+	ChannelPSNR []float64
+	MaxError    int // max absolute sample difference
+}
+
+func psnr(mse float64) float64 {
+	if mse == 0 {
+		return math.Inf(1)
+	}
+
+	return 10 * math.Log10(255*255/mse)
+}
+
+// Compare two images with the same dimensions and channels
+func Compare(ref, img *Image) (*Report, error) {
+	if err := ref.check(); err != nil {
+		return nil, err
+	}
+
+	if err := img.check(); err != nil {
+		return nil, err
+	}
+
+	if ref.Width != img.Width || ref.Height != img.Height || ref.Channels != img.Channels {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Cannot compare a %dx%dx%d image with a %dx%dx%d image",
+			ref.Width, ref.Height, ref.Channels, img.Width, img.Height, img.Channels)
+	}
+
+	this := &Report{}
+	this.ChannelMSE = make([]float64, ref.Channels)
+	this.ChannelPSNR = make([]float64, ref.Channels)
+	sums := make([]uint64, ref.Channels)
+	total := uint64(0)
+	n := ref.Width * ref.Height * ref.Channels
+
+	for i := 0; i < n; i++ {
+		d := int(ref.Pix[i]) - int(img.Pix[i])
+
+		if d < 0 {
+			d = -d
+		}
+
+		if d > this.MaxError {
+			this.MaxError = d
+		}
+
+		sums[i%ref.Channels] += uint64(d * d)
+		total += uint64(d * d)
+	}
+
+	for c := range sums {
+		this.ChannelMSE[c] = float64(sums[c]) / float64(ref.Width*ref.Height)
+		this.ChannelPSNR[c] = psnr(this.ChannelMSE[c])
+	}
+
+	this.MSE = float64(total) / float64(n)
+	this.PSNR = psnr(this.MSE)
+	return this, nil
+}
+
+// Return the PSNR of the reconstruction in dB
+func PSNR(ref, img *Image) (float64, error) {
+	report, err := Compare(ref, img)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return report.PSNR, nil
+}
+
+func (this *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "PSNR: %.2f dB (MSE: %.3f, max error: %d)", this.PSNR, this.MSE, this.MaxError)
+
+	if len(this.ChannelPSNR) == 3 {
+		fmt.Fprintf(&sb, " [R: %.2f dB, G: %.2f dB, B: %.2f dB]", this.ChannelPSNR[0], this.ChannelPSNR[1],
+			this.ChannelPSNR[2])
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"kanzi"
+)
+
+// Errors wrap kanzi.ErrInvalidParam (invalid image), kanzi.ErrLimitExceeded
+// (image too large), kanzi.ErrUnsupported (unsupported PNM variant) or
+// kanzi.ErrCorruptData (invalid PNM data). The errors of the underlying
+// readers and writers are returned as is.
+
+const (
+	MAX_IMAGE_DIM     = 1 << 16 // max width and height
+	MAX_IMAGE_SAMPLES = 1 << 26 // max width*height*channels
+)
+
+// An image with 8 bit samples. The samples of a pixel are interleaved (EG.
+// RGB for 3 channels) and the pixels are stored row by row.
+type Image struct {
+	Width    int
+	Height   int
+	Channels int // 1 (gray) or 3 (RGB)
+	Pix      []byte
+}
+
+func NewImage(width, height, channels int) (*Image, error) {
+	if err := checkDimensions(width, height, channels); err != nil {
+		return nil, err
+	}
+
+	this := new(Image)
+	this.Width = width
+	this.Height = height
+	this.Channels = channels
+	this.Pix = make([]byte, width*height*channels)
+	return this, nil
+}
+
+func checkDimensions(width, height, channels int) error {
+	if width <= 0 || width > MAX_IMAGE_DIM || height <= 0 || height > MAX_IMAGE_DIM {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid image dimensions: %dx%d", width, height)
+	}
+
+	if channels != 1 && channels != 3 {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of channels: %d (must be 1 or 3)", channels)
+	}
+
+	if width*height*channels > MAX_IMAGE_SAMPLES {
+		return kanzi.Errorf(kanzi.ErrLimitExceeded, "Image too large: %dx%dx%d samples (max %d)", width, height,
+			channels, MAX_IMAGE_SAMPLES)
+	}
+
+	return nil
+}
+
+// Check the dimensions read from the header of an encoded image before the
+// image is allocated. The encoders code a flat image with a few bytes
+// whatever its size: the number of samples is bounded by 'ratio' times the
+// size of the data.
+func checkDecodedSize(width, height, channels, dataSize, ratio int) error {
+	if err := checkDimensions(width, height, channels); err != nil {
+		if errors.Is(err, kanzi.ErrLimitExceeded) == true {
+			return err
+		}
+
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: %v", err)
+	}
+
+	if samples := width * height * channels; samples/ratio > dataSize {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: %d samples for %d bytes", samples, dataSize)
+	}
+
+	return nil
+}
+
+func (this *Image) check() error {
+	if err := checkDimensions(this.Width, this.Height, this.Channels); err != nil {
+		return err
+	}
+
+	if len(this.Pix) < this.Width*this.Height*this.Channels {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid image: %d samples, expected %d", len(this.Pix),
+			this.Width*this.Height*this.Channels)
+	}
+
+	return nil
+}
+
+// Read a binary PGM (P5) or PPM (P6) image with a max value of at most 255
+func ReadPNM(r io.Reader) (*Image, error) {
+	br := bufio.NewReader(r)
+	var magic [2]byte
+
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid PNM header: %v", err)
+	}
+
+	if magic[0] != 'P' || (magic[1] != '5' && magic[1] != '6') {
+		if magic[0] == 'P' && magic[1] >= '1' && magic[1] <= '7' {
+			return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported PNM format: P%c (only P5 and P6 are supported)", magic[1])
+		}
+
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid PNM header: not a PGM or PPM image")
+	}
+
+	var fields [3]int
+
+	for i := range fields {
+		var err error
+
+		if fields[i], err = readPNMField(br); err != nil {
+			return nil, err
+		}
+	}
+
+	// A single whitespace precedes the samples
+	if _, err := br.ReadByte(); err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid PNM header: %v", err)
+	}
+
+	if fields[2] == 0 || fields[2] > 255 {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported PNM max value: %d (must be in [1..255])", fields[2])
+	}
+
+	channels := 1
+
+	if magic[1] == '6' {
+		channels = 3
+	}
+
+	if err := checkDimensions(fields[0], fields[1], channels); err != nil {
+		return nil, err
+	}
+
+	// Read the samples before allocating the image: a truncated file does
+	// not allocate the size claimed by the header
+	var samples bytes.Buffer
+	size := int64(fields[0] * fields[1] * channels)
+
+	if n, err := samples.ReadFrom(io.LimitReader(br, size)); err != nil || n != size {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid PNM data: %v", err)
+	}
+
+	img := &Image{Width: fields[0], Height: fields[1], Channels: channels, Pix: samples.Bytes()}
+
+	if fields[2] != 255 {
+		// Rescale to 8 bits
+		for i := range img.Pix {
+			img.Pix[i] = byte((int(img.Pix[i])*255 + fields[2]>>1) / fields[2])
+		}
+	}
+
+	return img, nil
+}
+
+// Read a decimal field of a PNM header (skip whitespaces and comments)
+func readPNMField(br *bufio.Reader) (int, error) {
+	val := -1
+
+	for {
+		b, err := br.ReadByte()
+
+		if err != nil {
+			return 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid PNM header: %v", err)
+		}
+
+		if b >= '0' && b <= '9' {
+			if val < 0 {
+				val = 0
+			}
+
+			if val = 10*val + int(b-'0'); val > MAX_IMAGE_DIM {
+				return 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid PNM header: value too large")
+			}
+
+			continue
+		}
+
+		if val >= 0 {
+			// End of the field: leave the separator for the caller
+			return val, br.UnreadByte()
+		}
+
+		if b == '#' {
+			// Comment up to the end of the line
+			if _, err := br.ReadString('\n'); err != nil {
+				return 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid PNM header: %v", err)
+			}
+		} else if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+			return 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid PNM header: unexpected character %q", b)
+		}
+	}
+}
+
+// Write the image as binary PGM (1 channel) or PPM (3 channels)
+func WritePNM(w io.Writer, img *Image) error {
+	if err := img.check(); err != nil {
+		return err
+	}
+
+	format := 5
+
+	if img.Channels == 3 {
+		format = 6
+	}
+
+	if _, err := fmt.Fprintf(w, "P%d\n%d %d\n255\n", format, img.Width, img.Height); err != nil {
+		return err
+	}
+
+	_, err := w.Write(img.Pix[0 : img.Width*img.Height*img.Channels])
+	return err
+}
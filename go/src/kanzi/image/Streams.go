@@ -0,0 +1,230 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"bytes"
+	"kanzi"
+	"kanzi/entropy"
+	"strings"
+)
+
+// The coded symbols of an image are split into byte streams (EG. block modes,
+// DC differences, levels, runs) with their own statistics. Each stream is
+// entropy coded separately: the lengths of the streams (32 bits each) are
+// followed by the coded streams.
+// Signed values are coded on one byte (as an int8) when they fit in
+// [-127..127], otherwise an escape byte is followed by the value on 24 bits.
+// Runs are coded as a sequence of 255 bytes followed by the remainder.
+
+const (
+	RANGE_CODER     = 0 // RangeCodec
+	EXPGOLOMB_CODER = 1 // ExpGolombCodec
+
+	VALUE_ESCAPE = 0x80
+	MAX_VALUE    = 1<<23 - 1
+)
+
+func GetCoderType(name string) (int, error) {
+	switch strings.ToUpper(name) {
+	case "RANGE":
+		return RANGE_CODER, nil
+
+	case "EXPGOLOMB":
+		return EXPGOLOMB_CODER, nil
+
+	default:
+		return -1, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported coefficient coder: '%s'", name)
+	}
+}
+
+func GetCoderName(coder int) string {
+	switch coder {
+	case RANGE_CODER:
+		return "RANGE"
+
+	case EXPGOLOMB_CODER:
+		return "EXPGOLOMB"
+
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func appendValue(buf []byte, val int) []byte {
+	if val >= -127 && val <= 127 {
+		return append(buf, byte(int8(val)))
+	}
+
+	return append(buf, VALUE_ESCAPE, byte(val>>16), byte(val>>8), byte(val))
+}
+
+func appendRun(buf []byte, run int) []byte {
+	for run >= 255 {
+		buf = append(buf, 255)
+		run -= 255
+	}
+
+	return append(buf, byte(run))
+}
+
+// A decoded stream
+type byteStream struct {
+	buf []byte
+	pos int
+}
+
+func (this *byteStream) readByte() (byte, error) {
+	if this.pos >= len(this.buf) {
+		return 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: unexpected end of stream")
+	}
+
+	this.pos++
+	return this.buf[this.pos-1], nil
+}
+
+func (this *byteStream) readValue() (int, error) {
+	b, err := this.readByte()
+
+	if err != nil || b != VALUE_ESCAPE {
+		return int(int8(b)), err
+	}
+
+	if this.pos+3 > len(this.buf) {
+		return 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: unexpected end of stream")
+	}
+
+	val := int(int32(uint32(this.buf[this.pos])<<24|uint32(this.buf[this.pos+1])<<16|uint32(this.buf[this.pos+2])<<8) >> 8)
+	this.pos += 3
+	return val, nil
+}
+
+func (this *byteStream) readRun() (int, error) {
+	run := 0
+
+	for {
+		b, err := this.readByte()
+
+		if err != nil {
+			return 0, err
+		}
+
+		run += int(b)
+
+		if b != 255 {
+			return run, nil
+		}
+	}
+}
+
+// Entropy code the streams. If a stream is signed, its bytes are int8 values
+// (ExpGolombCodec only).
+func writeStreams(obs kanzi.OutputBitStream, coder int, streams [][]byte, signed []bool) error {
+	for _, s := range streams {
+		obs.WriteBits(uint64(len(s)), 32)
+	}
+
+	for i, s := range streams {
+		if len(s) == 0 {
+			continue
+		}
+
+		var ee kanzi.EntropyEncoder
+		var err error
+
+		if coder == RANGE_CODER {
+			ee, err = entropy.NewRangeEncoder(obs)
+		} else {
+			ee, err = entropy.NewExpGolombEncoder(obs, signed[i])
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = ee.Encode(s); err != nil {
+			return err
+		}
+
+		ee.Dispose()
+	}
+
+	return nil
+}
+
+// Decode the streams written by writeStreams. Each stream is at most
+// maxLength bytes long.
+func readStreams(ibs kanzi.InputBitStream, coder int, signed []bool, maxLength int) ([]*byteStream, error) {
+	streams := make([]*byteStream, len(signed))
+
+	for i := range streams {
+		length := ibs.ReadBits(32)
+
+		if length > uint64(maxLength) {
+			return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: stream %d too large (%d bytes)", i, length)
+		}
+
+		streams[i] = &byteStream{buf: make([]byte, length)}
+	}
+
+	for i, s := range streams {
+		if len(s.buf) == 0 {
+			continue
+		}
+
+		var ed kanzi.EntropyDecoder
+		var err error
+
+		if coder == RANGE_CODER {
+			ed, err = entropy.NewRangeDecoder(ibs)
+		} else {
+			ed, err = entropy.NewExpGolombDecoder(ibs, signed[i])
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = ed.Decode(s.buf); err != nil {
+			return nil, err
+		}
+
+		ed.Dispose()
+	}
+
+	return streams, nil
+}
+
+// Turn a panic of a bitstream or an entropy decoder into an error
+func panicError(r interface{}) error {
+	return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: %v", r)
+}
+
+type bufferStream struct {
+	bytes.Buffer
+}
+
+func (this *bufferStream) Close() error {
+	return nil
+}
+
+type readerStream struct {
+	*bytes.Reader
+}
+
+func (this *readerStream) Close() error {
+	return nil
+}
@@ -0,0 +1,776 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"encoding/binary"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/transform"
+	"math/bits"
+)
+
+// Embedded (progressive) image codec based on the CDF 9/7 wavelet transform.
+// Each plane (Y, Cb, Cr for RGB images) is decomposed by DWT_CDF_9_7 and the
+// coefficients are coded bit plane by bit plane, from the most significant
+// one. Each bit plane is coded in two passes over all the planes and
+// subbands (coarse to fine): a significance pass (coefficients becoming
+// significant and their sign) and a refinement pass (next bit of the
+// coefficients already significant). The bits are coded by a
+// BinaryEntropyEncoder with a WaveletPredictor whose context depends on the
+// significance of the neighbors and of the parent coefficient.
+// The most important information comes first: the output can be truncated
+// at any byte and the decoder rebuilds the best image from the prefix.
+// In lossless mode, the planes (reversible color transform for RGB images)
+// are decomposed by the integer DWT_CDF_5_3 instead, without padding: the
+// complete output rebuilds the image exactly and its prefixes are lossy
+// versions of the image.
+//
+// Format (big endian):
+// magic (32 bits), version (8 bits), width-1 (16 bits), height-1 (16 bits),
+// channels (8 bits), transform (4 bits), decomposition levels (4 bits),
+// number of bit planes of each plane (8 bits each), then the entropy coded
+// bits.
+
+const (
+	WAVELET_IMAGE_MAGIC   = 0x4B575654 // "KWVT"
+	WAVELET_IMAGE_VERSION = 1
+	WAVELET_FRAC_BITS     = 3 // fixed point precision of the samples
+	MAX_WAVELET_LEVELS    = 5
+	MAX_WAVELET_PLANES    = 30
+	WAVELET_CDF_9_7       = 0 // lossy, padded planes
+	WAVELET_CDF_5_3       = 1 // lossless
+
+	waveletMaxRatio = 1 << 22 // max samples per byte (a flat image takes about 20 bytes)
+
+	waveletSignificant = 1 // coefficient flags
+	waveletNegative    = 2
+	waveletNew         = 4 // significant in the current bit plane
+	waveletRefined     = 8 // refined at least once
+
+	// Contexts of the predictor
+	waveletSignContexts      = 2 * 4 * 3 * 3 * 3 * 2
+	waveletRefinementContext = waveletSignContexts + 2*4*3
+	waveletContexts          = waveletRefinementContext + 2*4
+)
+
+type subband struct {
+	x0, y0 int
+	width  int
+	height int
+	orient int // 0: LL, 1: HL, 2: LH, 3: HH
+	parent int // index of the parent subband (-1: none)
+}
+
+// A plane of coefficients with the state of the coder
+type waveletPlane struct {
+	values []int  // magnitudes (complete for the encoder, decoded bits for the decoder)
+	flags  []byte // see waveletSignificant, ...
+	low    []byte // lowest bit plane coded (decoder)
+	planes int    // number of bit planes
+	chroma int
+}
+
+// Send a bit to the entropy coder (encoder) or get it from the entropy
+// decoder (decoder). Return false if the decoder reached the end of the data.
+type bitCoder interface {
+	code(bit byte, ctx int) (byte, bool)
+}
+
+// State shared by the encoder and the decoder
+type waveletCodec struct {
+	width     int // dimensions of the planes (padded for WAVELET_CDF_9_7)
+	height    int
+	levels    uint
+	subbands  []subband
+	planes    []*waveletPlane
+	encoding  bool
+	predictor *WaveletPredictor
+}
+
+// Return the number of decomposition levels and the padded dimensions
+func waveletDimensions(width, height int) (uint, int, int) {
+	if width < 8 {
+		width = 8
+	}
+
+	if height < 8 {
+		height = 8
+	}
+
+	levels := uint(1)
+	minDim := width
+
+	if height < minDim {
+		minDim = height
+	}
+
+	for levels < MAX_WAVELET_LEVELS && minDim>>(levels+1) >= 8 {
+		levels++
+	}
+
+	return levels, paddedSize(width, levels), paddedSize(height, levels)
+}
+
+// Return the number of decomposition levels of the lossless transform (the
+// dimensions are not padded)
+func losslessLevels(width, height int) uint {
+	levels := uint(1)
+	minDim := width
+
+	if height < minDim {
+		minDim = height
+	}
+
+	for levels < MAX_WAVELET_LEVELS && minDim>>(levels+1) >= 8 {
+		levels++
+	}
+
+	return levels
+}
+
+func newWaveletTransform(transformType int, width, height int, levels uint) (kanzi.IntTransform, error) {
+	if transformType == WAVELET_CDF_5_3 {
+		return transform.NewDWT53(uint(width), uint(height), levels)
+	}
+
+	return transform.NewDWT(uint(width), uint(height), levels)
+}
+
+// Return the size of the low pass subband after the given number of levels
+// (the low pass subband of n samples has (n+1)/2 samples)
+func lowPassSize(size int, levels uint) int {
+	return (size + 1<<levels - 1) >> levels
+}
+
+func newWaveletCodec(width, height int, levels uint, channels int, encoding bool) (*waveletCodec, error) {
+	this := new(waveletCodec)
+	this.width = width
+	this.height = height
+	this.levels = levels
+	this.encoding = encoding
+	var err error
+
+	if this.predictor, err = NewWaveletPredictor(waveletContexts); err != nil {
+		return nil, err
+	}
+
+	// Coarse to fine subbands
+	this.subbands = append(this.subbands, subband{width: lowPassSize(width, levels),
+		height: lowPassSize(height, levels), parent: -1})
+
+	for l := levels; l >= 1; l-- {
+		w := lowPassSize(width, l)
+		h := lowPassSize(height, l)
+
+		for orient := 1; orient <= 3; orient++ {
+			sb := subband{width: w, height: h, orient: orient, parent: -1}
+
+			if orient&1 != 0 {
+				sb.x0 = w
+				sb.width = lowPassSize(width, l-1) - w
+			}
+
+			if orient&2 != 0 {
+				sb.y0 = h
+				sb.height = lowPassSize(height, l-1) - h
+			}
+
+			if l < levels {
+				sb.parent = len(this.subbands) - 3
+			}
+
+			this.subbands = append(this.subbands, sb)
+		}
+	}
+
+	this.planes = make([]*waveletPlane, channels)
+
+	for c := range this.planes {
+		pl := &waveletPlane{}
+		pl.values = make([]int, width*height)
+		pl.flags = make([]byte, width*height)
+
+		if encoding == false {
+			pl.low = make([]byte, width*height)
+		}
+
+		if c > 0 {
+			pl.chroma = 1
+		}
+
+		this.planes[c] = pl
+	}
+
+	return this, nil
+}
+
+// Code all the bit planes. Return false if the decoder reached the end of the
+// data.
+func (this *waveletCodec) run(coder bitCoder) bool {
+	maxPlanes := 0
+
+	for _, pl := range this.planes {
+		if pl.planes > maxPlanes {
+			maxPlanes = pl.planes
+		}
+	}
+
+	for p := maxPlanes - 1; p >= 0; p-- {
+		for _, pl := range this.planes {
+			if p < pl.planes && this.significancePass(pl, uint(p), coder) == false {
+				return false
+			}
+		}
+
+		for _, pl := range this.planes {
+			if p < pl.planes && this.refinementPass(pl, uint(p), coder) == false {
+				return false
+			}
+		}
+
+		for _, pl := range this.planes {
+			for i := range pl.flags {
+				pl.flags[i] &^= waveletNew
+			}
+		}
+	}
+
+	return true
+}
+
+func (this *waveletCodec) significancePass(pl *waveletPlane, p uint, coder bitCoder) bool {
+	stride := this.width
+
+	for _, sb := range this.subbands {
+		for y := 0; y < sb.height; y++ {
+			for x := 0; x < sb.width; x++ {
+				i := (sb.y0+y)*stride + sb.x0 + x
+
+				if pl.flags[i]&waveletSignificant != 0 {
+					continue
+				}
+
+				bit := byte(pl.values[i]>>p) & 1
+				bit, ok := coder.code(bit, this.significanceContext(pl, &sb, x, y))
+
+				if ok == false {
+					return false
+				}
+
+				if this.encoding == false {
+					pl.low[i] = byte(p)
+				}
+
+				if bit == 0 {
+					continue
+				}
+
+				sign := (pl.flags[i] & waveletNegative) >> 1
+
+				if sign, ok = coder.code(sign, this.signContext(pl, &sb, x, y)); ok == false {
+					// The sign is unknown: the coefficient stays insignificant
+					return false
+				}
+
+				pl.flags[i] |= waveletSignificant | waveletNew | sign<<1
+
+				if this.encoding == false {
+					pl.values[i] = 1 << p
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+func (this *waveletCodec) refinementPass(pl *waveletPlane, p uint, coder bitCoder) bool {
+	stride := this.width
+
+	for _, sb := range this.subbands {
+		for y := 0; y < sb.height; y++ {
+			for x := 0; x < sb.width; x++ {
+				i := (sb.y0+y)*stride + sb.x0 + x
+
+				if pl.flags[i]&(waveletSignificant|waveletNew) != waveletSignificant {
+					continue
+				}
+
+				ctx := waveletRefinementContext + pl.chroma*4
+
+				if pl.flags[i]&waveletRefined == 0 {
+					ctx += 2
+				}
+
+				if h, v, _ := this.neighbors(pl, &sb, x, y); h+v > 0 {
+					ctx++
+				}
+
+				bit := byte(pl.values[i]>>p) & 1
+				bit, ok := coder.code(bit, ctx)
+
+				if ok == false {
+					return false
+				}
+
+				pl.flags[i] |= waveletRefined
+
+				if this.encoding == false {
+					pl.values[i] |= int(bit) << p
+					pl.low[i] = byte(p)
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// Return the number of significant horizontal, vertical and diagonal
+// neighbors in the subband
+func (this *waveletCodec) neighbors(pl *waveletPlane, sb *subband, x, y int) (int, int, int) {
+	stride := this.width
+	i := (sb.y0+y)*stride + sb.x0 + x
+	flags := pl.flags
+	h, v, d := 0, 0, 0
+
+	if x > 0 {
+		h += int(flags[i-1] & waveletSignificant)
+
+		if y > 0 {
+			d += int(flags[i-stride-1] & waveletSignificant)
+		}
+
+		if y < sb.height-1 {
+			d += int(flags[i+stride-1] & waveletSignificant)
+		}
+	}
+
+	if x < sb.width-1 {
+		h += int(flags[i+1] & waveletSignificant)
+
+		if y > 0 {
+			d += int(flags[i-stride+1] & waveletSignificant)
+		}
+
+		if y < sb.height-1 {
+			d += int(flags[i+stride+1] & waveletSignificant)
+		}
+	}
+
+	if y > 0 {
+		v += int(flags[i-stride] & waveletSignificant)
+	}
+
+	if y < sb.height-1 {
+		v += int(flags[i+stride] & waveletSignificant)
+	}
+
+	return h, v, d
+}
+
+func (this *waveletCodec) significanceContext(pl *waveletPlane, sb *subband, x, y int) int {
+	h, v, d := this.neighbors(pl, sb, x, y)
+
+	if d > 2 {
+		d = 2
+	}
+
+	parent := 0
+
+	if sb.parent >= 0 {
+		psb := &this.subbands[sb.parent]
+		px := x >> 1
+		py := y >> 1
+
+		// With odd dimensions, the last row or column may have no parent
+		if px < psb.width && py < psb.height {
+			parent = int(pl.flags[(psb.y0+py)*this.width+psb.x0+px] & waveletSignificant)
+		}
+	}
+
+	return ((((pl.chroma*4+sb.orient)*3+h)*3+v)*3+d)*2 + parent
+}
+
+// The context depends on the signs of the left and upper neighbors
+func (this *waveletCodec) signContext(pl *waveletPlane, sb *subband, x, y int) int {
+	i := (sb.y0+y)*this.width + sb.x0 + x
+	s := 0
+
+	if x > 0 && pl.flags[i-1]&waveletSignificant != 0 {
+		s += 1 - int(pl.flags[i-1]&waveletNegative)
+	}
+
+	if y > 0 && pl.flags[i-this.width]&waveletSignificant != 0 {
+		s += 1 - int(pl.flags[i-this.width]&waveletNegative)
+	}
+
+	class := 0
+
+	if s > 0 {
+		class = 1
+	} else if s < 0 {
+		class = 2
+	}
+
+	return waveletSignContexts + (pl.chroma*4+sb.orient)*3 + class
+}
+
+type WaveletEncoder struct {
+	encoder   *entropy.BinaryEntropyEncoder
+	predictor *WaveletPredictor
+	lossless  bool
+}
+
+func NewWaveletEncoder() (*WaveletEncoder, error) {
+	return &WaveletEncoder{}, nil
+}
+
+// Select the lossless mode (reversible integer transform)
+func (this *WaveletEncoder) SetLossless(lossless bool) {
+	this.lossless = lossless
+}
+
+func (this *WaveletEncoder) code(bit byte, ctx int) (byte, bool) {
+	this.predictor.SetContext(ctx)
+	this.encoder.EncodeBit(bit)
+	return bit, true
+}
+
+// Encode the image. If maxBytes is not 0, the output is truncated to at most
+// maxBytes bytes (at least the header is kept).
+func (this *WaveletEncoder) Encode(img *Image, maxBytes int) (res []byte, err error) {
+	if err = img.check(); err != nil {
+		return nil, err
+	}
+
+	if maxBytes < 0 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid max size: %d", maxBytes)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = kanzi.Errorf(kanzi.ErrIO, "Cannot encode image: %v", r)
+		}
+	}()
+
+	transformType := WAVELET_CDF_9_7
+	levels, width, height := waveletDimensions(img.Width, img.Height)
+	var planes [][]int
+
+	if this.lossless == true {
+		transformType = WAVELET_CDF_5_3
+		levels, width, height = losslessLevels(img.Width, img.Height), img.Width, img.Height
+		planes = toReversiblePlanes(img)
+	} else {
+		planes = toPlanes(img, width, height)
+
+		for _, plane := range planes {
+			for i := range plane {
+				plane[i] = (plane[i] - 128) << WAVELET_FRAC_BITS
+			}
+		}
+	}
+
+	codec, err := newWaveletCodec(width, height, levels, img.Channels, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dwt, err := newWaveletTransform(transformType, width, height, levels)
+
+	if err != nil {
+		return nil, err
+	}
+
+	coefs := make([]int, width*height)
+
+	for c, plane := range planes {
+		if _, _, err = dwt.Forward(plane, coefs); err != nil {
+			return nil, err
+		}
+
+		pl := codec.planes[c]
+		maxVal := 0
+
+		for i, v := range coefs {
+			if v < 0 {
+				pl.flags[i] = waveletNegative
+				v = -v
+			}
+
+			pl.values[i] = v
+
+			if v > maxVal {
+				maxVal = v
+			}
+		}
+
+		pl.planes = bits.Len(uint(maxVal))
+	}
+
+	bs := &bufferStream{}
+	obs, err := bitstream.NewDefaultOutputBitStream(bs, 65536)
+
+	if err != nil {
+		return nil, err
+	}
+
+	obs.WriteBits(WAVELET_IMAGE_MAGIC, 32)
+	obs.WriteBits(WAVELET_IMAGE_VERSION, 8)
+	obs.WriteBits(uint64(img.Width-1), 16)
+	obs.WriteBits(uint64(img.Height-1), 16)
+	obs.WriteBits(uint64(img.Channels), 8)
+	obs.WriteBits(uint64(transformType), 4)
+	obs.WriteBits(uint64(levels), 4)
+
+	for _, pl := range codec.planes {
+		obs.WriteBits(uint64(pl.planes), 8)
+	}
+
+	this.predictor = codec.predictor
+
+	if this.encoder, err = entropy.NewBinaryEntropyEncoder(obs, codec.predictor); err != nil {
+		return nil, err
+	}
+
+	codec.run(this)
+	this.encoder.Dispose()
+	this.encoder = nil
+	this.predictor = nil
+
+	if _, err = obs.Close(); err != nil {
+		return nil, err
+	}
+
+	res = bs.Bytes()
+
+	if headerSize := waveletHeaderSize(img.Channels); maxBytes != 0 && maxBytes < len(res) {
+		if maxBytes < headerSize {
+			maxBytes = headerSize
+		}
+
+		res = res[0:maxBytes]
+	}
+
+	return res, nil
+}
+
+func waveletHeaderSize(channels int) int {
+	return 11 + channels
+}
+
+type WaveletDecoder struct {
+	decoder   *entropy.BinaryEntropyDecoder
+	predictor *WaveletPredictor
+	ibs       kanzi.InputBitStream
+	available uint64 // number of bits of the input
+	truncated bool
+}
+
+func NewWaveletDecoder() (*WaveletDecoder, error) {
+	return &WaveletDecoder{}, nil
+}
+
+func (this *WaveletDecoder) code(bit byte, ctx int) (byte, bool) {
+	// All the bits used to decode the next bit must come from the input
+	if this.ibs.Read() > this.available {
+		return 0, false
+	}
+
+	this.predictor.SetContext(ctx)
+	return this.decoder.DecodeBit(), true
+}
+
+// Return true if the last decoded input was truncated (lower quality image)
+func (this *WaveletDecoder) Truncated() bool {
+	return this.truncated
+}
+
+// Decode a complete or truncated output of WaveletEncoder
+func (this *WaveletDecoder) Decode(data []byte) (img *Image, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			img = nil
+			err = panicError(r)
+		}
+
+		this.decoder = nil
+		this.predictor = nil
+		this.ibs = nil
+	}()
+
+	if len(data) < waveletHeaderSize(1) {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: truncated header")
+	}
+
+	// The data is followed by zeros: reading past the end is detected with
+	// the position in the bitstream
+	this.available = uint64(len(data)) * 8
+
+	if this.ibs, err = bitstream.NewDefaultInputBitStream(&paddedStream{data: data}, 65536); err != nil {
+		return nil, err
+	}
+
+	ibs := this.ibs
+
+	if ibs.ReadBits(32) != WAVELET_IMAGE_MAGIC {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: not a wavelet image")
+	}
+
+	if version := ibs.ReadBits(8); version != WAVELET_IMAGE_VERSION {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported wavelet image version: %d", version)
+	}
+
+	width := int(ibs.ReadBits(16)) + 1
+	height := int(ibs.ReadBits(16)) + 1
+	channels := int(ibs.ReadBits(8))
+	transformType := int(ibs.ReadBits(4))
+	levels := uint(ibs.ReadBits(4))
+
+	if err = checkDecodedSize(width, height, channels, len(data), waveletMaxRatio); err != nil {
+		return nil, err
+	}
+
+	if img, err = NewImage(width, height, channels); err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: %v", err)
+	}
+
+	if len(data) < waveletHeaderSize(channels) {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: truncated header")
+	}
+
+	expected, pw, ph := waveletDimensions(width, height)
+
+	if transformType == WAVELET_CDF_5_3 {
+		expected, pw, ph = losslessLevels(width, height), width, height
+	} else if transformType != WAVELET_CDF_9_7 {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: unknown transform: %d", transformType)
+	}
+
+	if levels != expected {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: invalid number of levels: %d", levels)
+	}
+
+	codec, err := newWaveletCodec(pw, ph, levels, channels, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pl := range codec.planes {
+		if pl.planes = int(ibs.ReadBits(8)); pl.planes > MAX_WAVELET_PLANES {
+			return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: invalid number of bit planes: %d", pl.planes)
+		}
+
+		for i := range pl.low {
+			pl.low[i] = byte(pl.planes)
+		}
+	}
+
+	this.predictor = codec.predictor
+
+	if this.decoder, err = entropy.NewBinaryEntropyDecoder(ibs, codec.predictor); err != nil {
+		return nil, err
+	}
+
+	this.decoder.Initialize()
+	this.truncated = codec.run(this) == false
+	dwt, err := newWaveletTransform(transformType, pw, ph, levels)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Reconstruct the coefficients (middle of the uncertainty interval)
+	planes := make([][]int, channels)
+	coefs := make([]int, pw*ph)
+
+	for c, pl := range codec.planes {
+		for i, v := range pl.values {
+			if pl.flags[i]&waveletSignificant == 0 {
+				coefs[i] = 0
+				continue
+			}
+
+			v += (1 << pl.low[i]) >> 1
+
+			if pl.flags[i]&waveletNegative != 0 {
+				v = -v
+			}
+
+			coefs[i] = v
+		}
+
+		planes[c] = make([]int, pw*ph)
+
+		if _, _, err = dwt.Inverse(coefs, planes[c]); err != nil {
+			return nil, err
+		}
+
+		if transformType == WAVELET_CDF_9_7 {
+			for i, v := range planes[c] {
+				planes[c][i] = ((v + 1<<(WAVELET_FRAC_BITS-1)) >> WAVELET_FRAC_BITS) + 128
+			}
+		}
+	}
+
+	if transformType == WAVELET_CDF_5_3 {
+		fromReversiblePlanes(planes, img)
+	} else {
+		fromPlanes(planes, pw, img)
+	}
+
+	return img, nil
+}
+
+// Input stream returning zeros after the data
+type paddedStream struct {
+	data []byte
+}
+
+func (this *paddedStream) Read(b []byte) (int, error) {
+	n := copy(b, this.data)
+	this.data = this.data[n:]
+
+	for i := n; i < len(b); i++ {
+		b[i] = 0
+	}
+
+	return len(b), nil
+}
+
+func (this *paddedStream) Close() error {
+	return nil
+}
+
+// Return true if the data starts like an output of WaveletEncoder
+func IsWaveletImage(data []byte) bool {
+	return len(data) >= 4 && binary.BigEndian.Uint32(data) == WAVELET_IMAGE_MAGIC
+}
+
+// Return the dimensions of an image encoded by WaveletEncoder or DCTEncoder
+func GetImageSize(data []byte) (int, int, error) {
+	if len(data) < 9 || (IsWaveletImage(data) == false && IsDCTImage(data) == false) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid image data: unknown format")
+	}
+
+	return int(binary.BigEndian.Uint16(data[5:])) + 1, int(binary.BigEndian.Uint16(data[7:])) + 1, nil
+}
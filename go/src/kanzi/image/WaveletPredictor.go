@@ -0,0 +1,86 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"kanzi"
+)
+
+const (
+	WAVELET_PREDICTOR_LIMIT = 30 // max adaptation count (rate 1/32)
+)
+
+// Predictor of the bit plane coder: one adaptive probability per context.
+// The coder selects the context (computed from the neighborhood of the
+// coefficient) before each bit. The adaptation rate starts high and decreases
+// with the number of bits seen in the context.
+type WaveletPredictor struct {
+	probs  []int // probability of 1 (16 bits) per context
+	counts []int // number of updates per context (capped)
+	ctx    int
+}
+
+func NewWaveletPredictor(contexts int) (*WaveletPredictor, error) {
+	if contexts < 1 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of contexts: %d", contexts)
+	}
+
+	this := new(WaveletPredictor)
+	this.probs = make([]int, contexts)
+	this.counts = make([]int, contexts)
+	this.Reset()
+	return this, nil
+}
+
+// Implement kanzi.Resettable interface
+func (this *WaveletPredictor) Reset() {
+	for i := range this.probs {
+		this.probs[i] = 1 << 15
+		this.counts[i] = 0
+	}
+
+	this.ctx = 0
+}
+
+// Select the context of the next bit
+func (this *WaveletPredictor) SetContext(ctx int) {
+	this.ctx = ctx
+}
+
+// Update the probability model
+func (this *WaveletPredictor) Update(bit byte) {
+	n := this.counts[this.ctx]
+	this.probs[this.ctx] += ((int(bit&1) << 16) - this.probs[this.ctx]) / (n + 2)
+
+	if n < WAVELET_PREDICTOR_LIMIT {
+		this.counts[this.ctx]++
+	}
+}
+
+// Return the split value representing the probability of 1 in the [0..4095] range.
+func (this *WaveletPredictor) Get() uint {
+	p := this.probs[this.ctx] >> 4
+
+	if p < 1 {
+		return 1
+	}
+
+	if p > 4095 {
+		return 4095
+	}
+
+	return uint(p)
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integer
+
+import (
+	"kanzi"
+	"math/bits"
+)
+
+// Bit packing of blocks of BITPACK_BLOCK_SIZE values (the last block can be
+// shorter): the width (number of bits of the largest value, unsigned) is
+// written first, then the values packed on 'width' bits in 64 bit words.
+// The values are interleaved in BITPACK_LANES lanes (value i in lane i%4)
+// and each lane is packed in its own words (word j of lane l at index 4*j+l),
+// so that the lanes are processed with the same shifts (SIMD friendly).
+// The number of values to decode is the size, or the length of the output
+// buffer if the size is 0.
+// Implement kanzi.IntFunction.
+
+const (
+	BITPACK_BLOCK_SIZE = 256
+	BITPACK_LANES      = 4
+)
+
+type BitPacker struct {
+	size uint
+}
+
+func NewBitPacker(size uint) (*BitPacker, error) {
+	this := new(BitPacker)
+	this.size = size
+	return this, nil
+}
+
+func (this *BitPacker) Size() uint {
+	return this.size
+}
+
+func (this *BitPacker) SetSize(sz uint) bool {
+	this.size = sz
+	return true
+}
+
+// Return the number of words used to pack count values (at most a block)
+func PackedLen(count int, width uint) int {
+	perLane := (count + BITPACK_LANES - 1) / BITPACK_LANES
+	return BITPACK_LANES * ((perLane*int(width) + 63) >> 6)
+}
+
+func (this *BitPacker) Forward(src, dst []int) (uint, uint, error) {
+	if src == nil || dst == nil {
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameIntSlices(src, dst, false) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
+	}
+
+	dstIdx := 0
+
+	for start := 0; start < len(src); start += BITPACK_BLOCK_SIZE {
+		block := src[start:min(start+BITPACK_BLOCK_SIZE, len(src))]
+		acc := uint64(0)
+
+		for _, v := range block {
+			acc |= uint64(v)
+		}
+
+		width := uint(bits.Len64(acc))
+		words := PackedLen(len(block), width)
+
+		if dstIdx+1+words > len(dst) {
+			return uint(start), uint(dstIdx), kanzi.Errorf(kanzi.ErrBufferTooSmall,
+				"Output buffer too small, required:%v, available:%v", dstIdx+1+words, len(dst))
+		}
+
+		dst[dstIdx] = int(width)
+		out := dst[dstIdx+1 : dstIdx+1+words]
+		dstIdx += 1 + words
+
+		for i := range out {
+			out[i] = 0
+		}
+
+		if width == 0 {
+			continue
+		}
+
+		for pos := 0; pos*BITPACK_LANES < len(block); pos++ {
+			bitPos := uint(pos) * width
+			word := int(bitPos>>6) * BITPACK_LANES
+			shift := bitPos & 63
+			values := block[pos*BITPACK_LANES : min(pos*BITPACK_LANES+BITPACK_LANES, len(block))]
+
+			for lane, v := range values {
+				out[word+lane] |= int(uint64(v) << shift)
+
+				if shift+width > 64 {
+					out[word+BITPACK_LANES+lane] |= int(uint64(v) >> (64 - shift))
+				}
+			}
+		}
+	}
+
+	return uint(len(src)), uint(dstIdx), nil
+}
+
+func (this *BitPacker) Inverse(src, dst []int) (uint, uint, error) {
+	if src == nil || dst == nil {
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null buffer")
+	}
+
+	if len(src) > 0 && kanzi.SameIntSlices(src, dst, false) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Input and output buffers cannot be equal")
+	}
+
+	count := len(dst)
+
+	if this.size > 0 {
+		if int(this.size) > len(dst) {
+			return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Output buffer too small, required:%v, available:%v",
+				this.size, len(dst))
+		}
+
+		count = int(this.size)
+	}
+
+	srcIdx := 0
+
+	for start := 0; start < count; start += BITPACK_BLOCK_SIZE {
+		block := dst[start:min(start+BITPACK_BLOCK_SIZE, count)]
+
+		if srcIdx >= len(src) || src[srcIdx] < 0 || src[srcIdx] > 64 {
+			return uint(srcIdx), uint(start), kanzi.Errorf(kanzi.ErrCorruptData, "Invalid bit packed data")
+		}
+
+		width := uint(src[srcIdx])
+		words := PackedLen(len(block), width)
+
+		if srcIdx+1+words > len(src) {
+			return uint(srcIdx), uint(start), kanzi.Errorf(kanzi.ErrCorruptData, "Invalid bit packed data: truncated block")
+		}
+
+		in := src[srcIdx+1 : srcIdx+1+words]
+		srcIdx += 1 + words
+		mask := uint64(0xFFFFFFFFFFFFFFFF) >> (64 - width)
+
+		if width == 0 {
+			for i := range block {
+				block[i] = 0
+			}
+
+			continue
+		}
+
+		for pos := 0; pos*BITPACK_LANES < len(block); pos++ {
+			bitPos := uint(pos) * width
+			word := int(bitPos>>6) * BITPACK_LANES
+			shift := bitPos & 63
+			values := block[pos*BITPACK_LANES : min(pos*BITPACK_LANES+BITPACK_LANES, len(block))]
+
+			for lane := range values {
+				v := uint64(in[word+lane]) >> shift
+
+				if shift+width > 64 {
+					v |= uint64(in[word+BITPACK_LANES+lane]) << (64 - shift)
+				}
+
+				values[lane] = int(v & mask)
+			}
+		}
+	}
+
+	return uint(srcIdx), uint(count), nil
+}
+
+func (this BitPacker) MaxEncodedLen(srcLen int) int {
+	res := (srcLen / BITPACK_BLOCK_SIZE) * (1 + PackedLen(BITPACK_BLOCK_SIZE, 64))
+
+	if rem := srcLen % BITPACK_BLOCK_SIZE; rem > 0 {
+		res += 1 + PackedLen(rem, 64)
+	}
+
+	return res
+}
@@ -0,0 +1,810 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integer
+
+import (
+	"bytes"
+	"io"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// Columns of 32 or 64 bit integers (EG. the values or the timestamps of a
+// time series) compressed in independent blocks. The values are handled as
+// int: the width of the column is checked when the values are written and
+// when they are decoded into narrower integers.
+// The values of a block go through the transform stages, then are coded by
+// the coder:
+// - BITPACK: output of a BitPacker (widths on 7 bits, 64 bit words)
+// - EXPGOLOMB: ExpGolombEncoder.EncodeInt
+// - RICE: chunks of RICE_CHUNK_SIZE values, each one with its parameter
+//   (5 bits) and RiceGolombEncoder.EncodeInt, or an escape parameter followed
+//   by the width (7 bits) and the raw values
+// The EXPGOLOMB and RICE blocks start with a flag (1 bit) set if there are
+// negative values (a sign follows the non zero values).
+//
+// Format (big endian):
+// magic (32 bits), version (8 bits), transform (16 bits), coder (4 bits),
+// width of the values (8 bits: 32 or 64, since version 2, version 1 columns
+// hold 64 bit values), block size (32 bits), then the blocks: number of
+// values (32 bits), coded values. A block of 0 values ends the column.
+
+const (
+	COLUMN_MAGIC              = 0x4B494E54 // "KINT"
+	COLUMN_VERSION            = 2
+	DEFAULT_COLUMN_BLOCK_SIZE = 16384
+	MAX_COLUMN_BLOCK_SIZE     = 1 << 20
+	DEFAULT_INT_TRANSFORM     = "DELTA+ZIGZAG"
+	DEFAULT_INT_CODER         = "BITPACK"
+	RICE_CHUNK_SIZE           = 128
+
+	riceEscape = 31
+)
+
+// Options of the column writers. A nil value selects the defaults.
+type Options struct {
+	Transform string // transform stages (default: DELTA+ZIGZAG)
+	Coder     string // BITPACK, EXPGOLOMB or RICE (default: BITPACK)
+	BlockSize uint   // number of values per block (default: 16384)
+	Width     uint   // width of the values in bits: 32 or 64 (default: 64)
+}
+
+func (this *Options) withDefaults() Options {
+	var opts Options
+
+	if this != nil {
+		opts = *this
+	}
+
+	if opts.Transform == "" {
+		opts.Transform = DEFAULT_INT_TRANSFORM
+	}
+
+	if opts.Coder == "" {
+		opts.Coder = DEFAULT_INT_CODER
+	}
+
+	if opts.BlockSize == 0 {
+		opts.BlockSize = DEFAULT_COLUMN_BLOCK_SIZE
+	}
+
+	if opts.Width == 0 {
+		opts.Width = 64
+	}
+
+	return opts
+}
+
+// Return an error if a value does not fit in the width of the column
+func checkWidth(values []int, width uint, kind error) error {
+	if width == 64 {
+		return nil
+	}
+
+	for _, v := range values {
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return kanzi.Errorf(kind, "Value out of range for a column of %d bit values: %d", width, v)
+		}
+	}
+
+	return nil
+}
+
+// State shared by the writer and the reader
+type columnCodec struct {
+	functions []kanzi.IntFunction
+	coder     byte
+	width     uint // of the values
+	blockSize int
+	buffers   [2][]int
+	packer    *BitPacker
+}
+
+func newColumnCodec(transformType uint16, coder byte, width uint, blockSize int) (*columnCodec, error) {
+	if coder > RICE_CODER {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported integer coder: %d", coder)
+	}
+
+	if width != 32 && width != 64 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid value width: %d (must be 32 or 64)", width)
+	}
+
+	if blockSize < 1 || blockSize > MAX_COLUMN_BLOCK_SIZE {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid block size: %d (must be in [1..%d])",
+			blockSize, MAX_COLUMN_BLOCK_SIZE)
+	}
+
+	functions, err := NewIntFunctions(transformType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &columnCodec{functions: functions, coder: coder, width: width, blockSize: blockSize}
+	this.packer, _ = NewBitPacker(0)
+	size := this.codedLen(blockSize)
+	size = max(size, this.packer.MaxEncodedLen(size))
+	this.buffers[0] = make([]int, size)
+	this.buffers[1] = make([]int, size)
+	return this, nil
+}
+
+// Return the number of values after the transform stages
+func (this *columnCodec) codedLen(count int) int {
+	for _, f := range this.functions {
+		count = f.MaxEncodedLen(count)
+	}
+
+	return count
+}
+
+// Return the best Rice parameter (or riceEscape) for the values and the
+// width of the raw values
+func riceParameter(values []int, signed bool) (uint, uint) {
+	sum, nonZero := uint64(0), 0
+	maxWidth, rawWidth := 0, uint(0)
+
+	for _, v := range values {
+		mag := uint64(v)
+
+		if signed == true {
+			rawWidth = max(rawWidth, uint(bits.Len64(uint64(v^(v>>63))))+1)
+
+			if v < 0 {
+				mag = uint64(-v)
+			}
+		} else {
+			rawWidth = max(rawWidth, uint(bits.Len64(mag)))
+		}
+
+		maxWidth = max(maxWidth, bits.Len64(mag))
+		sum += mag & 0xFFFFFFFFFFFF // no overflow (the large values are escaped)
+
+		if v != 0 {
+			nonZero++
+		}
+	}
+
+	if maxWidth > 48 {
+		return riceEscape, rawWidth
+	}
+
+	if signed == false {
+		nonZero = 0
+	}
+
+	param, cost := uint(riceEscape), 7+len(values)*int(rawWidth)
+
+	for k := uint(0); k < riceEscape; k++ {
+		c := len(values)*int(k+1) + int(sum>>k) + nonZero
+
+		if c < cost {
+			param, cost = k, c
+		} else if param != riceEscape {
+			break
+		}
+	}
+
+	return param, rawWidth
+}
+
+// Write the values of a column in blocks. The underlying stream is closed
+// by Close.
+type ColumnWriter struct {
+	codec        *columnCodec
+	obs          kanzi.OutputBitStream
+	egEncoders   [2]*entropy.ExpGolombEncoder // unsigned, signed
+	riceEncoders [2]*entropy.RiceGolombEncoder
+	pending      []int
+	written      uint64
+	closed       bool
+}
+
+func NewColumnWriter(os kanzi.OutputStream, opts *Options) (*ColumnWriter, error) {
+	if os == nil {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null output stream parameter")
+	}
+
+	o := opts.withDefaults()
+	transformType, err := GetIntTransformType(o.Transform)
+
+	if err != nil {
+		return nil, err
+	}
+
+	coder, err := GetIntCoderType(o.Coder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if o.BlockSize > MAX_COLUMN_BLOCK_SIZE {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid block size: %d (must be in [1..%d])",
+			o.BlockSize, MAX_COLUMN_BLOCK_SIZE)
+	}
+
+	codec, err := newColumnCodec(transformType, coder, o.Width, int(o.BlockSize))
+
+	if err != nil {
+		return nil, err
+	}
+
+	obs, err := bitstream.NewDefaultOutputBitStream(os, 65536)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := &ColumnWriter{codec: codec, obs: obs}
+	this.pending = make([]int, 0, codec.blockSize)
+
+	for i, signed := range []bool{false, true} {
+		this.egEncoders[i], _ = entropy.NewExpGolombEncoder(obs, signed)
+		this.riceEncoders[i], _ = entropy.NewRiceGolombEncoder(obs, signed, 1)
+	}
+
+	// The header stays in the buffer of the bitstream: no error
+	obs.WriteBits(COLUMN_MAGIC, 32)
+	obs.WriteBits(COLUMN_VERSION, 8)
+	obs.WriteBits(uint64(transformType), 16)
+	obs.WriteBits(uint64(coder), 4)
+	obs.WriteBits(uint64(codec.width), 8)
+	obs.WriteBits(uint64(codec.blockSize), 32)
+	return this, nil
+}
+
+// Return the number of values written so far
+func (this *ColumnWriter) Written() uint64 {
+	return this.written
+}
+
+func (this *ColumnWriter) Write(values []int) (n int, err error) {
+	if this.closed == true {
+		return 0, kanzi.Errorf(kanzi.ErrClosed, "Column writer closed")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = kanzi.Errorf(kanzi.ErrIO, "Cannot write integer column: %v", r)
+		}
+	}()
+
+	if err = checkWidth(values, this.codec.width, kanzi.ErrInvalidParam); err != nil {
+		return 0, err
+	}
+
+	for len(values) > 0 {
+		k := min(this.codec.blockSize-len(this.pending), len(values))
+		this.pending = append(this.pending, values[0:k]...)
+		values = values[k:]
+		n += k
+		this.written += uint64(k)
+
+		if len(this.pending) == this.codec.blockSize {
+			if err = this.writeBlock(this.pending); err != nil {
+				return n, err
+			}
+
+			this.pending = this.pending[:0]
+		}
+	}
+
+	return n, nil
+}
+
+// Write the pending values, the end of the column and close the stream
+func (this *ColumnWriter) Close() (err error) {
+	if this.closed == true {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = kanzi.Errorf(kanzi.ErrIO, "Cannot write integer column: %v", r)
+		}
+	}()
+
+	if len(this.pending) > 0 {
+		if err = this.writeBlock(this.pending); err != nil {
+			return err
+		}
+
+		this.pending = this.pending[:0]
+	}
+
+	this.obs.WriteBits(0, 32)
+
+	if _, err = this.obs.Close(); err != nil {
+		return err
+	}
+
+	this.closed = true
+	return nil
+}
+
+func (this *ColumnWriter) writeBlock(block []int) error {
+	codec := this.codec
+	this.obs.WriteBits(uint64(len(block)), 32)
+	values := block
+
+	for i, f := range codec.functions {
+		out := codec.buffers[i&1][0:f.MaxEncodedLen(len(values))]
+		_, n, err := f.Forward(values, out)
+
+		if err != nil {
+			return err
+		}
+
+		values = out[0:n]
+	}
+
+	if codec.coder == BITPACK_CODER {
+		// Pack in the buffer not holding the values
+		packed := codec.buffers[len(codec.functions)&1]
+		_, n, err := codec.packer.Forward(values, packed)
+
+		if err != nil {
+			return err
+		}
+
+		this.writePacked(packed[0:n], len(values))
+		return nil
+	}
+
+	signed := 0
+
+	for _, v := range values {
+		if v < 0 {
+			signed = 1
+			break
+		}
+	}
+
+	this.obs.WriteBit(signed)
+
+	if codec.coder == EXPGOLOMB_CODER {
+		for _, v := range values {
+			this.egEncoders[signed].EncodeInt(v)
+		}
+
+		return nil
+	}
+
+	rice := this.riceEncoders[signed]
+
+	for start := 0; start < len(values); start += RICE_CHUNK_SIZE {
+		chunk := values[start:min(start+RICE_CHUNK_SIZE, len(values))]
+		param, width := riceParameter(chunk, signed == 1)
+		this.obs.WriteBits(uint64(param), 5)
+
+		if param == riceEscape {
+			this.obs.WriteBits(uint64(width), 7)
+
+			if width > 0 {
+				for _, v := range chunk {
+					this.obs.WriteBits(uint64(v)&(0xFFFFFFFFFFFFFFFF>>(64-width)), width)
+				}
+			}
+
+			continue
+		}
+
+		rice.SetLogBase(param)
+
+		for _, v := range chunk {
+			rice.EncodeInt(v)
+		}
+	}
+
+	return nil
+}
+
+func (this *ColumnWriter) writePacked(packed []int, count int) {
+	idx := 0
+
+	for start := 0; start < count; start += BITPACK_BLOCK_SIZE {
+		width := uint(packed[idx])
+		words := PackedLen(min(count-start, BITPACK_BLOCK_SIZE), width)
+		this.obs.WriteBits(uint64(width), 7)
+
+		for _, w := range packed[idx+1 : idx+1+words] {
+			this.obs.WriteBits(uint64(w), 64)
+		}
+
+		idx += 1 + words
+	}
+}
+
+// Read the values of a column written by a ColumnWriter
+type ColumnReader struct {
+	codec        *columnCodec
+	ibs          kanzi.InputBitStream
+	egDecoders   [2]*entropy.ExpGolombDecoder // unsigned, signed
+	riceDecoders [2]*entropy.RiceGolombDecoder
+	block        []int // decoded values not read yet
+	eos          bool
+}
+
+func NewColumnReader(is kanzi.InputStream) (this *ColumnReader, err error) {
+	if is == nil {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null input stream parameter")
+	}
+
+	ibs, err := bitstream.NewDefaultInputBitStream(is, 65536)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			this = nil
+			err = kanzi.Errorf(kanzi.ErrCorruptData, "Invalid integer column header: %v", r)
+		}
+	}()
+
+	if ibs.ReadBits(32) != COLUMN_MAGIC {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid integer column: invalid magic number")
+	}
+
+	version := ibs.ReadBits(8)
+
+	if version < 1 || version > COLUMN_VERSION {
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported integer column version: %d", version)
+	}
+
+	transformType := uint16(ibs.ReadBits(16))
+	coder := byte(ibs.ReadBits(4))
+	width := uint(64)
+
+	if version > 1 {
+		width = uint(ibs.ReadBits(8))
+	}
+
+	blockSize := int(ibs.ReadBits(32))
+	codec, err := newColumnCodec(transformType, coder, width, blockSize)
+
+	if err != nil {
+		return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid integer column header: %v", err)
+	}
+
+	this = &ColumnReader{codec: codec, ibs: ibs}
+
+	for i, signed := range []bool{false, true} {
+		this.egDecoders[i], _ = entropy.NewExpGolombDecoder(ibs, signed)
+		this.riceDecoders[i], _ = entropy.NewRiceGolombDecoder(ibs, signed, 1)
+	}
+
+	return this, nil
+}
+
+// Return the width of the values of the column (32 or 64 bits)
+func (this *ColumnReader) Width() uint {
+	return this.codec.width
+}
+
+// Read values into the slice. Return io.EOF after the last value.
+func (this *ColumnReader) Read(values []int) (n int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = kanzi.Errorf(kanzi.ErrCorruptData, "Invalid integer column: %v", r)
+		}
+	}()
+
+	for n < len(values) {
+		if len(this.block) == 0 {
+			if this.eos == true {
+				break
+			}
+
+			if err = this.readBlock(); err != nil {
+				return n, err
+			}
+
+			continue
+		}
+
+		k := copy(values[n:], this.block)
+		this.block = this.block[k:]
+		n += k
+	}
+
+	if n == 0 && len(values) > 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+func (this *ColumnReader) readBlock() error {
+	codec := this.codec
+	ibs := this.ibs
+	count := int(ibs.ReadBits(32))
+
+	if count == 0 {
+		this.eos = true
+		return nil
+	}
+
+	if count > codec.blockSize {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid integer column: invalid block size: %d", count)
+	}
+
+	m := codec.codedLen(count)
+	values := codec.buffers[0][0:m]
+
+	switch codec.coder {
+	case BITPACK_CODER:
+		packed := codec.buffers[1]
+		idx := 0
+
+		for start := 0; start < m; start += BITPACK_BLOCK_SIZE {
+			width := uint(ibs.ReadBits(7))
+
+			if width > 64 {
+				return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid integer column: invalid bit width: %d", width)
+			}
+
+			words := PackedLen(min(m-start, BITPACK_BLOCK_SIZE), width)
+			packed[idx] = int(width)
+
+			for i := idx + 1; i <= idx+words; i++ {
+				packed[i] = int(ibs.ReadBits(64))
+			}
+
+			idx += 1 + words
+		}
+
+		codec.packer.SetSize(uint(m))
+
+		if _, _, err := codec.packer.Inverse(packed[0:idx], values); err != nil {
+			return err
+		}
+
+	case EXPGOLOMB_CODER:
+		eg := this.egDecoders[ibs.ReadBit()]
+
+		for i := range values {
+			values[i] = eg.DecodeInt()
+		}
+
+	default:
+		signed := ibs.ReadBit()
+		rice := this.riceDecoders[signed]
+
+		for start := 0; start < m; start += RICE_CHUNK_SIZE {
+			chunk := values[start:min(start+RICE_CHUNK_SIZE, m)]
+			param := uint(ibs.ReadBits(5))
+
+			if param != riceEscape {
+				rice.SetLogBase(param)
+
+				for i := range chunk {
+					chunk[i] = rice.DecodeInt()
+				}
+
+				continue
+			}
+
+			width := uint(ibs.ReadBits(7))
+
+			if width > 64 {
+				return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid integer column: invalid bit width: %d", width)
+			}
+
+			for i := range chunk {
+				chunk[i] = 0
+
+				if width > 0 {
+					chunk[i] = int(ibs.ReadBits(width))
+
+					if signed == 1 {
+						chunk[i] = chunk[i] << (64 - width) >> (64 - width)
+					}
+				}
+			}
+		}
+	}
+
+	// Inverse stages, last one first
+	for i := len(codec.functions) - 1; i >= 0; i-- {
+		out := codec.buffers[(len(codec.functions)-i)&1]
+		_, n, err := codec.functions[i].Inverse(values, out)
+
+		if err != nil {
+			return err
+		}
+
+		values = out[0:n]
+	}
+
+	if len(values) != count {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid integer column: invalid number of values")
+	}
+
+	if err := checkWidth(values, codec.width, kanzi.ErrCorruptData); err != nil {
+		return err
+	}
+
+	this.block = values
+	return nil
+}
+
+// Compress the values and append the column to dst. Return the extended
+// slice (dst is returned unchanged in case of error).
+func CompressInts(dst []byte, src []int, opts *Options) ([]byte, error) {
+	return compressColumn(dst, len(src), opts.withDefaults().Width, opts, func(values []int, start int) {
+		copy(values, src[start:])
+	})
+}
+
+// Compress the values in a column of 32 bit values
+func CompressInt32s(dst []byte, src []int32, opts *Options) ([]byte, error) {
+	return compressColumn(dst, len(src), 32, opts, func(values []int, start int) {
+		for i := range values {
+			values[i] = int(src[start+i])
+		}
+	})
+}
+
+// Compress the values in a column of 64 bit values
+func CompressInt64s(dst []byte, src []int64, opts *Options) ([]byte, error) {
+	return compressColumn(dst, len(src), 64, opts, func(values []int, start int) {
+		for i := range values {
+			values[i] = int(src[start+i])
+		}
+	})
+}
+
+// Write 'count' values, provided block by block by get, in a column of
+// values of the given width
+func compressColumn(dst []byte, count int, width uint, opts *Options, get func(values []int, start int)) ([]byte, error) {
+	if width > strconv.IntSize {
+		return dst, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported value width: %d (the platform int has %d bits)",
+			width, strconv.IntSize)
+	}
+
+	o := opts.withDefaults()
+	o.Width = width
+
+	// Do not allocate a large block for a small input
+	if (opts == nil || opts.BlockSize == 0) && count < int(o.BlockSize) {
+		o.BlockSize = uint(max(count, 1))
+	}
+
+	bs := &bufferStream{}
+	writer, err := NewColumnWriter(bs, &o)
+
+	if err != nil {
+		return dst, err
+	}
+
+	buf := make([]int, min(count, int(o.BlockSize)))
+
+	for start := 0; start < count; start += len(buf) {
+		values := buf[0:min(len(buf), count-start)]
+		get(values, start)
+
+		if _, err = writer.Write(values); err != nil {
+			return dst, err
+		}
+	}
+
+	if err = writer.Close(); err != nil {
+		return dst, err
+	}
+
+	return append(dst, bs.Bytes()...), nil
+}
+
+// Decompress the column and append the values to dst. Return the extended
+// slice (dst is returned unchanged in case of error). The column must fit in
+// an int (64 bit values are rejected on 32 bit platforms).
+func DecompressInts(dst []int, src []byte) ([]int, error) {
+	res := dst
+	err := decompressColumn(src, strconv.IntSize, func(values []int) {
+		res = append(res, values...)
+	})
+
+	if err != nil {
+		return dst, err
+	}
+
+	return res, nil
+}
+
+// Decompress a column of 32 bit values (a column of 64 bit values is
+// rejected)
+func DecompressInt32s(dst []int32, src []byte) ([]int32, error) {
+	res := dst
+	err := decompressColumn(src, 32, func(values []int) {
+		for _, v := range values {
+			res = append(res, int32(v))
+		}
+	})
+
+	if err != nil {
+		return dst, err
+	}
+
+	return res, nil
+}
+
+// Decompress a column of 32 or 64 bit values
+func DecompressInt64s(dst []int64, src []byte) ([]int64, error) {
+	res := dst
+	err := decompressColumn(src, 64, func(values []int) {
+		for _, v := range values {
+			res = append(res, int64(v))
+		}
+	})
+
+	if err != nil {
+		return dst, err
+	}
+
+	return res, nil
+}
+
+// Read the column block by block, fail if the values are wider than 'width'
+// bits
+func decompressColumn(src []byte, width uint, put func(values []int)) error {
+	reader, err := NewColumnReader(&readerStream{bytes.NewReader(src)})
+
+	if err != nil {
+		return err
+	}
+
+	if reader.Width() > width {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Cannot decode a column of %d bit values into %d bit integers",
+			reader.Width(), width)
+	}
+
+	buf := make([]int, reader.codec.blockSize)
+
+	for {
+		n, err := reader.Read(buf)
+		put(buf[0:n])
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type bufferStream struct {
+	bytes.Buffer
+}
+
+func (this *bufferStream) Close() error {
+	return nil
+}
+
+type readerStream struct {
+	*bytes.Reader
+}
+
+func (this *readerStream) Close() error {
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integer
+
+import (
+	"kanzi"
+)
+
+// Delta coding of order 1 (difference with the previous value) or 2
+// (delta-of-delta: difference with the linear extrapolation of the 2
+// previous values, for regular timestamps). The first values are kept.
+// The arithmetic wraps around: any input is reversible.
+// The input and output buffers can be the same.
+// Implement kanzi.IntFunction.
+type DeltaCodec struct {
+	order int
+}
+
+func NewDeltaCodec(order int) (*DeltaCodec, error) {
+	if order != 1 && order != 2 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid delta order: %d (must be 1 or 2)", order)
+	}
+
+	this := new(DeltaCodec)
+	this.order = order
+	return this, nil
+}
+
+func (this *DeltaCodec) Order() int {
+	return this.order
+}
+
+func (this *DeltaCodec) Forward(src, dst []int) (uint, uint, error) {
+	if err := checkBuffers(src, dst, len(src)); err != nil {
+		return 0, 0, err
+	}
+
+	prev1, prev2 := 0, 0
+
+	for i, v := range src {
+		if this.order == 1 {
+			dst[i] = v - prev1
+		} else {
+			dst[i] = v - 2*prev1 + prev2
+		}
+
+		if i > 0 || this.order == 1 {
+			prev2 = prev1
+		} else {
+			prev2 = v // first delta-of-delta: difference with the first value
+		}
+
+		prev1 = v
+	}
+
+	return uint(len(src)), uint(len(src)), nil
+}
+
+func (this *DeltaCodec) Inverse(src, dst []int) (uint, uint, error) {
+	if err := checkBuffers(src, dst, len(src)); err != nil {
+		return 0, 0, err
+	}
+
+	prev1, prev2 := 0, 0
+
+	for i, v := range src {
+		if this.order == 1 {
+			v += prev1
+		} else {
+			v += 2*prev1 - prev2
+		}
+
+		dst[i] = v
+
+		if i > 0 || this.order == 1 {
+			prev2 = prev1
+		} else {
+			prev2 = v
+		}
+
+		prev1 = v
+	}
+
+	return uint(len(src)), uint(len(src)), nil
+}
+
+func (this DeltaCodec) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
+
+func checkBuffers(src, dst []int, required int) error {
+	if src == nil || dst == nil {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null buffer")
+	}
+
+	if len(dst) < required {
+		return kanzi.Errorf(kanzi.ErrBufferTooSmall, "Output buffer too small, required:%v, available:%v",
+			required, len(dst))
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integer
+
+// Frame of reference: the minimum value is written first, followed by the
+// differences with the minimum (non negative values, unless the range of the
+// values exceeds the range of int: the arithmetic wraps around).
+// The input and output buffers can be the same (the output is one value
+// longer).
+// Implement kanzi.IntFunction.
+type FrameOfReference struct {
+}
+
+func NewFrameOfReference() (*FrameOfReference, error) {
+	return &FrameOfReference{}, nil
+}
+
+func (this *FrameOfReference) Forward(src, dst []int) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if err := checkBuffers(src, dst, len(src)+1); err != nil {
+		return 0, 0, err
+	}
+
+	minVal := src[0]
+
+	for _, v := range src {
+		minVal = min(minVal, v)
+	}
+
+	// Backwards: the buffers can be the same
+	for i := len(src) - 1; i >= 0; i-- {
+		dst[i+1] = src[i] - minVal
+	}
+
+	dst[0] = minVal
+	return uint(len(src)), uint(len(src) + 1), nil
+}
+
+func (this *FrameOfReference) Inverse(src, dst []int) (uint, uint, error) {
+	if len(src) == 0 {
+		return 0, 0, nil
+	}
+
+	if err := checkBuffers(src, dst, len(src)-1); err != nil {
+		return 0, 0, err
+	}
+
+	ref := src[0]
+
+	for i, v := range src[1:] {
+		dst[i] = v + ref
+	}
+
+	return uint(len(src)), uint(len(src) - 1), nil
+}
+
+func (this FrameOfReference) MaxEncodedLen(srcLen int) int {
+	return srcLen + 1
+}
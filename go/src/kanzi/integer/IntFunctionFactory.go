@@ -0,0 +1,190 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integer
+
+import (
+	"kanzi"
+	"strings"
+)
+
+// The transforms of a column are applied in sequence (EG. "DELTA+ZIGZAG"),
+// the types of the stages are stored on 4 bits each (first stage in the
+// lowest bits) and the values are then coded by one of the coders.
+
+const (
+	NULL_TRANSFORM_TYPE = byte(0)
+	DELTA_TYPE          = byte(1) // difference with the previous value
+	DELTA2_TYPE         = byte(2) // delta-of-delta
+	ZIGZAG_TYPE         = byte(3)
+	FOR_TYPE            = byte(4) // frame of reference
+
+	MAX_TRANSFORM_STAGES = 4
+
+	BITPACK_CODER   = byte(0)
+	EXPGOLOMB_CODER = byte(1)
+	RICE_CODER      = byte(2)
+)
+
+func NewIntFunction(functionType byte) (kanzi.IntFunction, error) {
+	switch functionType {
+	case DELTA_TYPE:
+		return NewDeltaCodec(1)
+
+	case DELTA2_TYPE:
+		return NewDeltaCodec(2)
+
+	case ZIGZAG_TYPE:
+		return NewZigZagCodec()
+
+	case FOR_TYPE:
+		return NewFrameOfReference()
+
+	default:
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported integer function type: %d", functionType)
+	}
+}
+
+// Return the stages of the transform (lowest bits first)
+func NewIntFunctions(transformType uint16) ([]kanzi.IntFunction, error) {
+	res := make([]kanzi.IntFunction, 0, MAX_TRANSFORM_STAGES)
+
+	for t := transformType; t != 0; t >>= 4 {
+		if byte(t&0x0F) == NULL_TRANSFORM_TYPE {
+			continue
+		}
+
+		f, err := NewIntFunction(byte(t & 0x0F))
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, f)
+	}
+
+	return res, nil
+}
+
+func GetIntFunctionName(functionType byte) string {
+	switch functionType {
+	case NULL_TRANSFORM_TYPE:
+		return "NONE"
+
+	case DELTA_TYPE:
+		return "DELTA"
+
+	case DELTA2_TYPE:
+		return "DELTA2"
+
+	case ZIGZAG_TYPE:
+		return "ZIGZAG"
+
+	case FOR_TYPE:
+		return "FOR"
+
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Return the type of the transform described by the names of the stages
+// separated by '+' (case insensitive), EG. "delta+zigzag"
+func GetIntTransformType(name string) (uint16, error) {
+	res := uint16(0)
+	shift := uint(0)
+
+	for _, token := range strings.Split(strings.ToUpper(name), "+") {
+		var t byte
+
+		switch token {
+		case "NONE":
+			continue
+
+		case "DELTA":
+			t = DELTA_TYPE
+
+		case "DELTA2", "DELTA-OF-DELTA":
+			t = DELTA2_TYPE
+
+		case "ZIGZAG":
+			t = ZIGZAG_TYPE
+
+		case "FOR":
+			t = FOR_TYPE
+
+		default:
+			return 0, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported integer transform: '%s'", token)
+		}
+
+		if shift >= 4*MAX_TRANSFORM_STAGES {
+			return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Too many integer transforms (at most %d): '%s'",
+				MAX_TRANSFORM_STAGES, name)
+		}
+
+		res |= uint16(t) << shift
+		shift += 4
+	}
+
+	return res, nil
+}
+
+func GetIntTransformName(transformType uint16) string {
+	names := make([]string, 0, MAX_TRANSFORM_STAGES)
+
+	for t := transformType; t != 0; t >>= 4 {
+		if byte(t&0x0F) != NULL_TRANSFORM_TYPE {
+			names = append(names, GetIntFunctionName(byte(t&0x0F)))
+		}
+	}
+
+	if len(names) == 0 {
+		return "NONE"
+	}
+
+	return strings.Join(names, "+")
+}
+
+func GetIntCoderType(name string) (byte, error) {
+	switch strings.ToUpper(name) {
+	case "BITPACK":
+		return BITPACK_CODER, nil
+
+	case "EXPGOLOMB":
+		return EXPGOLOMB_CODER, nil
+
+	case "RICE":
+		return RICE_CODER, nil
+
+	default:
+		return 0, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported integer coder: '%s'", name)
+	}
+}
+
+func GetIntCoderName(coder byte) string {
+	switch coder {
+	case BITPACK_CODER:
+		return "BITPACK"
+
+	case EXPGOLOMB_CODER:
+		return "EXPGOLOMB"
+
+	case RICE_CODER:
+		return "RICE"
+
+	default:
+		return "UNKNOWN"
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integer
+
+// Map the signed values to unsigned ones, interleaving the negative and
+// positive values (0, -1, 1, -2, 2, ... => 0, 1, 2, 3, 4, ...), so that the
+// small magnitudes get small codes.
+// The input and output buffers can be the same.
+// Implement kanzi.IntFunction.
+type ZigZagCodec struct {
+}
+
+func NewZigZagCodec() (*ZigZagCodec, error) {
+	return &ZigZagCodec{}, nil
+}
+
+func (this *ZigZagCodec) Forward(src, dst []int) (uint, uint, error) {
+	if err := checkBuffers(src, dst, len(src)); err != nil {
+		return 0, 0, err
+	}
+
+	for i, v := range src {
+		dst[i] = ZigZagEncode(v)
+	}
+
+	return uint(len(src)), uint(len(src)), nil
+}
+
+func (this *ZigZagCodec) Inverse(src, dst []int) (uint, uint, error) {
+	if err := checkBuffers(src, dst, len(src)); err != nil {
+		return 0, 0, err
+	}
+
+	for i, v := range src {
+		dst[i] = ZigZagDecode(v)
+	}
+
+	return uint(len(src)), uint(len(src)), nil
+}
+
+func (this ZigZagCodec) MaxEncodedLen(srcLen int) int {
+	return srcLen
+}
+
+func ZigZagEncode(val int) int {
+	return (val << 1) ^ (val >> 63)
+}
+
+func ZigZagDecode(val int) int {
+	return int(uint(val)>>1) ^ -(val & 1)
+}
@@ -26,16 +26,18 @@ type BlockEvent struct {
 	eventType int
 	blockId   int
 	blockSize int
-	hash      uint32
+	hash      []byte
 	hashing   bool
 }
 
-func NewBlockEvent(type_, blockId, blockSize int, hash uint32, hashing bool) (*BlockEvent, error) {
+// The hash is the checksum of the block (4, 8 or 32 bytes, depending on the
+// checksum type). It is copied.
+func NewBlockEvent(type_, blockId, blockSize int, hash []byte, hashing bool) (*BlockEvent, error) {
 	this := new(BlockEvent)
 	this.eventType = type_
 	this.blockId = blockId
 	this.blockSize = blockSize
-	this.hash = hash
+	this.hash = append([]byte(nil), hash...)
 	this.hashing = hashing
 	return this, nil
 }
@@ -52,7 +54,7 @@ func (this *BlockEvent) BlockSize() int {
 	return this.blockSize
 }
 
-func (this *BlockEvent) Hash() uint32 {
+func (this *BlockEvent) Hash() []byte {
 	return this.hash
 }
 
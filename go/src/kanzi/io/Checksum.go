@@ -0,0 +1,163 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"kanzi"
+	"kanzi/util"
+	"strings"
+)
+
+// Block checksums. The checksum bit of the header selects the 32 bit XXHash
+// (seeded with BITSTREAM_TYPE). The other algorithms are selected with the
+// extended flags: when the EXTENDED_FLAGS_MASK bit of the header is set, 16
+// bits of flags follow the header (before the encryption header), the lowest
+// 4 bits contain the checksum type. The other bits are reserved (zero).
+// The checksums are written (big endian) after the block header and verified
+// after the inverse transform.
+
+const (
+	CHECKSUM_NONE     = 0
+	CHECKSUM_XXHASH32 = 1
+	CHECKSUM_XXHASH64 = 2
+	CHECKSUM_CRC32C   = 3
+	CHECKSUM_SHA256   = 4
+
+	EXTENDED_FLAGS_MASK = 0x02 // header reserved bits
+	CHECKSUM_TYPE_MASK  = 0x0F // extended flags
+)
+
+var (
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Return the checksum type matching the name (case insensitive)
+func GetChecksumType(name string) (byte, error) {
+	switch strings.ToUpper(name) {
+	case "NONE":
+		return CHECKSUM_NONE, nil
+
+	case "XXHASH32", "XXH32":
+		return CHECKSUM_XXHASH32, nil
+
+	case "XXHASH64", "XXH64":
+		return CHECKSUM_XXHASH64, nil
+
+	case "CRC32C":
+		return CHECKSUM_CRC32C, nil
+
+	case "SHA256", "SHA-256":
+		return CHECKSUM_SHA256, nil
+
+	default:
+		return 0, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported checksum type: '%s'", name)
+	}
+}
+
+func GetChecksumName(checksumType byte) string {
+	switch checksumType {
+	case CHECKSUM_NONE:
+		return "NONE"
+
+	case CHECKSUM_XXHASH32:
+		return "XXHASH32"
+
+	case CHECKSUM_XXHASH64:
+		return "XXHASH64"
+
+	case CHECKSUM_CRC32C:
+		return "CRC32C"
+
+	case CHECKSUM_SHA256:
+		return "SHA256"
+
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Compute the checksums of the blocks. Stateless, so it is shared by the
+// workers.
+type blockHasher struct {
+	checksumType byte
+	xxh32        *util.XXHash
+	xxh64        *util.XXHash64
+}
+
+// Return nil for CHECKSUM_NONE
+func newBlockHasher(checksumType byte) (*blockHasher, error) {
+	if checksumType == CHECKSUM_NONE {
+		return nil, nil
+	}
+
+	this := new(blockHasher)
+	this.checksumType = checksumType
+	var err error
+
+	switch checksumType {
+	case CHECKSUM_XXHASH32:
+		this.xxh32, err = util.NewXXHash(BITSTREAM_TYPE)
+
+	case CHECKSUM_XXHASH64:
+		this.xxh64, err = util.NewXXHash64(BITSTREAM_TYPE)
+
+	case CHECKSUM_CRC32C, CHECKSUM_SHA256:
+
+	default:
+		return nil, kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported checksum type: %d", checksumType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Return the size of the checksums in bytes
+func (this *blockHasher) size() int {
+	switch this.checksumType {
+	case CHECKSUM_XXHASH64:
+		return 8
+
+	case CHECKSUM_SHA256:
+		return sha256.Size
+
+	default:
+		return 4
+	}
+}
+
+// Append the checksum of the data to dst
+func (this *blockHasher) hash(dst, data []byte) []byte {
+	switch this.checksumType {
+	case CHECKSUM_XXHASH32:
+		return binary.BigEndian.AppendUint32(dst, this.xxh32.Hash(data))
+
+	case CHECKSUM_XXHASH64:
+		return binary.BigEndian.AppendUint64(dst, this.xxh64.Hash(data))
+
+	case CHECKSUM_CRC32C:
+		return binary.BigEndian.AppendUint32(dst, crc32.Checksum(data, crc32cTable))
+
+	default:
+		sum := sha256.Sum256(data)
+		return append(dst, sum[:]...)
+	}
+}
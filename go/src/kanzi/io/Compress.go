@@ -0,0 +1,288 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package io provides the compressed streams and the one-shot Compress and
+// Decompress functions. kanzi/io imports kanzi (interfaces and errors), so
+// kanzi cannot import the streams without an import cycle: the one-shot
+// functions are registered in the kanzi package when this package is
+// imported, and kanzi.Compress and kanzi.Decompress call them.
+package io
+
+import (
+	"crypto/ed25519"
+	"kanzi"
+)
+
+func init() {
+	kanzi.RegisterOneShot(compressAny, decompressAny)
+}
+
+// One-shot compression and decompression of byte slices. The functions
+// append to the destination slice (like the append builtin) so that the
+// caller can reuse its buffers.
+// To process many payloads, reusing one stream with Reset is cheaper than
+// calling these functions (the blocks, buffers and codecs are kept).
+
+const (
+	DEFAULT_ENTROPY_CODEC = "Huffman"
+	DEFAULT_TRANSFORM     = "BWT+MTF"
+	DEFAULT_BLOCK_SIZE    = 1024 * 1024
+)
+
+// Options of Compress and Decompress. A nil value selects the defaults.
+type Options struct {
+	Entropy           string             // entropy codec name (default: Huffman)
+	Transform         string             // transform name (default: BWT+MTF)
+	BlockSize         uint               // default: 1 MB, reduced to the size of small inputs
+	Checksum          bool               // add a checksum (XXHASH32) to each block
+	ChecksumAlgorithm string             // checksum algorithm name, implies Checksum (see GetChecksumType)
+	Jobs              uint               // number of concurrent jobs (default: 1)
+	Limits            DecodingLimits     // decompression limits (default: none)
+	Password          []byte             // encrypt the blocks (default: no encryption)
+	SigningKey        ed25519.PrivateKey // sign the stream (default: no signature)
+	VerifyKey         ed25519.PublicKey  // check the signature of the stream (default: no check)
+	Redundancy        uint               // parity shards, in percent of the data (default: none)
+	SyncMarkers       bool               // write a sync marker before each block (see Salvage.go)
+	Metadata          *Metadata          // stored in the header (default: none)
+}
+
+// Return the options with the default values filled in
+func (this *Options) withDefaults(inputSize int) Options {
+	var opts Options
+
+	if this != nil {
+		opts = *this
+	}
+
+	if opts.Entropy == "" {
+		opts.Entropy = DEFAULT_ENTROPY_CODEC
+	}
+
+	if opts.Transform == "" {
+		opts.Transform = DEFAULT_TRANSFORM
+	}
+
+	if opts.BlockSize == 0 {
+		opts.BlockSize = DEFAULT_BLOCK_SIZE
+
+		// Do not allocate a large block for a small input
+		if inputSize < DEFAULT_BLOCK_SIZE {
+			opts.BlockSize = uint(inputSize+7) & ^uint(7)
+
+			if opts.BlockSize < MIN_BITSTREAM_BLOCK_SIZE {
+				opts.BlockSize = MIN_BITSTREAM_BLOCK_SIZE
+			}
+		}
+	}
+
+	if opts.Jobs == 0 {
+		opts.Jobs = 1
+	}
+
+	return opts
+}
+
+// Return the options passed to kanzi.Compress or kanzi.Decompress
+func getOptions(opts interface{}) (*Options, error) {
+	switch o := opts.(type) {
+	case nil:
+		return nil, nil
+
+	case *Options:
+		return o, nil
+
+	case Options:
+		return &o, nil
+
+	default:
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid options: %T (expected *io.Options)", opts)
+	}
+}
+
+func compressAny(dst, src []byte, opts interface{}) ([]byte, error) {
+	o, err := getOptions(opts)
+
+	if err != nil {
+		return dst, err
+	}
+
+	return Compress(dst, src, o)
+}
+
+func decompressAny(dst, src []byte, opts interface{}) ([]byte, error) {
+	o, err := getOptions(opts)
+
+	if err != nil {
+		return dst, err
+	}
+
+	return Decompress(dst, src, o)
+}
+
+// Convert a panic (EG. unknown codec name, truncated header) to an error
+func recoverError(r interface{}, code int) error {
+	if ioerr, isIOErr := r.(*IOError); isIOErr == true {
+		return ioerr
+	}
+
+	return PanicIOError(r, code)
+}
+
+// Compress src and append the compressed stream to dst. Return the extended
+// slice (dst is returned unchanged in case of error).
+func Compress(dst, src []byte, opts *Options) (res []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = dst
+			err = recoverError(r, ERR_CREATE_COMPRESSOR)
+		}
+	}()
+
+	o := opts.withDefaults(len(src))
+	bos := &byteOutputStream{buf: dst}
+	cos, err := NewCompressedOutputStream(o.Entropy, o.Transform, bos, o.BlockSize, o.Checksum, nil, o.Jobs)
+
+	if err != nil {
+		return dst, err
+	}
+
+	closed := false
+
+	// Release the stream (workers, buffers) after an error. The error of
+	// Close is dropped: the first error is returned.
+	defer func() {
+		if closed == false {
+			cos.Close()
+		}
+	}()
+
+	if len(o.ChecksumAlgorithm) > 0 {
+		checksumType, err := GetChecksumType(o.ChecksumAlgorithm)
+
+		if err != nil {
+			return dst, err
+		}
+
+		if err = cos.SetChecksumType(checksumType); err != nil {
+			return dst, err
+		}
+	}
+
+	if len(o.Password) > 0 {
+		if err = cos.SetPassword(o.Password); err != nil {
+			return dst, err
+		}
+	}
+
+	if o.SigningKey != nil {
+		if err = cos.SetSigningKey(o.SigningKey); err != nil {
+			return dst, err
+		}
+	}
+
+	if o.Redundancy > 0 {
+		if err = cos.SetRedundancy(o.Redundancy); err != nil {
+			return dst, err
+		}
+	}
+
+	if o.Metadata != nil {
+		if err = cos.SetMetadata(o.Metadata); err != nil {
+			return dst, err
+		}
+	}
+
+	if o.SyncMarkers == true {
+		if err = cos.SetSyncMarkers(true); err != nil {
+			return dst, err
+		}
+	}
+
+	if _, err = cos.Write(src); err != nil {
+		return dst, err
+	}
+
+	closed = true
+
+	if err = cos.Close(); err != nil {
+		return dst, err
+	}
+
+	return bos.buf, nil
+}
+
+// Decompress the compressed stream in src and append the decompressed data
+// to dst. Return the extended slice (dst is returned unchanged in case of
+// error). Only the number of jobs, the limits, the password and the public
+// key are used in the options (damaged frames are repaired if the stream is
+// protected with parity shards).
+func Decompress(dst, src []byte, opts *Options) (res []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = dst
+			err = recoverError(r, ERR_READ_FILE)
+		}
+	}()
+
+	o := opts.withDefaults(0)
+	cis, err := NewCompressedInputStream(&byteInputStream{buf: src}, nil, o.Jobs)
+
+	if err != nil {
+		return dst, err
+	}
+
+	cis.SetLimits(o.Limits)
+
+	if len(o.Password) > 0 {
+		if err = cis.SetPassword(o.Password); err != nil {
+			return dst, err
+		}
+	}
+
+	if o.VerifyKey != nil {
+		if err = cis.SetVerifyKey(o.VerifyKey); err != nil {
+			return dst, err
+		}
+	}
+
+	defer cis.Close()
+	res = dst
+
+	for {
+		if len(res) == cap(res) {
+			// Grow the slice (amortized)
+			res = append(res, 0)[0:len(res)]
+		}
+
+		n, err := cis.Read(res[len(res):cap(res)])
+
+		if err != nil {
+			return dst, err
+		}
+
+		if n < 0 {
+			// End of stream
+			break
+		}
+
+		res = res[0 : len(res)+n]
+	}
+
+	if err = cis.Close(); err != nil {
+		return dst, err
+	}
+
+	return res, nil
+}
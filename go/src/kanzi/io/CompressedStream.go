@@ -18,14 +18,18 @@ package io
 import (
 	"bytes"
 	"container/list"
-	"errors"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"kanzi"
 	"kanzi/bitstream"
 	"kanzi/entropy"
 	"kanzi/function"
-	"kanzi/util"
+	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
 // Write to/read from stream using a 2 step process:
@@ -33,17 +37,23 @@ import (
 // - step 1: a ByteFunction is used to reduce the size of the input data (bytes input & output)
 // - step 2: an EntropyEncoder is used to entropy code the results of step 1 (bytes input, bits output)
 // Decoding is the exact reverse process.
+// Since version 1 of the bitstream format, each block is entropy coded in its
+// own buffer and written with a 32 bit length prefix (in bytes). Hence, both
+// steps run concurrently for all blocks and only the writing of the blocks to
+// the bitstream is sequential. A length of 0 marks the end of the stream.
+// Version 0 streams (blocks entropy coded back to back) can still be decoded.
 
 const (
-	BITSTREAM_TYPE             = 0x4B414E5A // "KANZ"
-	BITSTREAM_FORMAT_VERSION   = 0
-	STREAM_DEFAULT_BUFFER_SIZE = 1024 * 1024
-	COPY_LENGTH_MASK           = 0x0F
-	SMALL_BLOCK_MASK           = 0x80
-	SKIP_FUNCTION_MASK         = 0x40
-	MIN_BITSTREAM_BLOCK_SIZE   = 1024
-	MAX_BITSTREAM_BLOCK_SIZE   = 512 * 1024 * 1024
-	SMALL_BLOCK_SIZE           = 15
+	BITSTREAM_TYPE              = 0x4B414E5A // "KANZ"
+	BITSTREAM_FORMAT_VERSION    = 1
+	STREAM_DEFAULT_BUFFER_SIZE  = 1024 * 1024
+	COPY_LENGTH_MASK            = 0x0F
+	SMALL_BLOCK_MASK            = 0x80
+	SKIP_FUNCTION_MASK          = 0x40
+	MIN_BITSTREAM_BLOCK_SIZE    = 1024
+	MAX_BITSTREAM_BLOCK_SIZE    = 512 * 1024 * 1024
+	SMALL_BLOCK_SIZE            = 15
+	BLOCK_BITSTREAM_BUFFER_SIZE = 16384
 
 	ERR_MISSING_FILENAME    = -1
 	ERR_BLOCK_SIZE          = -2
@@ -61,6 +71,12 @@ const (
 	ERR_CREATE_CODEC        = -14
 	ERR_INVALID_FILE        = -15
 	ERR_STREAM_VERSION      = -16
+	ERR_OUTPUT_LIMIT        = -17
+	ERR_RATIO_LIMIT         = -18
+	ERR_BLOCK_SIZE_LIMIT    = -19
+	ERR_MISSING_PASSWORD    = -20
+	ERR_AUTHENTICATION      = -21
+	ERR_SIGNATURE           = -22
 	ERR_UNKNOWN             = -127
 )
 
@@ -68,18 +84,106 @@ var (
 	EMPTY_BYTE_SLICE = make([]byte, 0)
 )
 
+// The errors returned by the package are IOErrors. The code is the exit code
+// of the command line tools and the IOError wraps one of the sentinel errors
+// of the kanzi package (and the cause, if any):
+// - kanzi.ErrInvalidParam: invalid argument (EG. block size, number of jobs)
+// - kanzi.ErrUnsupported: unknown codec or transform, unsupported version
+// - kanzi.ErrCorruptData: invalid header or block, checksum mismatch, truncated stream
+// - kanzi.ErrClosed: the stream is closed (or has been reset)
+// - kanzi.ErrIO: the underlying stream failed (its error is wrapped)
+// - kanzi.ErrLimitExceeded: a decoding limit is exceeded (see DecodingLimits)
+// - kanzi.ErrAuthentication: an encrypted stream cannot be authenticated, the
+// signature of a stream is missing or invalid
 type IOError struct {
 	msg  string
 	code int
+	err  error // the cause or the sentinel error matching the code
 }
 
+// Create an IOError wrapping the sentinel error matching the code
 func NewIOError(msg string, code int) *IOError {
+	return WrapIOError(msg, code, nil)
+}
+
+// Create an IOError wrapping err (EG. an error returned by a codec or by the
+// underlying stream). The sentinel error matching the code is wrapped too,
+// unless err already wraps a sentinel error.
+func WrapIOError(msg string, code int, err error) *IOError {
 	this := new(IOError)
 	this.msg = msg
 	this.code = code
+	this.err = err
+	kind := codeKind(code)
+
+	if err == nil {
+		this.err = kind
+	} else if kind != nil && kanzi.Kind(err) == nil {
+		this.err = kanzi.Errorf(kind, "%w", err)
+	}
+
 	return this
 }
 
+// Create an IOError from the value of a recovered panic (wrapped if it is an
+// error)
+func PanicIOError(r interface{}, code int) *IOError {
+	cause, _ := r.(error)
+	return WrapIOError(fmt.Sprintf("%v", r), code, cause)
+}
+
+// Create the error of a block from the value of a panic recovered while
+// decoding it. A runtime error (EG. an index out of range in a decoder) is
+// caused by invalid data: it is reported as corrupt data, without the details
+// of the runtime in the message (the runtime error is still wrapped).
+func blockPanicError(r interface{}, blockId int, code int) *IOError {
+	if rerr, isRuntime := r.(runtime.Error); isRuntime == true {
+		return WrapIOError(fmt.Sprintf("Invalid data in block %d: the block cannot be decoded", blockId),
+			ERR_PROCESS_BLOCK, corrupted(rerr))
+	}
+
+	return PanicIOError(r, code)
+}
+
+// Return the sentinel error matching an error code
+func codeKind(code int) error {
+	switch code {
+	case ERR_MISSING_FILENAME, ERR_BLOCK_SIZE, ERR_CREATE_COMPRESSOR,
+		ERR_CREATE_DECOMPRESSOR, ERR_CREATE_BITSTREAM, ERR_MISSING_PASSWORD:
+		return kanzi.ErrInvalidParam
+
+	case ERR_INVALID_CODEC, ERR_CREATE_CODEC, ERR_STREAM_VERSION:
+		return kanzi.ErrUnsupported
+
+	case ERR_OUTPUT_IS_DIR, ERR_OVERWRITE_FILE, ERR_CREATE_FILE, ERR_OPEN_FILE,
+		ERR_READ_FILE, ERR_WRITE_FILE:
+		return kanzi.ErrIO
+
+	case ERR_PROCESS_BLOCK, ERR_INVALID_FILE:
+		return kanzi.ErrCorruptData
+
+	case ERR_OUTPUT_LIMIT, ERR_RATIO_LIMIT, ERR_BLOCK_SIZE_LIMIT:
+		return kanzi.ErrLimitExceeded
+
+	case ERR_AUTHENTICATION, ERR_SIGNATURE:
+		return kanzi.ErrAuthentication
+
+	default:
+		return nil
+	}
+}
+
+// Wrap an error raised while decoding the data of a block: whatever the
+// cause (EG. an invalid parameter computed from the data), the block is
+// corrupted
+func corrupted(err error) error {
+	if err == nil {
+		return kanzi.ErrCorruptData
+	}
+
+	return kanzi.Errorf(kanzi.ErrCorruptData, "%w", err)
+}
+
 // Implement error interface
 func (this IOError) Error() string {
 	return fmt.Sprintf("%v (code %v)", this.msg, this.code)
@@ -93,46 +197,86 @@ func (this IOError) ErrorCode() int {
 	return this.code
 }
 
+// Return the wrapped error (see errors.Is and errors.As)
+func (this IOError) Unwrap() error {
+	return this.err
+}
+
+// A block travelling through the encoding pipeline
+type encodingTask struct {
+	id        int
+	data      []byte // raw block
+	length    int    // number of bytes in data
+	checksum  []byte
+	block     []byte // entropy coded block
+	err       *IOError
+	listeners []BlockListener
+	done      chan bool
+}
+
+// The codecs, bitstream and buffer owned by a worker are reused from block
+// to block to avoid memory allocations
+type blockEncoder struct {
+	transform kanzi.ByteFunction
+	ee        kanzi.EntropyEncoder
+	obs       *bitstream.DefaultOutputBitStream
+	bos       *byteOutputStream
+	buffer    []byte
+	sealed    []byte // encrypted block
+}
+
 type CompressedOutputStream struct {
 	blockSize     uint
-	hasher        *util.XXHash
-	data          []byte
-	buffers       [][]byte
+	hasher        *blockHasher
 	entropyType   byte
 	transformType byte
-	obs           kanzi.OutputBitStream
+	transformArgs []uint // parameters of the transform name (EG. image layout)
+	obs           *bitstream.DefaultOutputBitStream
+	os            kanzi.OutputStream
 	debugWriter   io.Writer
 	initialized   bool
 	closed        bool
 	blockId       int
-	curIdx        int
 	jobs          int
-	channels      []chan error
+	current       *encodingTask // block being filled by Write
+	nbTasks       int           // number of tasks allocated (at most 2*jobs)
+	free          chan *encodingTask
+	work          chan *encodingTask
+	ordered       chan *encodingTask
+	pending       sync.WaitGroup
+	started       bool
+	encoders      []*blockEncoder  // one per worker, kept across Reset
+	cipher        *streamCipher    // nil if the blocks are not encrypted
+	signer        *streamSigner    // nil if the stream is not signed
+	fec           *fecOutputStream // nil if the stream is not protected
+	syncMarkers   bool
+	metadata      []byte // metadata section, nil if there is no metadata
+	err           error
+	errLock       sync.Mutex
 	listeners     *list.List
+	listenersLock sync.Mutex
 }
 
 func NewCompressedOutputStream(entropyCodec string, functionType string, os kanzi.OutputStream, blockSize uint,
 	checksum bool, debugWriter io.Writer, jobs uint) (*CompressedOutputStream, error) {
 	if os == nil {
-		return nil, errors.New("Invalid null output stream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null output stream parameter")
 	}
 
 	if blockSize > MAX_BITSTREAM_BLOCK_SIZE {
-		errMsg := fmt.Sprintf("The block size must be at most %d", MAX_BITSTREAM_BLOCK_SIZE)
-		return nil, errors.New(errMsg)
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The block size must be at most %d", MAX_BITSTREAM_BLOCK_SIZE)
 	}
 
 	if blockSize < MIN_BITSTREAM_BLOCK_SIZE {
-		errMsg := fmt.Sprintf("The block size must be at least %d", MIN_BITSTREAM_BLOCK_SIZE)
-		return nil, errors.New(errMsg)
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The block size must be at least %d", MIN_BITSTREAM_BLOCK_SIZE)
 	}
 
 	if int(blockSize)&-8 != int(blockSize) {
-		return nil, errors.New("The block size must be a multiple of 8")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The block size must be a multiple of 8")
 	}
 
 	if jobs < 1 || jobs > 16 {
-		return nil, errors.New("The number of jobs must be in [1..16]")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The number of jobs must be in [1..16]")
 	}
 
 	this := new(CompressedOutputStream)
@@ -148,36 +292,38 @@ func NewCompressedOutputStream(entropyCodec string, functionType string, os kanz
 		return nil, err
 	}
 
+	this.os = os
+
 	// Check entropy type validity (panic on error)
 	this.entropyType = entropy.GetEntropyCodecType(entropyCodec)
 
 	// Check transform type validity (panic on error)
 	this.transformType = function.GetByteFunctionType(functionType)
+	this.transformArgs = function.GetByteFunctionArgs(functionType)
 
 	this.blockSize = blockSize
 
 	if checksum == true {
-		this.hasher, err = util.NewXXHash(BITSTREAM_TYPE)
-
-		if err != nil {
+		if this.hasher, err = newBlockHasher(CHECKSUM_XXHASH32); err != nil {
 			return nil, err
 		}
 	}
 
-	this.data = make([]byte, jobs*blockSize)
-	this.buffers = make([][]byte, jobs)
-
-	for i := range this.buffers {
-		this.buffers[i] = EMPTY_BYTE_SLICE
-	}
-
 	this.debugWriter = debugWriter
 	this.jobs = int(jobs)
 	this.blockId = 0
-	this.channels = make([]chan error, this.jobs+1)
 
-	for i := range this.channels {
-		this.channels[i] = make(chan error)
+	// At most 2*jobs blocks are in flight: the memory used is bounded and
+	// the workers always have a block to process while the previous ones
+	// are written
+	maxTasks := 2 * this.jobs
+	this.free = make(chan *encodingTask, maxTasks)
+	this.work = make(chan *encodingTask, maxTasks)
+	this.ordered = make(chan *encodingTask, maxTasks)
+	this.encoders = make([]*blockEncoder, this.jobs)
+
+	for i := range this.encoders {
+		this.encoders[i] = &blockEncoder{buffer: EMPTY_BYTE_SLICE}
 	}
 
 	this.listeners = list.New()
@@ -189,7 +335,9 @@ func (this *CompressedOutputStream) AddListener(bl BlockListener) bool {
 		return false
 	}
 
+	this.listenersLock.Lock()
 	this.listeners.PushFront(bl)
+	this.listenersLock.Unlock()
 	return true
 }
 
@@ -198,6 +346,9 @@ func (this *CompressedOutputStream) RemoveListener(bl BlockListener) bool {
 		return false
 	}
 
+	this.listenersLock.Lock()
+	defer this.listenersLock.Unlock()
+
 	for e := this.listeners.Front(); e != nil; e = e.Next() {
 		if e.Value == bl {
 			this.listeners.Remove(e)
@@ -243,315 +394,815 @@ func (this *CompressedOutputStream) WriteHeader() *IOError {
 		return NewIOError("Cannot write block size to header", ERR_WRITE_FILE)
 	}
 
-	if this.obs.WriteBits(0, 4) != 4 {
-		return NewIOError("Cannot write reserved bits to header", ERR_WRITE_FILE)
+	flags := byte(0)
+
+	if this.cipher != nil {
+		flags |= ENCRYPTION_MASK
 	}
 
-	return nil
-}
+	if this.signer != nil {
+		flags |= SIGNATURE_MASK
+	}
 
-// Implement the kanzi.OutputStream interface
-func (this *CompressedOutputStream) Write(array []byte) (int, error) {
-	if this.closed == true {
-		return 0, NewIOError("Stream closed", ERR_WRITE_FILE)
+	if this.metadata != nil {
+		flags |= METADATA_MASK
 	}
 
-	startChunk := 0
-	remaining := len(array)
-	bSize := int(this.jobs) * int(this.blockSize)
+	// The 32 bit XXHash checksum does not require the extended flags (so the
+	// streams can be decoded by previous versions of the decoder)
+	var extFlags []byte
 
-	for remaining > 0 {
-		if this.curIdx >= bSize {
-			// Buffer full, time to encode
-			if err := this.processBlock(); err != nil {
-				return len(array) - remaining, err
-			}
+	if (this.hasher != nil && this.hasher.checksumType != CHECKSUM_XXHASH32) || this.fec != nil || this.syncMarkers == true {
+		ext := uint16(0)
+
+		if this.hasher != nil {
+			ext = uint16(this.hasher.checksumType)
 		}
 
-		lenChunk := len(array) - startChunk
+		if this.fec != nil {
+			ext |= FEC_MASK
+		}
 
-		if lenChunk+this.curIdx >= bSize {
-			// Limit to number of available bytes in buffer
-			lenChunk = bSize - this.curIdx
+		if this.syncMarkers == true {
+			ext |= SYNC_MARKERS_MASK
 		}
 
-		// Process a chunk of in-buffer data. No access to bitstream required
-		copy(this.data[this.curIdx:], array[startChunk:startChunk+lenChunk])
-		this.curIdx += lenChunk
-		startChunk += lenChunk
-		remaining -= lenChunk
+		extFlags = binary.BigEndian.AppendUint16(nil, ext)
+
+		if this.fec != nil {
+			extFlags = append(extFlags, this.fec.header()...)
+		}
+
+		flags |= EXTENDED_FLAGS_MASK
 	}
 
-	return len(array) - remaining, nil
-}
+	if this.obs.WriteBits(uint64(flags), 4) != 4 {
+		return NewIOError("Cannot write reserved bits to header", ERR_WRITE_FILE)
+	}
 
-// Implement the kanzi.OutputStream interface
-func (this *CompressedOutputStream) Close() error {
-	if this.closed == true {
-		return nil
+	if extFlags != nil {
+		writeBytes(this.obs, extFlags)
 	}
 
-	if this.curIdx > 0 {
-		if err := this.processBlock(); err != nil {
-			return err
-		}
+	if this.metadata != nil {
+		writeBytes(this.obs, this.metadata)
 
-		this.curIdx = 0
+		// The metadata is signed and authenticated with the extended flags
+		extFlags = append(extFlags, this.metadata...)
 	}
 
-	// Write end block of size 0
-	this.obs.WriteBits(SMALL_BLOCK_MASK, 8)
+	if this.signer != nil {
+		this.signer.reset()
+		this.signer.update(headerBytes(BITSTREAM_FORMAT_VERSION, this.hasher != nil, this.entropyType,
+			this.transformType, this.blockSize, flags))
+		this.signer.update(extFlags)
+	}
 
-	if _, err := this.obs.Close(); err != nil {
-		return err
+	if this.cipher != nil {
+		if err := this.writeEncryptionHeader(flags, extFlags); err != nil {
+			return err
+		}
 	}
 
-	this.closed = true
+	this.initialized = true
+	return nil
+}
 
-	// Release resources
-	this.data = EMPTY_BYTE_SLICE
+// Pick the IV of the stream, then write the encryption parameters and the
+// tag authenticating the header
+func (this *CompressedOutputStream) writeEncryptionHeader(flags byte, extFlags []byte) (err *IOError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = PanicIOError(r, ERR_WRITE_FILE)
+		}
+	}()
 
-	for i := range this.buffers {
-		this.buffers[i] = EMPTY_BYTE_SLICE
+	c := this.cipher
+
+	if _, err := rand.Read(c.iv[:]); err != nil {
+		return WrapIOError("Cannot create the stream key: "+err.Error(), ERR_CREATE_CODEC, err)
 	}
 
-	for _, c := range this.channels {
-		close(c)
+	if err := c.initStream(); err != nil {
+		return WrapIOError("Cannot create the stream key: "+err.Error(), ERR_CREATE_CODEC, err)
+	}
+
+	data := c.headerData(BITSTREAM_FORMAT_VERSION, this.hasher != nil, this.entropyType&0x1F,
+		this.transformType&0x1F, this.blockSize, flags, extFlags)
+	header := c.headerBytes(c.seal(nil, NONCE_HEADER, 0, nil, data))
+	writeBytes(this.obs, header)
+
+	if this.signer != nil {
+		this.signer.update(header)
 	}
 
-	this.listeners.Init()
 	return nil
 }
 
-func (this *CompressedOutputStream) processBlock() error {
-	if this.curIdx == 0 {
-		return nil
+// Encrypt the blocks with AES-256-GCM using a key derived from the password
+// (see Encryption.go). Must be called before the header is written. The key
+// derivation is deliberately slow but it is performed once: the streams
+// written after a Reset reuse the master key (each stream has its own key).
+func (this *CompressedOutputStream) SetPassword(password []byte) error {
+	if this.initialized == true {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The password must be set before writing the header")
 	}
 
-	if this.initialized == false {
-		if err := this.WriteHeader(); err != nil {
-			return err
-		}
+	c, err := newStreamCipher(password)
 
-		this.initialized = true
+	if err != nil {
+		return err
 	}
 
-	offset := uint(0)
-	blockNumber := this.blockId
-
-	// Protect against future concurrent modification of the list of block listeners
-	listeners_ := make([]BlockListener, this.listeners.Len())
+	if err = c.initKey(); err != nil {
+		return WrapIOError("Cannot derive the key from the password: "+err.Error(), ERR_CREATE_CODEC, err)
+	}
 
-	if this.listeners.Len() > 0 {
-		n := 0
+	this.cipher = c
+	return nil
+}
 
-		for e := this.listeners.Front(); e != nil; e = e.Next() {
-			listeners_[n] = e.Value.(BlockListener)
-			n++
-		}
+// Select the algorithm of the block checksums (see Checksum.go). Must be
+// called before the header is written.
+func (this *CompressedOutputStream) SetChecksumType(checksumType byte) error {
+	if this.initialized == true {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The checksum type must be set before writing the header")
 	}
 
-	// Invoke as many go routines as required
-	for jobId := 0; jobId < this.jobs; jobId++ {
-		blockNumber++
-		sz := uint(this.curIdx)
+	hasher, err := newBlockHasher(checksumType)
 
-		if sz >= this.blockSize {
-			sz = this.blockSize
-		}
+	if err != nil {
+		return err
+	}
 
-		// Invoke the tasks concurrently
-		// Tasks are chained through channels. Upon completion of transform
-		// (concurrently) the tasks wait for a signal to start entropy encoding
-		go this.encode(this.data[offset:offset+sz], this.buffers[jobId], sz,
-			this.transformType, this.entropyType, blockNumber,
-			this.channels[jobId], this.channels[jobId+1], listeners_)
+	this.hasher = hasher
+	return nil
+}
 
-		offset += sz
-		this.curIdx -= int(sz)
+// Protect the stream with Reed-Solomon parity shards (see FEC.go). The
+// redundancy is the size of the parity relative to the size of the data, in
+// percent (0 to disable, at most MAX_REDUNDANCY). Must be called before the
+// header is written. The redundancy is kept across Reset.
+func (this *CompressedOutputStream) SetRedundancy(redundancy uint) error {
+	if this.initialized == true {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The redundancy must be set before writing the header")
+	}
 
-		if this.curIdx == 0 {
-			break
-		}
+	if redundancy > MAX_REDUNDANCY {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The redundancy must be at most %d%%", MAX_REDUNDANCY)
 	}
 
-	// Allow start of entropy coding for first block
-	this.channels[0] <- error(nil)
+	if redundancy == 0 {
+		this.fec = nil
+		return this.obs.Reset(this.os)
+	}
 
-	// Wait for completion of last task
-	err := <-this.channels[blockNumber-this.blockId]
+	dataShards, parityShards := fecShards(redundancy)
+	fec, err := newFECOutputStream(this.os, dataShards, parityShards, FEC_SHARD_SIZE)
 
-	this.blockId += this.jobs
-	return err
-}
+	if err != nil {
+		return err
+	}
 
-// Return the number of bytes written so far
-func (this *CompressedOutputStream) GetWritten() uint64 {
-	return (this.obs.Written() + 7) >> 3
+	this.fec = fec
+	return this.obs.Reset(this.fec)
 }
 
-func (this *CompressedOutputStream) encode(data, buf []byte, blockLength uint,
-	typeOfTransform byte, typeOfEntropy byte, currentBlockId int,
-	input, output chan error, listeners_ []BlockListener) {
-	transform, err := function.NewByteFunction(blockLength, typeOfTransform)
-
-	if err != nil {
-		<-input
-		output <- NewIOError(err.Error(), ERR_CREATE_CODEC)
-		return
+// Write a sync marker before each block, so that a decoder in salvage mode
+// can skip the damaged blocks (see Salvage.go). Must be called before the
+// header is written. The setting is kept across Reset.
+func (this *CompressedOutputStream) SetSyncMarkers(enabled bool) error {
+	if this.initialized == true {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The sync markers must be enabled before writing the header")
 	}
 
-	buffer := buf
-	requiredSize := transform.MaxEncodedLen(int(blockLength))
+	this.syncMarkers = enabled
+	return nil
+}
 
-	if requiredSize == -1 {
-		// Max size unknown => guess
-		requiredSize = int(blockLength) * 5 >> 2
+// Store the metadata in the header (see Metadata.go), nil to remove it. Must
+// be called before the header is written. The metadata is kept across Reset.
+func (this *CompressedOutputStream) SetMetadata(metadata *Metadata) error {
+	if this.initialized == true {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The metadata must be set before writing the header")
 	}
 
-	if typeOfTransform == function.NULL_TRANSFORM_TYPE {
-		buffer = data // share buffers if no transform
-	} else if len(buffer) < requiredSize {
-		buffer = make([]byte, requiredSize)
+	if metadata == nil {
+		this.metadata = nil
+		return nil
 	}
 
-	mode := byte(0)
-	dataSize := uint(0)
-	postTransformLength := blockLength
-	checksum := uint32(0)
-	iIdx := uint(0)
-	oIdx := uint(0)
+	section, err := metadata.encode()
 
-	// Compute block checksum
-	if this.hasher != nil {
-		checksum = this.hasher.Hash(data[0:blockLength])
+	if err != nil {
+		return err
 	}
 
-	if len(listeners_) > 0 {
-		// Notify before transform
-		evt, err := NewBlockEvent(EVT_BEFORE_TRANSFORM, currentBlockId,
-			int(blockLength), checksum, this.hasher != nil)
+	this.metadata = section
+	return nil
+}
 
-		if err == nil {
-			for _, bl := range listeners_ {
-				bl.ProcessEvent(evt)
-			}
-		}
+// Sign the stream with the private key (see Signature.go). Must be called
+// before the header is written. The key is kept across Reset.
+func (this *CompressedOutputStream) SetSigningKey(key ed25519.PrivateKey) error {
+	if this.initialized == true {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The signing key must be set before writing the header")
 	}
 
-	if blockLength <= SMALL_BLOCK_SIZE {
-		// Just copy
-		if !kanzi.SameByteSlices(buffer, data, false) {
-			copy(buffer, data[0:blockLength])
-		}
+	if len(key) != ed25519.PrivateKeySize {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid private key size: %d", len(key))
+	}
 
-		iIdx += blockLength
-		oIdx += blockLength
-		mode = byte(SMALL_BLOCK_SIZE | (blockLength & COPY_LENGTH_MASK))
-	} else {
+	this.signer = newStreamSigner(key, nil)
+	return nil
+}
 
-		// Forward transform
-		iIdx, oIdx, err = transform.Forward(data, buffer)
+// Implement the kanzi.OutputStream interface
+func (this *CompressedOutputStream) Write(array []byte) (int, error) {
+	if this.closed == true {
+		return 0, WrapIOError("Stream closed", ERR_WRITE_FILE, kanzi.ErrClosed)
+	}
 
-		if err != nil {
-			// Transform failed (probably due to lack of space in output buffer)
-			if !kanzi.SameByteSlices(buffer, data, false) {
-				copy(buffer, data)
-			}
+	startChunk := 0
+	remaining := len(array)
 
-			iIdx = blockLength
-			oIdx = blockLength
-			mode |= SKIP_FUNCTION_MASK
+	for remaining > 0 {
+		if this.current == nil {
+			this.current = this.getTask()
 		}
 
-		postTransformLength = oIdx
-
-		for i := uint64(0xFF); i < uint64(postTransformLength); i <<= 8 {
-			dataSize++
-		}
+		lenChunk := len(array) - startChunk
+		t := this.current
 
-		if dataSize > 3 {
-			<-input
-			output <- NewIOError("Invalid block data length", ERR_WRITE_FILE)
-			return
+		if lenChunk+t.length >= int(this.blockSize) {
+			// Limit to number of available bytes in block
+			lenChunk = int(this.blockSize) - t.length
 		}
 
-		// Record size of 'block size' - 1 in bytes
-		mode |= byte(dataSize & 0x03)
-		dataSize++
-	}
-
-	if len(listeners_) > 0 {
-		// Notify after transform
-		evt, err := NewBlockEvent(EVT_AFTER_TRANSFORM, currentBlockId,
-			int(postTransformLength), checksum, this.hasher != nil)
+		// Process a chunk of in-buffer data. No access to bitstream required
+		copy(t.data[t.length:], array[startChunk:startChunk+lenChunk])
+		t.length += lenChunk
+		startChunk += lenChunk
+		remaining -= lenChunk
 
-		if err == nil {
-			for _, bl := range listeners_ {
-				bl.ProcessEvent(evt)
+		if t.length == int(this.blockSize) {
+			// Block full, time to encode
+			if err := this.processBlock(); err != nil {
+				return len(array) - remaining, err
 			}
 		}
 	}
 
-	// Wait for the concurrent task processing the previous block to complete
-	// entropy encoding. Entropy encoding must happen sequentially (and
-	// in the correct block order) in the bitstream.
-	err2 := <-input
+	return len(array) - remaining, nil
+}
 
-	if err2 != nil {
-		output <- err2
-		return
+// Encode the pending data and write all pending bytes to the underlying
+// stream. Blocks are byte aligned (since version 1 of the format, the version
+// 0 blocks are not padded), so a stream can be flushed at message boundaries
+// (EG. when sent over a network connection).
+func (this *CompressedOutputStream) Flush() error {
+	if this.closed == true {
+		return WrapIOError("Stream closed", ERR_WRITE_FILE, kanzi.ErrClosed)
 	}
 
-	// Each block is encoded separately
-	// Rebuild the entropy encoder to reset block statistics
-	ee, err := entropy.NewEntropyEncoder(this.obs, typeOfEntropy)
+	if err := this.WriteHeader(); err != nil {
+		return err
+	}
 
-	if err != nil {
-		output <- NewIOError(err.Error(), ERR_CREATE_CODEC)
-		return
+	if err := this.processBlock(); err != nil {
+		return err
 	}
 
-	// Write block 'header' (mode + compressed length)
-	written := this.obs.Written()
-	this.obs.WriteBits(uint64(mode), 8)
+	// Wait for all the blocks in flight to be written
+	this.pending.Wait()
 
-	if dataSize > 0 {
-		this.obs.WriteBits(uint64(postTransformLength), 8*dataSize)
+	if err := this.getError(); err != nil {
+		return err
 	}
 
-	// Write checksum
-	if this.hasher != nil {
-		this.obs.WriteBits(uint64(checksum), 32)
+	if err := this.obs.Flush(); err != nil {
+		return WrapIOError(err.Error(), ERR_WRITE_FILE, err)
 	}
 
-	if len(listeners_) > 0 {
-		// Notify before entropy
-		evt, err := NewBlockEvent(EVT_BEFORE_ENTROPY, currentBlockId,
-			int(postTransformLength), checksum, this.hasher != nil)
-
-		if err == nil {
-			for _, bl := range listeners_ {
-				bl.ProcessEvent(evt)
-			}
+	if this.fec != nil {
+		// Write the frame even if it is not full
+		if err := this.fec.flush(); err != nil {
+			return WrapIOError(err.Error(), ERR_WRITE_FILE, err)
 		}
 	}
 
-	// Entropy encode block
-	_, err = ee.Encode(buffer[0:postTransformLength])
+	return nil
+}
 
-	if err != nil {
-		output <- NewIOError(err.Error(), ERR_PROCESS_BLOCK)
-		return
+// Implement the kanzi.OutputStream interface
+func (this *CompressedOutputStream) Close() error {
+	if this.closed == true {
+		return nil
 	}
 
-	// Dispose before displaying statistics. Dispose may write to the bitstream
-	ee.Dispose()
+	err := this.close()
 
-	if len(listeners_) > 0 {
-		// Notify after entropy
+	// Stop the workers and the writer, also after an error
+	this.pending.Wait()
+	this.stopPipeline()
+
+	if err != nil {
+		return err
+	}
+
+	this.closed = true
+	this.listeners.Init()
+	return nil
+}
+
+func (this *CompressedOutputStream) close() error {
+	if err := this.processBlock(); err != nil {
+		return err
+	}
+
+	if this.initialized == false {
+		// Empty stream: the header is still required by the decoder
+		if err := this.WriteHeader(); err != nil {
+			return err
+		}
+	}
+
+	// Wait for all the blocks in flight to be written
+	this.pending.Wait()
+
+	if err := this.getError(); err != nil {
+		return err
+	}
+
+	endId := this.blockId + 1
+
+	if this.cipher != nil {
+		// Authenticate the number of blocks to detect truncated streams
+		if err := this.writeBlock(endId, this.cipher.seal(nil, NONCE_END, this.blockId, nil, nil)); err != nil {
+			return err
+		}
+
+		endId++
+	}
+
+	// Write end block of size 0
+	if err := this.writeBlock(endId, EMPTY_BYTE_SLICE); err != nil {
+		return err
+	}
+
+	if this.signer != nil {
+		if err := this.writeSignature(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := this.obs.Close(); err != nil {
+		return WrapIOError(err.Error(), ERR_WRITE_FILE, err)
+	}
+
+	return nil
+}
+
+// Reset discards the state of the stream and makes it equivalent to a new
+// stream (with the same parameters) writing to os. The pending data and the
+// blocks in flight are discarded and the previous output stream is not
+// closed. The blocks, buffers and codecs are reused, so one stream can
+// compress many small payloads efficiently.
+func (this *CompressedOutputStream) Reset(os kanzi.OutputStream) error {
+	if os == nil {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null output stream parameter")
+	}
+
+	if this.closed == false {
+		// Make the writer skip the blocks in flight
+		this.setError(WrapIOError("Stream reset", ERR_WRITE_FILE, kanzi.ErrClosed))
+		this.pending.Wait()
+		this.stopPipeline()
+	}
+
+	this.os = os
+
+	if this.fec != nil {
+		this.fec.reset(os)
+		os = this.fec
+	}
+
+	if err := this.obs.Reset(os); err != nil {
+		return err
+	}
+
+	this.err = nil
+	this.initialized = false
+	this.closed = false
+	this.blockId = 0
+	return nil
+}
+
+// Stop the workers and the writer once all the blocks have been written.
+// The blocks are kept for reuse.
+func (this *CompressedOutputStream) stopPipeline() {
+	if this.current != nil {
+		this.current.length = 0
+		this.free <- this.current
+		this.current = nil
+	}
+
+	if this.started == true {
+		close(this.work)
+		close(this.ordered)
+		this.work = make(chan *encodingTask, cap(this.free))
+		this.ordered = make(chan *encodingTask, cap(this.free))
+		this.started = false
+	}
+}
+
+// Return a block to fill, waiting for one to be written if all the blocks
+// are in flight
+func (this *CompressedOutputStream) getTask() *encodingTask {
+	select {
+	case t := <-this.free:
+		return t
+	default:
+	}
+
+	if this.nbTasks < cap(this.free) {
+		this.nbTasks++
+		t := &encodingTask{data: make([]byte, this.blockSize), block: EMPTY_BYTE_SLICE}
+		t.done = make(chan bool, 1)
+		return t
+	}
+
+	return <-this.free
+}
+
+func (this *CompressedOutputStream) getError() error {
+	this.errLock.Lock()
+	defer this.errLock.Unlock()
+	return this.err
+}
+
+// Keep the first error encountered
+func (this *CompressedOutputStream) setError(err error) {
+	this.errLock.Lock()
+
+	if this.err == nil {
+		this.err = err
+	}
+
+	this.errLock.Unlock()
+}
+
+func (this *CompressedOutputStream) snapshotListeners() []BlockListener {
+	this.listenersLock.Lock()
+	defer this.listenersLock.Unlock()
+	listeners_ := make([]BlockListener, 0, this.listeners.Len())
+
+	for e := this.listeners.Front(); e != nil; e = e.Next() {
+		listeners_ = append(listeners_, e.Value.(BlockListener))
+	}
+
+	return listeners_
+}
+
+// Submit the current block to the pipeline. The blocks are transformed and
+// entropy coded concurrently by the workers while the writer task writes
+// them to the bitstream in order. Return without waiting for the block to be
+// processed (unless an error occured previously).
+func (this *CompressedOutputStream) processBlock() error {
+	if err := this.getError(); err != nil {
+		return err
+	}
+
+	t := this.current
+
+	if t == nil || t.length == 0 {
+		return nil
+	}
+
+	if this.initialized == false {
+		if err := this.WriteHeader(); err != nil {
+			return err
+		}
+	}
+
+	if this.started == false {
+		// The header is written: start the workers and the writer
+		this.started = true
+
+		for i := 0; i < this.jobs; i++ {
+			go this.encodeBlocks(this.encoders[i], this.work)
+		}
+
+		go this.writeBlocks(this.ordered)
+	}
+
+	this.blockId++
+	t.id = this.blockId
+	t.err = nil
+	// Protect against future concurrent modification of the list of block listeners
+	t.listeners = this.snapshotListeners()
+	this.current = nil
+	this.pending.Add(1)
+
+	// The channels are large enough to hold all the tasks: never blocks
+	this.ordered <- t
+	this.work <- t
+	return nil
+}
+
+// Worker: transform and entropy code the blocks in any order
+func (this *CompressedOutputStream) encodeBlocks(w *blockEncoder, work <-chan *encodingTask) {
+	for t := range work {
+		this.encodeBlock(t, w)
+		t.done <- true
+	}
+}
+
+// Writer: write the encoded blocks to the bitstream in order
+func (this *CompressedOutputStream) writeBlocks(ordered <-chan *encodingTask) {
+	for t := range ordered {
+		<-t.done
+
+		if t.err != nil {
+			this.setError(t.err)
+		} else if this.getError() == nil {
+			if err := this.writeBlock(t.id, t.block); err != nil {
+				this.setError(err)
+			}
+		}
+
+		t.length = 0
+		this.free <- t
+		this.pending.Done()
+	}
+}
+
+// Write block length (in bytes) or sync marker followed by the block data
+func (this *CompressedOutputStream) writeBlock(id int, block []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = PanicIOError(r, ERR_WRITE_FILE)
+		}
+	}()
+
+	var buf [SYNC_MARKER_SIZE]byte
+	header := blockHeader(buf[:0], this.syncMarkers, id, len(block))
+	writeBytes(this.obs, header)
+	writeBytes(this.obs, block)
+
+	if this.signer != nil {
+		this.signer.update(header)
+		this.signer.update(block)
+	}
+
+	return nil
+}
+
+// Sign the stream (up to the end marker) and write the signature
+func (this *CompressedOutputStream) writeSignature() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = PanicIOError(r, ERR_WRITE_FILE)
+		}
+	}()
+
+	signature, err := this.signer.sign()
+
+	if err != nil {
+		return WrapIOError("Cannot sign the stream: "+err.Error(), ERR_CREATE_CODEC, err)
+	}
+
+	writeBytes(this.obs, signature)
+	return nil
+}
+
+// Return the number of bytes written so far (with parity shards, the frames
+// are counted once written)
+func (this *CompressedOutputStream) GetWritten() uint64 {
+	if this.fec != nil {
+		return this.fec.written
+	}
+
+	return (this.obs.Written() + 7) >> 3
+}
+
+// Return a transform for a block of the given size. The previous instance is
+// reused if it is sizeable.
+func reuseByteFunction(transform kanzi.ByteFunction, size uint, functionType byte, args []uint) (kanzi.ByteFunction, error) {
+	if transform != nil {
+		if s, isSizeable := transform.(kanzi.Sizeable); isSizeable == true && s.SetSize(size) == true {
+			return transform, nil
+		}
+	}
+
+	return function.NewByteFunctionWithArgs(size, functionType, args)
+}
+
+// Transform and entropy code one block into its own buffer (t.block).
+// Blocks are independent so this step runs concurrently.
+func (this *CompressedOutputStream) encodeBlock(t *encodingTask, w *blockEncoder) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.err = PanicIOError(r, ERR_PROCESS_BLOCK)
+
+			// The state of the transform and of the bitstream is unknown, do
+			// not reuse them
+			w.transform = nil
+			w.obs = nil
+			w.ee = nil
+		}
+	}()
+
+	data := t.data[0:t.length]
+	blockLength := uint(t.length)
+	currentBlockId := t.id
+	listeners_ := t.listeners
+	typeOfTransform := this.transformType
+	transform, err := reuseByteFunction(w.transform, blockLength, typeOfTransform, this.transformArgs)
+
+	if err != nil {
+		t.err = WrapIOError(err.Error(), ERR_CREATE_CODEC, err)
+		return
+	}
+
+	w.transform = transform
+	buffer := w.buffer
+	requiredSize := transform.MaxEncodedLen(int(blockLength))
+
+	if requiredSize == -1 {
+		// Max size unknown => guess
+		requiredSize = int(blockLength) * 5 >> 2
+	}
+
+	if typeOfTransform == function.NULL_TRANSFORM_TYPE {
+		buffer = data // share buffers if no transform
+	} else if len(buffer) < requiredSize {
+		buffer = make([]byte, requiredSize)
+		w.buffer = buffer
+	}
+
+	mode := byte(0)
+	dataSize := uint(0)
+	postTransformLength := blockLength
+	var checksum []byte
+	iIdx := uint(0)
+	oIdx := uint(0)
+
+	// Compute block checksum
+	if this.hasher != nil {
+		t.checksum = this.hasher.hash(t.checksum[:0], data[0:blockLength])
+		checksum = t.checksum
+	}
+
+	if len(listeners_) > 0 {
+		// Notify before transform
+		evt, err := NewBlockEvent(EVT_BEFORE_TRANSFORM, currentBlockId,
+			int(blockLength), checksum, this.hasher != nil)
+
+		if err == nil {
+			for _, bl := range listeners_ {
+				bl.ProcessEvent(evt)
+			}
+		}
+	}
+
+	if blockLength <= SMALL_BLOCK_SIZE {
+		// Just copy
+		if !kanzi.SameByteSlices(buffer, data, false) {
+			copy(buffer, data[0:blockLength])
+		}
+
+		iIdx += blockLength
+		oIdx += blockLength
+		mode = byte(SMALL_BLOCK_MASK | (blockLength & COPY_LENGTH_MASK))
+	} else {
+
+		// Forward transform
+		iIdx, oIdx, err = transform.Forward(data, buffer)
+
+		if err != nil {
+			// Transform failed (probably due to lack of space in output buffer)
+			if !kanzi.SameByteSlices(buffer, data, false) {
+				copy(buffer, data)
+			}
+
+			iIdx = blockLength
+			oIdx = blockLength
+			mode |= SKIP_FUNCTION_MASK
+		}
+
+		postTransformLength = oIdx
+
+		for i := uint64(0xFF); i < uint64(postTransformLength); i <<= 8 {
+			dataSize++
+		}
+
+		if dataSize > 3 {
+			t.err = WrapIOError("Invalid block data length", ERR_WRITE_FILE, kanzi.ErrInvalidParam)
+			return
+		}
+
+		// Record size of 'block size' - 1 in bytes
+		mode |= byte(dataSize & 0x03)
+		dataSize++
+	}
+
+	if len(listeners_) > 0 {
+		// Notify after transform
+		evt, err := NewBlockEvent(EVT_AFTER_TRANSFORM, currentBlockId,
+			int(postTransformLength), checksum, this.hasher != nil)
+
+		if err == nil {
+			for _, bl := range listeners_ {
+				bl.ProcessEvent(evt)
+			}
+		}
+	}
+
+	// Each block is entropy coded in its own bitstream (flushed to t.block)
+	if w.obs == nil {
+		w.bos = &byteOutputStream{}
+		w.obs, err = bitstream.NewDefaultOutputBitStream(w.bos, BLOCK_BITSTREAM_BUFFER_SIZE)
+
+		if err != nil {
+			w.obs = nil
+			t.err = WrapIOError(err.Error(), ERR_CREATE_BITSTREAM, err)
+			return
+		}
+	}
+
+	obs := w.obs
+	w.bos.buf = t.block[:0]
+
+	// Reset (or rebuild) the entropy encoder to reset block statistics
+	if r, isResettable := w.ee.(kanzi.Resettable); isResettable == true {
+		r.Reset()
+	} else if w.ee, err = entropy.NewEntropyEncoder(obs, this.entropyType); err != nil {
+		t.err = WrapIOError(err.Error(), ERR_CREATE_CODEC, err)
+		return
+	}
+
+	ee := w.ee
+
+	// Write block 'header' (mode + compressed length)
+	obs.WriteBits(uint64(mode), 8)
+
+	if dataSize > 0 {
+		obs.WriteBits(uint64(postTransformLength), 8*dataSize)
+	}
+
+	// Write checksum
+	if this.hasher != nil {
+		writeBytes(obs, checksum)
+	}
+
+	if len(listeners_) > 0 {
+		// Notify before entropy
+		evt, err := NewBlockEvent(EVT_BEFORE_ENTROPY, currentBlockId,
+			int(postTransformLength), checksum, this.hasher != nil)
+
+		if err == nil {
+			for _, bl := range listeners_ {
+				bl.ProcessEvent(evt)
+			}
+		}
+	}
+
+	// Entropy encode block
+	_, err = ee.Encode(buffer[0:postTransformLength])
+
+	if err != nil {
+		t.err = WrapIOError(err.Error(), ERR_PROCESS_BLOCK, err)
+		w.obs = nil
+		w.ee = nil
+		return
+	}
+
+	// Dispose before displaying statistics. Dispose may write to the bitstream
+	ee.Dispose()
+
+	// Pad the last byte and flush the bitstream to the block buffer
+	if err = obs.Flush(); err != nil {
+		t.err = WrapIOError(err.Error(), ERR_PROCESS_BLOCK, err)
+		w.obs = nil
+		w.ee = nil
+		return
+	}
+
+	t.block = w.bos.buf
+	w.bos.buf = nil
+
+	if this.cipher != nil {
+		// Seal the block (the buffers of the task and the worker are swapped)
+		sealed := this.cipher.seal(w.sealed[:0], NONCE_BLOCK, currentBlockId, t.block, nil)
+		w.sealed = t.block
+		t.block = sealed
+	}
+
+	if len(listeners_) > 0 {
+		// Notify after entropy (block size includes the length prefix)
 		evt, err := NewBlockEvent(EVT_AFTER_ENTROPY, currentBlockId,
-			int(this.obs.Written()-written)/8, checksum, this.hasher != nil)
+			len(t.block)+4, checksum, this.hasher != nil)
 
 		if err == nil {
 			for _, bl := range listeners_ {
@@ -559,49 +1210,129 @@ func (this *CompressedOutputStream) encode(data, buf []byte, blockLength uint,
 			}
 		}
 	}
+}
+
+// Write a byte aligned array to the bitstream
+func writeBytes(obs kanzi.OutputBitStream, block []byte) {
+	n := len(block) & -8
+
+	for i := 0; i < n; i += 8 {
+		obs.WriteBits(binary.BigEndian.Uint64(block[i:]), 64)
+	}
 
-	// Notify of completion of the task
-	output <- error(nil)
+	for i := n; i < len(block); i++ {
+		obs.WriteBits(uint64(block[i]), 8)
+	}
 }
 
-type Message struct {
-	err      *IOError
-	decoded  int
-	blockId  int
-	text     string
-	checksum uint32
+// Read a byte aligned array from the bitstream
+func readBytes(ibs kanzi.InputBitStream, block []byte) {
+	n := len(block) & -8
+
+	for i := 0; i < n; i += 8 {
+		binary.BigEndian.PutUint64(block[i:], ibs.ReadBits(64))
+	}
+
+	for i := n; i < len(block); i++ {
+		block[i] = byte(ibs.ReadBits(8))
+	}
 }
 
-type semaphore chan bool
+// A block travelling through the decoding pipeline
+// The codecs and bitstream owned by a worker (or by the reader for version 0
+// streams) are reused from block to block to avoid memory allocations
+type blockDecoder struct {
+	transform kanzi.ByteFunction
+	ed        kanzi.EntropyDecoder
+	ibs       kanzi.InputBitStream
+	bis       *byteInputStream
+	plain     []byte // decrypted block
+	checksum  []byte // checksum of the decoded block
+}
+
+type decodingTask struct {
+	id                 int
+	block              []byte // entropy coded block (since version 1)
+	buffer             []byte // entropy decoded block
+	data               []byte // decoded block
+	mode               byte
+	preTransformLength uint
+	checksum           []byte
+	limit              uint // maximum decoded length (see outputBudget)
+	decoded            int
+	read               uint64 // position in the bitstream after the block (in bits)
+	eos                bool
+	err                *IOError
+	unreadable         bool // the reader failed: the rest of the stream is lost
+	lost               int  // number of blocks skipped by the reader before this one
+	listeners          []BlockListener
+	done               chan bool
+}
+
+// Limits protect the decoder against decompression bombs (EG. when the input
+// is not trusted). A zero value disables the corresponding check.
+type DecodingLimits struct {
+	MaxOutputSize uint64 // maximum number of decoded bytes
+	MaxRatio      uint   // maximum ratio of decoded bytes to compressed bytes
+	MaxBlockSize  uint   // maximum block size declared in the stream header
+}
 
 type CompressedInputStream struct {
 	blockSize     uint
-	hasher        *util.XXHash
+	hasher        *blockHasher
 	data          []byte
-	buffers       [][]byte
 	entropyType   byte
 	transformType byte
-	ibs           kanzi.InputBitStream
+	is            kanzi.InputStream
+	fec           *fecInputStream // decode the frames of protected streams
+	ibs           *bitstream.DefaultInputBitStream
 	debugWriter   io.Writer
 	initialized   bool
 	closed        bool
+	eos           bool
+	err           error
+	version       uint
 	blockId       int
 	maxIdx        int
 	curIdx        int
 	jobs          int
-	syncChan      []semaphore
-	resChan       chan Message
+	current       *decodingTask // block being consumed by Read
+	nbTasks       int           // number of tasks allocated (at most 2*jobs)
+	free          chan *decodingTask
+	work          chan *decodingTask
+	ordered       chan *decodingTask
+	quit          chan bool
+	readerDone    chan bool
+	started       bool
+	source        *cancelableInputStream // reads of the input stream, abandoned on stop
+	decoders      []*blockDecoder        // one per worker, kept across Reset
+	cipher        *streamCipher          // nil if no password has been provided
+	encrypted     bool
+	verifier      *streamSigner // nil if no public key has been provided
+	signed        bool
+	limits        DecodingLimits
+	outputSize    uint64 // number of bytes decoded so far
+	syncMarkers   bool
+	metadata      *Metadata
+	salvage       int
+	lost          []LostRange
+	skipped       uint64        // number of lost bytes not replaced with zeros
+	zeros         []byte        // zero block (SALVAGE_ZERO_FILL)
+	zeroBlocks    int           // number of zero blocks to return before the next block
+	zeroRead      uint64        // position in the bitstream after the lost blocks (in bits)
+	next          *decodingTask // block to return after the zero blocks
 	listeners     *list.List
+	listenersLock sync.Mutex
 }
 
-func NewCompressedInputStream(is *BufferedInputStream,
+func NewCompressedInputStream(is kanzi.InputStream,
 	debugWriter io.Writer, jobs uint) (*CompressedInputStream, error) {
 	if is == nil {
-		return nil, errors.New("Invalid null input stream parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null input stream parameter")
 	}
 
 	if jobs < 1 || jobs > 16 {
-		return nil, errors.New("The number of jobs must be in [1..16]")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The number of jobs must be in [1..16]")
 	}
 
 	this := new(CompressedInputStream)
@@ -609,28 +1340,28 @@ func NewCompressedInputStream(is *BufferedInputStream,
 	this.jobs = int(jobs)
 	this.blockId = 0
 	this.data = EMPTY_BYTE_SLICE
-	this.buffers = make([][]byte, jobs)
-
-	for i := range this.buffers {
-		this.buffers[i] = EMPTY_BYTE_SLICE
-	}
 
-	// Channel of semaphores
-	this.syncChan = make([]semaphore, this.jobs)
+	// At most 2*jobs blocks are in flight (see CompressedOutputStream)
+	maxTasks := 2 * this.jobs
+	this.free = make(chan *decodingTask, maxTasks)
+	this.work = make(chan *decodingTask, maxTasks)
+	this.ordered = make(chan *decodingTask, maxTasks)
+	this.quit = make(chan bool)
+	this.readerDone = make(chan bool)
+	this.decoders = make([]*blockDecoder, this.jobs)
 
-	for i := range this.syncChan {
-		if i > 0 {
-			// First channel is nil
-			this.syncChan[i] = make(semaphore)
-		}
+	for i := range this.decoders {
+		this.decoders[i] = &blockDecoder{}
 	}
 
-	this.resChan = make(chan Message)
+	this.is = is
+	this.source = newCancelableInputStream(is)
+	this.fec = newFECInputStream(this.source)
 	var err error
 
-	if this.ibs, err = bitstream.NewDefaultInputBitStream(is, STREAM_DEFAULT_BUFFER_SIZE); err != nil {
+	if this.ibs, err = bitstream.NewDefaultInputBitStream(this.fec, STREAM_DEFAULT_BUFFER_SIZE); err != nil {
 		errMsg := fmt.Sprintf("Cannot create input bit stream: %v", err)
-		return nil, NewIOError(errMsg, ERR_CREATE_BITSTREAM)
+		return nil, WrapIOError(errMsg, ERR_CREATE_BITSTREAM, err)
 	}
 
 	this.listeners = list.New()
@@ -642,7 +1373,9 @@ func (this *CompressedInputStream) AddListener(bl BlockListener) bool {
 		return false
 	}
 
+	this.listenersLock.Lock()
 	this.listeners.PushFront(bl)
+	this.listenersLock.Unlock()
 	return true
 }
 
@@ -651,6 +1384,9 @@ func (this *CompressedInputStream) RemoveListener(bl BlockListener) bool {
 		return false
 	}
 
+	this.listenersLock.Lock()
+	defer this.listenersLock.Unlock()
+
 	for e := this.listeners.Front(); e != nil; e = e.Next() {
 		if e.Value == bl {
 			this.listeners.Remove(e)
@@ -661,6 +1397,18 @@ func (this *CompressedInputStream) RemoveListener(bl BlockListener) bool {
 	return false
 }
 
+func (this *CompressedInputStream) snapshotListeners() []BlockListener {
+	this.listenersLock.Lock()
+	defer this.listenersLock.Unlock()
+	listeners_ := make([]BlockListener, 0, this.listeners.Len())
+
+	for e := this.listeners.Front(); e != nil; e = e.Next() {
+		listeners_ = append(listeners_, e.Value.(BlockListener))
+	}
+
+	return listeners_
+}
+
 func (this *CompressedInputStream) ReadHeader() error {
 	if this.initialized == true {
 		return nil
@@ -668,7 +1416,8 @@ func (this *CompressedInputStream) ReadHeader() error {
 
 	defer func() {
 		if r := recover(); r != nil {
-			panic(NewIOError("Cannot read bitstream header: "+r.(error).Error(), ERR_READ_FILE))
+			err := r.(error)
+			panic(WrapIOError("Cannot read bitstream header: "+err.Error(), ERR_READ_FILE, err))
 		}
 	}()
 
@@ -683,96 +1432,515 @@ func (this *CompressedInputStream) ReadHeader() error {
 
 	version := this.ibs.ReadBits(7)
 
-	// Sanity check
-	if version != BITSTREAM_FORMAT_VERSION {
+	// Sanity check (previous versions of the format can be decoded)
+	if version > BITSTREAM_FORMAT_VERSION {
 		errMsg := fmt.Sprintf("Invalid bitstream, cannot read this version of the stream: %d", version)
 		return NewIOError(errMsg, ERR_STREAM_VERSION)
 	}
 
-	// Read block checksum
-	if this.ibs.ReadBit() == 1 {
-		var err error
-		this.hasher, err = util.NewXXHash(BITSTREAM_TYPE)
+	this.version = uint(version)
 
-		if err != nil {
-			return err
-		}
-	}
+	// Read block checksum
+	checksum := this.ibs.ReadBit() == 1
 
 	// Read entropy codec
-	this.entropyType = byte(this.ibs.ReadBits(5))
+	entropyType := byte(this.ibs.ReadBits(5))
 
 	// Read transform
-	this.transformType = byte(this.ibs.ReadBits(5))
+	transformType := byte(this.ibs.ReadBits(5))
+
+	if entropyType != this.entropyType || transformType != this.transformType {
+		// The codecs of the workers cannot be reused (after a Reset)
+		for _, d := range this.decoders {
+			d.ed = nil
+			d.transform = nil
+		}
+
+		this.entropyType = entropyType
+		this.transformType = transformType
+	}
 
 	// Read block size
 	this.blockSize = uint(this.ibs.ReadBits(26)) << 3
 
 	if this.blockSize < MIN_BITSTREAM_BLOCK_SIZE || this.blockSize > MAX_BITSTREAM_BLOCK_SIZE {
 		errMsg := fmt.Sprintf("Invalid bitstream, incorrect block size: %d", this.blockSize)
-		return NewIOError(errMsg, ERR_BLOCK_SIZE)
+		return WrapIOError(errMsg, ERR_BLOCK_SIZE, kanzi.ErrCorruptData)
+	}
+
+	if this.limits.MaxBlockSize > 0 && this.blockSize > this.limits.MaxBlockSize {
+		errMsg := fmt.Sprintf("Block size limit exceeded: the stream declares blocks of %d bytes (limit: %d)",
+			this.blockSize, this.limits.MaxBlockSize)
+		return NewIOError(errMsg, ERR_BLOCK_SIZE_LIMIT)
 	}
 
 	// Read reserved bits
-	this.ibs.ReadBits(4)
+	flags := byte(this.ibs.ReadBits(4))
+	this.encrypted = flags&ENCRYPTION_MASK != 0
+	this.signed = flags&SIGNATURE_MASK != 0
+	checksumType := byte(CHECKSUM_NONE)
+	var extFlags []byte
+
+	if checksum == true {
+		checksumType = CHECKSUM_XXHASH32
+	}
+
+	if flags&EXTENDED_FLAGS_MASK != 0 {
+		if this.version == 0 {
+			return NewIOError("Invalid bitstream: extended flags are not supported in version 0", ERR_INVALID_FILE)
+		}
+
+		extFlags = make([]byte, 2)
+		readBytes(this.ibs, extFlags)
+		ext := binary.BigEndian.Uint16(extFlags)
+
+		if ext&^(CHECKSUM_TYPE_MASK|FEC_MASK|SYNC_MARKERS_MASK) != 0 {
+			errMsg := fmt.Sprintf("Invalid bitstream, unsupported extended flags: %#x", ext)
+			return NewIOError(errMsg, ERR_STREAM_VERSION)
+		}
+
+		checksumType = byte(ext & CHECKSUM_TYPE_MASK)
+		this.syncMarkers = ext&SYNC_MARKERS_MASK != 0
+
+		// The 32 bit XXHash is only stored in the extended flags if they are
+		// required by another feature
+		if (checksumType == CHECKSUM_XXHASH32 && ext&^CHECKSUM_TYPE_MASK == 0) || (checksumType == CHECKSUM_NONE) == checksum {
+			errMsg := fmt.Sprintf("Invalid bitstream, incorrect checksum type: %d", checksumType)
+			return NewIOError(errMsg, ERR_INVALID_FILE)
+		}
+
+		if ext&FEC_MASK != 0 {
+			// The frames are decoded by this.fec
+			fecHeader := make([]byte, FEC_HEADER_SIZE)
+			readBytes(this.ibs, fecHeader)
+
+			if _, _, err := parseFECHeader(fecHeader); err != nil {
+				return WrapIOError("Invalid bitstream: "+err.Error(), ERR_INVALID_FILE, err)
+			}
+
+			extFlags = append(extFlags, fecHeader...)
+		}
+	}
+
+	var err error
+
+	if this.hasher, err = newBlockHasher(checksumType); err != nil {
+		return WrapIOError("Invalid bitstream: "+err.Error(), ERR_STREAM_VERSION, err)
+	}
+
+	if this.signed == true && this.version == 0 {
+		return NewIOError("Invalid bitstream: signatures are not supported in version 0", ERR_INVALID_FILE)
+	}
+
+	this.metadata = nil
+
+	if flags&METADATA_MASK != 0 {
+		if this.version == 0 {
+			return NewIOError("Invalid bitstream: metadata is not supported in version 0", ERR_INVALID_FILE)
+		}
+
+		section := make([]byte, 2)
+		readBytes(this.ibs, section)
+		section = append(section, make([]byte, binary.BigEndian.Uint16(section))...)
+		readBytes(this.ibs, section[2:])
+
+		if this.metadata, err = decodeMetadata(section[2:]); err != nil {
+			return WrapIOError("Invalid bitstream: "+err.Error(), ERR_INVALID_FILE, err)
+		}
+
+		// The metadata is signed and authenticated with the extended flags
+		extFlags = append(extFlags, section...)
+	}
+
+	if this.verifier != nil {
+		if this.signed == false {
+			// Do not silently accept a stream that cannot be verified
+			return NewIOError("Invalid signature: the stream is not signed", ERR_SIGNATURE)
+		}
+
+		this.verifier.reset()
+		this.verifier.update(headerBytes(this.version, this.hasher != nil, this.entropyType,
+			this.transformType, this.blockSize, flags))
+		this.verifier.update(extFlags)
+	}
+
+	if this.encrypted == true {
+		if err := this.readEncryptionHeader(flags, extFlags); err != nil {
+			return err
+		}
+	} else if this.cipher != nil {
+		// Do not silently accept a stream that cannot be authenticated
+		return NewIOError("Authentication failed: the stream is not encrypted", ERR_AUTHENTICATION)
+	}
 
 	if this.debugWriter != nil {
-		fmt.Fprintf(this.debugWriter, "Checksum set to %v\n", (this.hasher != nil))
+		fmt.Fprintf(this.debugWriter, "Checksum set to %v\n", GetChecksumName(checksumType))
 		fmt.Fprintf(this.debugWriter, "Block size set to %d bytes\n", this.blockSize)
 		w1 := function.GetByteFunctionName(this.transformType)
 
-		if w1 == "NONE" {
-			w1 = "no"
-		}
+		if w1 == "NONE" {
+			w1 = "no"
+		}
+
+		fmt.Fprintf(this.debugWriter, "Using %v transform (stage 1)\n", w1)
+		w2 := entropy.GetEntropyCodecName(this.entropyType)
+
+		if w2 == "NONE" {
+			w2 = "no"
+		}
+
+		fmt.Fprintf(this.debugWriter, "Using %v entropy codec (stage 2)\n", w2)
+
+		if this.encrypted == true {
+			fmt.Fprintf(this.debugWriter, "Using AES-256-GCM encryption (key derived with scrypt, N=2^%d, r=%d, p=%d)\n",
+				this.cipher.logN, this.cipher.r, this.cipher.p)
+		}
+
+		if this.signed == true {
+			fmt.Fprintf(this.debugWriter, "Signed stream (Ed25519)\n")
+		}
+
+		if flags&EXTENDED_FLAGS_MASK != 0 && binary.BigEndian.Uint16(extFlags)&FEC_MASK != 0 {
+			fmt.Fprintf(this.debugWriter, "Using Reed-Solomon parity (%d+%d shards of %d bytes per frame)\n",
+				extFlags[2], extFlags[3], binary.BigEndian.Uint16(extFlags[4:]))
+		}
+
+		if this.syncMarkers == true {
+			fmt.Fprintf(this.debugWriter, "Using sync markers\n")
+		}
+
+		if m := this.metadata; m != nil {
+			if len(m.Name) > 0 {
+				fmt.Fprintf(this.debugWriter, "Original name: %q\n", m.Name)
+			}
+
+			if m.ModTime.IsZero() == false {
+				fmt.Fprintf(this.debugWriter, "Original modification time: %v\n", m.ModTime)
+			}
+
+			if len(m.ContentType) > 0 {
+				fmt.Fprintf(this.debugWriter, "Content type: %q\n", m.ContentType)
+			}
+
+			if len(m.Tags) > 0 {
+				fmt.Fprintf(this.debugWriter, "%d user tag(s)\n", len(m.Tags))
+			}
+		}
+	}
+
+	this.initialized = true
+	return nil
+}
+
+// Read the encryption parameters, derive the key of the stream and check the
+// tag of the header (a wrong password is detected here)
+func (this *CompressedInputStream) readEncryptionHeader(flags byte, extFlags []byte) error {
+	if this.version == 0 {
+		return NewIOError("Invalid bitstream: encryption is not supported in version 0", ERR_INVALID_FILE)
+	}
+
+	c := this.cipher
+
+	if c == nil {
+		return NewIOError("The stream is encrypted: a password is required", ERR_MISSING_PASSWORD)
+	}
+
+	c.kdf = uint(this.ibs.ReadBits(8))
+	c.logN = uint(this.ibs.ReadBits(8))
+	c.r = uint(this.ibs.ReadBits(8))
+	c.p = uint(this.ibs.ReadBits(8))
+	readBytes(this.ibs, c.salt[:])
+	readBytes(this.ibs, c.iv[:])
+	var tag [ENCRYPTION_TAG_SIZE]byte
+	readBytes(this.ibs, tag[:])
+
+	if err := c.checkParameters(); err != nil {
+		return WrapIOError(err.Error(), ERR_INVALID_FILE, err)
+	}
+
+	if err := c.deriveKey(); err != nil {
+		return WrapIOError("Cannot derive the key from the password: "+err.Error(), ERR_CREATE_CODEC, err)
+	}
+
+	if err := c.initStream(); err != nil {
+		return WrapIOError("Cannot create the stream key: "+err.Error(), ERR_CREATE_CODEC, err)
+	}
+
+	data := c.headerData(this.version, this.hasher != nil, this.entropyType, this.transformType,
+		this.blockSize, flags, extFlags)
+
+	if _, err := c.open(nil, NONCE_HEADER, 0, tag[:], data); err != nil {
+		return NewIOError("Authentication failed: invalid password or corrupted header", ERR_AUTHENTICATION)
+	}
+
+	if this.verifier != nil {
+		this.verifier.update(c.headerBytes(tag[:]))
+	}
+
+	return nil
+}
+
+// Provide the password of encrypted streams (see CompressedOutputStream).
+// Must be called before the header is read. Once a password is provided,
+// the streams that are not encrypted are rejected. The password is kept
+// across Reset.
+func (this *CompressedInputStream) SetPassword(password []byte) error {
+	if this.initialized == true {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The password must be set before reading the header")
+	}
+
+	c, err := newStreamCipher(password)
+
+	if err != nil {
+		return err
+	}
+
+	this.cipher = c
+	return nil
+}
+
+// Check the signature of the streams with the public key (see Signature.go).
+// Must be called before the header is read. The streams that are not signed
+// are rejected. The signature is checked at the end of the stream: an error
+// is returned instead of the end of stream, after all the data has been
+// decoded (see VerifyEmbedded to check a stream before decoding it). The key
+// is kept across Reset.
+func (this *CompressedInputStream) SetVerifyKey(key ed25519.PublicKey) error {
+	if this.initialized == true {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The public key must be set before reading the header")
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid public key size: %d", len(key))
+	}
+
+	this.verifier = newStreamSigner(nil, key)
+	return nil
+}
+
+// Return the number of damaged shards rebuilt so far (see SetRedundancy)
+func (this *CompressedInputStream) GetRepaired() uint64 {
+	return atomic.LoadUint64(&this.fec.repaired)
+}
+
+// Skip (SALVAGE_SKIP) or replace with zeros (SALVAGE_ZERO_FILL) the blocks
+// that cannot be decoded instead of stopping with an error (see Salvage.go).
+// The lost ranges are returned by GetLostRanges. Errors that do not concern
+// a block (EG. invalid header, invalid signature, limit exceeded) still stop
+// the decoding. Must be called before the header is read. The mode is kept
+// across Reset.
+func (this *CompressedInputStream) SetSalvageMode(mode int) error {
+	if this.initialized == true {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "The salvage mode must be set before reading the header")
+	}
+
+	if mode != SALVAGE_OFF && mode != SALVAGE_SKIP && mode != SALVAGE_ZERO_FILL {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid salvage mode: %d", mode)
+	}
+
+	this.salvage = mode
+	return nil
+}
+
+// Return the metadata stored in the header (see Metadata.go), nil if there is
+// none. Valid after the header has been read.
+func (this *CompressedInputStream) GetMetadata() *Metadata {
+	return this.metadata
+}
+
+// Return the ranges of the original data lost so far (see SetSalvageMode)
+func (this *CompressedInputStream) GetLostRanges() []LostRange {
+	return this.lost
+}
+
+// Return the type of entropy codec (valid after the header has been read)
+func (this *CompressedInputStream) GetEntropyType() byte {
+	return this.entropyType
+}
+
+// Return the type of transform (valid after the header has been read)
+func (this *CompressedInputStream) GetTransformType() byte {
+	return this.transformType
+}
+
+// Set the limits checked while decoding (see DecodingLimits). Decoding stops
+// with an error (ERR_OUTPUT_LIMIT, ERR_RATIO_LIMIT or ERR_BLOCK_SIZE_LIMIT)
+// when a limit is exceeded. The limits are kept across Reset.
+func (this *CompressedInputStream) SetLimits(limits DecodingLimits) {
+	this.limits = limits
+}
+
+func (this *CompressedInputStream) GetLimits() DecodingLimits {
+	return this.limits
+}
+
+// Return the maximum length of the next block: the block size or what remains
+// of the output limit. Called by the reader which runs ahead of Read, so the
+// blocks in flight are not deducted (the exact check is done by checkLimits).
+func (this *CompressedInputStream) outputBudget() uint {
+	if this.limits.MaxOutputSize == 0 {
+		return this.blockSize
+	}
+
+	outputSize := atomic.LoadUint64(&this.outputSize)
+
+	if outputSize >= this.limits.MaxOutputSize {
+		return 0
+	}
+
+	return uint(min(uint64(this.blockSize), this.limits.MaxOutputSize-outputSize))
+}
+
+// Error returned when a block is rejected before decoding because it can
+// decode to more bytes than the output limit allows
+func (this *CompressedInputStream) budgetError(id int, limit uint) *IOError {
+	errMsg := fmt.Sprintf("Output size limit exceeded: block %d does not fit in the remaining %d bytes (limit: %d)",
+		id, limit, this.limits.MaxOutputSize)
+	return NewIOError(errMsg, ERR_OUTPUT_LIMIT)
+}
+
+// Check the limits before returning a decoded block. The blocks which cannot
+// fit in the output limit are rejected before decoding (see outputBudget).
+func (this *CompressedInputStream) checkLimits(decoded int, read uint64) *IOError {
+	outputSize := this.outputSize + uint64(decoded)
+
+	if this.limits.MaxOutputSize > 0 && outputSize > this.limits.MaxOutputSize {
+		errMsg := fmt.Sprintf("Output size limit exceeded: more than %d bytes decoded", this.limits.MaxOutputSize)
+		return NewIOError(errMsg, ERR_OUTPUT_LIMIT)
+	}
+
+	compressed := (read + 7) >> 3
+
+	if this.limits.MaxRatio > 0 && outputSize > uint64(this.limits.MaxRatio)*compressed {
+		errMsg := fmt.Sprintf("Expansion ratio limit exceeded: %d bytes decoded from %d bytes (limit: %d)",
+			outputSize, compressed, this.limits.MaxRatio)
+		return NewIOError(errMsg, ERR_RATIO_LIMIT)
+	}
+
+	// Read concurrently by the reader (see outputBudget)
+	atomic.StoreUint64(&this.outputSize, outputSize)
+	return nil
+}
+
+// Return the number of decoded bytes that can be read without decoding
+// another block
+func (this *CompressedInputStream) Available() int {
+	return this.maxIdx - this.curIdx
+}
+
+// Implement kanzi.InputStream interface
+func (this *CompressedInputStream) Close() error {
+	if this.closed == true {
+		return nil
+	}
+
+	this.closed = true
+	this.stopPipeline()
+
+	// Closing the bitstream closes the input stream
+	_, err := this.ibs.Close()
+
+	// Release resources
+	this.maxIdx = 0
+	this.data = EMPTY_BYTE_SLICE
+	this.listeners.Init()
+
+	if err != nil {
+		return WrapIOError(err.Error(), ERR_READ_FILE, err)
+	}
+
+	return nil
+}
+
+// Reset discards the state of the stream and makes it equivalent to a new
+// stream reading from is. The previous input stream is not closed (a read in
+// flight is abandoned). The blocks, buffers and codecs are
+// reused, so one stream can decompress many small payloads efficiently.
+func (this *CompressedInputStream) Reset(is kanzi.InputStream) error {
+	if is == nil {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null input stream parameter")
+	}
 
-		fmt.Fprintf(this.debugWriter, "Using %v transform (stage 1)\n", w1)
-		w2 := entropy.GetEntropyCodecName(this.entropyType)
+	if this.closed == false {
+		this.stopPipeline()
+	}
 
-		if w2 == "NONE" {
-			w2 = "no"
-		}
+	this.source = newCancelableInputStream(is)
+	this.fec.reset(this.source)
 
-		fmt.Fprintf(this.debugWriter, "Using %v entropy codec (stage 2)\n", w2)
+	if err := this.ibs.Reset(this.fec); err != nil {
+		return err
 	}
 
+	this.is = is
+	this.hasher = nil
+	this.initialized = false
+	this.closed = false
+	this.eos = false
+	this.err = nil
+	this.version = 0
+	this.encrypted = false
+	this.signed = false
+	this.blockId = 0
+	this.maxIdx = 0
+	this.curIdx = 0
+	this.outputSize = 0
+	this.syncMarkers = false
+	this.metadata = nil
+	this.lost = nil
+	this.skipped = 0
+	this.zeroBlocks = 0
+	this.data = EMPTY_BYTE_SLICE
 	return nil
 }
 
-// Implement kanzi.InputStream interface
-func (this *CompressedInputStream) Close() error {
-	if this.closed == true {
-		return nil
+// Stop the reader and the workers, the blocks in flight are kept for reuse.
+// The input stream is not used after the call: a read in flight is canceled.
+func (this *CompressedInputStream) stopPipeline() {
+	if this.current != nil {
+		this.free <- this.current
+		this.current = nil
 	}
 
-	if _, err := this.ibs.Close(); err != nil {
-		return err
+	if this.next != nil {
+		this.free <- this.next
+		this.next = nil
 	}
 
-	this.closed = true
+	if this.started == false {
+		return
+	}
 
-	// Release resources
-	this.maxIdx = 0
-	this.data = EMPTY_BYTE_SLICE
+	// Stop the reader task
+	close(this.quit)
 
-	for i := range this.buffers {
-		this.buffers[i] = EMPTY_BYTE_SLICE
+	select {
+	case <-this.readerDone:
+	default:
+		// The reader task may be blocked on the input stream (which may not
+		// unblock a read on Close): cancel the read
+		this.source.cancel()
+		<-this.readerDone
 	}
 
-	for _, c := range this.syncChan {
-		if c != nil {
-			close(c)
-		}
+	// Wait for the workers to release the blocks in flight
+	for t := range this.ordered {
+		<-t.done
+		this.free <- t
 	}
 
-	close(this.resChan)
-	this.listeners.Init()
-	return nil
+	maxTasks := cap(this.free)
+	this.work = make(chan *decodingTask, maxTasks)
+	this.ordered = make(chan *decodingTask, maxTasks)
+	this.quit = make(chan bool)
+	this.readerDone = make(chan bool)
+	this.started = false
 }
 
 // Implement kanzi.InputStream interface
 func (this *CompressedInputStream) Read(array []byte) (int, error) {
 	if this.closed == true {
-		return 0, NewIOError("Stream closed", ERR_READ_FILE)
+		return 0, WrapIOError("Stream closed", ERR_READ_FILE, kanzi.ErrClosed)
 	}
 
 	startChunk := 0
@@ -822,143 +1990,509 @@ func (this *CompressedInputStream) Read(array []byte) (int, error) {
 	return len(array) - remaining, nil
 }
 
+// Return the next decoded block (in order). The blocks are read by the reader
+// task, decoded concurrently by the workers and consumed here.
 func (this *CompressedInputStream) processBlock() (int, error) {
 	if this.initialized == false {
 		if err := this.ReadHeader(); err != nil {
 			return 0, err
 		}
-
-		this.initialized = true
 	}
 
-	if len(this.data) < int(this.blockSize)*this.jobs {
-		this.data = make([]byte, this.jobs*int(this.blockSize))
-	}
+	for {
+		if this.current != nil {
+			// The block has been consumed, recycle it
+			this.free <- this.current
+			this.current = nil
+		}
 
-	blockNumber := this.blockId
-	offset := uint(0)
+		if this.err != nil {
+			return 0, this.err
+		}
 
-	// Protect against future concurrent modification of the list of block listeners
-	listeners_ := make([]BlockListener, this.listeners.Len())
+		if this.zeroBlocks > 0 {
+			// Salvage mode: replace a lost block with zeros
+			this.zeroBlocks--
+			return this.zeroFill()
+		}
+
+		if this.eos == true {
+			return 0, nil
+		}
+
+		if this.started == false {
+			// The header is read: start the reader and the workers
+			this.started = true
 
-	if this.listeners.Len() > 0 {
-		n := 0
+			for i := 0; i < this.jobs; i++ {
+				go this.decodeBlocks(this.decoders[i], this.work)
+			}
 
-		for e := this.listeners.Front(); e != nil; e = e.Next() {
-			listeners_[n] = e.Value.(BlockListener)
-			n++
+			go this.readBlocks(this.work, this.ordered, this.quit, this.readerDone)
 		}
-	}
 
-	// Invoke as many go routines as required
-	for jobId := 0; jobId < this.jobs; jobId++ {
-		blockNumber++
-		curChan := this.syncChan[jobId]
-		nextChan := this.syncChan[(jobId+1)%this.jobs]
-		// Invoke the tasks concurrently
-		// Tasks are daisy chained through channels. All tasks wait for a signal
-		// on the input channel to start entropy decoding and then issue a message
-		// to the next task on the output channel upon entropy decoding completion.
-		// The transform step runs concurrently. The result is returned on the shared
-		// channel. The output channel is nil for the last task and the input channel
-		// is nil for the first task.
-		go this.decode(this.data[offset:offset+this.blockSize], this.buffers[jobId],
-			this.transformType, this.entropyType, blockNumber,
-			curChan, nextChan, this.resChan, listeners_)
+		t := this.next
+		this.next = nil
 
-		offset += this.blockSize
-	}
+		if t == nil {
+			var ok bool
 
-	var err error
-	decoded := 0
-	results := make([]Message, this.jobs)
+			if t, ok = <-this.ordered; ok == false {
+				// The reader task has stopped
+				this.eos = true
+				return 0, nil
+			}
 
-	// Wait for completion of all concurrent tasks
-	for i := 0; i < this.jobs; i++ {
-		// Listen for results on the shared channel
-		msg := <-this.resChan
+			<-t.done
 
-		// Order the results based on block ID
-		results[msg.blockId-this.blockId-1] = msg
-	}
+			if t.lost > 0 {
+				// Salvage mode: the reader skipped the blocks with an invalid
+				// sync marker
+				errMsg := fmt.Sprintf("Invalid bitstream: sync marker of block %d not found", t.id-t.lost)
+				this.addLost(t.id-t.lost, t.lost, NewIOError(errMsg, ERR_INVALID_FILE), t.read)
 
-	// Process results
-	for _, res := range results {
-		if res.err != nil {
-			if err == nil {
-				// Keep first error encountered
-				err = res.err
+				if this.zeroBlocks > 0 {
+					this.next = t
+					continue
+				}
 			}
-		} else {
-			// Add the number of decoded bytes for the current block
-			decoded += res.decoded
 		}
 
-		if len(listeners_) > 0 {
+		this.current = t
+
+		if t.err != nil {
+			if this.salvage == SALVAGE_OFF || t.eos == true {
+				this.err = t.err
+				return 0, this.err
+			}
+
+			if t.unreadable == true {
+				// The rest of the stream is lost
+				this.addLost(t.id, 0, t.err, t.read)
+				this.eos = true
+			} else {
+				this.addLost(t.id, 1, t.err, t.read)
+			}
+
+			continue
+		}
+
+		if t.eos == true {
+			this.eos = true
+			return 0, nil
+		}
+
+		if err := this.checkLimits(t.decoded, t.read); err != nil {
+			this.err = err
+			return 0, err
+		}
+
+		if len(t.listeners) > 0 && t.decoded > 0 {
 			// Notify listeners after transform
-			evt, err := NewBlockEvent(EVT_AFTER_TRANSFORM, res.blockId,
-				res.decoded, res.checksum, this.hasher != nil)
+			evt, err := NewBlockEvent(EVT_AFTER_TRANSFORM, t.id,
+				t.decoded, t.checksum, this.hasher != nil)
 
 			if err == nil {
-				for _, bl := range listeners_ {
+				for _, bl := range t.listeners {
 					bl.ProcessEvent(evt)
 				}
 			}
 		}
+
+		this.data = t.data
+		this.curIdx = 0
+		return t.decoded, nil
+	}
+}
+
+// Record blocks lost in salvage mode (blocks=0 if the rest of the stream is
+// lost). In SALVAGE_ZERO_FILL mode, processBlock returns a zero block for
+// each lost block.
+func (this *CompressedInputStream) addLost(id, blocks int, err error, read uint64) {
+	r := LostRange{FirstBlock: id, Blocks: blocks, Offset: this.outputSize + this.skipped, Err: err}
+	r.Length = uint64(blocks) * uint64(this.blockSize)
+	this.lost = append(this.lost, r)
+
+	if this.salvage == SALVAGE_ZERO_FILL {
+		this.zeroBlocks = blocks
+		this.zeroRead = read
+	} else {
+		this.skipped += r.Length
+	}
+}
+
+// Return a zero block in place of a lost block
+func (this *CompressedInputStream) zeroFill() (int, error) {
+	if len(this.zeros) != int(this.blockSize) {
+		this.zeros = make([]byte, this.blockSize)
 	}
 
-	this.blockId += this.jobs
+	if err := this.checkLimits(len(this.zeros), this.zeroRead); err != nil {
+		this.err = err
+		return 0, err
+	}
+
+	this.data = this.zeros
 	this.curIdx = 0
-	return decoded, err
+	return len(this.zeros), nil
 }
 
-// Return the number of bytes read so far
-func (this *CompressedInputStream) GetRead() uint64 {
-	return (this.ibs.Read() + 7) >> 3
+// Return a block to decode into, waiting for one to be consumed if all the
+// blocks are in flight. Return nil if the stream is closed.
+func (this *CompressedInputStream) getTask(quit <-chan bool) *decodingTask {
+	var t *decodingTask
+
+	select {
+	case <-quit:
+		return nil
+	case t = <-this.free:
+	default:
+		if this.nbTasks < cap(this.free) {
+			this.nbTasks++
+			t = &decodingTask{block: EMPTY_BYTE_SLICE, buffer: EMPTY_BYTE_SLICE, data: EMPTY_BYTE_SLICE}
+			t.done = make(chan bool, 1)
+		} else {
+			select {
+			case t = <-this.free:
+			case <-quit:
+				return nil
+			}
+		}
+	}
+
+	// The block size may have changed since the block was allocated (Reset).
+	// With an output limit, the decoded block is not larger than the budget
+	// (plus one byte to detect the blocks exceeding it).
+	t.limit = this.outputBudget()
+
+	if len(t.data) < int(min(t.limit+1, this.blockSize)) {
+		t.data = make([]byte, min(t.limit+1, this.blockSize))
+	}
+
+	return t
+}
+
+// Reader: read the blocks sequentially from the bitstream and dispatch them
+// to the workers. Since version 1, each block is read in its own buffer and
+// decoded (entropy + transform) by a worker. In version 0 streams, the blocks
+// are entropy decoded back to back, so the reader performs this step and the
+// workers only apply the inverse transform.
+func (this *CompressedInputStream) readBlocks(work, ordered chan<- *decodingTask,
+	quit <-chan bool, done chan<- bool) {
+	defer close(done)
+	defer close(work)
+	defer close(ordered)
+
+	// Version 0: the blocks are entropy decoded from the main bitstream
+	d := &blockDecoder{ibs: this.ibs}
+
+	for {
+		t := this.getTask(quit)
+
+		if t == nil {
+			return
+		}
+
+		this.blockId++
+		t.id = this.blockId
+		t.err = nil
+		t.eos = false
+		t.unreadable = false
+		t.lost = 0
+		t.decoded = 0
+		// Protect against future concurrent modification of the list of block listeners
+		t.listeners = this.snapshotListeners()
+
+		if this.version == 0 {
+			this.decodeEntropy(d, t)
+		} else {
+			this.readBlock(t)
+		}
+
+		t.read = this.ibs.Read()
+
+		if t.err != nil || t.eos == true {
+			// Last task: no processing required
+			t.unreadable = t.err != nil
+			t.done <- true
+			ordered <- t
+			return
+		}
+
+		// The channels are large enough to hold all the tasks: never blocks
+		ordered <- t
+		work <- t
+	}
+}
+
+// Read the length prefix (or the sync marker) and the data of a block
+func (this *CompressedInputStream) readBlock(t *decodingTask) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isRuntime := r.(runtime.Error); isRuntime == true {
+				t.err = blockPanicError(r, t.id, ERR_READ_FILE)
+				return
+			}
+
+			cause, _ := r.(error)
+			t.err = WrapIOError(fmt.Sprintf("Cannot read block from bitstream: %v", r), ERR_READ_FILE, cause)
+		}
+	}()
+
+	maxLength := 2*uint64(this.blockSize) + 1024
+
+	if this.encrypted == true {
+		maxLength += ENCRYPTION_TAG_SIZE
+	}
+
+	length, id, err := this.readBlockHeader(t.id, maxLength)
+
+	if err != nil {
+		t.err = err
+		return
+	}
+
+	if id != t.id {
+		// Salvage mode: the blocks in between are lost
+		t.lost = id - t.id
+		t.id = id
+		this.blockId = id
+	}
+
+	if length == 0 {
+		if this.encrypted == true {
+			t.err = NewIOError("Authentication failed: missing end of stream block (truncated stream)", ERR_AUTHENTICATION)
+			return
+		}
+
+		// End of stream
+		t.eos = true
+		this.readSignature(t)
+		return
+	}
+
+	if this.encrypted == true && length == ENCRYPTION_TAG_SIZE {
+		// A sealed data block is never empty: this is the end of stream
+		// block, authenticating the number of blocks
+		var tag [ENCRYPTION_TAG_SIZE]byte
+		readBytes(this.ibs, tag[:])
+
+		if _, err := this.cipher.open(nil, NONCE_END, t.id-1, tag[:], nil); err != nil {
+			t.err = NewIOError("Authentication failed: invalid end of stream block (truncated stream)", ERR_AUTHENTICATION)
+			return
+		}
+
+		if this.verifier != nil {
+			this.verifier.update(tag[:])
+		}
+
+		if length, _, err := this.readBlockHeader(t.id+1, 0); err != nil || length != 0 {
+			t.err = NewIOError("Invalid bitstream: missing end of stream marker", ERR_INVALID_FILE)
+			return
+		}
+
+		t.eos = true
+		this.readSignature(t)
+		return
+	}
+
+	// The compressed block can be a bit larger than the block (incompressible data)
+	if length > maxLength {
+		errMsg := fmt.Sprintf("Invalid compressed block length: %d", length)
+		t.err = WrapIOError(errMsg, ERR_BLOCK_SIZE, kanzi.ErrCorruptData)
+		return
+	}
+
+	// Same bound for the budget left by the output limit: do not read a
+	// block which cannot fit
+	if t.limit < this.blockSize && length > maxLength-2*uint64(this.blockSize-t.limit) {
+		t.err = this.budgetError(t.id, t.limit)
+		return
+	}
+
+	if uint64(cap(t.block)) < length {
+		t.block = make([]byte, length)
+	}
+
+	t.block = t.block[0:length]
+	readBytes(this.ibs, t.block)
+
+	if this.verifier != nil {
+		this.verifier.update(t.block)
+	}
+}
+
+// Read the length prefix of a block or its sync marker. Return the length
+// and the id of the block. In salvage mode, the stream is scanned for the
+// next valid sync marker if the marker of the block is invalid (the id
+// returned is the id of the block found).
+func (this *CompressedInputStream) readBlockHeader(id int, maxLength uint64) (uint64, int, *IOError) {
+	if this.syncMarkers == false {
+		length := this.ibs.ReadBits(32)
+
+		if this.verifier != nil {
+			this.verifier.updateLength(int(length))
+		}
+
+		return length, id, nil
+	}
+
+	var marker [SYNC_MARKER_SIZE]byte
+	readBytes(this.ibs, marker[:])
+	maxId := id
+
+	if this.salvage != SALVAGE_OFF {
+		maxId += MAX_SYNC_SKIP
+	}
+
+	for checkSyncMarker(marker[:], id, maxId, maxLength) == false {
+		if this.salvage == SALVAGE_OFF {
+			errMsg := fmt.Sprintf("Invalid bitstream: invalid sync marker for block %d", id)
+			return 0, id, NewIOError(errMsg, ERR_INVALID_FILE)
+		}
+
+		// Slide by one byte
+		copy(marker[0:], marker[1:])
+		marker[SYNC_MARKER_SIZE-1] = byte(this.ibs.ReadBits(8))
+	}
+
+	if this.verifier != nil {
+		this.verifier.update(marker[:])
+	}
+
+	return uint64(binary.BigEndian.Uint32(marker[8:])), int(binary.BigEndian.Uint32(marker[4:])), nil
 }
 
-// Used by block decoding tasks to synchronize and return result
-func notify(chan1 chan bool, chan2 chan Message, run bool, msg Message) {
-	if chan1 != nil {
-		chan1 <- run
+// Read the signature following the end of stream marker (if the stream is
+// signed) and check it (if a public key has been provided)
+func (this *CompressedInputStream) readSignature(t *decodingTask) {
+	if this.signed == false {
+		return
+	}
+
+	var signature [SIGNATURE_SIZE]byte
+	readBytes(this.ibs, signature[:])
+
+	if this.verifier != nil && this.verifier.verify(signature[:]) == false {
+		t.err = NewIOError("Invalid signature: the stream has been modified or signed with another key", ERR_SIGNATURE)
 	}
+}
+
+// Worker: decode the blocks in any order
+func (this *CompressedInputStream) decodeBlocks(d *blockDecoder, work <-chan *decodingTask) {
+	for t := range work {
+		if this.version > 0 {
+			// Each block is entropy coded in its own bitstream
+			this.decodeBlock(d, t)
+		}
+
+		if t.err == nil && t.eos == false {
+			this.decodeTransform(d, t)
+		}
 
-	if chan2 != nil {
-		chan2 <- msg
+		t.done <- true
 	}
 }
 
-func (this *CompressedInputStream) decode(data, buf []byte,
-	typeOfTransform byte, typeOfEntropy byte, currentBlockId int,
-	input, output chan bool, result chan Message,
-	listeners_ []BlockListener) {
-	buffer := buf
-	res := Message{blockId: currentBlockId}
+// Entropy decode a block read in its own buffer (t.block)
+func (this *CompressedInputStream) decodeBlock(d *blockDecoder, t *decodingTask) {
+	if this.encrypted == true {
+		// Authenticate the block before decoding it (the buffers of the task
+		// and the worker are swapped)
+		plain, err := this.cipher.open(d.plain[:0], NONCE_BLOCK, t.id, t.block, nil)
 
-	// Wait for task processing the previous block to complete
-	if input != nil {
-		run := <-input
+		if err != nil {
+			errMsg := fmt.Sprintf("Authentication failed: block %d is corrupted", t.id)
+			t.err = NewIOError(errMsg, ERR_AUTHENTICATION)
+			return
+		}
+
+		d.plain = t.block
+		t.block = plain
+	}
+
+	if d.ibs == nil {
+		d.bis = &byteInputStream{}
+		ibs, err := bitstream.NewDefaultInputBitStream(d.bis, BLOCK_BITSTREAM_BUFFER_SIZE)
 
-		// If one of the previous tasks failed, skip
-		if run == false {
-			notify(output, result, false, res)
+		if err != nil {
+			t.err = WrapIOError(err.Error(), ERR_CREATE_BITSTREAM, err)
 			return
 		}
+
+		d.ibs = ibs
+	}
+
+	d.bis.buf = t.block
+	d.bis.index = 0
+	start := d.ibs.Read()
+	this.decodeEntropy(d, t)
+
+	if t.err == nil {
+		// Consume the padding bits so that the bitstream is empty before the
+		// next block
+		t.err = skipBits(d.ibs, start+uint64(len(t.block))*8)
+	}
+
+	if t.err != nil {
+		// The state of the bitstream is unknown, do not reuse it
+		d.ibs = nil
+		d.ed = nil
+	}
+
+	d.bis.buf = nil
+}
+
+// Read and discard bits up to the given position in the bitstream
+func skipBits(ibs kanzi.InputBitStream, position uint64) (err *IOError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = PanicIOError(r, ERR_READ_FILE)
+		}
+	}()
+
+	read := ibs.Read()
+
+	if read > position {
+		return NewIOError("Invalid block data: decoded past the end of the block", ERR_PROCESS_BLOCK)
+	}
+
+	for remaining := position - read; remaining > 0; {
+		n := remaining
+
+		if n > 64 {
+			n = 64
+		}
+
+		ibs.ReadBits(uint(n))
+		remaining -= n
 	}
 
+	return nil
+}
+
+// Return the number of bytes read so far
+func (this *CompressedInputStream) GetRead() uint64 {
+	return (this.ibs.Read() + 7) >> 3
+}
+
+// Entropy decode one block from the bitstream into t.buffer
+func (this *CompressedInputStream) decodeEntropy(d *blockDecoder, t *decodingTask) {
+	ibs := d.ibs
+	currentBlockId := t.id
+	listeners_ := t.listeners
+
 	defer func() {
 		if r := recover(); r != nil {
-			// Error => cancel concurrent decoding tasks
-			res.err = NewIOError(r.(error).Error(), ERR_READ_FILE)
-			notify(output, result, false, res)
+			t.err = blockPanicError(r, currentBlockId, ERR_READ_FILE)
 		}
 	}()
 
 	// Extract header directly from bitstream
-	read := this.ibs.Read()
-	mode := byte(this.ibs.ReadBits(8))
+	read := ibs.Read()
+	mode := byte(ibs.ReadBits(8))
 	var preTransformLength uint
-	checksum1 := uint32(0)
+	var checksum1 []byte
 
 	if (mode & SMALL_BLOCK_MASK) != 0 {
 		preTransformLength = uint(mode & COPY_LENGTH_MASK)
@@ -966,27 +2500,48 @@ func (this *CompressedInputStream) decode(data, buf []byte,
 		dataSize := uint(1 + (mode & 0x03))
 		length := dataSize << 3
 		mask := uint64(1<<length) - 1
-		preTransformLength = uint(this.ibs.ReadBits(length) & mask)
+		preTransformLength = uint(ibs.ReadBits(length) & mask)
 	}
 
 	if preTransformLength == 0 {
-		// Last block is empty, return success and cancel pending tasks
-		res.decoded = 0
-		notify(output, result, false, res)
+		// Last block is empty
+		t.eos = true
 		return
 	}
 
-	if preTransformLength > MAX_BITSTREAM_BLOCK_SIZE {
-		// Error => cancel concurrent decoding tasks
+	// The transformed block can be a bit larger than the block (see
+	// ByteFunction.MaxEncodedLen)
+	if preTransformLength > 2*this.blockSize+1024 {
 		errMsg := fmt.Sprintf("Invalid compressed block length: %d", preTransformLength)
-		res.err = NewIOError(errMsg, ERR_BLOCK_SIZE)
-		notify(output, result, false, res)
+		t.err = WrapIOError(errMsg, ERR_BLOCK_SIZE, kanzi.ErrCorruptData)
 		return
 	}
 
+	// Same bound for the budget left by the output limit (the copied blocks
+	// are not transformed: the bound is exact)
+	if t.limit < this.blockSize {
+		maxLength := 2*t.limit + 1024
+
+		if (mode&SMALL_BLOCK_MASK) != 0 || (mode&SKIP_FUNCTION_MASK) != 0 {
+			maxLength = t.limit
+		}
+
+		if preTransformLength > maxLength {
+			t.err = this.budgetError(t.id, t.limit)
+			return
+		}
+	}
+
 	// Extract checksum from bit stream (if any)
 	if this.hasher != nil {
-		checksum1 = uint32(this.ibs.ReadBits(32))
+		size := this.hasher.size()
+
+		if cap(t.checksum) < size {
+			t.checksum = make([]byte, size)
+		}
+
+		checksum1 = t.checksum[0:size]
+		readBytes(ibs, checksum1)
 	}
 
 	if len(listeners_) > 0 {
@@ -1001,47 +2556,43 @@ func (this *CompressedInputStream) decode(data, buf []byte,
 		}
 	}
 
-	res.checksum = checksum1
-
-	if this.transformType == function.NULL_TRANSFORM_TYPE {
-		buffer = data // share buffers if no transform
-	} else {
-		bufferSize := this.blockSize
+	t.mode = mode
+	t.checksum = checksum1
+	t.preTransformLength = preTransformLength
+	bufferSize := t.limit
 
-		if bufferSize < preTransformLength {
-			bufferSize = preTransformLength
-		}
+	if bufferSize < preTransformLength {
+		bufferSize = preTransformLength
+	}
 
-		if len(buffer) < int(bufferSize) {
-			buffer = make([]byte, bufferSize)
-		}
+	if len(t.buffer) < int(bufferSize) {
+		t.buffer = make([]byte, bufferSize)
 	}
 
 	// Each block is decoded separately
-	// Rebuild the entropy decoder to reset block statistics
-	ed, err := entropy.NewEntropyDecoder(this.ibs, typeOfEntropy)
-
-	if err != nil {
-		// Error => cancel concurrent decoding tasks
-		res.err = NewIOError(err.Error(), ERR_INVALID_CODEC)
-		notify(output, result, false, res)
+	// Reset (or rebuild) the entropy decoder to reset block statistics
+	if r, isResettable := d.ed.(kanzi.Resettable); isResettable == true {
+		r.Reset()
+	} else if ed, err := entropy.NewEntropyDecoder(ibs, this.entropyType); err != nil {
+		t.err = WrapIOError(err.Error(), ERR_INVALID_CODEC, err)
 		return
+	} else {
+		d.ed = ed
 	}
 
+	ed := d.ed
 	defer ed.Dispose()
 
 	// Block entropy decode
-	if _, err = ed.Decode(buffer[0:preTransformLength]); err != nil {
-		// Error => cancel concurrent decoding tasks
-		res.err = NewIOError(err.Error(), ERR_PROCESS_BLOCK)
-		notify(output, result, false, res)
+	if _, err := ed.Decode(t.buffer[0:preTransformLength]); err != nil {
+		t.err = WrapIOError(err.Error(), ERR_PROCESS_BLOCK, corrupted(err))
 		return
 	}
 
 	if len(listeners_) > 0 {
 		// Notify after entropy
 		evt, err := NewBlockEvent(EVT_AFTER_ENTROPY, currentBlockId,
-			int((this.ibs.Read()-read)/8), checksum1, this.hasher != nil)
+			int((ibs.Read()-read)/8), checksum1, this.hasher != nil)
 
 		if err == nil {
 			for _, bl := range listeners_ {
@@ -1049,15 +2600,37 @@ func (this *CompressedInputStream) decode(data, buf []byte,
 			}
 		}
 	}
+}
+
+// Apply the inverse transform to the entropy decoded block (t.buffer) and
+// verify the checksum
+func (this *CompressedInputStream) decodeTransform(d *blockDecoder, t *decodingTask) {
+	currentBlockId := t.id
+	listeners_ := t.listeners
+	preTransformLength := t.preTransformLength
+	buffer := t.buffer
+	data := t.data[0:min(t.limit+1, this.blockSize)]
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isRuntime := r.(runtime.Error); isRuntime == true {
+				t.err = blockPanicError(r, currentBlockId, ERR_PROCESS_BLOCK)
+			} else {
+				cause, _ := r.(error)
+				t.err = WrapIOError(fmt.Sprintf("%v", r), ERR_PROCESS_BLOCK, corrupted(cause))
+			}
 
-	// After completion of the entropy decoding, unfreeze the task processing
-	// the next block (if any)
-	notify(output, nil, true, res)
+			if t.limit < this.blockSize {
+				// The output buffer is limited by the output budget
+				t.err = this.budgetError(t.id, t.limit)
+			}
+		}
+	}()
 
 	if len(listeners_) > 0 {
 		// Notify before transform
 		evt, err := NewBlockEvent(EVT_BEFORE_TRANSFORM, currentBlockId,
-			int(preTransformLength), checksum1, this.hasher != nil)
+			int(preTransformLength), t.checksum, this.hasher != nil)
 
 		if err == nil {
 			for _, bl := range listeners_ {
@@ -1066,51 +2639,55 @@ func (this *CompressedInputStream) decode(data, buf []byte,
 		}
 	}
 
-	read = this.ibs.Read() - read
-
-	if ((mode & SMALL_BLOCK_MASK) != 0) || ((mode & SKIP_FUNCTION_MASK) != 0) {
-		if !bytes.Equal(buffer, data) {
-			copy(data, buffer[0:preTransformLength])
+	if ((t.mode & SMALL_BLOCK_MASK) != 0) || ((t.mode & SKIP_FUNCTION_MASK) != 0) {
+		if preTransformLength > uint(len(data)) {
+			errMsg := fmt.Sprintf("Invalid block length: %d", preTransformLength)
+			t.err = WrapIOError(errMsg, ERR_BLOCK_SIZE, kanzi.ErrCorruptData)
+			return
 		}
 
-		res.decoded = int(preTransformLength)
+		copy(data, buffer[0:preTransformLength])
+		t.decoded = int(preTransformLength)
 	} else {
-		// Each block is decoded separately
-		// Rebuild the entropy decoder to reset block statistics
-		transform, err := function.NewByteFunction(preTransformLength, typeOfTransform)
+		transform, err := reuseByteFunction(d.transform, preTransformLength, this.transformType, nil)
 
 		if err != nil {
-			// Error => return
-			res.err = NewIOError(err.Error(), ERR_INVALID_CODEC)
-			notify(nil, result, false, res)
+			t.err = WrapIOError(err.Error(), ERR_INVALID_CODEC, err)
 			return
 		}
 
+		d.transform = transform
 		var oIdx uint
 
 		// Inverse transform
 		if _, oIdx, err = transform.Inverse(buffer, data); err != nil {
-			// Error => return
-			res.err = NewIOError(err.Error(), ERR_PROCESS_BLOCK)
-			notify(nil, result, false, res)
+			if t.limit < this.blockSize {
+				// The output buffer is limited by the output budget
+				t.err = this.budgetError(t.id, t.limit)
+			} else {
+				t.err = WrapIOError(err.Error(), ERR_PROCESS_BLOCK, corrupted(err))
+			}
+
 			return
 		}
 
-		res.decoded = int(oIdx)
-
-		// Verify checksum
-		if this.hasher != nil {
-			checksum2 := this.hasher.Hash(data[0:res.decoded])
-
-			if checksum2 != checksum1 {
-				errMsg := fmt.Sprintf("Corrupted bitstream: expected checksum %x, found %x", checksum1, checksum2)
-				res.err = NewIOError(errMsg, ERR_PROCESS_BLOCK)
-				notify(nil, result, false, res)
-				return
-			}
+		if oIdx > t.limit {
+			t.err = this.budgetError(t.id, t.limit)
+			return
 		}
 
+		t.decoded = int(oIdx)
 	}
 
-	notify(nil, result, false, res)
+	// Verify checksum (also for the blocks copied without transform)
+	if this.hasher != nil {
+		d.checksum = this.hasher.hash(d.checksum[:0], data[0:t.decoded])
+		checksum2 := d.checksum
+
+		if bytes.Equal(checksum2, t.checksum) == false {
+			errMsg := fmt.Sprintf("Corrupted bitstream: expected checksum %x, found %x", t.checksum, checksum2)
+			t.err = NewIOError(errMsg, ERR_PROCESS_BLOCK)
+			return
+		}
+	}
 }
@@ -0,0 +1,208 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"kanzi"
+	"kanzi/util"
+)
+
+// Optional encryption stage (after entropy coding) of the compressed streams.
+// The entropy coded blocks are sealed with AES-256-GCM, so each block is
+// authenticated before it is decoded: no plaintext of a tampered (or
+// truncated) block is ever emitted.
+// A master key is derived from the password with scrypt and a random salt.
+// Each stream uses its own key, derived from the master key and a random IV
+// with HKDF, so the nonce of a block can simply be derived from the block id.
+// When the encryption bit of the header is set, the header is followed by:
+// KDF type (8 bits), log2(N), r, p (8 bits each), salt (128 bits), IV (128
+// bits) and a tag (128 bits) authenticating the header. A wrong password is
+// detected with this tag, before any block is read.
+// The last block of an encrypted stream is followed by an empty sealed block
+// (a tag only) bound to the number of blocks, so a truncated stream is
+// detected as well.
+
+const (
+	ENCRYPTION_MASK      = 0x08 // header reserved bits
+	KDF_SCRYPT           = 1
+	SCRYPT_LOG_N         = 15 // 32 MB of memory with r=8
+	SCRYPT_R             = 8
+	SCRYPT_P             = 1
+	MAX_SCRYPT_LOG_N     = 20
+	MAX_SCRYPT_R         = 32
+	MAX_SCRYPT_P         = 16
+	MAX_SCRYPT_COST      = 4 * SCRYPT_R * SCRYPT_P << SCRYPT_LOG_N // N*r*p, 128 MB of memory at most
+	ENCRYPTION_SALT_SIZE = 16
+	ENCRYPTION_TAG_SIZE  = 16
+
+	// Nonce domains
+	NONCE_BLOCK  = 0
+	NONCE_HEADER = 1
+	NONCE_END    = 2
+)
+
+type streamCipher struct {
+	password []byte
+	kdf      uint
+	logN     uint
+	r        uint
+	p        uint
+	salt     [ENCRYPTION_SALT_SIZE]byte
+	iv       [ENCRYPTION_SALT_SIZE]byte
+	key      []byte // master key (derived from the password and the salt)
+	keySalt  [ENCRYPTION_SALT_SIZE]byte
+	keyCost  [3]uint // log2(N), r and p used to derive the master key
+	aead     cipher.AEAD
+}
+
+func newStreamCipher(password []byte) (*streamCipher, error) {
+	if len(password) == 0 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid empty password")
+	}
+
+	this := new(streamCipher)
+	this.password = append([]byte(nil), password...)
+	this.kdf = KDF_SCRYPT
+	this.logN = SCRYPT_LOG_N
+	this.r = SCRYPT_R
+	this.p = SCRYPT_P
+	return this, nil
+}
+
+// Derive the master key from the password (if the salt or the KDF parameters
+// have changed since the last derivation)
+func (this *streamCipher) deriveKey() error {
+	cost := [3]uint{this.logN, this.r, this.p}
+
+	if this.key != nil && this.keySalt == this.salt && this.keyCost == cost {
+		return nil
+	}
+
+	this.key = nil
+	key, err := util.Scrypt(this.password, this.salt[:], 1<<this.logN, int(this.r), int(this.p), 32)
+
+	if err != nil {
+		return err
+	}
+
+	this.key = key
+	this.keySalt = this.salt
+	this.keyCost = cost
+	return nil
+}
+
+// Encoder: pick a random salt (once) and derive the master key
+func (this *streamCipher) initKey() error {
+	if _, err := rand.Read(this.salt[:]); err != nil {
+		return err
+	}
+
+	return this.deriveKey()
+}
+
+// Derive the key of a stream from the master key and the IV
+func (this *streamCipher) initStream() error {
+	key, err := hkdf.Key(sha256.New, this.key, this.iv[:], "kanzi AES-256-GCM block key", 32)
+
+	if err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return err
+	}
+
+	this.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// Return the data authenticated by the header tag: the header fields, the
+// extended flags (if any) and the encryption parameters
+func (this *streamCipher) headerData(version uint, checksum bool, entropyType, transformType byte,
+	blockSize uint, flags byte, extFlags []byte) []byte {
+	var buf bytes.Buffer
+	cksum := byte(0)
+
+	if checksum == true {
+		cksum = 1
+	}
+
+	buf.Write([]byte{byte(version), cksum, entropyType, transformType})
+	binary.Write(&buf, binary.BigEndian, uint32(blockSize))
+	buf.WriteByte(flags)
+	buf.Write(extFlags)
+	buf.Write([]byte{byte(this.kdf), byte(this.logN), byte(this.r), byte(this.p)})
+	buf.Write(this.salt[:])
+	buf.Write(this.iv[:])
+	return buf.Bytes()
+}
+
+// Return the encryption header (following the header of the stream)
+func (this *streamCipher) headerBytes(tag []byte) []byte {
+	buf := make([]byte, 0, 4+2*ENCRYPTION_SALT_SIZE+len(tag))
+	buf = append(buf, byte(this.kdf), byte(this.logN), byte(this.r), byte(this.p))
+	buf = append(buf, this.salt[:]...)
+	buf = append(buf, this.iv[:]...)
+	return append(buf, tag...)
+}
+
+func (this *streamCipher) nonce(domain byte, id int) []byte {
+	var nonce [12]byte
+	nonce[0] = domain
+	binary.BigEndian.PutUint64(nonce[4:], uint64(id))
+	return nonce[:]
+}
+
+// Append the sealed block (ciphertext and tag) to dst
+func (this *streamCipher) seal(dst []byte, domain byte, id int, block, data []byte) []byte {
+	return this.aead.Seal(dst, this.nonce(domain, id), block, data)
+}
+
+// Append the decrypted block to dst. Fail if the block cannot be authenticated.
+func (this *streamCipher) open(dst []byte, domain byte, id int, block, data []byte) ([]byte, error) {
+	return this.aead.Open(dst, this.nonce(domain, id), block, data)
+}
+
+// Check the KDF parameters read from a header (they determine the memory
+// and time needed to derive the key). The header is not authenticated yet:
+// the cost is capped to a few times the cost of the encoder parameters.
+func (this *streamCipher) checkParameters() error {
+	if this.kdf != KDF_SCRYPT {
+		return kanzi.Errorf(kanzi.ErrUnsupported, "Unsupported key derivation function: %d", this.kdf)
+	}
+
+	if this.logN < 1 || this.logN > MAX_SCRYPT_LOG_N || this.r < 1 || this.r > MAX_SCRYPT_R ||
+		this.p < 1 || this.p > MAX_SCRYPT_P {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Invalid key derivation parameters: N=2^%d, r=%d, p=%d",
+			this.logN, this.r, this.p)
+	}
+
+	if uint64(this.r*this.p)<<this.logN > MAX_SCRYPT_COST {
+		return kanzi.Errorf(kanzi.ErrLimitExceeded, "Key derivation too expensive: N=2^%d, r=%d, p=%d (max N*r*p=%d)",
+			this.logN, this.r, this.p, MAX_SCRYPT_COST)
+	}
+
+	return nil
+}
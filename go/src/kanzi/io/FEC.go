@@ -0,0 +1,457 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"kanzi"
+	"kanzi/util"
+	"sync/atomic"
+)
+
+// Forward error correction. When the FEC_MASK bit of the extended flags is
+// set, a FEC header follows the extended flags: number of data shards (8
+// bits), number of parity shards (8 bits) and shard size (16 bits, in bytes).
+// The rest of the stream (encryption header, blocks, end marker, signature)
+// is cut into frames of fixed size, so that a damaged length prefix cannot
+// desynchronize the decoder. A frame holds dataShards data shards followed by
+// parityShards Reed-Solomon parity shards. Each shard is followed by its
+// CRC32C (big endian), used to detect the damaged shards. The data shards of
+// a frame contain the number of stream bytes in the frame (32 bits) followed
+// by the stream bytes (and zero padding). Up to parityShards damaged shards
+// per frame can be rebuilt: a burst error of up to (parityShards-1)*shardSize
+// bytes is always repaired.
+
+const (
+	FEC_MASK           = 0x10 // extended flags
+	FEC_HEADER_SIZE    = 4
+	FEC_DATA_SHARDS    = 16
+	FEC_SHARD_SIZE     = 1024
+	FEC_CHECKSUM_SIZE  = 4
+	MIN_FEC_SHARD_SIZE = 64
+	MAX_REDUNDANCY     = 100 // percent
+)
+
+// Return the number of data and parity shards for a redundancy in percent
+// (parity size relative to the data size)
+func fecShards(redundancy uint) (uint, uint) {
+	return FEC_DATA_SHARDS, (FEC_DATA_SHARDS*redundancy + 99) / 100
+}
+
+func fecHeaderBytes(dataShards, parityShards, shardSize uint) []byte {
+	return []byte{byte(dataShards), byte(parityShards), byte(shardSize >> 8), byte(shardSize)}
+}
+
+// Check the FEC header read from a stream and create the codec
+func parseFECHeader(header []byte) (*util.ReedSolomon, int, error) {
+	shardSize := int(binary.BigEndian.Uint16(header[2:]))
+
+	if shardSize < MIN_FEC_SHARD_SIZE {
+		return nil, 0, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid FEC shard size: %d", shardSize)
+	}
+
+	rs, err := util.NewReedSolomon(uint(header[0]), uint(header[1]))
+
+	if err != nil {
+		return nil, 0, corrupted(err)
+	}
+
+	return rs, shardSize, nil
+}
+
+// A frame of shards, with the buffers of the Reed-Solomon codec
+type fecFrame struct {
+	rs        *util.ReedSolomon
+	shardSize int
+	buffer    []byte   // shards and checksums, as stored in the stream
+	shards    [][]byte // shards (slices of buffer)
+	present   []bool
+	data      []byte // content of the data shards (length and stream bytes)
+}
+
+func newFECFrame(rs *util.ReedSolomon, shardSize int) *fecFrame {
+	this := new(fecFrame)
+	this.rs = rs
+	this.shardSize = shardSize
+	n := rs.DataShards() + rs.ParityShards()
+	this.buffer = make([]byte, n*(shardSize+FEC_CHECKSUM_SIZE))
+	this.shards = make([][]byte, n)
+	this.present = make([]bool, n)
+	this.data = make([]byte, rs.DataShards()*shardSize)
+
+	for i := range this.shards {
+		offset := i * (shardSize + FEC_CHECKSUM_SIZE)
+		this.shards[i] = this.buffer[offset : offset+shardSize]
+	}
+
+	return this
+}
+
+// Maximum number of stream bytes in a frame
+func (this *fecFrame) capacity() int {
+	return len(this.data) - 4
+}
+
+func (this *fecFrame) checksum(i int) []byte {
+	offset := (i+1)*(this.shardSize+FEC_CHECKSUM_SIZE) - FEC_CHECKSUM_SIZE
+	return this.buffer[offset : offset+FEC_CHECKSUM_SIZE]
+}
+
+// Fill the shards from this.data (length bytes used) and compute the parity
+// shards and the checksums
+func (this *fecFrame) encode(length int) {
+	binary.BigEndian.PutUint32(this.data, uint32(length))
+	clear(this.data[4+length:])
+
+	for i := 0; i < this.rs.DataShards(); i++ {
+		copy(this.shards[i], this.data[i*this.shardSize:])
+	}
+
+	this.rs.Encode(this.shards)
+
+	for i := range this.shards {
+		binary.BigEndian.PutUint32(this.checksum(i), crc32.Checksum(this.shards[i], crc32cTable))
+	}
+}
+
+// Check the shards of a frame read in the buffer (size bytes, the missing
+// bytes of a truncated frame are damaged), rebuild the damaged shards and
+// return the stream bytes. Return the number of shards repaired.
+func (this *fecFrame) decode(size int) ([]byte, int, error) {
+	damaged := 0
+
+	for i := range this.shards {
+		end := (i + 1) * (this.shardSize + FEC_CHECKSUM_SIZE)
+		this.present[i] = end <= size &&
+			binary.BigEndian.Uint32(this.checksum(i)) == crc32.Checksum(this.shards[i], crc32cTable)
+
+		if this.present[i] == false {
+			damaged++
+		}
+	}
+
+	if damaged > 0 {
+		if err := this.rs.Reconstruct(this.shards, this.present); err != nil {
+			return nil, damaged, err
+		}
+
+		for i := range this.shards {
+			if this.present[i] == false {
+				binary.BigEndian.PutUint32(this.checksum(i), crc32.Checksum(this.shards[i], crc32cTable))
+			}
+		}
+	}
+
+	for i := 0; i < this.rs.DataShards(); i++ {
+		copy(this.data[i*this.shardSize:], this.shards[i])
+	}
+
+	length := int(binary.BigEndian.Uint32(this.data))
+
+	if length > this.capacity() {
+		return nil, damaged, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid frame length: %d", length)
+	}
+
+	return this.data[4 : 4+length], damaged, nil
+}
+
+// Write the header as is, then the rest of the stream as frames
+type fecOutputStream struct {
+	os      kanzi.OutputStream
+	skip    int // header bytes still to write as is
+	frame   *fecFrame
+	length  int    // stream bytes in the current frame
+	written uint64 // bytes written to os
+}
+
+func newFECOutputStream(os kanzi.OutputStream, dataShards, parityShards, shardSize uint) (*fecOutputStream, error) {
+	rs, err := util.NewReedSolomon(dataShards, parityShards)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this := new(fecOutputStream)
+	this.frame = newFECFrame(rs, int(shardSize))
+	this.reset(os)
+	return this, nil
+}
+
+func (this *fecOutputStream) reset(os kanzi.OutputStream) {
+	this.os = os
+	this.skip = HEADER_SIZE + 2 + FEC_HEADER_SIZE
+	this.length = 0
+	this.written = 0
+}
+
+func (this *fecOutputStream) header() []byte {
+	rs := this.frame.rs
+	return fecHeaderBytes(uint(rs.DataShards()), uint(rs.ParityShards()), uint(this.frame.shardSize))
+}
+
+func (this *fecOutputStream) Write(b []byte) (int, error) {
+	written := 0
+
+	if this.skip > 0 {
+		n := min(this.skip, len(b))
+
+		if _, err := this.os.Write(b[0:n]); err != nil {
+			return 0, err
+		}
+
+		this.skip -= n
+		this.written += uint64(n)
+		written = n
+	}
+
+	for written < len(b) {
+		n := copy(this.frame.data[4+this.length:], b[written:])
+		this.length += n
+		written += n
+
+		if this.length == this.frame.capacity() {
+			if err := this.flush(); err != nil {
+				return written - n, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Write the current frame (partially filled, if the stream is flushed)
+func (this *fecOutputStream) flush() error {
+	if this.length == 0 {
+		return nil
+	}
+
+	this.frame.encode(this.length)
+	this.length = 0
+	_, err := this.os.Write(this.frame.buffer)
+	this.written += uint64(len(this.frame.buffer))
+	return err
+}
+
+func (this *fecOutputStream) Close() error {
+	if err := this.flush(); err != nil {
+		return err
+	}
+
+	return this.os.Close()
+}
+
+// Read the header as is and, if the stream is protected, the stream bytes
+// from the frames. The header is parsed here because the bitstream reads
+// ahead: the frames must be decoded before they reach it.
+type fecInputStream struct {
+	is       kanzi.InputStream
+	started  bool
+	header   []byte // header bytes not consumed yet
+	frame    *fecFrame
+	frameId  int
+	data     []byte // stream bytes not consumed yet
+	eos      bool
+	repaired uint64 // number of damaged shards rebuilt (atomic)
+}
+
+func newFECInputStream(is kanzi.InputStream) *fecInputStream {
+	this := new(fecInputStream)
+	this.reset(is)
+	return this
+}
+
+func (this *fecInputStream) reset(is kanzi.InputStream) {
+	this.is = is
+	this.started = false
+	this.header = nil
+	this.frame = nil
+	this.frameId = 0
+	this.data = nil
+	this.eos = false
+	atomic.StoreUint64(&this.repaired, 0)
+}
+
+// Read the header. If the FEC parameters are invalid, the header is handed
+// over as is (the error is reported by CompressedInputStream.ReadHeader).
+func (this *fecInputStream) readHeader() error {
+	this.started = true
+	this.header = make([]byte, HEADER_SIZE, HEADER_SIZE+2+FEC_HEADER_SIZE)
+	n, err := io.ReadFull(this.is, this.header)
+	this.header = this.header[0:n]
+
+	if err != nil {
+		return err
+	}
+
+	version := this.header[4] >> 1
+
+	if binary.BigEndian.Uint32(this.header) != BITSTREAM_TYPE || version == 0 ||
+		this.header[HEADER_SIZE-1]&EXTENDED_FLAGS_MASK == 0 {
+		return nil
+	}
+
+	if n, err = io.ReadFull(this.is, this.header[HEADER_SIZE:HEADER_SIZE+2]); err != nil {
+		this.header = this.header[0 : HEADER_SIZE+n]
+		return err
+	}
+
+	this.header = this.header[0 : HEADER_SIZE+2]
+
+	if binary.BigEndian.Uint16(this.header[HEADER_SIZE:])&FEC_MASK == 0 {
+		return nil
+	}
+
+	fecHeader := this.header[HEADER_SIZE+2 : HEADER_SIZE+2+FEC_HEADER_SIZE]
+
+	if n, err = io.ReadFull(this.is, fecHeader); err != nil {
+		this.header = this.header[0 : HEADER_SIZE+2+n]
+		return err
+	}
+
+	this.header = this.header[0 : HEADER_SIZE+2+FEC_HEADER_SIZE]
+
+	if rs, shardSize, err := parseFECHeader(fecHeader); err == nil {
+		this.frame = newFECFrame(rs, shardSize)
+	}
+
+	return nil
+}
+
+func (this *fecInputStream) Read(b []byte) (int, error) {
+	if this.started == false {
+		if err := this.readHeader(); err != nil {
+			this.frame = nil
+
+			if len(this.header) == 0 {
+				return 0, err
+			}
+		}
+	}
+
+	if len(this.header) > 0 {
+		n := copy(b, this.header)
+		this.header = this.header[n:]
+		return n, nil
+	}
+
+	if this.frame == nil {
+		return this.is.Read(b)
+	}
+
+	for len(this.data) == 0 {
+		if this.eos == true {
+			return 0, io.EOF
+		}
+
+		if err := this.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, this.data)
+	this.data = this.data[n:]
+	return n, nil
+}
+
+// Read and repair the next frame. A truncated frame is the last one.
+func (this *fecInputStream) readFrame() error {
+	this.data = nil
+	size, err := io.ReadFull(this.is, this.frame.buffer)
+
+	if err == io.EOF {
+		this.eos = true
+		return nil
+	}
+
+	if err == io.ErrUnexpectedEOF {
+		this.eos = true
+	} else if err != nil {
+		return err
+	}
+
+	this.frameId++
+	data, damaged, err := this.frame.decode(size)
+
+	if err != nil {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Cannot repair frame %d (%d damaged shards, at most %d can be repaired)",
+			this.frameId, damaged, this.frame.rs.ParityShards())
+	}
+
+	atomic.AddUint64(&this.repaired, uint64(damaged))
+	this.data = data
+	return nil
+}
+
+func (this *fecInputStream) Close() error {
+	return this.is.Close()
+}
+
+// Adapt an io.Reader to kanzi.InputStream
+type readerInputStream struct {
+	io.Reader
+}
+
+func (this readerInputStream) Close() error {
+	return nil
+}
+
+// Repair a stream protected with parity shards (see
+// CompressedOutputStream.SetRedundancy): the damaged shards are rebuilt and
+// the repaired stream, identical to the original one, is written to w.
+// Return the number of shards repaired. The blocks are not decoded.
+func Repair(r io.Reader, w io.Writer) (uint64, error) {
+	fis := newFECInputStream(readerInputStream{r})
+
+	if err := fis.readHeader(); err != nil {
+		return 0, WrapIOError("Cannot read bitstream header: "+err.Error(), ERR_READ_FILE, corrupted(err))
+	}
+
+	if binary.BigEndian.Uint32(fis.header) != BITSTREAM_TYPE {
+		return 0, NewIOError("Invalid stream type", ERR_INVALID_FILE)
+	}
+
+	if fis.frame == nil {
+		return 0, NewIOError("The stream is not protected with parity shards (no redundancy)", ERR_INVALID_FILE)
+	}
+
+	if _, err := w.Write(fis.header); err != nil {
+		return 0, WrapIOError("Cannot write the repaired stream: "+err.Error(), ERR_WRITE_FILE, err)
+	}
+
+	frames := 0
+
+	for {
+		if err := fis.readFrame(); err != nil {
+			return fis.repaired, WrapIOError(err.Error(), ERR_INVALID_FILE, corrupted(err))
+		}
+
+		if fis.eos == true && fis.frameId == frames {
+			return fis.repaired, nil
+		}
+
+		frames = fis.frameId
+
+		if _, err := w.Write(fis.frame.buffer); err != nil {
+			return fis.repaired, WrapIOError("Cannot write the repaired stream: "+err.Error(), ERR_WRITE_FILE, err)
+		}
+
+		if fis.eos == true {
+			return fis.repaired, nil
+		}
+	}
+}
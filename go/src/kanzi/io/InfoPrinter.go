@@ -16,9 +16,9 @@ limitations under the License.
 package io
 
 import (
-	"errors"
 	"fmt"
 	"io"
+	"kanzi"
 	"sync"
 	"time"
 )
@@ -47,7 +47,7 @@ type InfoPrinter struct {
 
 func NewInfoPrinter(type_ uint, writer io.Writer) (*InfoPrinter, error) {
 	if writer == nil {
-		return nil, errors.New("Invalid null writer parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null writer parameter")
 	}
 
 	this := new(InfoPrinter)
@@ -94,15 +94,19 @@ func (this *InfoPrinter) ProcessEvent(evt *BlockEvent) {
 			this.lock.Unlock()
 		}
 	} else if evt.EventType() == this.thresholds[2] {
-		this.lock.RLock()
+		this.lock.Lock()
 		bi, exists := this.map_[currentBlockId]
-		this.lock.RUnlock()
+
+		if exists == true {
+			delete(this.map_, currentBlockId)
+		}
+
+		this.lock.Unlock()
 
 		if exists == false {
 			return
 		}
 
-		delete(this.map_, currentBlockId)
 		//duration_ms := time.Now().Sub(bi.time).Nanoseconds() / 1000000
 
 		// Get block size after stage 2
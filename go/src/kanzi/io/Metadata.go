@@ -0,0 +1,180 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"kanzi"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Header extension with user metadata. When the METADATA_MASK bit of the
+// header is set, a metadata section follows the extended flags (and the FEC
+// header): size of the entries (16 bits, in bytes) followed by the entries.
+// An entry is a type (8 bits), a length (16 bits, in bytes) and a value.
+// Unknown entry types are skipped by the decoder, so new types can be added
+// without changing the version of the format. The metadata is signed and
+// authenticated (encrypted streams) but it is not encrypted.
+
+const (
+	METADATA_MASK     = 0x01 // header reserved bits
+	MAX_METADATA_SIZE = 65535
+
+	// Entry types
+	META_NAME         = 1 // UTF-8
+	META_MTIME        = 2 // seconds (64 bits) and nanoseconds (32 bits) since the Unix epoch
+	META_CONTENT_TYPE = 3 // UTF-8 (EG. a MIME type)
+	META_TAG          = 4 // UTF-8 key, 0 byte, UTF-8 value
+)
+
+// The metadata stored in the header of a stream. Empty fields are not stored.
+type Metadata struct {
+	Name        string            // original file name (without directory)
+	ModTime     time.Time         // modification time of the original file
+	ContentType string            // EG. a MIME type
+	Tags        map[string]string // user key/value pairs
+}
+
+// Return the metadata section (size and entries)
+func (this *Metadata) encode() ([]byte, error) {
+	if strings.ContainsAny(this.Name, "/\\") == true {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid metadata: the name must not contain a directory: %q", this.Name)
+	}
+
+	buf := make([]byte, 2, 256)
+	var err error
+
+	if buf, err = appendEntry(buf, META_NAME, this.Name); err != nil {
+		return nil, err
+	}
+
+	if this.ModTime.IsZero() == false {
+		var mtime [12]byte
+		binary.BigEndian.PutUint64(mtime[0:], uint64(this.ModTime.Unix()))
+		binary.BigEndian.PutUint32(mtime[8:], uint32(this.ModTime.Nanosecond()))
+
+		if buf, err = appendEntry(buf, META_MTIME, string(mtime[:])); err != nil {
+			return nil, err
+		}
+	}
+
+	if buf, err = appendEntry(buf, META_CONTENT_TYPE, this.ContentType); err != nil {
+		return nil, err
+	}
+
+	// Sort the tags so that the output is deterministic
+	keys := make([]string, 0, len(this.Tags))
+
+	for k := range this.Tags {
+		if len(k) == 0 || strings.IndexByte(k, 0) >= 0 {
+			return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid metadata: invalid tag key %q", k)
+		}
+
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if buf, err = appendEntry(buf, META_TAG, k+"\x00"+this.Tags[k]); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(buf)-2 > MAX_METADATA_SIZE {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid metadata: more than %d bytes", MAX_METADATA_SIZE)
+	}
+
+	binary.BigEndian.PutUint16(buf[0:], uint16(len(buf)-2))
+	return buf, nil
+}
+
+// Append an entry (nothing if the value is empty)
+func appendEntry(buf []byte, entryType byte, value string) ([]byte, error) {
+	if len(value) == 0 {
+		return buf, nil
+	}
+
+	if len(value) > MAX_METADATA_SIZE {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid metadata: value of type %d too large", entryType)
+	}
+
+	if entryType != META_MTIME && utf8.ValidString(value) == false {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid metadata: value of type %d not UTF-8", entryType)
+	}
+
+	buf = append(buf, entryType)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	return append(buf, value...), nil
+}
+
+// Parse the entries of a metadata section
+func decodeMetadata(entries []byte) (*Metadata, error) {
+	this := &Metadata{}
+
+	for len(entries) > 0 {
+		if len(entries) < 3 {
+			return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid metadata: truncated entry")
+		}
+
+		entryType := entries[0]
+		length := int(binary.BigEndian.Uint16(entries[1:]))
+
+		if len(entries) < 3+length {
+			return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid metadata: truncated entry of type %d", entryType)
+		}
+
+		value := entries[3 : 3+length]
+		entries = entries[3+length:]
+
+		switch entryType {
+		case META_NAME:
+			this.Name = string(value)
+
+		case META_MTIME:
+			if length != 12 {
+				return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid metadata: invalid modification time")
+			}
+
+			this.ModTime = time.Unix(int64(binary.BigEndian.Uint64(value[0:])), int64(binary.BigEndian.Uint32(value[8:])))
+
+		case META_CONTENT_TYPE:
+			this.ContentType = string(value)
+
+		case META_TAG:
+			idx := bytes.IndexByte(value, 0)
+
+			if idx <= 0 {
+				return nil, kanzi.Errorf(kanzi.ErrCorruptData, "Invalid metadata: invalid tag")
+			}
+
+			if this.Tags == nil {
+				this.Tags = make(map[string]string)
+			}
+
+			this.Tags[string(value[0:idx])] = string(value[idx+1:])
+
+		default:
+			// Added by a later version: skip
+		}
+	}
+
+	return this, nil
+}
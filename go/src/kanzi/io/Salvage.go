@@ -0,0 +1,78 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"encoding/binary"
+)
+
+// Recovery of damaged streams.
+// Sync markers: when the SYNC_MARKERS_MASK bit of the extended flags is set,
+// the 32 bit length prefix of each block (and of the end of stream marker)
+// is replaced with a sync marker: SYNC_MAGIC (32 bits), block id (32 bits)
+// and length (32 bits). The ids start at 1 and are consecutive (the end of
+// stream block of an encrypted stream and the end of stream marker have ids
+// too). A decoder can find the next block after a damaged length by scanning
+// the stream for a marker.
+// Salvage mode: the blocks that cannot be decoded (corrupted data, checksum
+// mismatch, invalid sync marker) are skipped or replaced with zeros instead
+// of stopping the decoding. The lost ranges of the original data are
+// reported (see CompressedInputStream.GetLostRanges). Without sync markers,
+// decoding stops at the first damaged length prefix.
+
+const (
+	SYNC_MARKERS_MASK = 0x20       // extended flags
+	SYNC_MAGIC        = 0x4B53594E // "KSYN"
+	SYNC_MARKER_SIZE  = 12
+	MAX_SYNC_SKIP     = 65536 // maximum number of blocks skipped to find a marker
+
+	SALVAGE_OFF       = 0 // stop at the first damaged block
+	SALVAGE_SKIP      = 1 // skip the damaged blocks
+	SALVAGE_ZERO_FILL = 2 // replace the damaged blocks with zeros
+)
+
+// A range of the original data lost in salvage mode. The offsets assume that
+// the lost blocks are full: all the blocks are, except the last one (and the
+// blocks written by a Flush).
+type LostRange struct {
+	FirstBlock int    // id of the first lost block (the first block of the stream is 1)
+	Blocks     int    // number of lost blocks, 0 if the rest of the stream is lost
+	Offset     uint64 // position of the lost bytes in the original data
+	Length     uint64 // number of lost bytes, 0 if the rest of the stream is lost
+	Err        error  // cause of the loss
+}
+
+// Return the sync marker (or the length prefix) of a block
+func blockHeader(buf []byte, syncMarkers bool, id, length int) []byte {
+	if syncMarkers == false {
+		return binary.BigEndian.AppendUint32(buf, uint32(length))
+	}
+
+	buf = binary.BigEndian.AppendUint32(buf, SYNC_MAGIC)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(id))
+	return binary.BigEndian.AppendUint32(buf, uint32(length))
+}
+
+// Check a sync marker: the id must be in [minId..maxId] and the length at
+// most maxLength
+func checkSyncMarker(marker []byte, minId, maxId int, maxLength uint64) bool {
+	if binary.BigEndian.Uint32(marker[0:]) != SYNC_MAGIC {
+		return false
+	}
+
+	id := int(binary.BigEndian.Uint32(marker[4:]))
+	return id >= minId && id <= maxId && uint64(binary.BigEndian.Uint32(marker[8:])) <= maxLength
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"kanzi"
+)
+
+// Ed25519 signatures of the compressed streams.
+// Embedded signature: when the signature bit of the header is set, the end
+// of stream marker is followed by a 64 byte signature of all the bytes of
+// the stream before it (header, blocks and end marker). The stream is hashed
+// with SHA-512 while the blocks are written (or read), so the signature
+// (Ed25519ph) does not require a second pass over the stream. Decoders that
+// ignore the signature bit simply stop reading before the signature.
+// Detached signature: the signature (Ed25519ph) of a whole file, stored
+// separately.
+// The keys and the detached signatures are stored as hexadecimal text.
+
+const (
+	SIGNATURE_MASK = 0x04 // header reserved bits
+	SIGNATURE_SIZE = ed25519.SignatureSize
+	HEADER_SIZE    = 10 // bytes, without the encryption header
+
+	EMBEDDED_SIGNATURE_CONTEXT = "kanzi embedded signature"
+	DETACHED_SIGNATURE_CONTEXT = "kanzi detached signature"
+)
+
+// Hash the bytes of a stream and sign it (or check its signature)
+type streamSigner struct {
+	privateKey ed25519.PrivateKey // nil when verifying
+	publicKey  ed25519.PublicKey
+	hash       hash.Hash
+}
+
+func newStreamSigner(privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) *streamSigner {
+	this := new(streamSigner)
+	this.privateKey = privateKey
+	this.publicKey = publicKey
+	this.hash = sha512.New()
+	return this
+}
+
+func (this *streamSigner) update(data []byte) {
+	this.hash.Write(data)
+}
+
+// Hash the 32 bit length prefix of a block
+func (this *streamSigner) updateLength(length int) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(length))
+	this.hash.Write(buf[:])
+}
+
+func (this *streamSigner) sign() ([]byte, error) {
+	opts := &ed25519.Options{Hash: crypto.SHA512, Context: EMBEDDED_SIGNATURE_CONTEXT}
+	return this.privateKey.Sign(nil, this.hash.Sum(nil), opts)
+}
+
+func (this *streamSigner) verify(signature []byte) bool {
+	opts := &ed25519.Options{Hash: crypto.SHA512, Context: EMBEDDED_SIGNATURE_CONTEXT}
+	return ed25519.VerifyWithOptions(this.publicKey, this.hash.Sum(nil), signature, opts) == nil
+}
+
+func (this *streamSigner) reset() {
+	this.hash.Reset()
+}
+
+// Return the bytes of the header (see CompressedOutputStream.WriteHeader)
+func headerBytes(version uint, checksum bool, entropyType, transformType byte,
+	blockSize uint, flags byte) []byte {
+	cksum := uint64(0)
+
+	if checksum == true {
+		cksum = 1
+	}
+
+	// 48 bits after the stream type
+	bits := uint64(version&0x7F) << 41
+	bits |= cksum << 40
+	bits |= uint64(entropyType&0x1F) << 35
+	bits |= uint64(transformType&0x1F) << 30
+	bits |= uint64(blockSize>>3&0x3FFFFFF) << 4
+	bits |= uint64(flags & 0x0F)
+	buf := make([]byte, HEADER_SIZE+2)
+	binary.BigEndian.PutUint32(buf[0:], BITSTREAM_TYPE)
+	binary.BigEndian.PutUint64(buf[4:], bits<<16)
+	return buf[0:HEADER_SIZE]
+}
+
+// Generate a key pair (the private key can be saved with its seed)
+func GenerateSigningKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// Parse a public key stored as hexadecimal text
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid public key: %d hexadecimal digits expected",
+			2*ed25519.PublicKeySize)
+	}
+
+	return ed25519.PublicKey(key), nil
+}
+
+// Parse a private key stored as hexadecimal text (the seed or the full key)
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+
+	if err == nil && len(key) == ed25519.SeedSize {
+		return ed25519.NewKeyFromSeed(key), nil
+	}
+
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid private key: %d or %d hexadecimal digits expected",
+			2*ed25519.SeedSize, 2*ed25519.PrivateKeySize)
+	}
+
+	return ed25519.NewKeyFromSeed(key[0:ed25519.SeedSize]), nil
+}
+
+// Return the detached signature of the data read from r
+func SignDetached(r io.Reader, key ed25519.PrivateKey) ([]byte, error) {
+	h := sha512.New()
+
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, WrapIOError("Cannot read the data to sign: "+err.Error(), ERR_READ_FILE, err)
+	}
+
+	opts := &ed25519.Options{Hash: crypto.SHA512, Context: DETACHED_SIGNATURE_CONTEXT}
+	return key.Sign(nil, h.Sum(nil), opts)
+}
+
+// Check the detached signature of the data read from r
+func VerifyDetached(r io.Reader, key ed25519.PublicKey, signature []byte) error {
+	h := sha512.New()
+
+	if _, err := io.Copy(h, r); err != nil {
+		return WrapIOError("Cannot read the signed data: "+err.Error(), ERR_READ_FILE, err)
+	}
+
+	opts := &ed25519.Options{Hash: crypto.SHA512, Context: DETACHED_SIGNATURE_CONTEXT}
+
+	if ed25519.VerifyWithOptions(key, h.Sum(nil), signature, opts) != nil {
+		return NewIOError("Invalid signature: the data has been modified or signed with another key", ERR_SIGNATURE)
+	}
+
+	return nil
+}
+
+// Check the embedded signature of the compressed stream read from r without
+// decoding it. Useful to reject a tampered stream before any data is
+// decoded: CompressedInputStream only checks the signature at the end of the
+// stream. The frames of a stream protected with parity shards are repaired
+// before they are hashed.
+func VerifyEmbedded(reader io.Reader, key ed25519.PublicKey) error {
+	r := newFECInputStream(readerInputStream{reader})
+	var header [HEADER_SIZE]byte
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return WrapIOError("Cannot read bitstream header: "+err.Error(), ERR_READ_FILE, corrupted(err))
+	}
+
+	if binary.BigEndian.Uint32(header[0:]) != BITSTREAM_TYPE {
+		return NewIOError("Invalid stream type", ERR_INVALID_FILE)
+	}
+
+	if header[HEADER_SIZE-1]&SIGNATURE_MASK == 0 {
+		return NewIOError("Invalid signature: the stream is not signed", ERR_SIGNATURE)
+	}
+
+	s := newStreamSigner(nil, key)
+	s.update(header[:])
+
+	// Hash everything but the last SIGNATURE_SIZE bytes
+	buf := make([]byte, 65536+SIGNATURE_SIZE)
+	n := 0
+
+	for {
+		read, err := r.Read(buf[n:])
+		n += read
+
+		if n > SIGNATURE_SIZE {
+			s.update(buf[0 : n-SIGNATURE_SIZE])
+			copy(buf, buf[n-SIGNATURE_SIZE:n])
+			n = SIGNATURE_SIZE
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return WrapIOError("Cannot read the signed stream: "+err.Error(), ERR_READ_FILE, err)
+		}
+	}
+
+	if n < SIGNATURE_SIZE || s.verify(buf[0:SIGNATURE_SIZE]) == false {
+		return NewIOError("Invalid signature: the stream has been modified or signed with another key", ERR_SIGNATURE)
+	}
+
+	return nil
+}
@@ -17,7 +17,8 @@ package io
 
 import (
 	"bufio"
-	"errors"
+	"io"
+	"kanzi"
 	"os"
 )
 
@@ -79,7 +80,7 @@ func NewNullOutputStream() (*NullOutputStream, error) {
 
 func (this *NullOutputStream) Write(b []byte) (n int, err error) {
 	if this.closed == true {
-		panic(errors.New("File closed"))
+		panic(kanzi.Errorf(kanzi.ErrClosed, "File closed"))
 	}
 
 	return len(b), nil
@@ -89,3 +90,104 @@ func (this *NullOutputStream) Close() error {
 	this.closed = true
 	return nil
 }
+
+// In memory streams used to entropy code each block independently
+type byteOutputStream struct {
+	buf []byte
+}
+
+func (this *byteOutputStream) Write(b []byte) (n int, err error) {
+	this.buf = append(this.buf, b...)
+	return len(b), nil
+}
+
+func (this *byteOutputStream) Close() error {
+	return nil
+}
+
+type byteInputStream struct {
+	buf   []byte
+	index int
+}
+
+func (this *byteInputStream) Read(b []byte) (n int, err error) {
+	if this.index >= len(this.buf) {
+		return 0, io.EOF
+	}
+
+	n = copy(b, this.buf[this.index:])
+	this.index += n
+	return n, nil
+}
+
+func (this *byteInputStream) Close() error {
+	return nil
+}
+
+// Input stream whose reads can be abandoned: the reads are performed by a
+// helper goroutine, so that after cancel() the read in flight and the next
+// ones fail at once, even if the source does not unblock a Read on Close
+// (EG. an HTTP response body). The helper goroutine of an abandoned read
+// exits when the source returns. The in-memory sources are read directly.
+type cancelableInputStream struct {
+	is       kanzi.InputStream
+	direct   bool
+	buffer   []byte
+	results  chan readResult
+	canceled chan bool
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func newCancelableInputStream(is kanzi.InputStream) *cancelableInputStream {
+	this := new(cancelableInputStream)
+	this.is = is
+	_, this.direct = is.(*byteInputStream)
+	this.results = make(chan readResult, 1)
+	this.canceled = make(chan bool)
+	return this
+}
+
+func (this *cancelableInputStream) Read(b []byte) (int, error) {
+	select {
+	case <-this.canceled:
+		return 0, kanzi.Errorf(kanzi.ErrClosed, "Read canceled")
+	default:
+	}
+
+	if this.direct == true {
+		return this.is.Read(b)
+	}
+
+	// The helper goroutine reads in its own buffer: it may outlive the call
+	if len(this.buffer) < len(b) {
+		this.buffer = make([]byte, len(b))
+	}
+
+	go func(buf []byte) {
+		n, err := this.is.Read(buf)
+		this.results <- readResult{n, err}
+	}(this.buffer[0:len(b)])
+
+	select {
+	case r := <-this.results:
+		copy(b, this.buffer[0:r.n])
+		return r.n, r.err
+
+	case <-this.canceled:
+		return 0, kanzi.Errorf(kanzi.ErrClosed, "Read canceled")
+	}
+}
+
+// Make the read in flight (if any) and the next reads fail. Must be called
+// at most once.
+func (this *cancelableInputStream) cancel() {
+	close(this.canceled)
+}
+
+func (this *cancelableInputStream) Close() error {
+	return this.is.Close()
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package net
+
+import (
+	"fmt"
+	"io"
+	"kanzi"
+	"kanzi/entropy"
+	"kanzi/function"
+	kio "kanzi/io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A CompressedConn wraps a net.Conn with a CompressedOutputStream (outgoing
+// traffic) and a CompressedInputStream (incoming traffic). Both directions
+// are independent so the connection is full duplex.
+// Upon first use, each end sends its stream header and checks that the header
+// sent by the peer uses the same transform and entropy codec (handshake).
+// Written data is buffered until a block is full or Flush() is called, so
+// Flush() must be called at message boundaries.
+// Errors are IOErrors of the kanzi/io package (see kio.IOError): a failed
+// handshake wraps kanzi.ErrUnsupported and a connection failure wraps
+// kanzi.ErrIO (and the net.Error, see errors.As).
+// A read error is fatal since it can happen in the middle of a block: this
+// includes a read deadline timeout, the following reads return the same error.
+
+const (
+	DEFAULT_BLOCK_SIZE = 16 * 1024
+	CLOSE_TIMEOUT      = 5 * time.Second // time given to Close to send the pending data
+)
+
+type CompressedConn struct {
+	conn          net.Conn
+	cos           *kio.CompressedOutputStream
+	cis           *kio.CompressedInputStream
+	entropyType   byte
+	transformType byte
+	handshakeLock sync.Mutex // held during the handshake
+	stateLock     sync.Mutex // short held, protects the handshake state and closed
+	handshakeDone bool
+	handshakeErr  error
+	readLock      sync.Mutex
+	writeLock     sync.Mutex
+	readErr       error // first read error, returned by all following reads
+	eof           bool
+	closed        bool
+}
+
+// Adapters to prevent the compressed streams from closing the connection
+type connWriter struct {
+	conn net.Conn
+}
+
+func (this connWriter) Write(b []byte) (int, error) {
+	return this.conn.Write(b)
+}
+
+func (this connWriter) Close() error {
+	return nil
+}
+
+type connReader struct {
+	conn net.Conn
+}
+
+func (this connReader) Read(b []byte) (int, error) {
+	return this.conn.Read(b)
+}
+
+func (this connReader) Close() error {
+	return nil
+}
+
+// The codec names are the ones used by the BlockCompressor (EG. "LZ4" and
+// "Huffman"). Blocks are processed by one job only to avoid waiting for
+// several blocks before returning data to the reader.
+func NewCompressedConn(conn net.Conn, entropyCodec string, functionType string,
+	blockSize uint, checksum bool) (*CompressedConn, error) {
+	if conn == nil {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid null connection parameter")
+	}
+
+	this := new(CompressedConn)
+	this.conn = conn
+	var err error
+
+	if this.entropyType, this.transformType, err = getTypes(entropyCodec, functionType); err != nil {
+		return nil, err
+	}
+
+	if this.cos, err = kio.NewCompressedOutputStream(entropyCodec, functionType,
+		connWriter{conn: conn}, blockSize, checksum, nil, 1); err != nil {
+		return nil, err
+	}
+
+	if this.cis, err = kio.NewCompressedInputStream(connReader{conn: conn}, nil, 1); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Check the codec names (the factories panic on unknown names)
+func getTypes(entropyCodec, functionType string) (eType byte, tType byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = kio.PanicIOError(r, kio.ERR_INVALID_CODEC)
+		}
+	}()
+
+	eType = entropy.GetEntropyCodecType(entropyCodec)
+	tType = function.GetByteFunctionType(functionType)
+	return eType, tType, nil
+}
+
+// Exchange the stream headers with the peer and check that both ends use
+// the same transform and entropy codec. Called implicitly by Read, Write
+// and Flush. Both ends of the connection must perform the handshake.
+func (this *CompressedConn) Handshake() error {
+	this.handshakeLock.Lock()
+	defer this.handshakeLock.Unlock()
+
+	if done, err := this.handshakeState(); done == true {
+		return err
+	}
+
+	err := this.handshake()
+	this.stateLock.Lock()
+	this.handshakeDone = true
+	this.handshakeErr = err
+	this.stateLock.Unlock()
+	return err
+}
+
+func (this *CompressedConn) handshakeState() (bool, error) {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+	return this.handshakeDone, this.handshakeErr
+}
+
+func (this *CompressedConn) handshake() error {
+	writeRes := make(chan error)
+
+	// Write the local header concurrently since the peer may not read it
+	// before it has written its own header (EG. synchronous pipes)
+	go func() {
+		this.writeLock.Lock()
+		defer this.writeLock.Unlock()
+		writeRes <- this.cos.Flush()
+	}()
+
+	readErr := this.readHeader()
+
+	if err := <-writeRes; err != nil {
+		return err
+	}
+
+	if readErr != nil {
+		return readErr
+	}
+
+	if this.cis.GetEntropyType() != this.entropyType {
+		errMsg := fmt.Sprintf("Handshake failed: local entropy codec is %v, remote entropy codec is %v",
+			entropy.GetEntropyCodecName(this.entropyType), entropy.GetEntropyCodecName(this.cis.GetEntropyType()))
+		return kio.NewIOError(errMsg, kio.ERR_INVALID_CODEC)
+	}
+
+	// Only the 5 lsb of the transform type are stored in the header
+	if this.cis.GetTransformType() != this.transformType&0x1F {
+		errMsg := fmt.Sprintf("Handshake failed: local transform is %v, remote transform is %v",
+			function.GetByteFunctionName(this.transformType), function.GetByteFunctionName(this.cis.GetTransformType()))
+		return kio.NewIOError(errMsg, kio.ERR_INVALID_CODEC)
+	}
+
+	return nil
+}
+
+func (this *CompressedConn) readHeader() (err error) {
+	this.readLock.Lock()
+	defer this.readLock.Unlock()
+
+	// ReadHeader panics on read errors
+	defer func() {
+		if r := recover(); r != nil {
+			if e, isErr := r.(error); isErr == true {
+				err = e
+			} else {
+				err = kio.PanicIOError(r, kio.ERR_READ_FILE)
+			}
+		}
+	}()
+
+	return this.cis.ReadHeader()
+}
+
+// Implement the net.Conn interface. Block until at least one byte is
+// available, then return the bytes decoded so far (at most len(b)).
+func (this *CompressedConn) Read(b []byte) (int, error) {
+	if err := this.Handshake(); err != nil {
+		return 0, err
+	}
+
+	this.readLock.Lock()
+	defer this.readLock.Unlock()
+
+	if this.readErr != nil {
+		return 0, this.readErr
+	}
+
+	if this.eof == true {
+		return 0, io.EOF
+	}
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	n, err := this.read(b)
+
+	if err != nil {
+		this.readErr = err
+	}
+
+	return n, err
+}
+
+func (this *CompressedConn) read(b []byte) (int, error) {
+	n := 0
+
+	if this.cis.Available() == 0 {
+		// Decode the next block
+		read, err := this.cis.Read(b[0:1])
+
+		if err != nil {
+			return 0, err
+		}
+
+		if read <= 0 {
+			// The peer has closed its output stream
+			this.eof = true
+			return 0, io.EOF
+		}
+
+		n = 1
+	}
+
+	// Return what is available without decoding more blocks
+	remaining := this.cis.Available()
+
+	if remaining > len(b)-n {
+		remaining = len(b) - n
+	}
+
+	if remaining > 0 {
+		read, err := this.cis.Read(b[n : n+remaining])
+
+		if read > 0 {
+			n += read
+		}
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Implement the net.Conn interface. The data is buffered and only sent when
+// a block is full or when Flush() is called.
+func (this *CompressedConn) Write(b []byte) (int, error) {
+	if err := this.Handshake(); err != nil {
+		return 0, err
+	}
+
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+	return this.cos.Write(b)
+}
+
+// Send all buffered data to the peer (EG. at the end of a message)
+func (this *CompressedConn) Flush() error {
+	if err := this.Handshake(); err != nil {
+		return err
+	}
+
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+	return this.cos.Flush()
+}
+
+// Implement the net.Conn interface. Flush the pending data, send the end of
+// stream marker and close the connection. The data is sent only if the
+// handshake has succeeded and the peer reads it within CLOSE_TIMEOUT.
+func (this *CompressedConn) Close() error {
+	this.stateLock.Lock()
+
+	if this.closed == true {
+		this.stateLock.Unlock()
+		return nil
+	}
+
+	this.closed = true
+	handshakeOK := this.handshakeDone == true && this.handshakeErr == nil
+	this.stateLock.Unlock()
+	var err error
+
+	if handshakeOK == true {
+		// The deadline also unblocks a pending write holding the lock
+		this.conn.SetWriteDeadline(time.Now().Add(CLOSE_TIMEOUT))
+		this.writeLock.Lock()
+		err = this.cos.Close()
+		this.writeLock.Unlock()
+	}
+
+	// Unblock pending reads (and a handshake in progress)
+	if err2 := this.conn.Close(); err == nil {
+		err = err2
+	}
+
+	this.readLock.Lock()
+	this.cis.Close()
+	this.readLock.Unlock()
+	return err
+}
+
+func (this *CompressedConn) LocalAddr() net.Addr {
+	return this.conn.LocalAddr()
+}
+
+func (this *CompressedConn) RemoteAddr() net.Addr {
+	return this.conn.RemoteAddr()
+}
+
+func (this *CompressedConn) SetDeadline(t time.Time) error {
+	return this.conn.SetDeadline(t)
+}
+
+func (this *CompressedConn) SetReadDeadline(t time.Time) error {
+	return this.conn.SetReadDeadline(t)
+}
+
+func (this *CompressedConn) SetWriteDeadline(t time.Time) error {
+	return this.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"kanzi"
+	"kanzi/audio"
+	"math"
+	"math/rand"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestAudioCodec\n\n")
+
+	fmt.Printf("Predictor test\n")
+	TestPredictor()
+
+	fmt.Printf("\nRound trip test\n")
+	TestRoundTrip()
+
+	fmt.Printf("\nWAV test\n")
+	TestWAV()
+
+	fmt.Printf("\nInvalid data test\n")
+	TestInvalidData()
+}
+
+func TestPredictor() {
+	rnd := rand.New(rand.NewSource(1))
+	samples := make([]int, 1000)
+
+	for i := range samples {
+		samples[i] = int(8000*math.Sin(float64(i)/15)) + rnd.Intn(64) - 32
+	}
+
+	lpc := audio.ComputeLPC(samples, 8)
+	coefs, shift := audio.QuantizeLPC(lpc[7], 12)
+
+	for order := -1; order <= audio.MAX_FIXED_ORDER; order++ {
+		predictor, err := audio.NewFixedPredictor(order)
+
+		if order < 0 {
+			expectError("Invalid fixed order", err, kanzi.ErrInvalidParam)
+			continue
+		}
+
+		checkPredictor(predictor, samples)
+	}
+
+	predictor, err := audio.NewLPCPredictor(coefs, shift)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	energy := checkPredictor(predictor, samples)
+	fmt.Printf("%-40s Success (order %d, shift %d, residual energy %.3f%%)\n", "LPC:",
+		predictor.Order(), predictor.Shift(), 100*energy)
+
+	if energy > 0.01 || predictor.Precision() > 12 {
+		fmt.Printf("Failure: poor prediction\n")
+		os.Exit(1)
+	}
+
+	_, err = audio.NewLPCPredictor(make([]int, audio.MAX_LPC_ORDER+1), 0)
+	expectError("Invalid order", err, kanzi.ErrInvalidParam)
+	_, err = audio.NewLPCPredictor([]int{1 << 15}, 0)
+	expectError("Invalid coefficient", err, kanzi.ErrInvalidParam)
+	_, _, err = predictor.Forward(samples[0:3], make([]int, 3))
+	expectError("Not enough samples", err, kanzi.ErrInvalidParam)
+}
+
+// Check the inverse of the predictor, return the relative energy of the residuals
+func checkPredictor(predictor *audio.LPCPredictor, samples []int) float64 {
+	residual := make([]int, predictor.MaxEncodedLen(len(samples)))
+	reverse := make([]int, len(samples))
+	predictor.Forward(samples, residual)
+
+	if _, _, err := predictor.Inverse(residual, reverse); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	in, out := 0.0, 0.0
+
+	for i := range samples {
+		if samples[i] != reverse[i] {
+			fmt.Printf("Failure: order %d, different sample at index %d\n", predictor.Order(), i)
+			os.Exit(1)
+		}
+
+		if i >= predictor.Order() {
+			in += float64(samples[i]) * float64(samples[i])
+			out += float64(residual[i]) * float64(residual[i])
+		}
+	}
+
+	return out / in
+}
+
+func TestRoundTrip() {
+	for _, bps := range []int{8, 16, 24, 32} {
+		for _, channels := range []int{1, 2, 3} {
+			for _, length := range []int{0, 1, 15, 1000, 10007} {
+				input := createAudio(channels, bps, length, int64(length))
+				roundTrip(input, 1024, audio.DEFAULT_LPC_ORDER)
+			}
+
+			fmt.Printf("%-40s Success\n", fmt.Sprintf("%d bits, %d channel(s):", bps, channels))
+		}
+	}
+
+	// Silence, extreme values and noise
+	for _, bps := range []int{8, 16, 24, 32} {
+		input, _ := audio.NewAudio(2, bps, 8000, 5000)
+		rnd := rand.New(rand.NewSource(int64(bps)))
+
+		for i := 2000; i < 5000; i++ {
+			input.Samples[0][i] = -1 << (bps - 1)
+			input.Samples[1][i] = 1<<(bps-1) - 1
+
+			if i >= 4000 {
+				input.Samples[0][i] = int(rnd.Int63n(1<<bps)) - 1<<(bps-1)
+				input.Samples[1][i] = -input.Samples[0][i] - 1
+			}
+		}
+
+		roundTrip(input, 1000, 8)
+	}
+
+	fmt.Printf("%-40s Success\n", "Extreme values:")
+
+	// Compression of a stereo 16 bit signal
+	input := createAudio(2, 16, 44100, 7)
+	rawSize := input.Length() * 4
+	sizes := make([]int, 0)
+
+	for _, order := range []int{0, 8, 32} {
+		for _, blockSize := range []int{576, 4096} {
+			sizes = append(sizes, roundTrip(input, blockSize, order))
+		}
+	}
+
+	fmt.Printf("%-40s Success (%d bytes -> %v bytes)\n", "Compression:", rawSize, sizes)
+
+	if sizes[3] >= rawSize/2 || sizes[3] >= sizes[1] {
+		fmt.Printf("Failure: poor compression\n")
+		os.Exit(1)
+	}
+}
+
+// Encode and decode the audio, return the size of the encoded data
+func roundTrip(input *audio.Audio, blockSize, order int) int {
+	encoder, err := audio.NewAudioEncoder(blockSize, order)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	data, err := encoder.Encode(input)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	decoder, _ := audio.NewAudioDecoder()
+	output, err := decoder.Decode(data)
+
+	if err != nil {
+		fmt.Printf("Failure: %d channel(s), %d bits, %d samples: %v\n", input.Channels(), input.BitsPerSample,
+			input.Length(), err)
+		os.Exit(1)
+	}
+
+	checkAudio(input, output)
+	return len(data)
+}
+
+func checkAudio(input, output *audio.Audio) {
+	if output.Channels() != input.Channels() || output.Length() != input.Length() ||
+		output.BitsPerSample != input.BitsPerSample || output.SampleRate != input.SampleRate {
+		fmt.Printf("Failure: different format\n")
+		os.Exit(1)
+	}
+
+	for c := range input.Samples {
+		for i, v := range input.Samples[c] {
+			if output.Samples[c][i] != v {
+				fmt.Printf("Failure: %d bits, different sample at index %d of channel %d: %d instead of %d\n",
+					input.BitsPerSample, i, c, output.Samples[c][i], v)
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+func TestWAV() {
+	for _, bps := range []int{8, 16, 24, 32} {
+		input := createAudio(2, bps, 999, 3)
+		var buf bytes.Buffer
+
+		if err := audio.WriteWAV(&buf, input); err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		if buf.Len() != 44+999*2*bps/8 {
+			fmt.Printf("Failure: invalid WAV size: %d\n", buf.Len())
+			os.Exit(1)
+		}
+
+		// Insert an unknown chunk before the data chunk
+		data := buf.Bytes()
+		chunk := []byte{'L', 'I', 'S', 'T', 3, 0, 0, 0, 1, 2, 3, 0}
+		data = append(append(append([]byte(nil), data[0:36]...), chunk...), data[36:]...)
+		output, err := audio.ReadWAV(bytes.NewReader(data))
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		checkAudio(input, output)
+		fmt.Printf("%-40s Success\n", fmt.Sprintf("%d bits:", bps))
+	}
+
+	var buf bytes.Buffer
+	audio.WriteWAV(&buf, createAudio(1, 16, 10, 1))
+	data := buf.Bytes()
+	_, err := audio.ReadWAV(bytes.NewReader(data[0:30]))
+	expectError("Truncated WAV", err, kanzi.ErrCorruptData)
+	data[20] = 3 // IEEE float
+	_, err = audio.ReadWAV(bytes.NewReader(data))
+	expectError("Unsupported WAV format", err, kanzi.ErrUnsupported)
+	_, err = audio.ReadWAV(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00AVI LIST")))
+	expectError("Not a WAV file", err, kanzi.ErrCorruptData)
+}
+
+func TestInvalidData() {
+	_, err := audio.NewAudioEncoder(8, 8)
+	expectError("Invalid block size", err, kanzi.ErrInvalidParam)
+	_, err = audio.NewAudioEncoder(1024, audio.MAX_LPC_ORDER+1)
+	expectError("Invalid order", err, kanzi.ErrInvalidParam)
+	_, err = audio.NewAudio(9, 16, 44100, 10)
+	expectError("Invalid channels", err, kanzi.ErrInvalidParam)
+	_, err = audio.NewAudio(2, 12, 44100, 10)
+	expectError("Invalid bits per sample", err, kanzi.ErrInvalidParam)
+
+	input := createAudio(2, 16, 5000, 9)
+	encoder, _ := audio.NewAudioEncoder(1024, 8)
+	input.Samples[1][10] = 40000
+	_, err = encoder.Encode(input)
+	expectError("Invalid sample", err, kanzi.ErrInvalidParam)
+	input.Samples[1][10] = 0
+
+	data, _ := encoder.Encode(input)
+	decoder, _ := audio.NewAudioDecoder()
+	_, err = decoder.Decode(data[0:10])
+	expectError("Truncated header", err, kanzi.ErrCorruptData)
+	_, err = decoder.Decode(data[0 : len(data)-1])
+	expectError("Truncated data", err, kanzi.ErrCorruptData)
+	_, err = decoder.Decode(append(append([]byte(nil), data...), 0))
+	expectError("Extra data", err, kanzi.ErrCorruptData)
+	damaged := append([]byte(nil), data...)
+	damaged[6] = 24
+	_, err = decoder.Decode(damaged)
+	expectError("Damaged header", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], data...)
+	damaged[4] = 2
+	_, err = decoder.Decode(damaged)
+	expectError("Unknown version", err, kanzi.ErrUnsupported)
+
+	// Every damaged byte of the frames is detected
+	rnd := rand.New(rand.NewSource(10))
+
+	for i := 0; i < 200; i++ {
+		damaged = append(damaged[:0], data...)
+		damaged[23+rnd.Intn(len(data)-23)] ^= byte(1 + rnd.Intn(255))
+
+		if _, err = decoder.Decode(damaged); err == nil || errors.Is(err, kanzi.ErrCorruptData) == false {
+			fmt.Printf("Failure: damaged data not detected: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%-40s Success\n", "Damaged frames:")
+}
+
+// Create a mix of tones with noise, the channels are correlated
+func createAudio(channels, bps, length int, seed int64) *audio.Audio {
+	res, _ := audio.NewAudio(channels, bps, 44100, length)
+	rnd := rand.New(rand.NewSource(seed))
+	amplitude := float64(int(1)<<(bps-1)) * 0.3
+
+	for i := 0; i < length; i++ {
+		t := float64(i) / 44100
+		base := math.Sin(2*math.Pi*440*t) + 0.5*math.Sin(2*math.Pi*1250*t+1) + 0.2*math.Sin(2*math.Pi*97*t)
+
+		for c := 0; c < channels; c++ {
+			val := base + 0.1*float64(c)*math.Sin(2*math.Pi*3000*t) + 0.001*rnd.NormFloat64()
+			res.Samples[c][i] = int(amplitude * val)
+		}
+	}
+
+	return res
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success (%v)\n", name+":", err)
+}
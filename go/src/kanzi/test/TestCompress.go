@@ -0,0 +1,503 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"kanzi"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"runtime"
+	"time"
+)
+
+// In memory stream
+type byteStream struct {
+	bytes.Buffer
+}
+
+func (this *byteStream) Close() error {
+	return nil
+}
+
+func main() {
+	fmt.Printf("TestCompress\n\n")
+
+	fmt.Printf("One-shot test\n")
+	TestOneShot()
+
+	fmt.Printf("\nChecksum types test\n")
+	TestChecksumTypes()
+
+	fmt.Printf("\nStored blocks checksum test\n")
+	TestStoredBlocks()
+
+	fmt.Printf("\nInvalid input test\n")
+	TestInvalid()
+
+	fmt.Printf("\nLimits test\n")
+	TestLimits()
+
+	fmt.Printf("\nReset test\n")
+	TestReset()
+
+	fmt.Printf("\nSmall payloads test\n")
+	TestSmallPayloads(10000)
+}
+
+func getData(rnd *rand.Rand, size int) []byte {
+	data := make([]byte, size)
+
+	for i := range data {
+		// Compressible data
+		data[i] = byte(65 + rnd.Intn(4*(1+i%8)))
+	}
+
+	return data
+}
+
+func TestOneShot() {
+	rnd := rand.New(rand.NewSource(12345))
+	sizes := []int{0, 1, 15, 16, 100, 1023, 1025, 65536, 1500000}
+	options := []*kio.Options{nil,
+		&kio.Options{Entropy: "ANS", Transform: "LZ4", Checksum: true},
+		&kio.Options{Entropy: "CM", Transform: "BWT", BlockSize: 64 * 1024, Jobs: 4}}
+	prefix := []byte("prefix")
+
+	for _, opts := range options {
+		for _, size := range sizes {
+			input := getData(rnd, size)
+
+			// The output is appended to the destination slice
+			dst := append([]byte(nil), prefix...)
+			compressed, err := kio.Compress(dst, input, opts)
+
+			if err != nil {
+				fmt.Printf("Compression error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if !bytes.Equal(compressed[0:len(prefix)], prefix) {
+				fmt.Printf("Failure: the destination prefix was modified\n")
+				os.Exit(1)
+			}
+
+			decompressed, err := kio.Decompress(prefix, compressed[len(prefix):], opts)
+
+			if err != nil {
+				fmt.Printf("Decompression error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if !bytes.Equal(decompressed[0:len(prefix)], prefix) || !bytes.Equal(decompressed[len(prefix):], input) {
+				fmt.Printf("Failure: different data (size %v)\n", size)
+				os.Exit(1)
+			}
+		}
+
+		if opts == nil {
+			fmt.Printf("Default options: success\n")
+		} else {
+			fmt.Printf("%v+%v: success\n", opts.Transform, opts.Entropy)
+		}
+	}
+
+	// Functions of the kanzi package (registered by kanzi/io)
+	input := getData(rnd, 100000)
+	compressed, err := kanzi.Compress(prefix, input, &kio.Options{Transform: "LZ4", Jobs: 2})
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	decompressed, err := kanzi.Decompress(nil, compressed[len(prefix):], nil)
+
+	if err != nil || !bytes.Equal(decompressed, input) {
+		fmt.Printf("Failure: kanzi.Decompress: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err = kanzi.Compress(nil, input, "LZ4"); errors.Is(err, kanzi.ErrInvalidParam) == false {
+		fmt.Printf("Failure: no error for invalid options: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("kanzi.Compress: success\n")
+}
+
+// Record the size of the block checksums
+type hashListener struct {
+	sizes map[int]bool
+}
+
+func (this *hashListener) ProcessEvent(evt *kio.BlockEvent) {
+	if evt.Hashing() == true {
+		this.sizes[len(evt.Hash())] = true
+	}
+}
+
+func TestChecksumTypes() {
+	input := getData(rand.New(rand.NewSource(7)), 100000)
+	expectedSizes := map[string]int{"XXHASH32": 4, "XXHASH64": 8, "CRC32C": 4, "SHA256": 32}
+	_, privateKey, _ := kio.GenerateSigningKey()
+
+	for _, name := range []string{"XXHASH32", "XXHASH64", "CRC32C", "SHA256"} {
+		// No transform nor entropy coding: a modified byte of the stream
+		// is a modified byte of the block
+		opts := &kio.Options{Entropy: "None", Transform: "None", BlockSize: 16 * 1024, ChecksumAlgorithm: name}
+		compressed, err := kio.Compress(nil, input, opts)
+
+		if err != nil {
+			fmt.Printf("Compression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		decompressed, err := kio.Decompress(nil, compressed, nil)
+
+		if err != nil || !bytes.Equal(decompressed, input) {
+			fmt.Printf("Failure: %v: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		compressed[len(compressed)/2] ^= 0x10
+
+		if _, err = kio.Decompress(nil, compressed, nil); errors.Is(err, kanzi.ErrCorruptData) == false {
+			fmt.Printf("Failure: %v: modified block not detected: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		// The listeners receive checksums of the right size
+		var buf byteStream
+		listener := &hashListener{sizes: make(map[int]bool)}
+		checksumType, _ := kio.GetChecksumType(name)
+		cos, _ := kio.NewCompressedOutputStream("ANS", "BWT", &buf, 16*1024, false, nil, 2)
+		cos.SetChecksumType(checksumType)
+		cos.SetPassword([]byte("password"))
+		cos.SetSigningKey(privateKey)
+		cos.AddListener(listener)
+		cos.Write(input)
+
+		if err = cos.Close(); err != nil {
+			fmt.Printf("Compression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(listener.sizes) != 1 || listener.sizes[expectedSizes[name]] == false {
+			fmt.Printf("Failure: %v: unexpected checksum sizes %v\n", name, listener.sizes)
+			os.Exit(1)
+		}
+
+		// The checksum type is authenticated with the header of encrypted streams
+		decompressed, err = kio.Decompress(nil, buf.Bytes(), &kio.Options{Password: []byte("password"),
+			VerifyKey: privateKey.Public().(ed25519.PublicKey)})
+
+		if err != nil || !bytes.Equal(decompressed, input) {
+			fmt.Printf("Failure: %v (encrypted and signed stream): %v\n", name, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8v: success\n", name)
+	}
+
+	if _, err := kio.GetChecksumType("MD5"); errors.Is(err, kanzi.ErrUnsupported) {
+		fmt.Printf("Unknown checksum type: %v (as expected)\n", err)
+	} else {
+		fmt.Printf("Failure: no error for an unknown checksum type\n")
+		os.Exit(1)
+	}
+}
+
+// The blocks copied without transform (small blocks and blocks the transform
+// cannot process) are also verified
+func TestStoredBlocks() {
+	rnd := rand.New(rand.NewSource(3))
+	noise := make([]byte, 16*1024)
+	rnd.Read(noise)
+	inputs := map[string][]byte{"small block": []byte("0123456789"), "skipped transform": noise}
+
+	for _, name := range []string{"small block", "skipped transform"} {
+		input := inputs[name]
+		opts := &kio.Options{Entropy: "None", Transform: "LZ4", BlockSize: 16 * 1024, ChecksumAlgorithm: "XXHASH32"}
+		compressed, err := kio.Compress(nil, input, opts)
+
+		if err != nil {
+			fmt.Printf("Compression error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// The block is stored as is: modify one of its bytes
+		idx := bytes.Index(compressed, input[0:8])
+
+		if idx < 0 {
+			fmt.Printf("Failure: %v: block not stored\n", name)
+			os.Exit(1)
+		}
+
+		compressed[idx+4] ^= 0x10
+
+		if _, err = kio.Decompress(nil, compressed, nil); errors.Is(err, kanzi.ErrCorruptData) == false {
+			fmt.Printf("Failure: %v: modified block not detected: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-17v: success\n", name)
+	}
+}
+
+func TestInvalid() {
+	input := getData(rand.New(rand.NewSource(1)), 10000)
+
+	if _, err := kio.Compress(nil, input, &kio.Options{Entropy: "Unknown"}); err != nil {
+		fmt.Printf("Unknown codec: %v (as expected)\n", err)
+	} else {
+		fmt.Printf("Failure: no error for an unknown codec\n")
+		os.Exit(1)
+	}
+
+	compressed, _ := kio.Compress(nil, input, nil)
+	dst := []byte("unchanged")
+
+	for _, n := range []int{0, 5, 12, len(compressed) / 2} {
+		res, err := kio.Decompress(dst, compressed[0:n], nil)
+
+		if err == nil || !bytes.Equal(res, dst) {
+			fmt.Printf("Failure: no error for a truncated input (%v bytes)\n", n)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Truncated input (%v bytes): %v (as expected)\n", n, err)
+	}
+}
+
+// Decode a small stream expanding to 16 MB with various limits
+func TestLimits() {
+	input := make([]byte, 16*1024*1024)
+	compressed, err := kio.Compress(nil, input, &kio.Options{Transform: "RLT", BlockSize: 4 * 1024 * 1024})
+
+	if err != nil {
+		fmt.Printf("Compression error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%v bytes compressed to %v bytes\n", len(input), len(compressed))
+	limits := []kio.DecodingLimits{
+		kio.DecodingLimits{},
+		kio.DecodingLimits{MaxOutputSize: uint64(len(input))},
+		kio.DecodingLimits{MaxOutputSize: 1000000},
+		kio.DecodingLimits{MaxRatio: 1000},
+		kio.DecodingLimits{MaxBlockSize: 1024 * 1024},
+	}
+	expected := []int{0, 0, kio.ERR_OUTPUT_LIMIT, kio.ERR_RATIO_LIMIT, kio.ERR_BLOCK_SIZE_LIMIT}
+
+	for i := range limits {
+		output, err := kio.Decompress(nil, compressed, &kio.Options{Limits: limits[i]})
+		code := 0
+
+		if err != nil {
+			ioerr, isIOErr := err.(*kio.IOError)
+
+			if isIOErr == false {
+				fmt.Printf("Failure: unexpected error: %v\n", err)
+				os.Exit(1)
+			}
+
+			code = ioerr.ErrorCode()
+		} else if len(output) != len(input) {
+			fmt.Printf("Failure: different data\n")
+			os.Exit(1)
+		}
+
+		if code != expected[i] {
+			fmt.Printf("Failure: expected error code %v, got %v (%v)\n", expected[i], code, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%+v: ", limits[i])
+
+		if err == nil {
+			fmt.Printf("success\n")
+		} else {
+			fmt.Printf("%v (as expected)\n", err)
+		}
+	}
+
+	// The blocks are not decoded beyond the output limit: the memory used
+	// depends on the limit, not on the block size declared by the stream
+	var stats1, stats2 runtime.MemStats
+	runtime.ReadMemStats(&stats1)
+	_, err = kio.Decompress(nil, compressed, &kio.Options{Jobs: 4, Limits: kio.DecodingLimits{MaxOutputSize: 100000}})
+	runtime.ReadMemStats(&stats2)
+	allocated := stats2.TotalAlloc - stats1.TotalAlloc
+
+	if ioerr, isIOErr := err.(*kio.IOError); isIOErr == false || ioerr.ErrorCode() != kio.ERR_OUTPUT_LIMIT {
+		fmt.Printf("Failure: expected error code %v, got %v\n", kio.ERR_OUTPUT_LIMIT, err)
+		os.Exit(1)
+	}
+
+	if allocated > 4*1024*1024 {
+		fmt.Printf("Failure: %v bytes allocated to decode 100000 bytes\n", allocated)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Output limit of 100000 bytes with 4 MB blocks: %v bytes allocated\n", allocated)
+}
+
+// Reuse one stream of each kind for several payloads, including a payload
+// abandoned in the middle of the stream
+func TestReset() {
+	rnd := rand.New(rand.NewSource(12345))
+	bs := &byteStream{}
+	cos, err := kio.NewCompressedOutputStream("FPAQ", "BWT", bs, 16*1024, true, nil, 2)
+
+	if err != nil {
+		fmt.Printf("Cannot create compressed stream: %v\n", err)
+		os.Exit(1)
+	}
+
+	cis, err := kio.NewCompressedInputStream(&byteStream{}, nil, 2)
+
+	if err != nil {
+		fmt.Printf("Cannot create compressed stream: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i := 0; i < 20; i++ {
+		input := getData(rnd, rnd.Intn(100000))
+		bs.Reset()
+		cos.Reset(bs)
+
+		if i%5 == 4 {
+			// Abandon a stream, then reset
+			cos.Write(input)
+			bs.Reset()
+			cos.Reset(bs)
+		}
+
+		if _, err = cos.Write(input); err == nil {
+			err = cos.Close()
+		}
+
+		if err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Compressed streams with different block sizes (the header is read again)
+		if i%3 == 2 {
+			compressed, _ := kio.Compress(nil, input, &kio.Options{BlockSize: 32 * 1024})
+			bs.Reset()
+			bs.Write(compressed)
+		}
+
+		if i%5 == 3 {
+			// Abandon a stream in the middle, then reset
+			other := &byteStream{}
+			other.Write(bs.Bytes())
+			cis.Reset(other)
+			cis.Read(make([]byte, 10))
+		}
+
+		cis.Reset(bs)
+		output := make([]byte, len(input)+1)
+		n := 0
+
+		for n < len(output) {
+			read, err := cis.Read(output[n:])
+
+			if err != nil {
+				fmt.Printf("Decoding error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if read < 0 {
+				break
+			}
+
+			n += read
+		}
+
+		if n != len(input) || !bytes.Equal(input, output[0:n]) {
+			fmt.Printf("Failure: different data (payload %v)\n", i)
+			os.Exit(1)
+		}
+	}
+
+	cis.Close()
+	fmt.Printf("Success\n")
+}
+
+// Compare the one-shot functions with reusable streams on small payloads
+func TestSmallPayloads(count int) {
+	rnd := rand.New(rand.NewSource(12345))
+	payloads := make([][]byte, 100)
+
+	for i := range payloads {
+		payloads[i] = getData(rnd, 50+rnd.Intn(500))
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	mallocs := stats.Mallocs
+	before := time.Now()
+	var buffer []byte
+
+	for i := 0; i < count; i++ {
+		payload := payloads[i%len(payloads)]
+		buffer, _ = kio.Compress(buffer[:0], payload, nil)
+	}
+
+	delta := time.Now().Sub(before).Nanoseconds()
+	runtime.ReadMemStats(&stats)
+	fmt.Printf("Compress:    %6d ns/payload, %5.1f allocs/payload\n",
+		delta/int64(count), float64(stats.Mallocs-mallocs)/float64(count))
+
+	bs := &byteStream{}
+	cos, err := kio.NewCompressedOutputStream("Huffman", "BWT+MTF", bs, 1024, false, nil, 1)
+
+	if err != nil {
+		fmt.Printf("Cannot create compressed stream: %v\n", err)
+		os.Exit(1)
+	}
+
+	runtime.ReadMemStats(&stats)
+	mallocs = stats.Mallocs
+	before = time.Now()
+
+	for i := 0; i < count; i++ {
+		payload := payloads[i%len(payloads)]
+		bs.Reset()
+		cos.Reset(bs)
+
+		if _, err = cos.Write(payload); err == nil {
+			err = cos.Close()
+		}
+
+		if err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	delta = time.Now().Sub(before).Nanoseconds()
+	runtime.ReadMemStats(&stats)
+	fmt.Printf("Stream.Reset:%6d ns/payload, %5.1f allocs/payload\n",
+		delta/int64(count), float64(stats.Mallocs-mallocs)/float64(count))
+}
@@ -0,0 +1,412 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	kio "kanzi/io"
+	knet "kanzi/net"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestCompressedConn\n\n")
+
+	fmt.Printf("Echo test over net.Pipe\n")
+	c1, c2 := net.Pipe()
+	TestEcho(c1, c2, "Huffman", "LZ4")
+
+	fmt.Printf("Echo test over loopback TCP\n")
+	c1, c2 = tcpPair()
+	TestEcho(c1, c2, "Huffman", "LZ4")
+	c1, c2 = tcpPair()
+	TestEcho(c1, c2, "Range", "BWT")
+
+	fmt.Printf("Handshake mismatch test\n")
+	TestHandshakeMismatch()
+
+	fmt.Printf("Deadline test\n")
+	TestDeadline()
+
+	fmt.Printf("Close tests\n")
+	TestCloseSilentPeer()
+	TestCloseBlockedPeer()
+
+	fmt.Printf("Flush test\n")
+	TestFlushVersion()
+}
+
+// In memory stream
+type bufferStream struct {
+	bytes.Buffer
+}
+
+func (this *bufferStream) Close() error {
+	return nil
+}
+
+// The blocks of a flushed stream end on a byte boundary: the stream must not
+// be readable by a decoder of the version 0 of the format (no padding)
+func TestFlushVersion() {
+	msg := bytes.Repeat([]byte("flushed message "), 100)
+	bs := &bufferStream{}
+	cos, _ := kio.NewCompressedOutputStream("Huffman", "LZ4", bs, 64*1024, false, nil, 1)
+
+	if _, err := cos.Write(msg); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := cos.Flush(); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Magic (32 bits) then version (7 bits)
+	if data := bs.Bytes(); len(data) < 5 || binary.BigEndian.Uint32(data) != kio.BITSTREAM_TYPE || data[4]>>1 == 0 {
+		fmt.Printf("Failure: the flushed stream is not written with version 1 or above\n")
+		os.Exit(1)
+	}
+
+	cis, _ := kio.NewCompressedInputStream(bs, nil, 1)
+	read := make([]byte, len(msg))
+
+	if _, err := io.ReadFull(cis, read); err != nil || bytes.Equal(msg, read) == false {
+		fmt.Printf("Failure: cannot read the flushed message: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Flushed stream version: Success\n")
+}
+
+// Fail if f does not return within the timeout
+func checkReturns(name string, timeout time.Duration, f func() error) error {
+	res := make(chan error)
+
+	go func() {
+		res <- f()
+	}()
+
+	select {
+	case err := <-res:
+		return err
+
+	case <-time.After(timeout):
+		fmt.Printf("Failure: %v is blocked\n", name)
+		os.Exit(1)
+	}
+
+	return nil
+}
+
+func tcpPair() (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		fmt.Printf("Cannot listen on loopback interface: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer listener.Close()
+	accepted := make(chan net.Conn)
+
+	go func() {
+		c, err := listener.Accept()
+
+		if err != nil {
+			fmt.Printf("Cannot accept connection: %v\n", err)
+			os.Exit(1)
+		}
+
+		accepted <- c
+	}()
+
+	c1, err := net.Dial("tcp", listener.Addr().String())
+
+	if err != nil {
+		fmt.Printf("Cannot connect: %v\n", err)
+		os.Exit(1)
+	}
+
+	return c1, <-accepted
+}
+
+func newConn(c net.Conn, entropy, transform string) *knet.CompressedConn {
+	cc, err := knet.NewCompressedConn(c, entropy, transform, knet.DEFAULT_BLOCK_SIZE, true)
+
+	if err != nil {
+		fmt.Printf("Cannot create compressed connection: %v\n", err)
+		os.Exit(1)
+	}
+
+	return cc
+}
+
+// Messages are prefixed with their length
+func writeMessage(cc *knet.CompressedConn, msg []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(msg)))
+
+	if _, err := cc.Write(header[:]); err != nil {
+		return err
+	}
+
+	if _, err := cc.Write(msg); err != nil {
+		return err
+	}
+
+	return cc.Flush()
+}
+
+func readMessage(cc *knet.CompressedConn) ([]byte, error) {
+	var header [4]byte
+
+	if _, err := io.ReadFull(cc, header[:]); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint32(header[:]))
+
+	if _, err := io.ReadFull(cc, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func TestEcho(c1, c2 net.Conn, entropy, transform string) {
+	client := newConn(c1, entropy, transform)
+	server := newConn(c2, entropy, transform)
+	done := make(chan error)
+
+	// Echo server
+	go func() {
+		for {
+			msg, err := readMessage(server)
+
+			if err == io.EOF {
+				// The client has closed the connection, the end of stream
+				// marker of the server cannot be delivered
+				server.Close()
+				done <- nil
+				return
+			}
+
+			if err != nil {
+				done <- err
+				return
+			}
+
+			if err = writeMessage(server, msg); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	total := 0
+
+	for ii := 0; ii < 200; ii++ {
+		var msg []byte
+
+		switch ii % 4 {
+		case 0:
+			// Tiny message (small block)
+			msg = make([]byte, 1+rnd.Intn(15))
+			rnd.Read(msg)
+
+		case 1:
+			// Random (incompressible) message
+			msg = make([]byte, rnd.Intn(3*knet.DEFAULT_BLOCK_SIZE))
+			rnd.Read(msg)
+
+		default:
+			// Compressible message
+			msg = make([]byte, rnd.Intn(5*knet.DEFAULT_BLOCK_SIZE))
+
+			for i := range msg {
+				msg[i] = byte(65 + rnd.Intn(4*(1+ii%3)))
+			}
+		}
+
+		if err := writeMessage(client, msg); err != nil {
+			fmt.Printf("Failed to write message %v: %v\n", ii, err)
+			os.Exit(1)
+		}
+
+		res, err := readMessage(client)
+
+		if err != nil {
+			fmt.Printf("Failed to read message %v: %v\n", ii, err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(msg, res) == false {
+			fmt.Printf("Different message %v (sizes %v and %v)\n", ii, len(msg), len(res))
+			os.Exit(1)
+		}
+
+		total += len(msg)
+	}
+
+	if err := client.Close(); err != nil {
+		fmt.Printf("Failed to close client connection: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := <-done; err != nil {
+		fmt.Printf("Server failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Identical (%v bytes echoed)\n\n", total)
+}
+
+func TestHandshakeMismatch() {
+	c1, c2 := net.Pipe()
+	client := newConn(c1, "Huffman", "LZ4")
+	server := newConn(c2, "Huffman", "None")
+	res := make(chan error)
+
+	go func() {
+		res <- server.Handshake()
+	}()
+
+	err1 := client.Handshake()
+	err2 := <-res
+
+	if err1 == nil || err2 == nil {
+		fmt.Printf("Handshake should have failed\n")
+		os.Exit(1)
+	}
+
+	if ioerr, isIOErr := err1.(*kio.IOError); isIOErr == false || ioerr.ErrorCode() != kio.ERR_INVALID_CODEC {
+		fmt.Printf("Unexpected handshake error: %v\n", err1)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Handshake failed as expected: %v\n\n", err1)
+	client.Close()
+	server.Close()
+}
+
+func TestDeadline() {
+	c1, c2 := tcpPair()
+	client := newConn(c1, "Huffman", "LZ4")
+	server := newConn(c2, "Huffman", "LZ4")
+	res := make(chan error)
+
+	go func() {
+		res <- server.Handshake()
+	}()
+
+	if err := client.Handshake(); err != nil {
+		fmt.Printf("Handshake failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	<-res
+
+	// The server never answers
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	before := time.Now()
+	buf := make([]byte, 16)
+	_, err := client.Read(buf)
+
+	if err == nil {
+		fmt.Printf("Read should have timed out\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Read failed after %v ms as expected: %v\n", time.Now().Sub(before).Nanoseconds()/1000000, err)
+
+	// The timeout is fatal even if the peer sends data afterwards
+	client.SetReadDeadline(time.Time{})
+	writeMessage(server, []byte("late message"))
+	_, err2 := client.Read(buf)
+
+	if err2 != err {
+		fmt.Printf("Read after timeout should have failed with %v, got %v\n", err, err2)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Read after timeout failed as expected\n\n")
+	client.Close()
+	server.Close()
+}
+
+// The peer never sends its header: Close must abort the pending handshake
+func TestCloseSilentPeer() {
+	c1, c2 := tcpPair()
+	defer c2.Close()
+	client := newConn(c1, "Huffman", "LZ4")
+	res := make(chan error)
+
+	go func() {
+		res <- client.Handshake()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	checkReturns("Close during handshake", 5*time.Second, client.Close)
+
+	if err := checkReturns("Handshake after Close", 5*time.Second, func() error { return <-res }); err == nil {
+		fmt.Printf("Handshake should have failed\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Close during handshake: Success\n")
+}
+
+// The peer stops reading: Close must not wait for the pending data forever
+func TestCloseBlockedPeer() {
+	c1, c2 := net.Pipe()
+	client := newConn(c1, "Huffman", "None")
+	server := newConn(c2, "Huffman", "None")
+	res := make(chan error)
+
+	go func() {
+		res <- server.Handshake()
+	}()
+
+	if err := client.Handshake(); err != nil {
+		fmt.Printf("Handshake failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	<-res
+
+	// Fill a block (incompressible data) so that the write blocks on the pipe
+	data := make([]byte, 4*knet.DEFAULT_BLOCK_SIZE)
+	rand.New(rand.NewSource(1)).Read(data)
+	go client.Write(data)
+	time.Sleep(100 * time.Millisecond)
+	before := time.Now()
+
+	if err := checkReturns("Close with a blocked peer", knet.CLOSE_TIMEOUT+5*time.Second, client.Close); err == nil {
+		fmt.Printf("Close should have failed to send the pending data\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Close with a blocked peer: Success (%v ms)\n\n", time.Now().Sub(before).Nanoseconds()/1000000)
+	server.Close()
+}
@@ -0,0 +1,507 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Streams written by the encoder of the version 0 of the bitstream format
+// (4 KB blocks, data from getFixtureData)
+//
+//go:embed data/v0_*.knz
+var fixtures embed.FS
+
+// In memory stream
+type byteStream struct {
+	bytes.Buffer
+}
+
+func (this *byteStream) Close() error {
+	return nil
+}
+
+func main() {
+	var entropy = flag.String("entropy", "CM", "entropy codec")
+	var transform = flag.String("transform", "BWT", "transform")
+	var size = flag.Int("size", 16*1024*1024, "size of the test data")
+	var chunk = flag.Int("chunk", 1024, "size of the chunks passed to Write and Read")
+	flag.Parse()
+
+	fmt.Printf("TestCompressedStream\n\n")
+	TestBlockedSource()
+	TestFailedClose()
+	TestPanic()
+	TestVersion0()
+	TestJobCounts()
+	TestAllocations()
+	fmt.Printf("\nCodecs: %v+%v, data size: %v, chunk size: %v, available CPUs: %v\n\n",
+		*transform, *entropy, *size, *chunk, runtime.NumCPU())
+	TestSpeed(*entropy, *transform, *size, *chunk)
+}
+
+// The data of the version 0 fixtures
+func getFixtureData() []byte {
+	words := []string{"kanzi ", "stream ", "block ", "entropy ", "transform ", "version ", "\n"}
+	data := make([]byte, 0, 10000)
+	seed := uint32(12345)
+
+	for len(data) < 10000 {
+		seed = seed*1103515245 + 12345
+		data = append(data, words[(seed>>16)%uint32(len(words))]...)
+	}
+
+	return data[0:10000]
+}
+
+func decompress(compressed []byte, jobs uint) ([]byte, error) {
+	cis, err := kio.NewCompressedInputStream(&byteStream{*bytes.NewBuffer(compressed)}, nil, jobs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	buf := make([]byte, 1000)
+
+	for {
+		n, err := cis.Read(buf)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n <= 0 {
+			break
+		}
+
+		output.Write(buf[0:n])
+	}
+
+	return output.Bytes(), cis.Close()
+}
+
+// The streams of the previous version of the format can still be decoded
+func TestVersion0() {
+	expected := getFixtureData()
+	entries, _ := fixtures.ReadDir("data")
+
+	if len(entries) == 0 {
+		fmt.Printf("Failure: no version 0 fixture\n")
+		os.Exit(1)
+	}
+
+	for _, entry := range entries {
+		compressed, _ := fixtures.ReadFile("data/" + entry.Name())
+
+		for _, jobs := range []uint{1, 3} {
+			output, err := decompress(compressed, jobs)
+
+			if err != nil || bytes.Equal(output, expected) == false {
+				fmt.Printf("Failure: %v (%v jobs): %v\n", entry.Name(), jobs, err)
+				os.Exit(1)
+			}
+		}
+
+		codecs := strings.Split(strings.TrimSuffix(strings.TrimPrefix(entry.Name(), "v0_"), ".knz"), "_")
+		fmt.Printf("Version 0 stream %-8s %-8s: Success\n", codecs[0], codecs[1])
+	}
+
+	fmt.Println()
+}
+
+// The blocks of the stream do not depend on the number of jobs of the encoder
+// and the decoder
+func TestJobCounts() {
+	input := getData(300000)
+
+	for _, codecs := range [][2]string{{"LZ4", "Huffman"}, {"BWT", "ANS"}, {"None", "FPAQ"}} {
+		for _, encJobs := range []uint{1, 3, 8} {
+			bs := &byteStream{}
+			cos, _ := kio.NewCompressedOutputStream(codecs[1], codecs[0], bs, 16*1024, true, nil, encJobs)
+			cos.Write(input)
+
+			if err := cos.Close(); err != nil {
+				fmt.Printf("Encoding error: %v\n", err)
+				os.Exit(1)
+			}
+
+			for _, decJobs := range []uint{1, 2, 5} {
+				if output, err := decompress(bs.Bytes(), decJobs); err != nil || bytes.Equal(output, input) == false {
+					fmt.Printf("Failure: %v+%v, %v encoding jobs, %v decoding jobs: %v\n",
+						codecs[0], codecs[1], encJobs, decJobs, err)
+					os.Exit(1)
+				}
+			}
+		}
+
+		fmt.Printf("Encoding jobs 1,3,8 / decoding jobs 1,2,5 %-4s %-8s: Success\n", codecs[0], codecs[1])
+	}
+
+	fmt.Println()
+}
+
+// Input stream returning some data, then blocking forever: Close does not
+// unblock a read in flight (like an HTTP response body)
+type blockedStream struct {
+	data    []byte
+	blocked chan bool
+}
+
+func (this *blockedStream) Read(b []byte) (int, error) {
+	if len(this.data) == 0 {
+		<-this.blocked
+	}
+
+	n := copy(b, this.data)
+	this.data = this.data[n:]
+	return n, nil
+}
+
+func (this *blockedStream) Close() error {
+	return nil
+}
+
+// Close and Reset must not wait for the reads of the input stream
+func TestBlockedSource() {
+	input := getData(1024 * 1024)
+	bs := &byteStream{}
+	cos, _ := kio.NewCompressedOutputStream("Huffman", "LZ4", bs, 64*1024, false, nil, 4)
+	cos.Write(input)
+	cos.Close()
+	compressed := bs.Bytes()
+
+	for _, reset := range []bool{false, true} {
+		source := &blockedStream{data: compressed[0 : len(compressed)/2], blocked: make(chan bool)}
+		cis, err := kio.NewCompressedInputStream(source, nil, 4)
+
+		if err != nil {
+			fmt.Printf("Cannot create compressed stream: %v\n", err)
+			os.Exit(1)
+		}
+
+		output := make([]byte, 64*1024)
+
+		if n, err := cis.Read(output); err != nil || bytes.Equal(input[0:n], output[0:n]) == false {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		done := make(chan error)
+
+		go func() {
+			if reset == true {
+				done <- cis.Reset(&byteStream{})
+			} else {
+				done <- cis.Close()
+			}
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				fmt.Printf("Failure: %v\n", err)
+				os.Exit(1)
+			}
+
+		case <-time.After(10 * time.Second):
+			fmt.Printf("Failure: the stream is blocked by a read of the input stream (reset: %v)\n", reset)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Blocked input stream: Success\n\n")
+}
+
+// Output stream failing once 'limit' bytes are written
+type failingStream struct {
+	limit int
+}
+
+func (this *failingStream) Write(b []byte) (int, error) {
+	if this.limit -= len(b); this.limit < 0 {
+		return 0, errors.New("no space left")
+	}
+
+	return len(b), nil
+}
+
+func (this *failingStream) Close() error {
+	return nil
+}
+
+// Close must stop the workers and the writer when it fails
+func TestFailedClose() {
+	goroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		cos, _ := kio.NewCompressedOutputStream("Huffman", "LZ4", &failingStream{limit: 100000}, 64*1024, false, nil, 4)
+		cos.Write(getData(1024 * 1024))
+
+		if err := cos.Close(); err == nil {
+			fmt.Printf("Failure: no error from the output stream\n")
+			os.Exit(1)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			fmt.Printf("Failure: %v goroutines left after the failed streams\n", runtime.NumGoroutine()-goroutines)
+			os.Exit(1)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fmt.Printf("Failed close: Success\n\n")
+}
+
+// Block listener panicking after the transform of the second block
+type panickingListener struct{}
+
+func (this panickingListener) ProcessEvent(evt *kio.BlockEvent) {
+	if evt.BlockId() == 2 && evt.EventType() == kio.EVT_AFTER_TRANSFORM {
+		panic("listener failure")
+	}
+}
+
+// A panic in a worker (transform, listener) is an error of the stream
+func TestPanic() {
+	cos, _ := kio.NewCompressedOutputStream("Huffman", "BWT", &byteStream{}, 64*1024, false, nil, 4)
+	cos.AddListener(panickingListener{})
+	cos.Write(getData(1024 * 1024))
+
+	if err := cos.Close(); err == nil || strings.Contains(err.Error(), "listener failure") == false {
+		fmt.Printf("Failure: unexpected error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Panic in a worker: Success\n\n")
+}
+
+// Compressible data: random words from a small dictionary
+func getData(size int) []byte {
+	words := make([][]byte, 256)
+	rnd := rand.New(rand.NewSource(12345))
+
+	for i := range words {
+		words[i] = make([]byte, 2+rnd.Intn(10))
+
+		for j := range words[i] {
+			words[i][j] = byte(97 + rnd.Intn(26))
+		}
+	}
+
+	data := make([]byte, 0, size+16)
+
+	for len(data) < size {
+		data = append(data, words[rnd.Intn(len(words))]...)
+		data = append(data, ' ')
+	}
+
+	return data[0:size]
+}
+
+// Compress and decompress the data, return the number of memory allocations
+// and the number of bytes allocated
+func measureAllocations(entropy, transform string, input []byte, blockSize uint) (uint64, uint64) {
+	var before, after runtime.MemStats
+	output := make([]byte, len(input))
+	bs := &byteStream{}
+	bs.Grow(2*len(input) + 1024) // do not count the growth of the output
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	cos, err := kio.NewCompressedOutputStream(entropy, transform, bs, blockSize, false, nil, 1)
+
+	if err != nil {
+		fmt.Printf("Cannot create compressed stream: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err = cos.Write(input); err == nil {
+		err = cos.Close()
+	}
+
+	if err != nil {
+		fmt.Printf("Encoding error: %v\n", err)
+		os.Exit(1)
+	}
+
+	cis, err := kio.NewCompressedInputStream(bs, nil, 1)
+
+	if err != nil {
+		fmt.Printf("Cannot create compressed stream: %v\n", err)
+		os.Exit(1)
+	}
+
+	for n := 0; n < len(output); {
+		read, err := cis.Read(output[n:])
+
+		if err != nil {
+			fmt.Printf("Decoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		n += read
+	}
+
+	cis.Close()
+	runtime.ReadMemStats(&after)
+
+	if bytes.Equal(input, output) == false {
+		fmt.Printf("Failure: different data with %v+%v\n", transform, entropy)
+		os.Exit(1)
+	}
+
+	return after.Mallocs - before.Mallocs, after.TotalAlloc - before.TotalAlloc
+}
+
+// Show the memory allocated per block (compression + decompression). The
+// codecs and buffers are allocated once per stream, so the difference between
+// a stream of 8 blocks and a stream of 32 blocks is the cost of 24 blocks.
+func TestAllocations() {
+	const blockSize = 64 * 1024
+	transforms := []string{"None", "BWT", "BWTS", "BWT+MTF", "LZ4", "RLT"}
+	entropies := []string{"None", "Huffman", "ANS", "Range", "FPAQ", "PAQ", "CM"}
+	input := getData(32 * blockSize)
+	fmt.Printf("Allocations per block (block size: %v)\n\n", blockSize)
+
+	for _, transform := range transforms {
+		for _, entropy := range entropies {
+			mallocs1, bytes1 := measureAllocations(entropy, transform, input[0:8*blockSize], blockSize)
+			mallocs2, bytes2 := measureAllocations(entropy, transform, input, blockSize)
+			fmt.Printf("%-10s %-8s allocs/block: %6.1f  bytes/block: %9.1f  (stream: %5d allocs, %9d bytes)\n",
+				transform, entropy, float64(int64(mallocs2)-int64(mallocs1))/24,
+				float64(int64(bytes2)-int64(bytes1))/24, mallocs1, bytes1)
+		}
+	}
+}
+
+// Show the scaling of the compressed streams from 1 to 16 jobs. The data is
+// written and read in small chunks. With several CPUs, the encoding must be
+// faster with more jobs.
+func TestSpeed(entropy, transform string, size, chunk int) {
+	input := getData(size)
+	output := make([]byte, size)
+	delta0 := [2]int64{}
+	speedup := 0.0
+
+	for jobs := uint(1); jobs <= 16; jobs <<= 1 {
+		delta1 := int64(0)
+		delta2 := int64(0)
+		bs := &byteStream{}
+		cos, err := kio.NewCompressedOutputStream(entropy, transform, bs, 1024*1024, false, nil, jobs)
+
+		if err != nil {
+			fmt.Printf("Cannot create compressed stream: %v\n", err)
+			os.Exit(1)
+		}
+
+		before := time.Now()
+
+		for i := 0; i < len(input); i += chunk {
+			end := i + chunk
+
+			if end > len(input) {
+				end = len(input)
+			}
+
+			if _, err = cos.Write(input[i:end]); err != nil {
+				fmt.Printf("Encoding error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if err = cos.Close(); err != nil {
+			fmt.Printf("Encoding error: %v\n", err)
+			os.Exit(1)
+		}
+
+		after := time.Now()
+		delta1 += after.Sub(before).Nanoseconds()
+		compressed := bs.Len()
+		cis, err := kio.NewCompressedInputStream(bs, nil, jobs)
+
+		if err != nil {
+			fmt.Printf("Cannot create compressed stream: %v\n", err)
+			os.Exit(1)
+		}
+
+		before = time.Now()
+
+		for i := 0; i < len(output); i += chunk {
+			end := i + chunk
+
+			if end > len(output) {
+				end = len(output)
+			}
+
+			n, err := cis.Read(output[i:end])
+
+			if err != nil {
+				fmt.Printf("Decoding error: %v\n", err)
+				os.Exit(1)
+			}
+
+			if n != end-i {
+				fmt.Printf("Failure: read %v bytes instead of %v with %v jobs\n", n, end-i, jobs)
+				os.Exit(1)
+			}
+		}
+
+		after = time.Now()
+		delta2 += after.Sub(before).Nanoseconds()
+		cis.Close()
+
+		if bytes.Equal(input, output) == false {
+			fmt.Printf("Failure: different data with %v jobs\n", jobs)
+			os.Exit(1)
+		}
+
+		if jobs == 1 {
+			delta0[0] = delta1
+			delta0[1] = delta2
+		}
+
+		fmt.Printf("Jobs: %2d  ", jobs)
+		fmt.Printf("encoding [ms]: %5d (%3d MB/s, x%.2f)  ", delta1/1000000,
+			int64(size)*1000000/delta1*1000/(1024*1024), float64(delta0[0])/float64(delta1))
+		fmt.Printf("decoding [ms]: %5d (%3d MB/s, x%.2f)  ", delta2/1000000,
+			int64(size)*1000000/delta2*1000/(1024*1024), float64(delta0[1])/float64(delta2))
+		fmt.Printf("ratio: %.3f\n", float64(compressed)/float64(size))
+
+		if jobs <= uint(runtime.NumCPU()) {
+			speedup = max(speedup, float64(delta0[0])/float64(delta1))
+		}
+	}
+
+	if runtime.NumCPU() > 1 && speedup < 1.2 {
+		fmt.Printf("Failure: no encoding speed-up with %v CPUs (x%.2f)\n", runtime.NumCPU(), speedup)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"kanzi"
+	kio "kanzi/io"
+	"kanzi/util"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestEncryption\n\n")
+
+	fmt.Printf("Scrypt test vectors\n")
+	TestScrypt()
+
+	fmt.Printf("\nEncrypted streams test\n")
+	TestEncryptedStreams()
+}
+
+func TestScrypt() {
+	// RFC 7914, section 12
+	vectors := []struct {
+		password string
+		salt     string
+		N, r, p  int
+		expected string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442" +
+			"fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162" +
+			"2eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+
+	for i, v := range vectors {
+		key, err := util.Scrypt([]byte(v.password), []byte(v.salt), v.N, v.r, v.p, 64)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		if hex.EncodeToString(key) != v.expected {
+			fmt.Printf("Failure: vector %d, got %x\n", i, key)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Vector %d: Success\n", i)
+	}
+
+	if _, err := util.Scrypt([]byte("password"), nil, 1000, 8, 1, 32); err == nil {
+		fmt.Printf("Failure: invalid cost parameter accepted\n")
+		os.Exit(1)
+	}
+}
+
+// An output stream counting the bytes written
+type countingStream struct {
+	written int
+}
+
+func (this *countingStream) Write(b []byte) (int, error) {
+	this.written += len(b)
+	return len(b), nil
+}
+
+func (this *countingStream) Close() error {
+	return nil
+}
+
+func TestEncryptedStreams() {
+	input := make([]byte, 300000)
+
+	for i := range input {
+		input[i] = byte(65 + (i*7)%13 + (i>>10)%5)
+	}
+
+	password := []byte("correct horse battery staple")
+	var compressed []byte
+
+	for _, jobs := range []uint{1, 4} {
+		options := &kio.Options{BlockSize: 64 * 1024, Checksum: true, Jobs: jobs, Password: password}
+		var err error
+		compressed, err = kio.Compress(nil, input, options)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		output, err := kio.Decompress(nil, compressed, options)
+
+		if err != nil || bytes.Equal(input, output) == false {
+			fmt.Printf("Failure: jobs=%d: %v\n", jobs, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Roundtrip (jobs=%d): Success (%d => %d bytes)\n", jobs, len(input), len(compressed))
+	}
+
+	// The same data is encrypted differently (random salt and IV)
+	other, _ := kio.Compress(nil, input, &kio.Options{BlockSize: 64 * 1024, Checksum: true, Password: password})
+
+	if bytes.Equal(compressed, other) == true {
+		fmt.Printf("Failure: identical encrypted streams\n")
+		os.Exit(1)
+	}
+
+	if bytes.Contains(compressed, input[0:64]) == true {
+		fmt.Printf("Failure: the plaintext is visible in the encrypted stream\n")
+		os.Exit(1)
+	}
+
+	// The header is authenticated: nothing is emitted with a wrong password
+	written, err := decode(compressed, []byte("wrong password"))
+	expectError("Wrong password", written, err, kanzi.ErrAuthentication, kio.ERR_AUTHENTICATION)
+	written, err = decode(compressed, nil)
+	expectError("Missing password", written, err, kanzi.ErrInvalidParam, kio.ERR_MISSING_PASSWORD)
+
+	// The first block is tampered with: nothing is emitted
+	tampered := append([]byte(nil), compressed...)
+	tampered[len(tampered)/8] ^= 0x01
+	written, err = decode(tampered, password)
+	expectError("Tampered block", written, err, kanzi.ErrAuthentication, kio.ERR_AUTHENTICATION)
+
+	// Remove the end block (32 bits length and tag) and the end marker
+	truncated := compressed[0 : len(compressed)-4-kio.ENCRYPTION_TAG_SIZE-4]
+	truncated = append(append([]byte(nil), truncated...), 0, 0, 0, 0)
+	_, err = decode(truncated, password)
+	expectError("Truncated stream", 0, err, kanzi.ErrAuthentication, kio.ERR_AUTHENTICATION)
+
+	plain, _ := kio.Compress(nil, input, &kio.Options{BlockSize: 64 * 1024})
+	written, err = decode(plain, password)
+	expectError("Unencrypted stream", written, err, kanzi.ErrAuthentication, kio.ERR_AUTHENTICATION)
+
+	// Reset keeps the password (the key derivation is not repeated)
+	var buf bytes.Buffer
+	cos, _ := kio.NewCompressedOutputStream("ANS", "BWT", &bufferCloser{&buf}, 64*1024, false, nil, 1)
+
+	if err := cos.SetPassword(password); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		cos.Write(input[0:1000])
+
+		if err := cos.Close(); err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		output, err := kio.Decompress(nil, buf.Bytes(), &kio.Options{Password: password})
+
+		if err != nil || bytes.Equal(input[0:1000], output) == false {
+			fmt.Printf("Failure: reset %d: %v\n", i, err)
+			os.Exit(1)
+		}
+
+		cos.Reset(&bufferCloser{&buf})
+	}
+
+	fmt.Printf("Reset: Success\n")
+
+	// The cost of the key derivation read from the header is limited
+	// (the header is authenticated after the key derivation)
+	params := []uint64{kio.KDF_SCRYPT, kio.SCRYPT_LOG_N, kio.SCRYPT_R, kio.SCRYPT_P}
+	pos := findBits(compressed, params)
+
+	if pos < 0 {
+		fmt.Printf("Failure: key derivation parameters not found in the header\n")
+		os.Exit(1)
+	}
+
+	expensive := append([]byte(nil), compressed...)
+	setBits(expensive, pos+16, 8, kio.MAX_SCRYPT_R)
+	setBits(expensive, pos+24, 8, kio.MAX_SCRYPT_P)
+	written, err = decode(expensive, password)
+	expectError("Expensive KDF", written, err, kanzi.ErrLimitExceeded, kio.ERR_INVALID_FILE)
+}
+
+// Return the bit position of the given bytes in data, -1 if not found (the
+// fields of the header are not byte aligned)
+func findBits(data []byte, values []uint64) int {
+	for pos := 0; pos+8*len(values) <= 8*len(data); pos++ {
+		found := true
+
+		for i, v := range values {
+			if getBits(data, pos+8*i, 8) != v {
+				found = false
+				break
+			}
+		}
+
+		if found == true {
+			return pos
+		}
+	}
+
+	return -1
+}
+
+func getBits(data []byte, pos, length int) uint64 {
+	res := uint64(0)
+
+	for i := pos; i < pos+length; i++ {
+		res = (res << 1) | uint64(data[i>>3]>>(7-uint(i&7))&1)
+	}
+
+	return res
+}
+
+func setBits(data []byte, pos, length int, value uint64) {
+	for i := 0; i < length; i++ {
+		bit := byte(value>>uint(length-1-i)) & 1
+		idx := pos + i
+		data[idx>>3] = data[idx>>3]&^(1<<(7-uint(idx&7))) | bit<<(7-uint(idx&7))
+	}
+}
+
+type bufferCloser struct {
+	*bytes.Buffer
+}
+
+type readerCloser struct {
+	*bytes.Reader
+}
+
+func (this *readerCloser) Close() error {
+	return nil
+}
+
+func (this *bufferCloser) Close() error {
+	return nil
+}
+
+// Decode the stream and return the number of bytes emitted and the error
+func decode(compressed []byte, password []byte) (int, error) {
+	cis, err := kio.NewCompressedInputStream(&readerCloser{bytes.NewReader(compressed)}, nil, 2)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if password != nil {
+		cis.SetPassword(password)
+	}
+
+	output := &countingStream{}
+	block := make([]byte, 1<<20)
+
+	for {
+		n, err := cis.Read(block)
+
+		if err != nil {
+			return output.written, err
+		}
+
+		if n <= 0 {
+			return output.written, nil
+		}
+
+		output.Write(block[0:n])
+	}
+}
+
+func expectError(name string, written int, err error, kind error, code int) {
+	var ioerr *kio.IOError
+
+	if written != 0 {
+		fmt.Printf("Failure: %v: %d bytes emitted before the error\n", name, written)
+		os.Exit(1)
+	}
+
+	if err == nil || errors.Is(err, kind) == false || errors.As(err, &ioerr) == false || ioerr.ErrorCode() != code {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-20s Success (%v)\n", name+":", err)
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/function"
+	kio "kanzi/io"
+	"os"
+	"strings"
+)
+
+// An input stream failing after a few bytes
+type failingStream struct {
+	data []byte
+}
+
+type streamError struct {
+	msg string
+}
+
+func (this *streamError) Error() string {
+	return this.msg
+}
+
+func (this *failingStream) Read(b []byte) (int, error) {
+	if len(this.data) == 0 {
+		return 0, &streamError{"Disk failure"}
+	}
+
+	n := copy(b, this.data)
+	this.data = this.data[n:]
+	return n, nil
+}
+
+func (this *failingStream) Write(b []byte) (int, error) {
+	return 0, &streamError{"Disk full"}
+}
+
+func (this *failingStream) Close() error {
+	return nil
+}
+
+// An output stream discarding the data
+type discardStream struct {
+}
+
+func (this discardStream) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (this discardStream) Close() error {
+	return nil
+}
+
+func main() {
+	fmt.Printf("TestErrors\n\n")
+
+	fmt.Printf("Codec errors test\n")
+	TestCodecErrors()
+
+	fmt.Printf("\nStream errors test\n")
+	TestStreamErrors()
+}
+
+// Check the kind of an error and that it is the only sentinel error wrapped
+func check(name string, err error, expected error) {
+	if err == nil {
+		fmt.Printf("Failure: %v: no error\n", name)
+		os.Exit(1)
+	}
+
+	if errors.Is(err, expected) == false || kanzi.Kind(err) != expected {
+		fmt.Printf("Failure: %v: expected '%v', got '%v' (%v)\n", name, expected, kanzi.Kind(err), err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-22s %-28s %v\n", name+":", "["+expected.Error()+"]", err)
+}
+
+func TestCodecErrors() {
+	// The message is not modified by the kind
+	err := kanzi.Errorf(kanzi.ErrCorruptData, "Invalid value: %d", 5)
+
+	if err.Error() != "Invalid value: 5" {
+		fmt.Printf("Failure: unexpected message: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The cause is wrapped
+	cause := &streamError{"cause"}
+	err = kanzi.Errorf(kanzi.ErrIO, "Failure: %w", cause)
+	var se *streamError
+
+	if errors.As(err, &se) == false || se != cause || errors.Is(err, kanzi.ErrIO) == false {
+		fmt.Printf("Failure: the cause is not wrapped: %v\n", err)
+		os.Exit(1)
+	}
+
+	_, err = entropy.NewHuffmanEncoder(nil)
+	check("Null bitstream", err, kanzi.ErrInvalidParam)
+
+	_, err = entropy.NewEntropyDecoder(nil, 99)
+	check("Unknown entropy codec", err, kanzi.ErrUnsupported)
+
+	_, err = function.NewByteFunction(0, 15)
+	check("Unknown function", err, kanzi.ErrUnsupported)
+
+	lz4, _ := function.NewLZ4Codec(0)
+	_, _, err = lz4.Forward(make([]byte, 1000), make([]byte, 10))
+	check("Small LZ4 buffer", err, kanzi.ErrBufferTooSmall)
+
+	// Literal length of 0xF + 140000*0xFF bytes
+	invalid := bytes.Repeat([]byte{0xFF}, 140002)
+	invalid[0] = 0xF0
+	invalid[len(invalid)-1] = 0
+	_, _, err = lz4.Inverse(invalid, make([]byte, 1000))
+	check("Invalid LZ4 data", err, kanzi.ErrCorruptData)
+
+	// The bitstreams raise a panic when the underlying stream fails
+	ibs, _ := bitstream.NewDefaultInputBitStream(&failingStream{data: []byte{1, 2}}, 1024)
+	ibs.ReadBits(16)
+	check("Bitstream failure", recoverError(func() { ibs.ReadBits(8) }), kanzi.ErrIO)
+
+	ibs.Close()
+	_, err = ibs.HasMoreToRead()
+	check("Closed bitstream", err, kanzi.ErrClosed)
+}
+
+// Run f and return the error it panics with
+func recoverError(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	f()
+	return nil
+}
+
+func TestStreamErrors() {
+	input := make([]byte, 100000)
+
+	for i := range input {
+		input[i] = byte(65 + (i*7)%13)
+	}
+
+	_, err := kio.Compress(nil, input, &kio.Options{Entropy: "Unknown"})
+	check("Unknown codec name", err, kanzi.ErrUnsupported)
+
+	_, err = kio.Compress(nil, input, &kio.Options{BlockSize: 1000})
+	check("Invalid block size", err, kanzi.ErrInvalidParam)
+
+	compressed, _ := kio.Compress(nil, input, &kio.Options{BlockSize: 16 * 1024, Checksum: true})
+
+	_, err = kio.Decompress(nil, []byte("Not a compressed stream"), nil)
+	check("Invalid stream type", err, kanzi.ErrCorruptData)
+
+	_, err = kio.Decompress(nil, compressed[0:len(compressed)/2], nil)
+	check("Truncated stream", err, kanzi.ErrCorruptData)
+
+	corrupted := append([]byte(nil), compressed...)
+	corrupted[len(corrupted)/2] ^= 0x55
+	_, err = kio.Decompress(nil, corrupted, nil)
+	check("Corrupted block", err, kanzi.ErrCorruptData)
+
+	// Version 127 of the format
+	corrupted = append([]byte(nil), compressed...)
+	corrupted[4] |= 0xFE
+	_, err = kio.Decompress(nil, corrupted, nil)
+	check("Unsupported version", err, kanzi.ErrUnsupported)
+
+	_, err = kio.Decompress(nil, compressed, &kio.Options{Limits: kio.DecodingLimits{MaxOutputSize: 1000}})
+	check("Output limit", err, kanzi.ErrLimitExceeded)
+
+	// The error codes are not modified
+	var ioerr *kio.IOError
+
+	if errors.As(err, &ioerr) == false || ioerr.ErrorCode() != kio.ERR_OUTPUT_LIMIT {
+		fmt.Printf("Failure: unexpected error code: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The error of the underlying stream is wrapped
+	cis, _ := kio.NewCompressedInputStream(&failingStream{data: compressed[0:100]}, nil, 1)
+	_, err = cis.Read(make([]byte, len(input)))
+	check("Stream failure", err, kanzi.ErrIO)
+	var se *streamError
+
+	if errors.As(err, &se) == false {
+		fmt.Printf("Failure: the stream error is not wrapped: %v\n", err)
+		os.Exit(1)
+	}
+
+	cis.Close()
+	_, err = cis.Read(make([]byte, 10))
+	check("Closed stream", err, kanzi.ErrClosed)
+
+	cos, _ := kio.NewCompressedOutputStream("Huffman", "BWT", &failingStream{}, 1024, false, nil, 1)
+	_, err = cos.Write(input)
+
+	if err == nil {
+		err = cos.Close()
+	}
+
+	check("Output failure", err, kanzi.ErrIO)
+	cos, _ = kio.NewCompressedOutputStream("Huffman", "BWT", discardStream{}, 1024, false, nil, 1)
+	cos.Close()
+	_, err = cos.Write(input)
+	check("Write after close", err, kanzi.ErrClosed)
+
+	// The valid stream can still be decoded
+	output, err := kio.Decompress(nil, compressed, nil)
+
+	if err != nil || bytes.Equal(input, output) == false {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The decoders fail on invalid data (EG. index out of range): the
+	// failure is reported as corrupt data, without the runtime details
+	for _, entropyCodec := range []string{"None", "Huffman"} {
+		compressed, _ = kio.Compress(nil, input, &kio.Options{Entropy: entropyCodec, Transform: "LZ4", BlockSize: 16 * 1024})
+		var decoderError error
+
+		for pos := 20; pos < len(compressed); pos += 7 {
+			corrupted = append(corrupted[:0], compressed...)
+			corrupted[pos] ^= 0x55
+			_, err = kio.Decompress(nil, corrupted, nil)
+
+			if err == nil {
+				continue
+			}
+
+			if kanzi.Kind(err) != kanzi.ErrCorruptData || strings.Contains(err.Error(), "runtime error") == true {
+				fmt.Printf("Failure: unexpected error (byte %d modified): %v\n", pos, err)
+				os.Exit(1)
+			}
+
+			if strings.Contains(err.Error(), "cannot be decoded") == true {
+				decoderError = err
+			}
+		}
+
+		check("Decoder failure", decoderError, kanzi.ErrCorruptData)
+	}
+}
@@ -0,0 +1,386 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"kanzi"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+	"runtime"
+)
+
+func main() {
+	fmt.Printf("TestExeFilter\n\n")
+
+	fmt.Printf("Correctness test\n")
+	TestCorrectness()
+
+	fmt.Printf("\nDetection test\n")
+	TestDetection()
+
+	fmt.Printf("\nCompressed stream test\n")
+	TestStream()
+
+	fmt.Printf("\nInvalid data test\n")
+	TestInvalidData()
+}
+
+func TestCorrectness() {
+	rnd := rand.New(rand.NewSource(1))
+	modes := []int{function.EXE_NONE, function.EXE_X86, function.EXE_ARM64, function.EXE_DETECT}
+	names := []string{"none", "x86", "ARM64", "detect"}
+
+	// Any data can be filtered: mostly opcode and displacement bytes, so
+	// that many branches overlap
+	for m, mode := range modes {
+		for _, size := range []int{0, 1, 4, 5, 6, 9, 100, 1000, 65536} {
+			for n := 0; n < 20; n++ {
+				input := make([]byte, size)
+
+				for i := range input {
+					input[i] = []byte{0xE8, 0xE9, 0x00, 0xFF, 0x94, 0x97, byte(rnd.Intn(256))}[rnd.Intn(7)]
+				}
+
+				roundTrip(input, mode, function.NULL_TRANSFORM_TYPE)
+			}
+		}
+
+		fmt.Printf("%-40s Success\n", "Mode "+names[m]+":")
+	}
+
+	input := append(createX86(100000, 2), createARM64(100000, 3)...)
+
+	for _, stage := range []string{"NONE", "LZ4", "RLT", "BWT+MTF", "BWTS+MTF"} {
+		nextType := function.GetByteFunctionType(stage)
+
+		for _, size := range []int{1, 1000, 100000, 200000} {
+			roundTrip(input[0:size], function.EXE_DETECT, nextType)
+			roundTrip(input[len(input)-size:], function.EXE_DETECT, nextType)
+		}
+
+		fmt.Printf("%-40s Success\n", "Next stage "+stage+":")
+	}
+}
+
+// Forward and inverse filter, return the filtered block
+func roundTrip(input []byte, mode int, nextType byte) []byte {
+	filter, err := function.NewExeFilter(uint(len(input)), mode, nextType)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	required := filter.MaxEncodedLen(len(input))
+
+	if required < 0 {
+		// Max size unknown (RLT)
+		required = 2*len(input) + 64
+	}
+
+	output := make([]byte, required)
+	_, dstIdx, err := filter.Forward(input, output)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	if (mode != function.EXE_DETECT && int(output[0]) != mode) || output[1] != nextType {
+		fmt.Printf("Failure: invalid header: %v\n", output[0:function.EXE_HEADER_SIZE])
+		os.Exit(1)
+	}
+
+	// The decoder only knows the function type
+	reverse := make([]byte, len(input))
+	filter, _ = function.NewExeFilter(dstIdx, function.EXE_DETECT, function.NULL_TRANSFORM_TYPE)
+	_, n, err := filter.Inverse(output, reverse)
+
+	if err != nil || n != uint(len(input)) || bytes.Equal(input, reverse) == false {
+		fmt.Printf("Failure: mode %d, next stage %d, %d bytes: %v\n", mode, nextType, len(input), err)
+		os.Exit(1)
+	}
+
+	return output[0:dstIdx]
+}
+
+func TestDetection() {
+	rnd := rand.New(rand.NewSource(4))
+	random := make([]byte, 100000)
+	rnd.Read(random)
+	text := bytes.Repeat([]byte("Executables and shared libraries are a large part of the archives. "), 2000)
+	utf8 := bytes.Repeat([]byte("可执行文件和共享库是构建产物归档的重要组成部分。"), 2000)
+	inputs := [][]byte{createX86(100000, 5), createARM64(100000, 6), random, text, utf8, make([]byte, 100000),
+		createX86(255, 7)}
+	names := []string{"x86 code", "ARM64 code", "Random bytes", "Text", "UTF-8 text", "Zeros", "Small block"}
+	expected := []int{function.EXE_X86, function.EXE_ARM64, function.EXE_NONE, function.EXE_NONE,
+		function.EXE_NONE, function.EXE_NONE, function.EXE_NONE}
+
+	for i, input := range inputs {
+		output := roundTrip(input, function.EXE_DETECT, function.NULL_TRANSFORM_TYPE)
+
+		if int(output[0]) != expected[i] {
+			fmt.Printf("Failure: %v: mode %d instead of %d\n", names[i], output[0], expected[i])
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-40s Success (mode %d)\n", names[i]+":", output[0])
+	}
+
+	// This program
+	if input := readExecutable(); input != nil {
+		modes := make(map[int]int)
+
+		for i := 0; i < len(input); i += 1 << 20 {
+			block := input[i:min(i+1<<20, len(input))]
+			modes[int(roundTrip(block, function.EXE_DETECT, function.NULL_TRANSFORM_TYPE)[0])]++
+		}
+
+		mode := map[string]int{"amd64": function.EXE_X86, "386": function.EXE_X86, "arm64": function.EXE_ARM64}
+
+		if m, known := mode[runtime.GOARCH]; known == true && modes[m] == 0 {
+			fmt.Printf("Failure: %v code not detected: %v\n", runtime.GOARCH, modes)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-40s Success (blocks per mode: %v)\n", "Executable ("+runtime.GOARCH+"):", modes)
+	}
+}
+
+func TestStream() {
+	inputs := [][]byte{createX86(1<<20, 8), createARM64(1<<20, 9), readExecutable()}
+	names := []string{"x86 code", "ARM64 code", "Executable"}
+
+	for i, input := range inputs {
+		if input == nil {
+			continue
+		}
+
+		for _, pair := range [][2]string{{"LZ4", "Huffman"}, {"BWT+MTF", "ANS"}} {
+			var sizes [2]int
+
+			for j, transform := range []string{pair[0], "Exe+" + pair[0]} {
+				opts := &kio.Options{Entropy: pair[1], Transform: transform, BlockSize: 1024 * 1024}
+				compressed, err := kio.Compress(nil, input, opts)
+
+				if err != nil {
+					fmt.Printf("Failure: %v\n", err)
+					os.Exit(1)
+				}
+
+				output, err := kio.Decompress(nil, compressed, opts)
+
+				if err != nil || bytes.Equal(input, output) == false {
+					fmt.Printf("Failure: %v+%v: %v\n", transform, pair[1], err)
+					os.Exit(1)
+				}
+
+				sizes[j] = len(compressed)
+			}
+
+			fmt.Printf("%-40s Success (%d bytes -> %d bytes without filter, %d bytes with filter)\n",
+				names[i]+", "+pair[0]+":", len(input), sizes[0], sizes[1])
+
+			if sizes[1] >= sizes[0] {
+				fmt.Printf("Failure: the filter does not improve the compression\n")
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Data: the filter is not applied
+	input := bytes.Repeat([]byte("Relative call and jump targets hurt LZ4 and BWT ratios. "), 20000)
+	opts := &kio.Options{Entropy: "Huffman", Transform: "EXE", BlockSize: 256 * 1024}
+	compressed, _ := kio.Compress(nil, input, opts)
+	output, err := kio.Decompress(nil, compressed, opts)
+	check("Text", err == nil && bytes.Equal(input, output))
+
+	for _, name := range []string{"EXE", "EXE+LZ4", "EXE+BWT+MTF", "EXE+BWTS+MTF", "EXE+SNAPPY"} {
+		if res := function.GetByteFunctionName(function.GetByteFunctionType(name)); res != name {
+			fmt.Printf("Failure: unexpected function name: %v instead of %v\n", res, name)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%-40s Success\n", "Function names:")
+}
+
+func TestInvalidData() {
+	_, err := function.NewExeFilter(0, function.EXE_DETECT+1, function.NULL_TRANSFORM_TYPE)
+	expectError("Invalid mode", err, kanzi.ErrInvalidParam)
+	_, err = function.NewExeFilter(0, function.EXE_X86, function.SHUFFLE_TYPE)
+	expectError("Invalid next stage", err, kanzi.ErrInvalidParam)
+
+	for _, name := range []string{"EXE+SHUFFLE", "EXE+EXE", "EXE+BWT+RANK"} {
+		expectError("Function "+name, getTypeError(name), kanzi.ErrUnsupported)
+	}
+
+	input := createX86(1000, 10)
+	filter, _ := function.NewExeFilter(0, function.EXE_X86, function.LZ4_TYPE)
+	output := make([]byte, filter.MaxEncodedLen(len(input)))
+	_, dstIdx, _ := filter.Forward(input, output)
+	output = output[0:dstIdx]
+	reverse := make([]byte, len(input))
+	inverse, _ := function.NewExeFilter(0, function.EXE_DETECT, function.NULL_TRANSFORM_TYPE)
+
+	_, _, err = inverse.Inverse(output[0:1], reverse)
+	expectError("Truncated header", err, kanzi.ErrCorruptData)
+	damaged := append([]byte(nil), output...)
+	damaged[0] = function.EXE_DETECT
+	_, _, err = inverse.Inverse(damaged, reverse)
+	expectError("Invalid mode", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], output...)
+	damaged[1] = function.EXE_TYPE
+	_, _, err = inverse.Inverse(damaged, reverse)
+	expectError("Invalid next stage", err, kanzi.ErrCorruptData)
+	_, _, err = inverse.Inverse(output, output)
+	expectError("Same buffers", err, kanzi.ErrInvalidParam)
+
+	// The next stage cannot process the whole block
+	input = make([]byte, 65536)
+	rand.New(rand.NewSource(10)).Read(input)
+	filter, _ = function.NewExeFilter(0, function.EXE_X86, function.RLT_TYPE)
+	_, _, err = filter.Forward(input, make([]byte, 1000))
+	expectError("Small output of the next stage", err, kanzi.ErrBufferTooSmall)
+}
+
+// Create x86 like code: functions made of common instructions and of calls
+// and jumps to the other functions
+func createX86(size int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	res := make([]byte, 0, size+16)
+	targets := make([]int, 300)
+
+	for i := range targets {
+		targets[i] = rnd.Intn(size)
+	}
+
+	instructions := [][]byte{
+		{0x48, 0x89, 0xE5},       // mov rbp, rsp
+		{0x48, 0x8B, 0x45, 0xF8}, // mov rax, [rbp-8]
+		{0x48, 0x83, 0xC4, 0x20}, // add rsp, 32
+		{0x55},                   // push rbp
+		{0x5D},                   // pop rbp
+		{0xC3},                   // ret
+		{0x31, 0xC0},             // xor eax, eax
+		{0x85, 0xC0},             // test eax, eax
+		{0x74, 0x0A},             // je +10
+		{0x89, 0x7D, 0xFC},       // mov [rbp-4], edi
+	}
+
+	for len(res) < size {
+		if r := rnd.Intn(8); r < 2 {
+			// Call (often the same functions) or jump
+			target := targets[min(rnd.Intn(len(targets)), rnd.Intn(len(targets)))]
+			rel := int32(target - (len(res) + 5))
+			res = append(res, byte(0xE8+r))
+			res = binary.LittleEndian.AppendUint32(res, uint32(rel))
+		} else {
+			res = append(res, instructions[rnd.Intn(len(instructions))]...)
+		}
+	}
+
+	return res[0:size]
+}
+
+// Create ARM64 like code: common instructions and calls to functions
+func createARM64(size int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	res := make([]byte, 0, size)
+	targets := make([]int, 300)
+
+	for i := range targets {
+		targets[i] = rnd.Intn(size / 4)
+	}
+
+	instructions := []uint32{
+		0xA9BF7BFD, // stp x29, x30, [sp, #-16]!
+		0x910003FD, // mov x29, sp
+		0xA8C17BFD, // ldp x29, x30, [sp], #16
+		0xD65F03C0, // ret
+		0xAA0003E1, // mov x1, x0
+		0xF9400000, // ldr x0, [x0]
+		0xB9400FE0, // ldr w0, [sp, #12]
+		0x52800000, // mov w0, #0
+		0x34000060, // cbz w0, +12
+	}
+
+	for len(res)+4 <= size {
+		insn := instructions[rnd.Intn(len(instructions))]
+
+		if rnd.Intn(6) == 0 {
+			// Call (often the same functions)
+			target := targets[min(rnd.Intn(len(targets)), rnd.Intn(len(targets)))]
+			insn = 0x94000000 | (uint32(target-len(res)/4) & 0x03FFFFFF)
+		}
+
+		res = binary.LittleEndian.AppendUint32(res, insn)
+	}
+
+	return append(res, make([]byte, size-len(res))...)
+}
+
+// Return the content of this program (nil if not available)
+func readExecutable() []byte {
+	name, err := os.Executable()
+
+	if err != nil {
+		return nil
+	}
+
+	res, err := os.ReadFile(name)
+
+	if err != nil {
+		return nil
+	}
+
+	return res
+}
+
+func getTypeError(name string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, _ = r.(error)
+		}
+	}()
+
+	function.GetByteFunctionType(name)
+	return nil
+}
+
+func check(name string, ok bool) {
+	if ok == false {
+		fmt.Printf("Failure: %v\n", name)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success\n", name+":")
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success (%v)\n", name+":", err)
+}
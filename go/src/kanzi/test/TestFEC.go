@@ -0,0 +1,344 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"kanzi"
+	kio "kanzi/io"
+	"kanzi/util"
+	"math/rand"
+	"os"
+)
+
+// Size of the stream header (not protected by the parity shards)
+const HEADER_SIZE = kio.HEADER_SIZE + 2 + kio.FEC_HEADER_SIZE
+
+func main() {
+	fmt.Printf("TestFEC\n\n")
+
+	fmt.Printf("Reed-Solomon test\n")
+	TestReedSolomon()
+
+	fmt.Printf("\nBit flips test\n")
+	TestBitFlips()
+
+	fmt.Printf("\nBurst errors test\n")
+	TestBurstErrors()
+
+	fmt.Printf("\nRepair test\n")
+	TestRepair()
+
+	fmt.Printf("\nStream test\n")
+	TestStream()
+}
+
+func TestReedSolomon() {
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, config := range [][2]uint{{1, 1}, {4, 2}, {10, 4}, {16, 16}, {200, 55}} {
+		rs, err := util.NewReedSolomon(config[0], config[1])
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		n := int(config[0] + config[1])
+		shards := make([][]byte, n)
+		original := make([][]byte, n)
+		present := make([]bool, n)
+
+		for i := range shards {
+			shards[i] = make([]byte, 100)
+			rnd.Read(shards[i])
+		}
+
+		rs.Encode(shards)
+
+		for i := range shards {
+			original[i] = append([]byte(nil), shards[i]...)
+		}
+
+		for test := 0; test < 100; test++ {
+			// Damage up to parityShards shards
+			for i := range present {
+				present[i] = true
+			}
+
+			for i := rnd.Intn(int(config[1])) + 1; i > 0; i-- {
+				j := rnd.Intn(n)
+				present[j] = false
+				rnd.Read(shards[j])
+			}
+
+			if err := rs.Reconstruct(shards, present); err != nil {
+				fmt.Printf("Failure: %v\n", err)
+				os.Exit(1)
+			}
+
+			for i := range shards {
+				if bytes.Equal(shards[i], original[i]) == false {
+					fmt.Printf("Failure: %d+%d shards, shard %d not rebuilt\n", config[0], config[1], i)
+					os.Exit(1)
+				}
+			}
+		}
+
+		// One shard too many
+		for i := range present {
+			present[i] = i > int(config[1])
+		}
+
+		expectError(fmt.Sprintf("%d+%d shards", config[0], config[1]), rs.Reconstruct(shards, present),
+			kanzi.ErrCorruptData)
+	}
+
+	_, err := util.NewReedSolomon(200, 56)
+	expectError("Too many shards", err, kanzi.ErrInvalidParam)
+}
+
+func TestBitFlips() {
+	input := createInput(400000)
+	rnd := rand.New(rand.NewSource(2))
+
+	for _, jobs := range []uint{1, 4} {
+		options := &kio.Options{BlockSize: 64 * 1024, Checksum: true, Jobs: jobs, Redundancy: 25}
+		compressed, err := kio.Compress(nil, input, options)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		// About one flipped bit per 16 KB
+		flips := len(compressed) / 16384
+
+		for i := 0; i < flips; i++ {
+			pos := HEADER_SIZE + rnd.Intn(len(compressed)-HEADER_SIZE)
+			compressed[pos] ^= byte(1 << uint(rnd.Intn(8)))
+		}
+
+		output, repaired, err := decode(compressed, jobs)
+
+		if err != nil || bytes.Equal(input, output) == false {
+			fmt.Printf("Failure: jobs=%d: %v\n", jobs, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%d flipped bits in %d bytes, %d shards repaired (jobs=%d): Success\n", flips,
+			len(compressed), repaired, jobs)
+	}
+
+	// Without parity, a flipped bit is fatal
+	compressed, _ := kio.Compress(nil, input, &kio.Options{BlockSize: 64 * 1024, Checksum: true})
+	compressed[len(compressed)/2] ^= 0x10
+	_, _, err := decode(compressed, 1)
+	expectError("No redundancy", err, kanzi.ErrCorruptData)
+}
+
+func TestBurstErrors() {
+	input := createInput(400000)
+	rnd := rand.New(rand.NewSource(3))
+	options := &kio.Options{BlockSize: 128 * 1024, Transform: "LZ4", Redundancy: 10}
+	compressed, _ := kio.Compress(nil, input, options)
+
+	// 10% => 2 parity shards: any burst shorter than a shard is repaired
+	for test := 0; test < 5; test++ {
+		damaged := append([]byte(nil), compressed...)
+		pos := HEADER_SIZE + rnd.Intn(len(damaged)-HEADER_SIZE-kio.FEC_SHARD_SIZE)
+		rnd.Read(damaged[pos : pos+kio.FEC_SHARD_SIZE])
+		output, repaired, err := decode(damaged, 2)
+
+		if err != nil || bytes.Equal(input, output) == false || repaired == 0 {
+			fmt.Printf("Failure: burst at %d: %v\n", pos, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Burst of %d bytes at %d, %d shards repaired: Success\n", kio.FEC_SHARD_SIZE, pos, repaired)
+	}
+
+	// Truncated stream: the missing shards of the last frame are rebuilt
+	output, repaired, err := decode(compressed[0:len(compressed)-500], 1)
+
+	if err != nil || bytes.Equal(input, output) == false || repaired != 1 {
+		fmt.Printf("Failure: truncated stream: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Truncated stream: Success\n")
+
+	// A burst larger than the parity shards cannot be repaired
+	damaged := append([]byte(nil), compressed...)
+	clear(damaged[HEADER_SIZE+100 : HEADER_SIZE+100+3*kio.FEC_SHARD_SIZE])
+	_, _, err = decode(damaged, 1)
+	expectError("Burst too large", err, kanzi.ErrCorruptData)
+}
+
+func TestRepair() {
+	input := createInput(200000)
+	publicKey, privateKey, _ := kio.GenerateSigningKey()
+	options := &kio.Options{BlockSize: 32 * 1024, Redundancy: 20, Password: []byte("password"),
+		SigningKey: privateKey, VerifyKey: publicKey, Jobs: 2}
+	compressed, err := kio.Compress(nil, input, options)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	damaged := append([]byte(nil), compressed...)
+	rnd := rand.New(rand.NewSource(4))
+
+	for i := 0; i < 10; i++ {
+		damaged[HEADER_SIZE+rnd.Intn(len(damaged)-HEADER_SIZE)] ^= 0x80
+	}
+
+	// The damaged stream is authenticated once repaired
+	if err = kio.VerifyEmbedded(bytes.NewReader(damaged), publicKey); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	if output, err := kio.Decompress(nil, damaged, options); err != nil || bytes.Equal(input, output) == false {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Encrypted and signed stream: Success\n")
+	var buf bytes.Buffer
+	repaired, err := kio.Repair(bytes.NewReader(damaged), &buf)
+
+	if err != nil || bytes.Equal(buf.Bytes(), compressed) == false {
+		fmt.Printf("Failure: the repaired stream differs from the original stream: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Repaired stream (%d shards): Success\n", repaired)
+	unprotected, _ := kio.Compress(nil, input, nil)
+	_, err = kio.Repair(bytes.NewReader(unprotected), &buf)
+	expectError("Unprotected stream", err, kanzi.ErrCorruptData)
+}
+
+func TestStream() {
+	input := createInput(100000)
+	var buf bytes.Buffer
+	cos, _ := kio.NewCompressedOutputStream("ANS", "BWT", &bufferCloser{&buf}, 16*1024, true, nil, 2)
+
+	if err := cos.SetRedundancy(kio.MAX_REDUNDANCY + 1); errors.Is(err, kanzi.ErrInvalidParam) == false {
+		fmt.Printf("Failure: invalid redundancy accepted: %v\n", err)
+		os.Exit(1)
+	}
+
+	cos.SetRedundancy(50)
+
+	// Flush writes partially filled frames, Reset keeps the redundancy
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		cos.Write(input[0 : 30000+i*1000])
+		cos.Flush()
+		cos.Write(input[30000+i*1000:])
+
+		if err := cos.Close(); err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		if buf.Bytes()[kio.HEADER_SIZE+1]&kio.FEC_MASK == 0 {
+			fmt.Printf("Failure: reset %d: the stream is not protected\n", i)
+			os.Exit(1)
+		}
+
+		damaged := append([]byte(nil), buf.Bytes()...)
+		clear(damaged[len(damaged)/2 : len(damaged)/2+2000])
+		output, _, err := decode(damaged, 1)
+
+		if err != nil || bytes.Equal(input, output) == false {
+			fmt.Printf("Failure: reset %d: %v\n", i, err)
+			os.Exit(1)
+		}
+
+		cos.Reset(&bufferCloser{&buf})
+	}
+
+	fmt.Printf("Flush and reset: Success\n")
+}
+
+// Decode the stream, return the data and the number of shards repaired
+func decode(compressed []byte, jobs uint) ([]byte, uint64, error) {
+	cis, err := kio.NewCompressedInputStream(&readerCloser{bytes.NewReader(compressed)}, nil, jobs)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var output bytes.Buffer
+	buffer := make([]byte, 65536)
+
+	for {
+		n, err := cis.Read(buffer)
+
+		if err != nil {
+			return nil, cis.GetRepaired(), err
+		}
+
+		if n < 0 {
+			break
+		}
+
+		output.Write(buffer[0:n])
+	}
+
+	return output.Bytes(), cis.GetRepaired(), cis.Close()
+}
+
+func createInput(size int) []byte {
+	input := make([]byte, size)
+	rnd := rand.New(rand.NewSource(int64(size)))
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(4+(i>>12)%20))
+	}
+
+	return input
+}
+
+type bufferCloser struct {
+	*bytes.Buffer
+}
+
+func (this *bufferCloser) Close() error {
+	return nil
+}
+
+type readerCloser struct {
+	*bytes.Reader
+}
+
+func (this *readerCloser) Close() error {
+	return nil
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success (%v)\n", name+":", err)
+}
@@ -0,0 +1,411 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/float"
+	"math"
+	"math/rand"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestFloatCodec\n\n")
+
+	fmt.Printf("Bit exactness test\n")
+	TestBitExact()
+
+	fmt.Printf("\nCompression test\n")
+	TestCompression()
+
+	fmt.Printf("\nTime series test\n")
+	TestSeries()
+
+	fmt.Printf("\nInvalid data test\n")
+	TestInvalidData()
+}
+
+func TestBitExact() {
+	rnd := rand.New(rand.NewSource(1))
+	special := []uint64{
+		0x7FF8000000000000, // quiet NaN
+		0x7FF0000000000001, // signaling NaN
+		0xFFF8DEADBEEF1234, // negative NaN with payload
+		0x7FFFFFFFFFFFFFFF,
+		0x7FF0000000000000, // +Inf
+		0xFFF0000000000000, // -Inf
+		0x0000000000000000, // +0
+		0x8000000000000000, // -0
+		0x0000000000000001, // smallest subnormal
+		0x800FFFFFFFFFFFFF,
+		math.Float64bits(math.MaxFloat64),
+		math.Float64bits(1.0),
+		math.Float64bits(1.0),
+		math.Float64bits(-1.0),
+		0x7FF8000000000000,
+	}
+
+	values := append([]uint64(nil), special...)
+
+	for i := 0; i < 10000; i++ {
+		switch rnd.Intn(4) {
+		case 0:
+			values = append(values, rnd.Uint64())
+
+		case 1:
+			values = append(values, special[rnd.Intn(len(special))])
+
+		case 2:
+			values = append(values, values[len(values)-1]^(1<<uint(rnd.Intn(64))))
+
+		default:
+			values = append(values, math.Float64bits(float64(rnd.Intn(1000))/100))
+		}
+	}
+
+	for _, predictor := range []int{float.GORILLA_PREDICTOR, float.FPC_PREDICTOR} {
+		for _, tableBits := range []uint{1, 10, float.DEFAULT_FPC_TABLE_BITS} {
+			bs := &bytes.Buffer{}
+			obs, _ := bitstream.NewDefaultOutputBitStream(nopCloser{bs}, 1024)
+			encoder, err := float.NewFloatEncoder(obs, predictor, tableBits)
+
+			if err != nil {
+				fmt.Printf("Failure: %v\n", err)
+				os.Exit(1)
+			}
+
+			for _, v := range values {
+				encoder.EncodeBits(v)
+			}
+
+			obs.Close()
+			ibs, _ := bitstream.NewDefaultInputBitStream(nopCloser{bs}, 1024)
+			decoder, _ := float.NewFloatDecoder(ibs, predictor, tableBits)
+
+			for i, v := range values {
+				if res := decoder.DecodeBits(); res != v {
+					fmt.Printf("Failure: %v, value %d: %016X instead of %016X\n", float.GetPredictorName(predictor),
+						i, res, v)
+					os.Exit(1)
+				}
+			}
+		}
+
+		// Through float64 values
+		input := make([]float64, len(special))
+
+		for i, v := range special {
+			input[i] = math.Float64frombits(v)
+		}
+
+		data, _ := float.CompressFloats(nil, input, &float.Options{Predictor: float.GetPredictorName(predictor)})
+		output, err := float.DecompressFloats(nil, data)
+
+		if err != nil || len(output) != len(input) {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		for i := range input {
+			if math.Float64bits(output[i]) != special[i] {
+				fmt.Printf("Failure: %016X instead of %016X\n", math.Float64bits(output[i]), special[i])
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("%-40s Success\n", float.GetPredictorName(predictor)+":")
+	}
+}
+
+func TestCompression() {
+	rnd := rand.New(rand.NewSource(2))
+	length := 100000
+	series := make(map[string][]float64)
+	names := []string{"constant", "temperature", "sine", "random walk", "noise"}
+
+	for _, name := range names {
+		values := make([]float64, length)
+		walk := 100.0
+
+		for i := range values {
+			switch name {
+			case "constant":
+				values[i] = 21.5
+
+			case "temperature":
+				// 2 decimals, slowly varying
+				values[i] = math.Round((20+5*math.Sin(float64(i)/5000))*100) / 100
+
+			case "sine":
+				values[i] = math.Sin(float64(i) / 100)
+
+			case "random walk":
+				walk += float64(rnd.Intn(21)-10) / 4
+				values[i] = walk
+
+			default:
+				values[i] = rnd.NormFloat64()
+			}
+		}
+
+		series[name] = values
+	}
+
+	for _, name := range names {
+		var sizes [2]int
+
+		for i, predictor := range []string{"GORILLA", "FPC"} {
+			data, err := float.CompressFloats(nil, series[name], &float.Options{Predictor: predictor})
+
+			if err != nil {
+				fmt.Printf("Failure: %v\n", err)
+				os.Exit(1)
+			}
+
+			output, err := float.DecompressFloats([]float64{1}, data)
+
+			if err != nil || len(output) != length+1 {
+				fmt.Printf("Failure: %v\n", err)
+				os.Exit(1)
+			}
+
+			for j, v := range series[name] {
+				if math.Float64bits(output[j+1]) != math.Float64bits(v) {
+					fmt.Printf("Failure: %v, different value at index %d\n", name, j)
+					os.Exit(1)
+				}
+			}
+
+			sizes[i] = len(data)
+		}
+
+		fmt.Printf("%-40s Success (Gorilla: %.2f bits per value, FPC: %.2f bits per value)\n", name+":",
+			float64(8*sizes[0])/float64(length), float64(8*sizes[1])/float64(length))
+
+		if name == "constant" && sizes[0]*8 > 2*length {
+			fmt.Printf("Failure: poor compression\n")
+			os.Exit(1)
+		}
+
+		if name == "temperature" && (sizes[0]*8 > 32*length || sizes[1]*8 > 32*length) {
+			fmt.Printf("Failure: poor compression\n")
+			os.Exit(1)
+		}
+	}
+
+	// Chunks
+	input := series["random walk"]
+	bs := &bytes.Buffer{}
+	writer, _ := float.NewFloatWriter(nopCloser{bs}, &float.Options{Predictor: "fpc", TableBits: 12})
+	writer.Write(input[0:70000])
+	writer.Write(input[70000:70000])
+	writer.Write(input[70000:])
+
+	if err := writer.Close(); err != nil || writer.Written() != uint64(length) {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	output, err := float.DecompressFloats(nil, bs.Bytes())
+
+	if err != nil || len(output) != length || output[length-1] != input[length-1] {
+		fmt.Printf("Failure: chunks: %v\n", err)
+		os.Exit(1)
+	}
+
+	_, err = writer.Write(input)
+	expectError("Write after close", err, kanzi.ErrClosed)
+	data, _ := float.CompressFloats(nil, nil, nil)
+	output, err = float.DecompressFloats(nil, data)
+	check("Empty stream", err == nil && len(output) == 0)
+}
+
+func TestSeries() {
+	rnd := rand.New(rand.NewSource(3))
+	length := 50000
+	timestamps := make([]int, length)
+	values := make([]float64, length)
+	ts := 1700000000000
+
+	for i := range timestamps {
+		// One point per second, a few late or missing points
+		ts += 1000
+
+		if rnd.Intn(100) == 0 {
+			ts += rnd.Intn(5000)
+		}
+
+		timestamps[i] = ts
+		values[i] = math.Round((50+10*math.Sin(float64(i)/300)+rnd.Float64())*10) / 10
+	}
+
+	valueData, timeData, err := float.CompressSeries(timestamps, values, nil)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	ts2, values2, err := float.DecompressSeries(valueData, timeData)
+
+	if err != nil || len(ts2) != length || len(values2) != length {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	for i := range timestamps {
+		if ts2[i] != timestamps[i] || values2[i] != values[i] {
+			fmt.Printf("Failure: different point at index %d\n", i)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%-40s Success (timestamps: %.2f bits per point, values: %.2f bits per point)\n", "One shot:",
+		float64(8*len(timeData))/float64(length), float64(8*len(valueData))/float64(length))
+
+	if len(timeData) > length {
+		fmt.Printf("Failure: poor timestamp compression\n")
+		os.Exit(1)
+	}
+
+	// Streaming
+	vbuf, tbuf := &bytes.Buffer{}, &bytes.Buffer{}
+	writer, _ := float.NewSeriesWriter(nopCloser{vbuf}, nopCloser{tbuf}, &float.Options{Predictor: "FPC"})
+
+	for written := 0; written < length; {
+		n := min(rnd.Intn(3000), length-written)
+
+		if _, err := writer.Write(timestamps[written:written+n], values[written:written+n]); err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		written += n
+	}
+
+	if err := writer.Close(); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	reader, err := float.NewSeriesReader(nopCloser{vbuf}, nopCloser{tbuf})
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	count := 0
+
+	for {
+		size := rnd.Intn(2000)
+		tsBuf, valBuf := make([]int, size), make([]float64, size)
+		n, err := reader.Read(tsBuf, valBuf)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		for i := 0; i < n; i++ {
+			if tsBuf[i] != timestamps[count+i] || valBuf[i] != values[count+i] {
+				fmt.Printf("Failure: different point at index %d\n", count+i)
+				os.Exit(1)
+			}
+		}
+
+		count += n
+	}
+
+	check("Streaming", count == length)
+	_, err = writer.Write(timestamps[0:2], values[0:1])
+	expectError("Different lengths", err, kanzi.ErrInvalidParam)
+}
+
+func TestInvalidData() {
+	_, err := float.CompressFloats(nil, []float64{1}, &float.Options{Predictor: "XOR"})
+	expectError("Unknown predictor", err, kanzi.ErrUnsupported)
+	_, err = float.CompressFloats(nil, []float64{1}, &float.Options{Predictor: "FPC", TableBits: 30})
+	expectError("Invalid table size", err, kanzi.ErrInvalidParam)
+	_, err = float.NewFloatEncoder(nil, float.GORILLA_PREDICTOR, 0)
+	expectError("Null bitstream", err, kanzi.ErrInvalidParam)
+
+	rnd := rand.New(rand.NewSource(4))
+	input := make([]float64, 1000)
+
+	for i := range input {
+		input[i] = rnd.Float64()
+	}
+
+	data, _ := float.CompressFloats(nil, input, nil)
+	_, err = float.DecompressFloats(nil, data[0:len(data)/2])
+	expectError("Truncated stream", err, kanzi.ErrCorruptData)
+	damaged := append([]byte(nil), data...)
+	damaged[1] = 0
+	_, err = float.DecompressFloats(nil, damaged)
+	expectError("Invalid magic", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], data...)
+	damaged[4] = 7
+	_, err = float.DecompressFloats(nil, damaged)
+	expectError("Unknown version", err, kanzi.ErrUnsupported)
+	damaged = append(damaged[:0], data...)
+	damaged[5] = 0xF0 // unknown predictor
+	_, err = float.DecompressFloats(nil, damaged)
+	expectError("Invalid predictor", err, kanzi.ErrCorruptData)
+
+	valueData, timeData, _ := float.CompressSeries([]int{1, 2, 3}, []float64{1, 2, 3}, nil)
+	valueData2, _, _ := float.CompressSeries([]int{1, 2}, []float64{1, 2}, nil)
+	_, _, err = float.DecompressSeries(valueData2, timeData)
+	expectError("Different lengths", err, kanzi.ErrCorruptData)
+	_, _, err = float.DecompressSeries(valueData, timeData[0:4])
+	expectError("Truncated timestamps", err, kanzi.ErrCorruptData)
+}
+
+func check(name string, ok bool) {
+	if ok == false {
+		fmt.Printf("Failure: %v\n", name)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success\n", name+":")
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success (%v)\n", name+":", err)
+}
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (this nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,431 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	khttp "kanzi/http"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestHTTP\n\n")
+	TestAcceptEncoding()
+	TestCorrectness("Huffman", "LZ4")
+	TestCorrectness("ANS", "BWT+MTF")
+	TestPlainClient()
+	TestStreaming()
+	TestEarlyClose()
+	TestFailedClose()
+	TestHijack()
+}
+
+func getData(rnd *rand.Rand, size int) []byte {
+	data := make([]byte, size)
+
+	for i := range data {
+		data[i] = byte(97 + rnd.Intn(8))
+	}
+
+	return data
+}
+
+func TestAcceptEncoding() {
+	fmt.Printf("Accept-Encoding test\n")
+	values := map[string]bool{
+		"kanzi":                   true,
+		"gzip, kanzi":             true,
+		"gzip;q=1.0, KANZI;q=0.5": true,
+		"kanzi;q=0":               false,
+		"kanzi; q=0.000":          false,
+		"gzip, deflate":           false,
+		"":                        false,
+	}
+
+	for value, expected := range values {
+		if khttp.AcceptsKanzi(value) != expected {
+			fmt.Printf("Unexpected result for '%v'\n", value)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Success\n\n")
+}
+
+// The server echoes the request body, the client compresses the request and
+// decompresses the response
+func TestCorrectness(entropy, transform string) {
+	fmt.Printf("Correctness test (%v, %v)\n", entropy, transform)
+	var rawEncoding string
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			http.Error(w, "Request body not decoded", http.StatusBadRequest)
+			return
+		}
+
+		// HTTP/1.x handlers must read the request body before writing the response
+		data, err := io.ReadAll(r.Body)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	})
+
+	handler, err := khttp.NewCompressionHandler(echo, entropy, transform, khttp.DEFAULT_BLOCK_SIZE)
+
+	if err != nil {
+		fmt.Printf("Cannot create handler: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Record the encoding seen on the wire
+	spy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawEncoding = r.Header.Get("Content-Encoding")
+		handler.ServeHTTP(w, r)
+	})
+
+	server := httptest.NewServer(spy)
+	defer server.Close()
+	transport, err := khttp.NewTransport(nil, entropy, transform, khttp.DEFAULT_BLOCK_SIZE, true)
+
+	if err != nil {
+		fmt.Printf("Cannot create transport: %v\n", err)
+		os.Exit(1)
+	}
+
+	client := &http.Client{Transport: transport}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for ii := 0; ii < 20; ii++ {
+		size := 0
+
+		if ii > 0 {
+			size = rnd.Intn(1 << uint(4+ii%16))
+		}
+
+		data := getData(rnd, size)
+		resp, err := client.Post(server.URL, "application/octet-stream", bytes.NewReader(data))
+
+		if err != nil {
+			fmt.Printf("Request failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		res, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			fmt.Printf("Cannot read response: %v\n", err)
+			os.Exit(1)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Unexpected status: %v (%s)\n", resp.StatusCode, res)
+			os.Exit(1)
+		}
+
+		// Empty bodies are sent as is
+		if size > 0 && (rawEncoding != khttp.CONTENT_ENCODING || resp.Uncompressed == false) {
+			fmt.Printf("Request or response not compressed %v %q %v\n", size, rawEncoding, resp.Uncompressed)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(data, res) == false {
+			fmt.Printf("Different (sizes %v and %v)\n", len(data), len(res))
+			os.Exit(1)
+		}
+
+		fmt.Printf("%v ", size)
+	}
+
+	fmt.Printf("\nIdentical\n\n")
+}
+
+// A client that does not accept the kanzi encoding gets a plain response
+func TestPlainClient() {
+	fmt.Printf("Plain client test\n")
+	hello := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Hello world")
+	})
+
+	handler, _ := khttp.NewCompressionHandler(hello, "Huffman", "LZ4", khttp.DEFAULT_BLOCK_SIZE)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "Hello world" {
+		fmt.Printf("Unexpected response: %v %q\n", rec.Header(), rec.Body.String())
+		os.Exit(1)
+	}
+
+	req.Header.Set("Accept-Encoding", "kanzi")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != khttp.CONTENT_ENCODING ||
+		strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") == false {
+		fmt.Printf("Unexpected response headers: %v\n", rec.Header())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n\n")
+}
+
+// Lines flushed by the server must reach the client before the response ends
+func TestStreaming() {
+	fmt.Printf("Streaming test\n")
+	next := make(chan bool)
+
+	events := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(w, "event %d\n", i)
+			w.(http.Flusher).Flush()
+			<-next
+		}
+	})
+
+	handler, _ := khttp.NewCompressionHandler(events, "Huffman", "None", khttp.DEFAULT_BLOCK_SIZE)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	transport, _ := khttp.NewTransport(nil, "Huffman", "None", khttp.DEFAULT_BLOCK_SIZE, false)
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(server.URL)
+
+	if err != nil {
+		fmt.Printf("Request failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	for i := 0; i < 5; i++ {
+		line, err := reader.ReadString('\n')
+
+		if err != nil || line != fmt.Sprintf("event %d\n", i) {
+			fmt.Printf("Unexpected line: %q (%v)\n", line, err)
+			os.Exit(1)
+		}
+
+		next <- true
+	}
+
+	fmt.Printf("Success\n\n")
+}
+
+// Closing the response body must not wait for a server which stops sending
+func TestEarlyClose() {
+	fmt.Printf("Early close test\n")
+	release := make(chan bool)
+	defer close(release)
+
+	events := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "event 0\n")
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+
+	handler, _ := khttp.NewCompressionHandler(events, "Huffman", "None", khttp.DEFAULT_BLOCK_SIZE)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	transport, _ := khttp.NewTransport(nil, "Huffman", "None", khttp.DEFAULT_BLOCK_SIZE, false)
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(server.URL)
+
+	if err != nil {
+		fmt.Printf("Request failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+
+	if line, err := reader.ReadString('\n'); err != nil || line != "event 0\n" {
+		fmt.Printf("Unexpected line: %q (%v)\n", line, err)
+		os.Exit(1)
+	}
+
+	// A read of the next block is pending in the decoder
+	done := make(chan error)
+	go func() { done <- resp.Body.Close() }()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		fmt.Printf("Failure: closing the response body is blocked\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n\n")
+}
+
+// A response writer which fails to send the body
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (this failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+// Return the value of the panic of the handler, nil if none
+func serve(handler http.Handler, w http.ResponseWriter, req *http.Request) (res interface{}) {
+	defer func() {
+		res = recover()
+	}()
+
+	handler.ServeHTTP(w, req)
+	return nil
+}
+
+// The end of the compressed response cannot be sent: the response must be
+// aborted, not reported as complete
+func TestFailedClose() {
+	fmt.Printf("Failed close test\n")
+	hello := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Hello world")
+	})
+
+	handler, _ := khttp.NewCompressionHandler(hello, "Huffman", "LZ4", khttp.DEFAULT_BLOCK_SIZE)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "kanzi")
+
+	if r := serve(handler, failingWriter{httptest.NewRecorder()}, req); r != http.ErrAbortHandler {
+		fmt.Printf("Failure: the response was not aborted: %v\n", r)
+		os.Exit(1)
+	}
+
+	// The panics of the wrapped handler are not replaced
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Hello world")
+		panic("handler failure")
+	})
+
+	handler, _ = khttp.NewCompressionHandler(failing, "Huffman", "LZ4", khttp.DEFAULT_BLOCK_SIZE)
+
+	if r := serve(handler, failingWriter{httptest.NewRecorder()}, req); r != "handler failure" {
+		fmt.Printf("Failure: unexpected panic: %v\n", r)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n\n")
+}
+
+// The wrapped handler can take over the connection (EG. websockets)
+func TestHijack() {
+	fmt.Printf("Hijack test\n")
+	raw := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, isHijacker := w.(http.Hijacker)
+
+		if isHijacker == false {
+			http.Error(w, "not a hijacker", http.StatusInternalServerError)
+			return
+		}
+
+		conn, rw, err := hj.Hijack()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		defer conn.Close()
+
+		if r.Header.Get("Upgrade") != "" {
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		} else {
+			rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		}
+
+		rw.Flush()
+	})
+
+	handler, _ := khttp.NewCompressionHandler(raw, "Huffman", "LZ4", khttp.DEFAULT_BLOCK_SIZE)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept-Encoding", "kanzi")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+
+	if err != nil {
+		fmt.Printf("Request failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "hijacked" {
+		fmt.Printf("Failure: unexpected response: %v %q\n", resp.Status, body)
+		os.Exit(1)
+	}
+
+	// Upgrade request: the response is not compressed
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	resp, err = http.DefaultTransport.RoundTrip(req)
+
+	if err != nil {
+		fmt.Printf("Request failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Content-Encoding") != "" {
+		fmt.Printf("Failure: unexpected upgrade response: %v %v\n", resp.Status, resp.Header)
+		os.Exit(1)
+	}
+
+	// The connection of a compressed response cannot be hijacked
+	late := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "compressed")
+
+		if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			panic("hijacked compressed response")
+		}
+	})
+
+	handler, _ = khttp.NewCompressionHandler(late, "Huffman", "LZ4", khttp.DEFAULT_BLOCK_SIZE)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "kanzi")
+	rec := httptest.NewRecorder()
+
+	if r := serve(handler, rec, req); r != nil || rec.Header().Get("Content-Encoding") != khttp.CONTENT_ENCODING {
+		fmt.Printf("Failure: unexpected compressed response: %v %v\n", r, rec.Header())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n\n")
+}
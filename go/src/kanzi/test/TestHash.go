@@ -16,16 +16,57 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"hash"
 	"kanzi/util"
+	"math/rand"
 	"os"
 	"time"
 )
 
+// Expected values computed with the reference implementations
+type hashVector struct {
+	input  string
+	seed   uint32
+	xxh32  uint32
+	xxh64  uint64
+	mm32   uint32
+	mm128a uint64
+	mm128b uint64
+}
+
+var hashVectors = []hashVector{
+	{"", 0, 0x02cc5d05, 0xef46db3751d8e999, 0x00000000, 0x0000000000000000, 0x0000000000000000},
+	{"", 0x9747b28c, 0x8d3b42d8, 0x495a197c8d074e3d, 0xebb6c228, 0x392b208a1daabbb3, 0x93b0608fe302957a},
+	{"a", 0, 0x550d7456, 0xd24ec4f1a98c6e5b, 0x3c2569b2, 0x85555565f6597889, 0xe6b53a48510e895a},
+	{"abc", 0, 0x32d153ff, 0x44bc2cf5ad770999, 0xb3dd93fa, 0xb4963f3f3fad7867, 0x3ba2744126ca2d52},
+	{"abc", 0x9747b28c, 0x4d4cb222, 0x7d79a0222a9406c7, 0xc84a62dd, 0x3743630dbfc3cedc, 0xcde0a23420b504bf},
+	{"message digest", 0, 0x7c948494, 0x066ed728fceeb3be, 0x638f4169, 0x875d2c2d76147dfc, 0xf622b02a12bc6f39},
+	{"Nobody inspects the spammish repetition", 0, 0xe2293b2f, 0xfbcea83c8a378bf1, 0x3126f6e3,
+		0x2abb2a444585bf0b, 0x51e22465cbb49f72},
+	{"The quick brown fox jumps over the lazy dog", 0, 0xe85ea4de, 0x0b242d361fda71bc, 0x2e4ff723,
+		0xe34bbc7bbc071b6c, 0x7a433ca9c49a9347},
+	{"The quick brown fox jumps over the lazy dog", 0x9747b28c, 0xc8579d72, 0x3e182f2bc9dbde4b, 0x2fa826cd,
+		0x738a7f3bd2633121, 0xf94573727ec016e5},
+	{"0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", 0, 0x029fbfb5,
+		0x9e75a8e1c64f3e4f, 0xa9d7f282, 0x292fda13444fd4b4, 0x132e4d00a2c5ad3e},
+	{"0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", 0x9747b28c, 0xc1817f66,
+		0x2a9d5130336e30b1, 0x25f44b12, 0xffd7de543a505164, 0x06b150b16b2a13e7},
+}
+
 func main() {
 	var filename = flag.String("input", "c:\\temp\\rt.jar", "name of the input file")
 	flag.Parse()
+
+	fmt.Printf("Test vectors\n")
+	TestVectors()
+	fmt.Printf("\nStreaming test\n")
+	TestStreaming()
+	fmt.Printf("\n")
+
 	iter := 500
 	fmt.Printf("Processing %v\n", *filename)
 	fmt.Printf("%v iterations\n", iter)
@@ -122,3 +163,94 @@ func main() {
 		fmt.Printf("Throughput [MB/s]: %v\n", (size/1024*1000/1024)/delta)
 	}
 }
+
+func TestVectors() {
+	for _, v := range hashVectors {
+		data := []byte(v.input)
+		xxh32, _ := util.NewXXHash(v.seed)
+		xxh64, _ := util.NewXXHash64(uint64(v.seed))
+		mm32, _ := util.NewMurMurHash3(v.seed)
+		mm128, _ := util.NewMurMurHash3_128(v.seed)
+		h1, h2 := mm128.Hash(data)
+		ok := xxh32.Hash(data) == v.xxh32 && xxh64.Hash(data) == v.xxh64 &&
+			mm32.Hash(data) == v.mm32 && h1 == v.mm128a && h2 == v.mm128b
+
+		// Streaming (one write)
+		xxh32.Write(data)
+		xxh64.Write(data)
+		mm32.Write(data)
+		mm128.Write(data)
+		h1, h2 = mm128.Sum128()
+		ok = ok && xxh32.Sum32() == v.xxh32 && xxh64.Sum64() == v.xxh64 &&
+			mm32.Sum32() == v.mm32 && h1 == v.mm128a && h2 == v.mm128b
+
+		// Canonical representation (big endian)
+		expected := fmt.Sprintf("%016x%016x", v.mm128a, v.mm128b)
+		ok = ok && hex.EncodeToString(mm128.Sum(nil)) == expected
+		expected = fmt.Sprintf("%08x", v.xxh32)
+		ok = ok && hex.EncodeToString(xxh32.Sum([]byte{})) == expected
+
+		if ok == false {
+			fmt.Printf("Failure: input=%q seed=%x\n", v.input, v.seed)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%d vectors: Success\n", len(hashVectors))
+}
+
+// Hashing the data in chunks of random sizes must yield the one-shot hash
+func TestStreaming() {
+	data := make([]byte, 10000)
+	rnd := rand.New(rand.NewSource(12345))
+	rnd.Read(data)
+
+	xxh32, _ := util.NewXXHash(0x12345678)
+	xxh64, _ := util.NewXXHash64(0x12345678)
+	mm32, _ := util.NewMurMurHash3(0x12345678)
+	mm128, _ := util.NewMurMurHash3_128(0x12345678)
+	hashes := []hash.Hash{xxh32, xxh64, mm32, mm128}
+	hashes32 := []hash.Hash32{xxh32, mm32}
+	var h64 hash.Hash64 = xxh64
+
+	for test := 0; test < 50; test++ {
+		input := data[0:rnd.Intn(len(data))]
+		h1, h2 := mm128.Hash(input)
+		oneShot := [][]byte{
+			fmt.Appendf(nil, "%08x", xxh32.Hash(input)),
+			fmt.Appendf(nil, "%016x", xxh64.Hash(input)),
+			fmt.Appendf(nil, "%08x", mm32.Hash(input)),
+			fmt.Appendf(nil, "%016x%016x", h1, h2),
+		}
+
+		for i, h := range hashes {
+			h.Reset()
+
+			for n := 0; n < len(input); {
+				size := rnd.Intn(70)
+
+				if size > len(input)-n {
+					size = len(input) - n
+				}
+
+				h.Write(input[n : n+size])
+				n += size
+
+				// Sum does not change the state
+				h.Sum(nil)
+			}
+
+			if bytes.Equal([]byte(hex.EncodeToString(h.Sum(nil))), oneShot[i]) == false {
+				fmt.Printf("Failure: hash #%d, length %d: %x instead of %s\n", i, len(input), h.Sum(nil), oneShot[i])
+				os.Exit(1)
+			}
+		}
+	}
+
+	if hashes32[0].Size() != 4 || hashes32[1].Size() != 4 || h64.Size() != 8 || mm128.Size() != 16 {
+		fmt.Printf("Failure: invalid sizes\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
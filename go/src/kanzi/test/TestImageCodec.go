@@ -0,0 +1,276 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"kanzi"
+	kimage "kanzi/image"
+	"math"
+	"math/rand"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestImageCodec\n\n")
+
+	fmt.Printf("PNM test\n")
+	TestPNM()
+
+	fmt.Printf("\nQuality test\n")
+	TestQuality()
+
+	fmt.Printf("\nBlock size test\n")
+	TestBlockSizes()
+
+	fmt.Printf("\nInvalid data test\n")
+	TestInvalidData()
+}
+
+func TestPNM() {
+	img := createImage(45, 31, 3, 1)
+	var buf bytes.Buffer
+
+	if err := kimage.WritePNM(&buf, img); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	if read, err := kimage.ReadPNM(&buf); err != nil || equalImages(img, read) == false {
+		fmt.Printf("Failure: PPM round trip: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success\n", "PPM round trip:")
+
+	// Comments and max value below 255
+	pgm := append([]byte("P5\n# comment\n3 2 # width and height\n15\n"), 0, 1, 2, 13, 14, 15)
+	read, err := kimage.ReadPNM(bytes.NewReader(pgm))
+
+	if err != nil || read.Channels != 1 || read.Width != 3 || read.Height != 2 ||
+		bytes.Equal(read.Pix, []byte{0, 17, 34, 221, 238, 255}) == false {
+		fmt.Printf("Failure: PGM with comments: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success\n", "PGM with comments:")
+	_, err = kimage.ReadPNM(bytes.NewReader([]byte("P3\n1 1\n255\n0 0 0\n")))
+	expectError("ASCII PPM", err, kanzi.ErrUnsupported)
+	_, err = kimage.ReadPNM(bytes.NewReader(pgm[0 : len(pgm)-1]))
+	expectError("Truncated PGM", err, kanzi.ErrCorruptData)
+
+	// The samples are not allocated from the header alone
+	_, err = kimage.ReadPNM(bytes.NewReader([]byte("P6\n65536 65536\n255\n")))
+	expectError("Oversized PPM", err, kanzi.ErrLimitExceeded)
+	_, err = kimage.ReadPNM(bytes.NewReader([]byte("P5\n8000 8000\n255\n\x00")))
+	expectError("Truncated large PGM", err, kanzi.ErrCorruptData)
+}
+
+func TestQuality() {
+	for _, channels := range []int{1, 3} {
+		img := createImage(160, 120, channels, 2)
+
+		for _, coder := range []int{kimage.RANGE_CODER, kimage.EXPGOLOMB_CODER} {
+			prevPSNR := 0.0
+			prevSize := 0
+
+			for _, quality := range []int{10, 30, 50, 75, 90, 100} {
+				encoder, _ := kimage.NewDCTEncoder(quality, coder)
+				name := fmt.Sprintf("%d channel(s), %s, quality %d", channels, kimage.GetCoderName(coder), quality)
+				data, report := roundTrip(name, encoder, img)
+
+				if report.PSNR <= prevPSNR || len(data) <= prevSize {
+					fmt.Printf("Failure: %v: the PSNR or the size do not increase with the quality\n", name)
+					os.Exit(1)
+				}
+
+				prevPSNR = report.PSNR
+				prevSize = len(data)
+				fmt.Printf("%-40s Success (%6d bytes, %.2f bpp, %v)\n", name+":", len(data),
+					float64(8*len(data))/float64(img.Width*img.Height), report)
+			}
+
+			if prevPSNR < 45 {
+				fmt.Printf("Failure: the PSNR at quality 100 is too low\n")
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Dimensions not multiple of the block size
+	img := createImage(37, 23, 3, 3)
+	encoder, _ := kimage.NewDCTEncoder(80, kimage.RANGE_CODER)
+	_, report := roundTrip("37x23", encoder, img)
+	fmt.Printf("%-40s Success (%v)\n", "Odd dimensions:", report)
+}
+
+func TestBlockSizes() {
+	// Smooth areas favor large blocks and details favor small blocks
+	img := createImage(256, 256, 1, 4)
+	var sizes [][2]int
+
+	for _, s := range [][2]int{{8, 8}, {4, 32}} {
+		encoder, _ := kimage.NewDCTEncoder(60, kimage.RANGE_CODER)
+
+		if err := encoder.SetBlockSizes(s[0], s[1]); err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		name := fmt.Sprintf("Blocks %d to %d", s[0], s[1])
+		data, report := roundTrip(name, encoder, img)
+		counts := ""
+
+		for size := kimage.MIN_DCT_BLOCK; size <= kimage.MAX_DCT_BLOCK; size <<= 1 {
+			counts += fmt.Sprintf(" %dx%d:%d", size, size, encoder.BlockCount(size))
+		}
+
+		fmt.Printf("%-40s %6d bytes, %.2f dB, blocks%s\n", name+":", len(data), report.PSNR, counts)
+		sizes = append(sizes, [2]int{len(data), int(report.PSNR * 100)})
+
+		if s[0] != s[1] && (encoder.BlockCount(4) == 0 || encoder.BlockCount(32) == 0) {
+			fmt.Printf("Failure: the block sizes are not adaptive\n")
+			os.Exit(1)
+		}
+	}
+
+	// Compare the rate/distortion costs (6 dB per bit per sample)
+	fixed := 8*float64(sizes[0][0]) - float64(sizes[0][1])/602*256*256
+	adaptive := 8*float64(sizes[1][0]) - float64(sizes[1][1])/602*256*256
+
+	if adaptive >= fixed {
+		fmt.Printf("Failure: the adaptive block sizes do not improve the rate/distortion\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success\n", "Adaptive block sizes:")
+
+	encoder, _ := kimage.NewDCTEncoder(60, kimage.RANGE_CODER)
+	expectError("Invalid block sizes", encoder.SetBlockSizes(8, 64), kanzi.ErrInvalidParam)
+	_, err := kimage.NewDCTEncoder(0, kimage.RANGE_CODER)
+	expectError("Invalid quality", err, kanzi.ErrInvalidParam)
+}
+
+func TestInvalidData() {
+	img := createImage(64, 48, 3, 5)
+	encoder, _ := kimage.NewDCTEncoder(75, kimage.RANGE_CODER)
+	data, _ := encoder.Encode(img)
+	decoder, _ := kimage.NewDCTDecoder()
+
+	_, err := decoder.Decode(data[0 : len(data)/2])
+	expectError("Truncated", err, kanzi.ErrCorruptData)
+	damaged := append([]byte(nil), data...)
+	damaged[0] ^= 1
+	_, err = decoder.Decode(damaged)
+	expectError("Invalid magic", err, kanzi.ErrCorruptData)
+
+	// 65536x65536x3 image in 64 bytes
+	damaged = append([]byte(nil), data[0:64]...)
+	damaged[5], damaged[6], damaged[7], damaged[8] = 0xFF, 0xFF, 0xFF, 0xFF
+	_, err = decoder.Decode(damaged)
+	expectError("Oversized header", err, kanzi.ErrLimitExceeded)
+
+	// 4096x4096x3 image in 16 bytes
+	damaged = append([]byte(nil), data[0:16]...)
+	damaged[5], damaged[6], damaged[7], damaged[8] = 0x0F, 0xFF, 0x0F, 0xFF
+	_, err = decoder.Decode(damaged)
+	expectError("Header larger than the data", err, kanzi.ErrCorruptData)
+	rnd := rand.New(rand.NewSource(5))
+
+	// Random damage must not crash the decoder
+	for i := 0; i < 200; i++ {
+		damaged = append(damaged[:0], data...)
+		damaged[20+rnd.Intn(len(data)-20)] ^= byte(1 + rnd.Intn(255))
+		decoder.Decode(damaged)
+	}
+
+	fmt.Printf("%-30s Success\n", "Random damage:")
+}
+
+// Encode and decode the image, check that the output of the decoder is the
+// reconstruction of the encoder
+func roundTrip(name string, encoder *kimage.DCTEncoder, img *kimage.Image) ([]byte, *kimage.Report) {
+	data, err := encoder.Encode(img)
+
+	if err != nil {
+		fmt.Printf("Failure: %v: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	decoder, _ := kimage.NewDCTDecoder()
+	decoded, err := decoder.Decode(data)
+
+	if err != nil {
+		fmt.Printf("Failure: %v: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	if equalImages(encoder.Reconstruction(), decoded) == false {
+		fmt.Printf("Failure: %v: the decoded image is not bit exact\n", name)
+		os.Exit(1)
+	}
+
+	report, err := kimage.Compare(img, decoded)
+
+	if err != nil {
+		fmt.Printf("Failure: %v: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	return data, report
+}
+
+// Create an image with smooth gradients, sharp edges and textured areas
+func createImage(width, height, channels int, seed int64) *kimage.Image {
+	img, _ := kimage.NewImage(width, height, channels)
+	rnd := rand.New(rand.NewSource(seed))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < channels; c++ {
+				val := 64 + 40*math.Sin(float64(x+20*c)/23) + 30*math.Cos(float64(y)/17)
+
+				if x > width/2 && y > height/2 {
+					// Texture
+					val += float64(((x/3)^(y/3))&1)*60 + float64(rnd.Intn(16))
+				} else if (x-width/4)*(x-width/4)+(y-height/4)*(y-height/4) < width*height/40 {
+					// Disc
+					val += 100
+				}
+
+				img.Pix[(y*width+x)*channels+c] = byte(math.Max(0, math.Min(255, val)))
+			}
+		}
+	}
+
+	return img
+}
+
+func equalImages(img1, img2 *kimage.Image) bool {
+	return img1.Width == img2.Width && img1.Height == img2.Height && img1.Channels == img2.Channels &&
+		bytes.Equal(img1.Pix, img2.Pix)
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success (%v)\n", name+":", err)
+}
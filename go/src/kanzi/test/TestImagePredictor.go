@@ -0,0 +1,366 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"kanzi"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math"
+	"math/rand"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestImagePredictor\n\n")
+
+	fmt.Printf("Correctness test\n")
+	TestCorrectness()
+
+	fmt.Printf("\nLayout guess test\n")
+	TestGuess()
+
+	fmt.Printf("\nCompressed stream test\n")
+	TestStream()
+
+	fmt.Printf("\nInvalid data test\n")
+	TestInvalidData()
+}
+
+func TestCorrectness() {
+	layouts := [][3]uint{{1, 1, 1}, {7, 7, 1}, {64, 64, 1}, {33, 100, 3}, {50, 200, 4}, {120, 360, 3}, {97, 400, 2}}
+
+	for _, layout := range layouts {
+		width, stride, channels := layout[0], layout[1], layout[2]
+
+		for _, rows := range []uint{1, 2, 17} {
+			// Last row incomplete
+			for _, extra := range []uint{0, stride / 2} {
+				size := stride*(rows-1) + extra + width*channels
+
+				if extra != 0 {
+					size = stride*(rows-1) + extra
+				}
+
+				for _, natural := range []bool{true, false} {
+					input := createRaster(width, stride, channels, size, natural, int64(size))
+					roundTrip(input, width, stride, channels)
+				}
+			}
+		}
+
+		fmt.Printf("%-40s Success\n", fmt.Sprintf("width %d, stride %d, %d channel(s):", width, stride, channels))
+	}
+
+	// Rows with extreme values (wrap around of the residuals)
+	rnd := rand.New(rand.NewSource(1))
+	input := make([]byte, 64*40)
+
+	for i := range input {
+		input[i] = byte(rnd.Intn(2) * 255)
+
+		if i%128 < 64 {
+			input[i] = byte(i + (i/64)*3)
+		}
+	}
+
+	modes := roundTrip(input, 16, 64, 4)
+	fmt.Printf("%-40s Success (predictors %v)\n", "Extreme values:", modes)
+}
+
+// Forward and inverse transform, return the predictors used
+func roundTrip(input []byte, width, stride, channels uint) []byte {
+	predictor, err := function.NewImagePredictor(uint(len(input)), width, stride, channels)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	output := make([]byte, predictor.MaxEncodedLen(len(input)))
+	_, dstIdx, err := predictor.Forward(input, output)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	reverse := make([]byte, len(input))
+	predictor, _ = function.NewImagePredictor(dstIdx, 0, 0, 0)
+	_, n, err := predictor.Inverse(output, reverse)
+
+	if err != nil || n != uint(len(input)) || bytes.Equal(input, reverse) == false {
+		fmt.Printf("Failure: width %d, stride %d, %d channel(s), %d bytes: %v\n", width, stride, channels,
+			len(input), err)
+		os.Exit(1)
+	}
+
+	rows := (len(input) + int(stride) - 1) / int(stride)
+	return output[function.PREDICT_HEADER_SIZE : function.PREDICT_HEADER_SIZE+rows]
+}
+
+func TestGuess() {
+	layouts := [][3]uint{{173, 519, 3}, {256, 256, 1}, {300, 1200, 4}, {97, 300, 3}}
+
+	for _, layout := range layouts {
+		width, stride, channels := layout[0], layout[1], layout[2]
+		input := createRaster(width, stride, channels, stride*200, true, 3)
+		predictor, _ := function.NewImagePredictor(0, 0, 0, 0)
+		output := make([]byte, predictor.MaxEncodedLen(len(input)))
+		_, dstIdx, err := predictor.Forward(input, output)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		guessedStride := binary.BigEndian.Uint32(output[1:])
+		reverse := make([]byte, len(input))
+		predictor, _ = function.NewImagePredictor(dstIdx, 0, 0, 0)
+
+		if _, _, err := predictor.Inverse(output, reverse); err != nil || bytes.Equal(input, reverse) == false {
+			fmt.Printf("Failure: guessed layout: %v\n", err)
+			os.Exit(1)
+		}
+
+		if uint(output[0]) != channels || uint(guessedStride) != stride {
+			fmt.Printf("Failure: wrong layout: %d channel(s), stride %d\n", output[0], guessedStride)
+			os.Exit(1)
+		}
+
+		rows := len(input) / int(guessedStride)
+		before := entropy(input)
+		after := entropy(output[function.PREDICT_HEADER_SIZE+rows : dstIdx])
+		fmt.Printf("%-40s Success (entropy: %.3f -> %.3f bits per byte)\n",
+			fmt.Sprintf("stride %d, %d channel(s):", stride, channels), before, after)
+
+		if after >= before {
+			fmt.Printf("Failure: the prediction does not reduce the entropy\n")
+			os.Exit(1)
+		}
+	}
+
+	// Small blocks
+	for _, size := range []int{0, 1, 10, 63, 64, 100} {
+		input := createRaster(uint(size)+1, uint(size)+1, 1, uint(size), true, 4)
+		predictor, _ := function.NewImagePredictor(0, 0, 0, 0)
+		output := make([]byte, predictor.MaxEncodedLen(len(input)))
+		_, dstIdx, err := predictor.Forward(input, output)
+		reverse := make([]byte, len(input))
+		predictor, _ = function.NewImagePredictor(dstIdx, 0, 0, 0)
+
+		if _, _, err2 := predictor.Inverse(output, reverse); err != nil || err2 != nil || bytes.Equal(input, reverse) == false {
+			fmt.Printf("Failure: small block of %d bytes: %v %v\n", size, err, err2)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%-40s Success\n", "Small blocks:")
+}
+
+func TestStream() {
+	input := createRaster(640, 640*3, 3, 640*3*300, true, 5)
+
+	for _, entropyName := range []string{"Huffman", "ANS", "FPAQ"} {
+		var sizes [2]int
+
+		for i, transform := range []string{"None", "Predict"} {
+			opts := &kio.Options{Entropy: entropyName, Transform: transform, BlockSize: 256 * 1024}
+			compressed, err := kio.Compress(nil, input, opts)
+
+			if err != nil {
+				fmt.Printf("Failure: %v\n", err)
+				os.Exit(1)
+			}
+
+			output, err := kio.Decompress(nil, compressed, opts)
+
+			if err != nil || bytes.Equal(input, output) == false {
+				fmt.Printf("Failure: %v+%v: %v\n", transform, entropyName, err)
+				os.Exit(1)
+			}
+
+			sizes[i] = len(compressed)
+		}
+
+		fmt.Printf("%-40s Success (%d bytes -> %d bytes without prediction, %d bytes with prediction)\n",
+			entropyName+":", len(input), sizes[0], sizes[1])
+
+		if sizes[1] >= sizes[0] {
+			fmt.Printf("Failure: the prediction does not improve the compression\n")
+			os.Exit(1)
+		}
+	}
+
+	if name := function.GetByteFunctionName(function.GetByteFunctionType("predict")); name != "PREDICT" {
+		fmt.Printf("Failure: unexpected function name: %v\n", name)
+		os.Exit(1)
+	}
+
+	// Layout given in the transform name: the stride is too large to be
+	// guessed. Random rows, each one close to the previous one.
+	rnd := rand.New(rand.NewSource(7))
+	input = make([]byte, 18000*60)
+
+	for i := range input {
+		if i < 18000 {
+			input[i] = byte(rnd.Intn(256))
+		} else {
+			input[i] = input[i-18000] + byte(rnd.Intn(3))
+		}
+	}
+
+	predictor, _ := function.NewImagePredictor(0, 0, 0, 0)
+	output := make([]byte, predictor.MaxEncodedLen(len(input)))
+	predictor.Forward(input, output)
+
+	if guessed := binary.BigEndian.Uint32(output[1:]); guessed == 18000 {
+		fmt.Printf("Failure: the stride should not be guessed\n")
+		os.Exit(1)
+	}
+
+	var sizes [2]int
+
+	for i, transform := range []string{"Predict", "Predict+6000,18000,3"} {
+		opts := &kio.Options{Entropy: "Huffman", Transform: transform, BlockSize: 4 * 1024 * 1024}
+		compressed, err := kio.Compress(nil, input, opts)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		output, err := kio.Decompress(nil, compressed, opts)
+
+		if err != nil || bytes.Equal(input, output) == false {
+			fmt.Printf("Failure: %v: %v\n", transform, err)
+			os.Exit(1)
+		}
+
+		sizes[i] = len(compressed)
+	}
+
+	fmt.Printf("%-40s Success (%d bytes -> %d bytes with the guessed layout, %d bytes with the given layout)\n",
+		"Layout in the name:", len(input), sizes[0], sizes[1])
+
+	if 2*sizes[1] >= sizes[0] {
+		fmt.Printf("Failure: the given layout does not improve the compression\n")
+		os.Exit(1)
+	}
+}
+
+func TestInvalidData() {
+	_, err := function.NewImagePredictor(0, 10, 20, 3)
+	expectError("Stride too small", err, kanzi.ErrInvalidParam)
+	_, err = function.NewImagePredictor(0, 10, 0, 17)
+	expectError("Invalid channels", err, kanzi.ErrInvalidParam)
+
+	for _, name := range []string{"PREDICT+10,20,3", "PREDICT+10,30", "PREDICT+10,x,3"} {
+		_, err = kio.Compress(nil, []byte("data"), &kio.Options{Transform: name})
+		expectError("Layout "+name, err, kanzi.ErrInvalidParam)
+	}
+
+	input := createRaster(30, 90, 3, 900, true, 6)
+	predictor, _ := function.NewImagePredictor(0, 30, 90, 3)
+	output := make([]byte, predictor.MaxEncodedLen(len(input)))
+	_, dstIdx, _ := predictor.Forward(input, output)
+	output = output[0:dstIdx]
+	reverse := make([]byte, len(input))
+	inverse, _ := function.NewImagePredictor(0, 0, 0, 0)
+
+	_, _, err = inverse.Inverse(output[0:5], reverse)
+	expectError("Truncated header", err, kanzi.ErrCorruptData)
+	damaged := append([]byte(nil), output...)
+	damaged[0] = 0
+	_, _, err = inverse.Inverse(damaged, reverse)
+	expectError("Invalid channels", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], output...)
+	damaged[8] = 200
+	_, _, err = inverse.Inverse(damaged, reverse)
+	expectError("Invalid width", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], output...)
+	damaged[function.PREDICT_HEADER_SIZE+2] = function.PREDICTORS
+	_, _, err = inverse.Inverse(damaged, reverse)
+	expectError("Invalid predictor", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], output...)
+	damaged[4] = 8 // stride 8: the length is not consistent
+	damaged[8] = 1
+	_, _, err = inverse.Inverse(damaged, reverse)
+	expectError("Invalid length", err, kanzi.ErrCorruptData)
+	_, _, err = inverse.Inverse(output, reverse[0:100])
+	expectError("Small output", err, kanzi.ErrBufferTooSmall)
+}
+
+// Create rows of pixels (smooth or random) followed by padding bytes
+func createRaster(width, stride, channels, size uint, natural bool, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	res := make([]byte, size)
+
+	for i := range res {
+		x := uint(i) % stride
+		y := uint(i) / stride
+
+		if x >= width*channels {
+			res[i] = byte(x) // padding
+			continue
+		}
+
+		if natural == false {
+			res[i] = byte(rnd.Intn(256))
+			continue
+		}
+
+		px := float64(x / channels)
+		c := float64(x % channels)
+		val := 120 + 50*math.Sin(px/19+c) + 40*math.Cos(float64(y)/13) + 20*math.Sin(px/7)*math.Cos(float64(y)/9)
+		res[i] = byte(val + float64(rnd.Intn(4)))
+	}
+
+	return res
+}
+
+// Order 0 entropy in bits per byte
+func entropy(data []byte) float64 {
+	var freqs [256]int
+
+	for _, b := range data {
+		freqs[b]++
+	}
+
+	res := 0.0
+
+	for _, f := range freqs {
+		if f > 0 {
+			p := float64(f) / float64(len(data))
+			res -= p * math.Log2(p)
+		}
+	}
+
+	return res
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success (%v)\n", name+":", err)
+}
@@ -0,0 +1,473 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"kanzi"
+	"kanzi/bitstream"
+	"kanzi/entropy"
+	"kanzi/integer"
+	"math"
+	"math/rand"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestIntCodec\n\n")
+
+	fmt.Printf("Integer functions test\n")
+	TestFunctions()
+
+	fmt.Printf("\nInteger entropy codes test\n")
+	TestEntropyCodes()
+
+	fmt.Printf("\nColumn compression test\n")
+	TestCompression()
+
+	fmt.Printf("\nStreaming test\n")
+	TestStreaming()
+
+	fmt.Printf("\nValue width test\n")
+	TestWidths()
+
+	fmt.Printf("\nInvalid data test\n")
+	TestInvalidData()
+}
+
+func TestFunctions() {
+	rnd := rand.New(rand.NewSource(1))
+	delta, _ := integer.NewDeltaCodec(1)
+	delta2, _ := integer.NewDeltaCodec(2)
+	zigzag, _ := integer.NewZigZagCodec()
+	frame, _ := integer.NewFrameOfReference()
+	packer, _ := integer.NewBitPacker(0)
+	functions := []kanzi.IntFunction{delta, delta2, zigzag, frame, packer}
+	names := []string{"Delta", "Delta-of-delta", "ZigZag", "Frame of reference", "Bit packing"}
+
+	for i, f := range functions {
+		for _, length := range []int{0, 1, 2, 3, 255, 256, 257, 1000} {
+			for _, width := range []uint{0, 1, 7, 33, 63, 64} {
+				input := randomValues(rnd, length, width)
+				roundTrip(f, input, names[i])
+			}
+
+			extremes := []int{math.MinInt64, math.MaxInt64, 0, -1, math.MinInt64, 1, math.MaxInt64}
+			roundTrip(f, extremes, names[i])
+		}
+
+		fmt.Printf("%-40s Success\n", names[i]+":")
+	}
+
+	// In place
+	input := randomValues(rnd, 1000, 40)
+	buf := append([]int(nil), input...)
+
+	for _, f := range []kanzi.IntFunction{delta, delta2, zigzag} {
+		f.Forward(buf, buf)
+		f.Inverse(buf, buf)
+	}
+
+	buf = append(buf, 0)
+	frame.Forward(buf[0:1000], buf)
+	frame.Inverse(buf, buf)
+
+	if equalInts(input, buf[0:1000]) == false {
+		fmt.Printf("Failure: in place transforms\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success\n", "In place:")
+
+	// Known values
+	output := make([]int, 5)
+	delta2.Forward([]int{1000, 1010, 1020, 1031, 1040}, output)
+	check("Delta-of-delta values", equalInts(output, []int{1000, 10, 0, 1, -2}))
+	zigzag.Forward([]int{0, -1, 1, -2, 2}, output)
+	check("ZigZag values", equalInts(output, []int{0, 1, 2, 3, 4}))
+	output = make([]int, 4)
+	frame.Forward([]int{7, 5, 9}, output)
+	check("Frame of reference values", equalInts(output, []int{5, 2, 0, 4}))
+
+	// Bit packing: output size and lane layout
+	values := make([]int, 300)
+
+	for i := range values {
+		values[i] = i & 7
+	}
+
+	packed := make([]int, packer.MaxEncodedLen(len(values)))
+	_, n, _ := packer.Forward(values, packed)
+	check("Bit packing size", n == uint(1+integer.PackedLen(256, 3)+1+integer.PackedLen(44, 3)) && n == 1+12+1+4)
+	check("Bit packing lanes", packed[0] == 3 && packed[1] == 0x0820820820820820 && uint64(packed[2]) == 0x9A69A69A69A69A69)
+}
+
+func roundTrip(f kanzi.IntFunction, input []int, name string) {
+	output := make([]int, f.MaxEncodedLen(len(input)))
+	reverse := make([]int, len(input))
+	_, n, err := f.Forward(input, output)
+
+	if err != nil {
+		fmt.Printf("Failure: %v: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	if _, m, err := f.Inverse(output[0:n], reverse); err != nil || m != uint(len(input)) || equalInts(input, reverse) == false {
+		fmt.Printf("Failure: %v: %d values, %v\n", name, len(input), err)
+		os.Exit(1)
+	}
+}
+
+func TestEntropyCodes() {
+	rnd := rand.New(rand.NewSource(2))
+	values := []int{0, 1, -1, 2, -2, 1000, -1000, 1 << 40, -(1 << 40), math.MaxInt64, math.MinInt64, math.MinInt64 + 1}
+
+	for i := 0; i < 1000; i++ {
+		values = append(values, int(rnd.Uint64())>>uint(rnd.Intn(64)))
+	}
+
+	for _, signed := range []bool{true, false} {
+		bs := &bytes.Buffer{}
+		obs, _ := bitstream.NewDefaultOutputBitStream(nopCloser{bs}, 1024)
+		eg, _ := entropy.NewExpGolombEncoder(obs, signed)
+		rice, _ := entropy.NewRiceGolombEncoder(obs, signed, 1)
+
+		for _, v := range values {
+			eg.EncodeInt(v)
+		}
+
+		for i, v := range values[0:500] {
+			rice.SetLogBase(uint(i % 31))
+			rice.EncodeInt(v >> 40)
+		}
+
+		obs.Close()
+		ibs, _ := bitstream.NewDefaultInputBitStream(nopCloser{bs}, 1024)
+		egd, _ := entropy.NewExpGolombDecoder(ibs, signed)
+		riced, _ := entropy.NewRiceGolombDecoder(ibs, signed, 1)
+
+		for _, v := range values {
+			if res := egd.DecodeInt(); res != v {
+				fmt.Printf("Failure: Exp-Golomb: %d instead of %d\n", res, v)
+				os.Exit(1)
+			}
+		}
+
+		for i, v := range values[0:500] {
+			riced.SetLogBase(uint(i % 31))
+
+			if res := riced.DecodeInt(); res != v>>40 && (signed == true || v >= 0) {
+				fmt.Printf("Failure: Rice: %d instead of %d\n", res, v>>40)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Printf("%-40s Success\n", fmt.Sprintf("Signed %v:", signed))
+	}
+}
+
+func TestCompression() {
+	rnd := rand.New(rand.NewSource(3))
+	series := make(map[string][]int)
+	names := []string{"timestamps", "counter", "gauge", "random", "extremes"}
+	length := 100000
+
+	for _, name := range names {
+		values := make([]int, length)
+		ts := 1700000000000
+
+		for i := range values {
+			switch name {
+			case "timestamps":
+				ts += 1000 + rnd.Intn(3) - 1
+				values[i] = ts
+
+			case "counter":
+				ts += rnd.Intn(50)
+				values[i] = ts
+
+			case "gauge":
+				values[i] = int(2000*math.Sin(float64(i)/500)) + rnd.Intn(20) - 500
+
+			case "random":
+				values[i] = int(rnd.Uint64())
+
+			case "extremes":
+				values[i] = []int{math.MinInt64, math.MaxInt64, 0, -1}[rnd.Intn(4)]
+			}
+		}
+
+		series[name] = values
+	}
+
+	transforms := []string{"NONE", "DELTA", "DELTA+ZIGZAG", "DELTA2+ZIGZAG", "FOR", "DELTA+FOR", "ZIGZAG+DELTA2+FOR+ZIGZAG"}
+	coders := []string{"BITPACK", "EXPGOLOMB", "RICE"}
+	best := make(map[string]string)
+	bestSize := make(map[string]int)
+
+	for _, name := range names {
+		for _, transform := range transforms {
+			for _, coder := range coders {
+				for _, blockSize := range []uint{0, 1, 1000} {
+					opts := &integer.Options{Transform: transform, Coder: coder, BlockSize: blockSize}
+					input := series[name]
+
+					if blockSize == 1 {
+						input = input[0:300]
+					}
+
+					data, err := integer.CompressInts(nil, input, opts)
+
+					if err != nil {
+						fmt.Printf("Failure: %v\n", err)
+						os.Exit(1)
+					}
+
+					output, err := integer.DecompressInts([]int{42}, data)
+
+					if err != nil || len(output) != len(input)+1 || equalInts(input, output[1:]) == false {
+						fmt.Printf("Failure: %v, %v+%v, block size %d: %v\n", name, transform, coder, blockSize, err)
+						os.Exit(1)
+					}
+
+					if blockSize == 0 && (bestSize[name] == 0 || len(data) < bestSize[name]) {
+						bestSize[name] = len(data)
+						best[name] = transform + "+" + coder
+					}
+				}
+			}
+		}
+
+		fmt.Printf("%-40s Success (best: %s, %.2f bits per value)\n", name+":", best[name],
+			float64(8*bestSize[name])/float64(length))
+	}
+
+	check("Timestamp compression", bestSize["timestamps"]*8 < 3*length)
+	check("Gauge compression", bestSize["gauge"]*8 < 16*length)
+
+	// Empty column
+	data, _ := integer.CompressInts(nil, nil, nil)
+	output, err := integer.DecompressInts(nil, data)
+	check("Empty column", err == nil && len(output) == 0)
+}
+
+func TestStreaming() {
+	rnd := rand.New(rand.NewSource(4))
+	input := randomValues(rnd, 50000, 20)
+	bs := &bytes.Buffer{}
+	writer, _ := integer.NewColumnWriter(nopCloser{bs}, &integer.Options{Coder: "RICE", BlockSize: 777})
+
+	for written := 0; written < len(input); {
+		n := min(rnd.Intn(2000), len(input)-written)
+
+		if _, err := writer.Write(input[written : written+n]); err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		written += n
+	}
+
+	if err := writer.Close(); err != nil || writer.Written() != uint64(len(input)) {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	_, err := writer.Write(input)
+	expectError("Write after close", err, kanzi.ErrClosed)
+	data := bs.Bytes()
+	reader, err := integer.NewColumnReader(nopCloser{bytes.NewBuffer(data)})
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	output := make([]int, 0, len(input))
+
+	for {
+		buf := make([]int, rnd.Intn(3000))
+		n, err := reader.Read(buf)
+		output = append(output, buf[0:n]...)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	check("Streaming round trip", equalInts(input, output))
+}
+
+func TestWidths() {
+	rnd := rand.New(rand.NewSource(6))
+	values := randomValues(rnd, 10000, 32)
+	values[0], values[1] = math.MinInt32, math.MaxInt32
+	input32 := make([]int32, len(values))
+	input64 := make([]int64, len(values))
+
+	for i, v := range values {
+		input32[i] = int32(v)
+		input64[i] = int64(v) << 31
+	}
+
+	input64[2], input64[3] = math.MinInt64, math.MaxInt64
+	data32, err := integer.CompressInt32s(nil, input32, nil)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	output32, err := integer.DecompressInt32s(nil, data32)
+	check("32 bit round trip", err == nil && len(output32) == len(input32))
+
+	for i := range input32 {
+		check("32 bit values", input32[i] == output32[i])
+	}
+
+	data64, err := integer.CompressInt64s(nil, input64, &integer.Options{Coder: "RICE"})
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	output64, err := integer.DecompressInt64s(nil, data64)
+	check("64 bit round trip", err == nil && len(output64) == len(input64))
+
+	for i := range input64 {
+		check("64 bit values", input64[i] == output64[i])
+	}
+
+	// 32 bit values are widened, 64 bit values are not truncated
+	output64, err = integer.DecompressInt64s(nil, data32)
+	check("32 bit column into int64", err == nil && len(output64) == len(input32) && output64[0] == math.MinInt32)
+	_, err = integer.DecompressInt32s(nil, data64)
+	expectError("64 bit column into int32", err, kanzi.ErrInvalidParam)
+	_, err = integer.CompressInts(nil, []int{1, math.MaxInt32 + 1}, &integer.Options{Width: 32})
+	expectError("Value out of range", err, kanzi.ErrInvalidParam)
+	_, err = integer.CompressInts(nil, []int{1}, &integer.Options{Width: 16})
+	expectError("Invalid width", err, kanzi.ErrInvalidParam)
+
+	// Width 48 in the header
+	damaged := append([]byte(nil), data64...)
+	damaged[7] = damaged[7]&0xF0 | 3
+	_, err = integer.DecompressInt64s(nil, damaged)
+	expectError("Invalid width in the header", err, kanzi.ErrCorruptData)
+}
+
+func TestInvalidData() {
+	_, err := integer.CompressInts(nil, []int{1}, &integer.Options{Transform: "DELTA+XOR"})
+	expectError("Unknown transform", err, kanzi.ErrUnsupported)
+	_, err = integer.CompressInts(nil, []int{1}, &integer.Options{Transform: "DELTA+DELTA+DELTA+DELTA+DELTA"})
+	expectError("Too many stages", err, kanzi.ErrInvalidParam)
+	_, err = integer.CompressInts(nil, []int{1}, &integer.Options{Coder: "HUFFMAN"})
+	expectError("Unknown coder", err, kanzi.ErrUnsupported)
+	_, err = integer.CompressInts(nil, []int{1}, &integer.Options{BlockSize: integer.MAX_COLUMN_BLOCK_SIZE + 1})
+	expectError("Invalid block size", err, kanzi.ErrInvalidParam)
+	_, err = integer.NewDeltaCodec(3)
+	expectError("Invalid delta order", err, kanzi.ErrInvalidParam)
+
+	t, _ := integer.GetIntTransformType("delta2+zigzag")
+	check("Transform name", integer.GetIntTransformName(t) == "DELTA2+ZIGZAG")
+
+	rnd := rand.New(rand.NewSource(5))
+	input := randomValues(rnd, 5000, 30)
+
+	for _, coder := range []string{"BITPACK", "EXPGOLOMB", "RICE"} {
+		data, _ := integer.CompressInts(nil, input, &integer.Options{Coder: coder, BlockSize: 1000})
+		_, err = integer.DecompressInts(nil, data[0:len(data)/2])
+		expectError("Truncated "+coder, err, kanzi.ErrCorruptData)
+	}
+
+	data, _ := integer.CompressInts(nil, input, nil)
+	damaged := append([]byte(nil), data...)
+	damaged[0] = 'X'
+	_, err = integer.DecompressInts(nil, damaged)
+	expectError("Invalid magic", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], data...)
+	damaged[4] = 9
+	_, err = integer.DecompressInts(nil, damaged)
+	expectError("Unknown version", err, kanzi.ErrUnsupported)
+	damaged = append(damaged[:0], data...)
+	damaged[6] = 0x70 // unknown transform
+	_, err = integer.DecompressInts(nil, damaged)
+	expectError("Invalid transform", err, kanzi.ErrCorruptData)
+	packer, _ := integer.NewBitPacker(10)
+	_, _, err = packer.Inverse([]int{65}, make([]int, 10))
+	expectError("Invalid bit width", err, kanzi.ErrCorruptData)
+}
+
+// Random values with the given number of bits (signed if width < 64)
+func randomValues(rnd *rand.Rand, length int, width uint) []int {
+	res := make([]int, length)
+
+	for i := range res {
+		if width > 0 {
+			res[i] = int(rnd.Uint64()) >> (64 - width)
+		}
+	}
+
+	return res
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func check(name string, ok bool) {
+	if ok == false {
+		fmt.Printf("Failure: %v\n", name)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success\n", name+":")
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success (%v)\n", name+":", err)
+}
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (this nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"kanzi"
+	kimage "kanzi/image"
+	"math"
+	"math/rand"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestJPEG\n\n")
+
+	fmt.Printf("Decoding with image/jpeg test\n")
+	TestDecoding()
+
+	fmt.Printf("\nHuffman tables test\n")
+	TestHuffmanTables()
+
+	fmt.Printf("\nInvalid parameters test\n")
+	_, err := kimage.NewJPEGEncoder(0, true)
+	expectError("Invalid quality", err, kanzi.ErrInvalidParam)
+	_, err = kimage.NewJPEGEncoder(101, false)
+	expectError("Invalid quality", err, kanzi.ErrInvalidParam)
+}
+
+func TestDecoding() {
+	dims := [][2]int{{1, 1}, {8, 8}, {16, 16}, {37, 23}, {200, 150}, {257, 97}}
+
+	for _, channels := range []int{1, 3} {
+		for _, dim := range dims {
+			img := createImage(dim[0], dim[1], channels, int64(dim[0]))
+			prevPSNR := 0.0
+
+			for _, quality := range []int{10, 50, 75, 95} {
+				for _, optimize := range []bool{false, true} {
+					encoder, _ := kimage.NewJPEGEncoder(quality, optimize)
+					data, err := encoder.Encode(img)
+
+					if err != nil {
+						fmt.Printf("Failure: %v\n", err)
+						os.Exit(1)
+					}
+
+					decoded := decode(data, img)
+					report, _ := kimage.Compare(img, decoded)
+
+					if optimize == true {
+						if dim[0] >= 16 && report.PSNR < prevPSNR-0.5 {
+							fmt.Printf("Failure: the PSNR does not increase with the quality\n")
+							os.Exit(1)
+						}
+
+						prevPSNR = report.PSNR
+					}
+
+					if dim[0] == 200 && optimize == true {
+						fmt.Printf("%d channel(s), quality %3d: %6d bytes, %v\n", channels, quality, len(data), report)
+					}
+				}
+			}
+
+			if prevPSNR < 30 && dim[0] >= 16 {
+				fmt.Printf("Failure: low PSNR at quality 95: %.2f dB\n", prevPSNR)
+				os.Exit(1)
+			}
+
+			fmt.Printf("%-40s Success\n", fmt.Sprintf("%dx%d, %d channel(s):", dim[0], dim[1], channels))
+		}
+	}
+}
+
+// Decode with the standard library and check the format
+func decode(data []byte, ref *kimage.Image) *kimage.Image {
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		fmt.Printf("Failure: image/jpeg: %v\n", err)
+		os.Exit(1)
+	}
+
+	bounds := decoded.Bounds()
+
+	if bounds.Dx() != ref.Width || bounds.Dy() != ref.Height {
+		fmt.Printf("Failure: invalid dimensions: %dx%d\n", bounds.Dx(), bounds.Dy())
+		os.Exit(1)
+	}
+
+	if ycc, ok := decoded.(*image.YCbCr); ok == true {
+		if ref.Channels != 3 || ycc.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+			fmt.Printf("Failure: unexpected color format: %v\n", ycc.SubsampleRatio)
+			os.Exit(1)
+		}
+	} else if _, ok := decoded.(*image.Gray); ok == false || ref.Channels != 1 {
+		fmt.Printf("Failure: unexpected image type: %T\n", decoded)
+		os.Exit(1)
+	}
+
+	res, _ := kimage.NewImage(ref.Width, ref.Height, ref.Channels)
+
+	for y := 0; y < ref.Height; y++ {
+		for x := 0; x < ref.Width; x++ {
+			idx := (y*ref.Width + x) * ref.Channels
+
+			if ref.Channels == 1 {
+				res.Pix[idx] = color.GrayModel.Convert(decoded.At(x, y)).(color.Gray).Y
+				continue
+			}
+
+			rgb := color.RGBAModel.Convert(decoded.At(x, y)).(color.RGBA)
+			res.Pix[idx], res.Pix[idx+1], res.Pix[idx+2] = rgb.R, rgb.G, rgb.B
+		}
+	}
+
+	return res
+}
+
+func TestHuffmanTables() {
+	// Optimized tables are smaller than the standard tables (except for tiny
+	// images where the tables themselves dominate)
+	for _, channels := range []int{1, 3} {
+		img := createImage(320, 240, channels, 3)
+		var sizes [2]int
+
+		for i, optimize := range []bool{false, true} {
+			encoder, _ := kimage.NewJPEGEncoder(80, optimize)
+			data, _ := encoder.Encode(img)
+			decode(data, img)
+			sizes[i] = len(data)
+		}
+
+		fmt.Printf("%-40s Success (standard: %d bytes, optimized: %d bytes)\n",
+			fmt.Sprintf("%d channel(s):", channels), sizes[0], sizes[1])
+
+		if sizes[1] >= sizes[0] {
+			fmt.Printf("Failure: the optimized tables are not better\n")
+			os.Exit(1)
+		}
+	}
+
+	// Skewed statistics (long codes) and extreme values (byte stuffing)
+	rnd := rand.New(rand.NewSource(5))
+
+	for _, channels := range []int{1, 3} {
+		img, _ := kimage.NewImage(512, 64, channels)
+
+		for i := range img.Pix {
+			if rnd.Intn(50) == 0 {
+				img.Pix[i] = byte(rnd.Intn(2) * 255)
+			} else {
+				img.Pix[i] = 128
+			}
+		}
+
+		for _, quality := range []int{1, 100} {
+			encoder, _ := kimage.NewJPEGEncoder(quality, true)
+			data, _ := encoder.Encode(img)
+			decode(data, img)
+		}
+	}
+
+	fmt.Printf("%-40s Success\n", "Extreme values:")
+}
+
+// Create an image with smooth gradients, sharp edges and textured areas
+func createImage(width, height, channels int, seed int64) *kimage.Image {
+	img, _ := kimage.NewImage(width, height, channels)
+	rnd := rand.New(rand.NewSource(seed))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < channels; c++ {
+				val := 64 + 40*math.Sin(float64(x+20*c)/23) + 30*math.Cos(float64(y)/17)
+
+				if x > width/2 && y > height/2 {
+					val += float64(((x/3)^(y/3))&1)*60 + float64(rnd.Intn(16))
+				} else if (x-width/4)*(x-width/4)+(y-height/4)*(y-height/4) < width*height/40 {
+					val += 100
+				}
+
+				img.Pix[(y*width+x)*channels+c] = byte(math.Max(0, math.Min(255, val)))
+			}
+		}
+	}
+
+	return img
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success (%v)\n", name+":", err)
+}
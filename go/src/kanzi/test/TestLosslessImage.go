@@ -0,0 +1,307 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"kanzi"
+	kimage "kanzi/image"
+	"kanzi/transform"
+	"math"
+	"math/rand"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestLosslessImage\n\n")
+
+	fmt.Printf("DWT_CDF_5_3 round trip test\n")
+	TestTransform()
+
+	fmt.Printf("\nLossless codec test\n")
+	TestLossless()
+
+	fmt.Printf("\nProgressive lossless test\n")
+	TestPrefixes()
+}
+
+func TestTransform() {
+	rnd := rand.New(rand.NewSource(11))
+	dims := [][2]uint{{1, 1}, {1, 17}, {2, 2}, {3, 5}, {8, 8}, {37, 23}, {64, 48}, {100, 75}, {255, 1}, {129, 130}}
+
+	for _, dim := range dims {
+		for steps := uint(1); steps <= 5; steps++ {
+			dwt, err := transform.NewDWT53(dim[0], dim[1], steps)
+
+			if err != nil {
+				fmt.Printf("Failure: %v\n", err)
+				os.Exit(1)
+			}
+
+			count := int(dim[0] * dim[1])
+			input := make([]int, count)
+
+			for i := range input {
+				input[i] = rnd.Intn(1<<12) - 1<<11
+			}
+
+			output := make([]int, count)
+			reverse := make([]int, count)
+			dwt.Forward(input, output)
+			dwt.Inverse(output, reverse)
+
+			// In place
+			inPlace := append([]int(nil), input...)
+			dwt.Forward(inPlace, inPlace)
+			dwt.Inverse(inPlace, inPlace)
+
+			for i := range input {
+				if input[i] != reverse[i] || input[i] != inPlace[i] {
+					fmt.Printf("Failure: %dx%d, %d steps: different value at index %d: %d, %d, %d\n",
+						dim[0], dim[1], steps, i, input[i], reverse[i], inPlace[i])
+					os.Exit(1)
+				}
+			}
+		}
+
+		fmt.Printf("%-30s Success\n", fmt.Sprintf("%dx%d:", dim[0], dim[1]))
+	}
+
+	dwt, _ := transform.NewDWT53(64, 64, 3)
+	buf := make([]int, 64*64)
+	_, err := transform.NewDWT53(0, 8, 1)
+	expectError("Invalid dimensions", err, kanzi.ErrInvalidParam)
+	_, err = transform.NewDWT53(8, 8, 0)
+	expectError("Invalid steps", err, kanzi.ErrInvalidParam)
+	_, _, err = dwt.Forward(buf[0:100], buf)
+	expectError("Small buffer", err, kanzi.ErrBufferTooSmall)
+}
+
+func TestLossless() {
+	type testImage struct {
+		name string
+		img  *kimage.Image
+	}
+
+	var images []testImage
+
+	for _, channels := range []int{1, 3} {
+		images = append(images,
+			testImage{"natural", createNaturalImage(256, 192, channels, 1)},
+			testImage{"natural", createNaturalImage(123, 77, channels, 2)},
+			testImage{"synthetic", createImage(99, 65, channels, 3)},
+			testImage{"random", createRandomImage(61, 47, channels, 4)},
+			testImage{"random", createRandomImage(1, 1, channels, 5)},
+			testImage{"random", createRandomImage(1, 33, channels, 6)},
+			testImage{"random", createRandomImage(50, 3, channels, 7)})
+	}
+
+	// Extreme values
+	img, _ := kimage.NewImage(20, 20, 3)
+
+	for i := range img.Pix {
+		img.Pix[i] = byte(((i / 3) & 1) * 255)
+
+		if i%3 == 1 {
+			img.Pix[i] = 255 - img.Pix[i]
+		}
+	}
+
+	images = append(images, testImage{"extreme", img})
+
+	for _, ti := range images {
+		img := ti.img
+		encoder, _ := kimage.NewWaveletEncoder()
+		encoder.SetLossless(true)
+		data, err := encoder.Encode(img, 0)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		decoder, _ := kimage.NewWaveletDecoder()
+		decoded, err := decoder.Decode(data)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		if decoded.Width != img.Width || decoded.Height != img.Height || decoded.Channels != img.Channels ||
+			bytes.Equal(decoded.Pix, img.Pix) == false {
+			report, _ := kimage.Compare(img, decoded)
+			fmt.Printf("Failure: %s %dx%d: the image is different: %v\n", ti.name, img.Width, img.Height, report)
+			os.Exit(1)
+		}
+
+		bpp := float64(8*len(data)) / float64(img.Width*img.Height)
+		fmt.Printf("%-30s Success (%6d bytes, %.3f bpp)\n",
+			fmt.Sprintf("%s %dx%dx%d:", ti.name, img.Width, img.Height, img.Channels), len(data), bpp)
+
+		// Natural images must compress
+		if ti.name == "natural" && bpp >= float64(8*img.Channels)*0.75 {
+			fmt.Printf("Failure: poor compression\n")
+			os.Exit(1)
+		}
+	}
+}
+
+func TestPrefixes() {
+	img := createNaturalImage(160, 120, 3, 8)
+	encoder, _ := kimage.NewWaveletEncoder()
+	encoder.SetLossless(true)
+	data, _ := encoder.Encode(img, 0)
+	decoder, _ := kimage.NewWaveletDecoder()
+	prevPSNR := 0.0
+
+	for size := 500; size < len(data); size *= 2 {
+		decoded, err := decoder.Decode(data[0:size])
+
+		if err != nil || decoder.Truncated() == false {
+			fmt.Printf("Failure: prefix of %d bytes: %v\n", size, err)
+			os.Exit(1)
+		}
+
+		report, _ := kimage.Compare(img, decoded)
+		fmt.Printf("Prefix of %6d bytes: %v\n", size, report)
+
+		if report.PSNR <= prevPSNR {
+			fmt.Printf("Failure: the PSNR does not increase with the size\n")
+			os.Exit(1)
+		}
+
+		prevPSNR = report.PSNR
+	}
+
+	decoded, _ := decoder.Decode(data)
+
+	if decoder.Truncated() == true || bytes.Equal(decoded.Pix, img.Pix) == false {
+		fmt.Printf("Failure: the complete stream is not lossless\n")
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success\n", "Prefixes:")
+
+	// The lossy mode keeps working
+	encoder.SetLossless(false)
+	lossy, _ := encoder.Encode(img, 0)
+	decoded, err := decoder.Decode(lossy)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	report, _ := kimage.Compare(img, decoded)
+	fmt.Printf("%-30s Success (%d bytes lossy, %d bytes lossless, %v)\n", "Lossy mode:", len(lossy), len(data), report)
+}
+
+// Create an image similar to a photograph: smooth areas with fractal noise
+// (midpoint displacement), a few edges and correlated channels
+func createNaturalImage(width, height, channels int, seed int64) *kimage.Image {
+	img, _ := kimage.NewImage(width, height, channels)
+	rnd := rand.New(rand.NewSource(seed))
+	size := 1
+
+	for size < width || size < height {
+		size <<= 1
+	}
+
+	field := make([]float64, (size+1)*(size+1))
+	stride := size + 1
+	amplitude := 96.0
+
+	for step := size; step > 1; step >>= 1 {
+		half := step >> 1
+
+		for y := 0; y < size; y += step {
+			for x := 0; x < size; x += step {
+				avg := (field[y*stride+x] + field[y*stride+x+step] + field[(y+step)*stride+x] +
+					field[(y+step)*stride+x+step]) / 4
+				field[(y+half)*stride+x+half] = avg + (rnd.Float64()-0.5)*amplitude
+				field[y*stride+x+half] = (field[y*stride+x]+field[y*stride+x+step])/2 + (rnd.Float64()-0.5)*amplitude/2
+				field[(y+half)*stride+x] = (field[y*stride+x]+field[(y+step)*stride+x])/2 + (rnd.Float64()-0.5)*amplitude/2
+			}
+		}
+
+		amplitude *= 0.55
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			val := 128 + field[y*stride+x]
+
+			// Object with an edge
+			if (x-width/3)*(x-width/3)+(y-height/2)*(y-height/2) < width*height/16 {
+				val = val*0.6 + 70
+			}
+
+			for c := 0; c < channels; c++ {
+				v := val + float64(c*12) + 4*math.Sin(float64(x*(c+1))/9) + float64(rnd.Intn(3))
+				img.Pix[(y*width+x)*channels+c] = byte(math.Max(0, math.Min(255, v)))
+			}
+		}
+	}
+
+	return img
+}
+
+func createRandomImage(width, height, channels int, seed int64) *kimage.Image {
+	img, _ := kimage.NewImage(width, height, channels)
+	rnd := rand.New(rand.NewSource(seed))
+
+	for i := range img.Pix {
+		img.Pix[i] = byte(rnd.Intn(256))
+	}
+
+	return img
+}
+
+// Create an image with smooth gradients, sharp edges and textured areas
+func createImage(width, height, channels int, seed int64) *kimage.Image {
+	img, _ := kimage.NewImage(width, height, channels)
+	rnd := rand.New(rand.NewSource(seed))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < channels; c++ {
+				val := 64 + 40*math.Sin(float64(x+20*c)/23) + 30*math.Cos(float64(y)/17)
+
+				if x > width/2 && y > height/2 {
+					val += float64(((x/3)^(y/3))&1)*60 + float64(rnd.Intn(16))
+				} else if (x-width/4)*(x-width/4)+(y-height/4)*(y-height/4) < width*height/40 {
+					val += 100
+				}
+
+				img.Pix[(y*width+x)*channels+c] = byte(math.Max(0, math.Min(255, val)))
+			}
+		}
+	}
+
+	return img
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success (%v)\n", name+":", err)
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"kanzi"
+	kio "kanzi/io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestMetadata\n\n")
+
+	fmt.Printf("Round trip test\n")
+	TestRoundTrip()
+
+	fmt.Printf("\nFormat test\n")
+	TestFormat()
+
+	fmt.Printf("\nInvalid metadata test\n")
+	TestInvalid()
+}
+
+func TestRoundTrip() {
+	input := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 1000))
+	publicKey, privateKey, _ := kio.GenerateSigningKey()
+	metadata := &kio.Metadata{
+		Name:        "fox.txt",
+		ModTime:     time.Date(2021, 3, 14, 15, 9, 26, 535897932, time.UTC),
+		ContentType: "text/plain; charset=utf-8",
+		Tags:        map[string]string{"author": "Aesop", "lang": "en", "empty": ""},
+	}
+
+	configs := []*kio.Options{
+		{Metadata: metadata},
+		{Metadata: metadata, ChecksumAlgorithm: "SHA256", Redundancy: 10, SyncMarkers: true},
+		{Metadata: metadata, Password: []byte("password"), SigningKey: privateKey, VerifyKey: publicKey},
+		{Metadata: &kio.Metadata{}},
+		{},
+	}
+
+	for i, options := range configs {
+		compressed, err := kio.Compress(nil, input, options)
+
+		if err != nil {
+			fmt.Printf("Failure: config %d: %v\n", i, err)
+			os.Exit(1)
+		}
+
+		output, read, err := decode(compressed, options.Password)
+
+		if err != nil || bytes.Equal(input, output) == false {
+			fmt.Printf("Failure: config %d: %v\n", i, err)
+			os.Exit(1)
+		}
+
+		if options.Metadata == nil {
+			if read != nil {
+				fmt.Printf("Failure: config %d: unexpected metadata: %+v\n", i, read)
+				os.Exit(1)
+			}
+		} else if equalMetadata(options.Metadata, read) == false {
+			fmt.Printf("Failure: config %d: expected %+v, got %+v\n", i, options.Metadata, read)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Config %d: Success (%d bytes)\n", i, len(compressed))
+	}
+
+	// The metadata is signed and authenticated
+	options := configs[2]
+	compressed, _ := kio.Compress(nil, input, options)
+	idx := bytes.Index(compressed, []byte("Aesop"))
+	compressed[idx] = 'a'
+	expectError("Modified (signature)", kio.VerifyEmbedded(bytes.NewReader(compressed), publicKey),
+		kanzi.ErrAuthentication)
+	_, _, err := decode(compressed, options.Password)
+	expectError("Modified (encryption)", err, kanzi.ErrAuthentication)
+
+	// The metadata is kept across Reset
+	var buf bytes.Buffer
+	cos, _ := kio.NewCompressedOutputStream("ANS", "LZ4", &bufferCloser{&buf}, 4096, false, nil, 1)
+	cos.SetMetadata(metadata)
+
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		cos.Write(input)
+		cos.Close()
+
+		if output, read, err := decode(buf.Bytes(), nil); err != nil || bytes.Equal(input, output) == false ||
+			equalMetadata(metadata, read) == false {
+			fmt.Printf("Failure: reset %d: %v\n", i, err)
+			os.Exit(1)
+		}
+
+		cos.Reset(&bufferCloser{&buf})
+	}
+
+	fmt.Printf("Reset: Success\n")
+}
+
+func TestFormat() {
+	input := []byte("data")
+	metadata := &kio.Metadata{Name: "a", ContentType: "b"}
+	compressed, _ := kio.Compress(nil, input, &kio.Options{Metadata: metadata})
+
+	if compressed[kio.HEADER_SIZE-1]&kio.METADATA_MASK == 0 {
+		fmt.Printf("Failure: the metadata flag is not set\n")
+		os.Exit(1)
+	}
+
+	// Size (16 bits), then type, length and value of each entry
+	section := []byte{0, 8, kio.META_NAME, 0, 1, 'a', kio.META_CONTENT_TYPE, 0, 1, 'b'}
+
+	if bytes.Equal(compressed[kio.HEADER_SIZE:kio.HEADER_SIZE+len(section)], section) == false {
+		fmt.Printf("Failure: unexpected metadata section: %v\n", compressed[kio.HEADER_SIZE:kio.HEADER_SIZE+len(section)])
+		os.Exit(1)
+	}
+
+	// Unknown entry types are skipped
+	compressed[kio.HEADER_SIZE+6] = 200
+	output, read, err := decode(compressed, nil)
+
+	if err != nil || bytes.Equal(input, output) == false || read.Name != "a" || read.ContentType != "" {
+		fmt.Printf("Failure: unknown entry: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Unknown entry: Success\n")
+
+	// Truncated entry
+	compressed[kio.HEADER_SIZE+1] = 7
+	_, _, err = decode(compressed, nil)
+	expectError("Truncated entry", err, kanzi.ErrCorruptData)
+}
+
+func TestInvalid() {
+	invalid := []struct {
+		name     string
+		metadata *kio.Metadata
+	}{
+		{"Directory", &kio.Metadata{Name: "dir/file"}},
+		{"Empty tag key", &kio.Metadata{Tags: map[string]string{"": "value"}}},
+		{"Not UTF-8", &kio.Metadata{ContentType: "\xff\xfe"}},
+		{"Too large", &kio.Metadata{Tags: map[string]string{"big": strings.Repeat("x", 70000)}}},
+	}
+
+	for _, test := range invalid {
+		var buf bytes.Buffer
+		cos, _ := kio.NewCompressedOutputStream("None", "None", &bufferCloser{&buf}, 4096, false, nil, 1)
+		expectError(test.name, cos.SetMetadata(test.metadata), kanzi.ErrInvalidParam)
+	}
+
+	var buf bytes.Buffer
+	cos, _ := kio.NewCompressedOutputStream("None", "None", &bufferCloser{&buf}, 4096, false, nil, 1)
+	cos.Write([]byte("data"))
+	cos.Flush()
+	expectError("After the header", cos.SetMetadata(&kio.Metadata{Name: "a"}), kanzi.ErrInvalidParam)
+}
+
+func equalMetadata(expected, read *kio.Metadata) bool {
+	if read == nil || expected.Name != read.Name || expected.ContentType != read.ContentType ||
+		expected.ModTime.Equal(read.ModTime) == false {
+		return false
+	}
+
+	return (len(expected.Tags) == 0 && len(read.Tags) == 0) || reflect.DeepEqual(expected.Tags, read.Tags)
+}
+
+// Decode the stream, return the data and the metadata
+func decode(compressed []byte, password []byte) ([]byte, *kio.Metadata, error) {
+	cis, err := kio.NewCompressedInputStream(&readerCloser{bytes.NewReader(compressed)}, nil, 1)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if password != nil {
+		cis.SetPassword(password)
+	}
+
+	if err = cis.ReadHeader(); err != nil {
+		return nil, nil, err
+	}
+
+	var output bytes.Buffer
+	buffer := make([]byte, 65536)
+
+	for {
+		n, err := cis.Read(buffer)
+
+		if err != nil {
+			return nil, cis.GetMetadata(), err
+		}
+
+		if n < 0 {
+			break
+		}
+
+		output.Write(buffer[0:n])
+	}
+
+	return output.Bytes(), cis.GetMetadata(), cis.Close()
+}
+
+type bufferCloser struct {
+	*bytes.Buffer
+}
+
+func (this *bufferCloser) Close() error {
+	return nil
+}
+
+type readerCloser struct {
+	*bytes.Reader
+}
+
+func (this *readerCloser) Close() error {
+	return nil
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success (%v)\n", name+":", err)
+}
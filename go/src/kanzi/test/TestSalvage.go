@@ -0,0 +1,332 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"kanzi"
+	kio "kanzi/io"
+	"math/rand"
+	"os"
+)
+
+const BLOCK_SIZE = 16 * 1024
+
+func main() {
+	fmt.Printf("TestSalvage\n\n")
+
+	fmt.Printf("Damaged block test\n")
+	TestDamagedBlock()
+
+	fmt.Printf("\nSync markers test\n")
+	TestSyncMarkers()
+
+	fmt.Printf("\nTruncated stream test\n")
+	TestTruncated()
+
+	fmt.Printf("\nEncrypted stream test\n")
+	TestEncrypted()
+}
+
+func TestDamagedBlock() {
+	input := createInput(10*BLOCK_SIZE + 1000)
+
+	for _, markers := range []bool{false, true} {
+		options := &kio.Options{BlockSize: BLOCK_SIZE, Checksum: true, SyncMarkers: markers}
+		compressed, err := kio.Compress(nil, input, options)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		if output, err := kio.Decompress(nil, compressed, nil); err != nil || bytes.Equal(input, output) == false {
+			fmt.Printf("Failure: markers=%t: %v\n", markers, err)
+			os.Exit(1)
+		}
+
+		// Damage the data of block 4
+		start, end := findBlock(compressed, 4, markers)
+		damaged := append([]byte(nil), compressed...)
+
+		for i := start + 8; i < end; i += 64 {
+			damaged[i] ^= 0x55
+		}
+
+		_, _, err = decode(damaged, 1, kio.SALVAGE_OFF, nil)
+		expectError(fmt.Sprintf("No salvage (markers=%t)", markers), err, nil)
+
+		for _, jobs := range []uint{1, 4} {
+			checkSalvage(fmt.Sprintf("markers=%t, jobs=%d", markers, jobs), input, damaged, jobs, nil, 4, 1)
+		}
+	}
+}
+
+func TestSyncMarkers() {
+	input := createInput(12*BLOCK_SIZE + 5000)
+	options := &kio.Options{BlockSize: BLOCK_SIZE, ChecksumAlgorithm: "CRC32C", SyncMarkers: true, Jobs: 2}
+	compressed, err := kio.Compress(nil, input, options)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	if compressed[kio.HEADER_SIZE+1]&kio.SYNC_MARKERS_MASK == 0 {
+		fmt.Printf("Failure: the sync markers are not enabled\n")
+		os.Exit(1)
+	}
+
+	// Damage the length of block 3: the blocks cannot be found without the
+	// sync markers
+	start, _ := findBlock(compressed, 3, true)
+	damaged := append([]byte(nil), compressed...)
+	binary.BigEndian.PutUint32(damaged[start-4:], 0x7FFFFFFF)
+	_, _, err = decode(damaged, 2, kio.SALVAGE_OFF, nil)
+	expectError("No salvage", err, kanzi.ErrCorruptData)
+	checkSalvage("Invalid length", input, damaged, 2, nil, 3, 1)
+
+	// Wipe blocks 6 to 8 (including the markers)
+	start, _ = findBlock(compressed, 6, true)
+	_, end := findBlock(compressed, 8, true)
+	damaged = append([]byte(nil), compressed...)
+	rand.New(rand.NewSource(1)).Read(damaged[start-kio.SYNC_MARKER_SIZE : end])
+	checkSalvage("Wiped blocks", input, damaged, 2, nil, 6, 3)
+
+	// Remove a part of block 10 and of block 11: block 10 is read past its
+	// end (up to block 12) and the blocks following it are lost too
+	start, _ = findBlock(compressed, 10, true)
+	damaged = append(append([]byte(nil), compressed[0:start+100]...), compressed[start+100+BLOCK_SIZE/2:]...)
+	checkSalvage("Removed bytes", input, damaged, 1, nil, 10, 1, 11, 2)
+}
+
+func TestTruncated() {
+	input := createInput(8*BLOCK_SIZE + 100)
+
+	for _, markers := range []bool{false, true} {
+		options := &kio.Options{BlockSize: BLOCK_SIZE, Checksum: true, SyncMarkers: markers}
+		compressed, _ := kio.Compress(nil, input, options)
+		start, _ := findBlock(compressed, 6, markers)
+		truncated := compressed[0 : start+100]
+		name := fmt.Sprintf("Truncated (markers=%t)", markers)
+		output, lost, err := decode(truncated, 2, kio.SALVAGE_SKIP, nil)
+
+		if err != nil || bytes.Equal(input[0:5*BLOCK_SIZE], output) == false {
+			fmt.Printf("Failure: %v: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		if len(lost) != 1 || lost[0].FirstBlock != 6 || lost[0].Blocks != 0 || lost[0].Offset != 5*BLOCK_SIZE {
+			fmt.Printf("Failure: %v: unexpected lost ranges: %v\n", name, lost)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-30s Success (%v)\n", name+":", lost[0].Err)
+	}
+}
+
+func TestEncrypted() {
+	input := createInput(9*BLOCK_SIZE + 4321)
+	password := []byte("password")
+	publicKey, privateKey, _ := kio.GenerateSigningKey()
+	options := &kio.Options{BlockSize: BLOCK_SIZE, Password: password, SyncMarkers: true, Jobs: 4,
+		SigningKey: privateKey, VerifyKey: publicKey}
+	compressed, err := kio.Compress(nil, input, options)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	if output, err := kio.Decompress(nil, compressed, options); err != nil || bytes.Equal(input, output) == false {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The sync markers are signed
+	if err = kio.VerifyEmbedded(bytes.NewReader(compressed), publicKey); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Damage the data of block 2 and the marker of block 7
+	damaged := append([]byte(nil), compressed...)
+	start, _ := findBlock(compressed, 2, true)
+	damaged[start+10] ^= 1
+	start, _ = findBlock(compressed, 7, true)
+	damaged[start-9] ^= 1
+	_, _, err = decode(damaged, 4, kio.SALVAGE_OFF, password)
+	expectError("No salvage", err, kanzi.ErrAuthentication)
+	checkSalvage("Encrypted", input, damaged, 4, password, 2, 1, 7, 1)
+
+	// Invalid mode
+	cis, _ := kio.NewCompressedInputStream(&readerCloser{bytes.NewReader(compressed)}, nil, 1)
+	expectError("Invalid mode", cis.SetSalvageMode(3), kanzi.ErrInvalidParam)
+}
+
+// Decode the damaged stream in both salvage modes and check the output and
+// the lost ranges. The expected losses are given as pairs of first block and
+// number of blocks (0: the rest of the stream is lost).
+func checkSalvage(name string, input, damaged []byte, jobs uint, password []byte, losses ...int) {
+	for _, mode := range []int{kio.SALVAGE_SKIP, kio.SALVAGE_ZERO_FILL} {
+		output, lost, err := decode(damaged, jobs, mode, password)
+
+		if err != nil {
+			fmt.Printf("Failure: %v: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		if len(lost) != len(losses)/2 {
+			fmt.Printf("Failure: %v: unexpected lost ranges: %v\n", name, lost)
+			os.Exit(1)
+		}
+
+		// Rebuild the expected output from the input
+		var expected []byte
+		offset := 0
+
+		for i, r := range lost {
+			if r.FirstBlock != losses[2*i] || r.Blocks != losses[2*i+1] ||
+				r.Offset != uint64((r.FirstBlock-1)*BLOCK_SIZE) || r.Length != uint64(r.Blocks*BLOCK_SIZE) {
+				fmt.Printf("Failure: %v: unexpected lost range: %+v\n", name, r)
+				os.Exit(1)
+			}
+
+			expected = append(expected, input[offset:r.Offset]...)
+
+			if r.Blocks == 0 {
+				offset = len(input)
+				break
+			}
+
+			if mode == kio.SALVAGE_ZERO_FILL {
+				expected = append(expected, make([]byte, r.Length)...)
+			}
+
+			offset = int(r.Offset + r.Length)
+		}
+
+		expected = append(expected, input[offset:]...)
+
+		if bytes.Equal(expected, output) == false {
+			fmt.Printf("Failure: %v: mode %d: unexpected output (%d bytes, expected %d)\n",
+				name, mode, len(output), len(expected))
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%-30s Success (%d lost range(s))\n", name+":", len(losses)/2)
+}
+
+// Return the position of the data of a block in a stream without extended
+// flags (except the sync markers flag) and the position of the next block
+func findBlock(compressed []byte, id int, markers bool) (int, int) {
+	pos := kio.HEADER_SIZE
+
+	if markers == true {
+		pos += 2
+	}
+
+	if compressed[kio.HEADER_SIZE-1]&kio.ENCRYPTION_MASK != 0 {
+		// KDF parameters, salt, IV and tag
+		pos += 4 + 2*kio.ENCRYPTION_SALT_SIZE + kio.ENCRYPTION_TAG_SIZE
+	}
+
+	for i := 1; ; i++ {
+		headerSize := 4
+
+		if markers == true {
+			headerSize = kio.SYNC_MARKER_SIZE
+		}
+
+		pos += headerSize
+		length := int(binary.BigEndian.Uint32(compressed[pos-4:]))
+
+		if i == id {
+			return pos, pos + length
+		}
+
+		pos += length
+	}
+}
+
+// Decode the stream, return the data and the lost ranges
+func decode(compressed []byte, jobs uint, mode int, password []byte) ([]byte, []kio.LostRange, error) {
+	cis, err := kio.NewCompressedInputStream(&readerCloser{bytes.NewReader(compressed)}, nil, jobs)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = cis.SetSalvageMode(mode); err != nil {
+		return nil, nil, err
+	}
+
+	if password != nil {
+		cis.SetPassword(password)
+	}
+
+	var output bytes.Buffer
+	buffer := make([]byte, 65536)
+
+	for {
+		n, err := cis.Read(buffer)
+
+		if err != nil {
+			return nil, cis.GetLostRanges(), err
+		}
+
+		if n < 0 {
+			break
+		}
+
+		output.Write(buffer[0:n])
+	}
+
+	return output.Bytes(), cis.GetLostRanges(), cis.Close()
+}
+
+func createInput(size int) []byte {
+	input := make([]byte, size)
+	rnd := rand.New(rand.NewSource(int64(size)))
+
+	for i := range input {
+		input[i] = byte(65 + rnd.Intn(4+(i>>12)%20))
+	}
+
+	return input
+}
+
+type readerCloser struct {
+	*bytes.Reader
+}
+
+func (this *readerCloser) Close() error {
+	return nil
+}
+
+// Check the error (of any kind if kind is nil)
+func expectError(name string, err error, kind error) {
+	if err == nil || (kind != nil && errors.Is(err, kind) == false) {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success (%v)\n", name+":", err)
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"kanzi"
+	"kanzi/function"
+	kio "kanzi/io"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	fmt.Printf("TestShuffleFilter\n\n")
+
+	fmt.Printf("Correctness test\n")
+	TestCorrectness()
+
+	fmt.Printf("\nWidth guess test\n")
+	TestGuess()
+
+	fmt.Printf("\nCompressed stream test\n")
+	TestStream()
+
+	fmt.Printf("\nInvalid data test\n")
+	TestInvalidData()
+
+	fmt.Printf("\nSpeed test\n")
+	TestSpeed()
+}
+
+func TestCorrectness() {
+	stages := []string{"NONE", "LZ4", "RLT", "BWT+MTF", "BWTS+MTF"}
+
+	for width := uint(1); width <= function.SHUFFLE_MAX_WIDTH; width++ {
+		for _, stride := range []uint{0, 1, 3, function.SHUFFLE_MAX_STRIDE} {
+			for _, size := range []int{0, 1, 15, 1000, 1003, 70001} {
+				input := createRecords(width, size, int64(size))
+				roundTrip(input, width, stride, function.NULL_TRANSFORM_TYPE)
+			}
+		}
+
+		fmt.Printf("%-40s Success\n", fmt.Sprintf("width %d:", width))
+	}
+
+	for _, stage := range stages {
+		nextType := function.GetByteFunctionType(stage)
+
+		for _, size := range []int{1, 100, 4099, 100000} {
+			roundTrip(createRecords(8, size, 1), 8, 1, nextType)
+			roundTrip(createRecords(8, size, 2), 0, 0, nextType)
+		}
+
+		fmt.Printf("%-40s Success\n", "Next stage "+stage+":")
+	}
+
+	// Random bytes
+	rnd := rand.New(rand.NewSource(3))
+	input := make([]byte, 10000)
+	rnd.Read(input)
+	roundTrip(input, 0, 0, function.NULL_TRANSFORM_TYPE)
+	roundTrip(input, 7, 2, function.LZ4_TYPE)
+	fmt.Printf("%-40s Success\n", "Random bytes:")
+}
+
+// Forward and inverse filter, return the filtered block
+func roundTrip(input []byte, width, stride uint, nextType byte) []byte {
+	filter, err := function.NewShuffleFilter(uint(len(input)), width, stride, nextType)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	required := filter.MaxEncodedLen(len(input))
+
+	if required < 0 {
+		// Max size unknown (RLT)
+		required = 2*len(input) + 64
+	}
+
+	output := make([]byte, required)
+	_, dstIdx, err := filter.Forward(input, output)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	if width != 0 && (uint(output[0]) != width || uint(output[1]) != stride || output[2] != nextType) {
+		fmt.Printf("Failure: invalid header: %v\n", output[0:function.SHUFFLE_HEADER_SIZE])
+		os.Exit(1)
+	}
+
+	// The decoder only knows the function type
+	reverse := make([]byte, len(input))
+	filter, _ = function.NewShuffleFilter(dstIdx, 0, 0, function.NULL_TRANSFORM_TYPE)
+	_, n, err := filter.Inverse(output, reverse)
+
+	if err != nil || n != uint(len(input)) || bytes.Equal(input, reverse) == false {
+		fmt.Printf("Failure: width %d, stride %d, next stage %d, %d bytes: %v\n", width, stride, nextType,
+			len(input), err)
+		os.Exit(1)
+	}
+
+	return output[0:dstIdx]
+}
+
+func TestGuess() {
+	for _, width := range []uint{2, 4, 8, 12} {
+		input := createRecords(width, 400000, 5)
+		output := roundTrip(input, 0, 0, function.NULL_TRANSFORM_TYPE)
+		before := entropy(input)
+		after := entropy(output[function.SHUFFLE_HEADER_SIZE:])
+		fmt.Printf("%-40s Success (width %d, stride %d, entropy: %.3f -> %.3f bits per byte)\n",
+			fmt.Sprintf("%d byte records:", width), output[0], output[1], before, after)
+
+		if uint(output[0]) != width {
+			fmt.Printf("Failure: wrong width\n")
+			os.Exit(1)
+		}
+
+		if after >= before {
+			fmt.Printf("Failure: the filter does not reduce the entropy\n")
+			os.Exit(1)
+		}
+	}
+
+	// Text: no filter
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 1000)
+	output := roundTrip(input, 0, 0, function.NULL_TRANSFORM_TYPE)
+	check("Text", output[0] == 1 && output[1] == 0)
+
+	// Small blocks
+	for _, size := range []int{0, 1, 10, 63, 64, 100} {
+		roundTrip(createRecords(4, size, 6), 0, 0, function.NULL_TRANSFORM_TYPE)
+	}
+
+	fmt.Printf("%-40s Success\n", "Small blocks:")
+}
+
+func TestStream() {
+	// A column of float64 values (single precision measurements) and an
+	// array of 12 byte structs
+	values := make([]byte, 8*200000)
+
+	for i := 0; i < len(values)/8; i++ {
+		val := 20 + 5*math.Sin(float64(i)/1000) + float64(i)*1e-4
+		binary.LittleEndian.PutUint64(values[8*i:], math.Float64bits(float64(float32(val))))
+	}
+
+	inputs := map[string][]byte{"float64 column": values, "12 byte structs": createRecords(12, 1200000, 7)}
+
+	for _, name := range []string{"float64 column", "12 byte structs"} {
+		input := inputs[name]
+
+		for _, pair := range [][2]string{{"LZ4", "Huffman"}, {"BWT+MTF", "ANS"}} {
+			var sizes [2]int
+
+			for i, transform := range []string{pair[0], "Shuffle+" + pair[0]} {
+				opts := &kio.Options{Entropy: pair[1], Transform: transform, BlockSize: 1024 * 1024}
+				compressed, err := kio.Compress(nil, input, opts)
+
+				if err != nil {
+					fmt.Printf("Failure: %v\n", err)
+					os.Exit(1)
+				}
+
+				output, err := kio.Decompress(nil, compressed, opts)
+
+				if err != nil || bytes.Equal(input, output) == false {
+					fmt.Printf("Failure: %v+%v: %v\n", transform, pair[1], err)
+					os.Exit(1)
+				}
+
+				sizes[i] = len(compressed)
+			}
+
+			fmt.Printf("%-40s Success (%d bytes -> %d bytes without filter, %d bytes with filter)\n",
+				name+", "+pair[0]+":", len(input), sizes[0], sizes[1])
+
+			if sizes[1] >= sizes[0] {
+				fmt.Printf("Failure: the filter does not improve the compression\n")
+				os.Exit(1)
+			}
+		}
+	}
+
+	for _, name := range []string{"SHUFFLE", "SHUFFLE+LZ4", "SHUFFLE+BWT+MTF", "SHUFFLE+BWTS+MTF", "SHUFFLE+RLT"} {
+		if res := function.GetByteFunctionName(function.GetByteFunctionType(name)); res != name {
+			fmt.Printf("Failure: unexpected function name: %v instead of %v\n", res, name)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%-40s Success\n", "Function names:")
+}
+
+func TestInvalidData() {
+	_, err := function.NewShuffleFilter(0, function.SHUFFLE_MAX_WIDTH+1, 0, function.NULL_TRANSFORM_TYPE)
+	expectError("Invalid width", err, kanzi.ErrInvalidParam)
+	_, err = function.NewShuffleFilter(0, 4, function.SHUFFLE_MAX_STRIDE+1, function.NULL_TRANSFORM_TYPE)
+	expectError("Invalid stride", err, kanzi.ErrInvalidParam)
+	_, err = function.NewShuffleFilter(0, 4, 1, function.PREDICT_TYPE)
+	expectError("Invalid next stage", err, kanzi.ErrInvalidParam)
+
+	for _, name := range []string{"SHUFFLE+PREDICT", "SHUFFLE+SHUFFLE", "SHUFFLE+BWT"} {
+		err = getTypeError(name)
+		expectError("Function "+name, err, kanzi.ErrUnsupported)
+	}
+
+	input := createRecords(4, 1000, 8)
+	filter, _ := function.NewShuffleFilter(0, 4, 1, function.LZ4_TYPE)
+	output := make([]byte, filter.MaxEncodedLen(len(input)))
+	_, dstIdx, _ := filter.Forward(input, output)
+	output = output[0:dstIdx]
+	reverse := make([]byte, len(input))
+	inverse, _ := function.NewShuffleFilter(0, 0, 0, function.NULL_TRANSFORM_TYPE)
+
+	_, _, err = inverse.Inverse(output[0:2], reverse)
+	expectError("Truncated header", err, kanzi.ErrCorruptData)
+	damaged := append([]byte(nil), output...)
+	damaged[0] = 0
+	_, _, err = inverse.Inverse(damaged, reverse)
+	expectError("Invalid width", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], output...)
+	damaged[2] = function.PREDICT_TYPE
+	_, _, err = inverse.Inverse(damaged, reverse)
+	expectError("Invalid next stage", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], output...)
+	damaged[2] = function.NULL_TRANSFORM_TYPE // LZ4 data copied: too large
+	_, _, err = inverse.Inverse(damaged, reverse[0:100])
+	expectError("Small output", err, kanzi.ErrBufferTooSmall)
+
+	// The next stage cannot process the whole block
+	filter, _ = function.NewShuffleFilter(0, 4, 0, function.RLT_TYPE)
+	rand.New(rand.NewSource(9)).Read(input)
+	_, _, err = filter.Forward(input, output[0:len(input)/2])
+	expectError("Small output of the next stage", err, kanzi.ErrBufferTooSmall)
+}
+
+func TestSpeed() {
+	size := 16 * 1024 * 1024
+	input := createRecords(8, size, 9)
+	output := make([]byte, size+function.SHUFFLE_HEADER_SIZE)
+	reverse := make([]byte, size)
+	iter := 10
+
+	for _, stride := range []uint{0, 1} {
+		filter, _ := function.NewShuffleFilter(0, 8, stride, function.NULL_TRANSFORM_TYPE)
+		var delta1, delta2 time.Duration
+
+		for i := 0; i < iter; i++ {
+			before := time.Now()
+			filter.Forward(input, output)
+			delta1 += time.Since(before)
+			before = time.Now()
+			filter.Inverse(output, reverse)
+			delta2 += time.Since(before)
+		}
+
+		if bytes.Equal(input, reverse) == false {
+			fmt.Printf("Failure: different output\n")
+			os.Exit(1)
+		}
+
+		mb := float64(iter*size) / (1024 * 1024)
+		fmt.Printf("%-40s Success (forward: %.0f MB/s, inverse: %.0f MB/s)\n", fmt.Sprintf("Width 8, stride %d:", stride),
+			mb/delta1.Seconds(), mb/delta2.Seconds())
+	}
+}
+
+func getTypeError(name string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, _ = r.(error)
+		}
+	}()
+
+	function.GetByteFunctionType(name)
+	return nil
+}
+
+// Create records of slowly varying little endian integers (the last bytes
+// of the wider records are flags)
+func createRecords(width uint, size int, seed int64) []byte {
+	rnd := rand.New(rand.NewSource(seed))
+	res := make([]byte, size)
+	var buf [16]byte
+	val := uint64(1 << 20)
+
+	for i := 0; i < size; i += int(width) {
+		val += uint64(rnd.Intn(200))
+		binary.LittleEndian.PutUint64(buf[0:], val)
+
+		if width > 8 {
+			binary.LittleEndian.PutUint64(buf[8:], uint64(rnd.Intn(4)))
+		}
+
+		copy(res[i:], buf[0:width])
+	}
+
+	return res
+}
+
+// Order 0 entropy in bits per byte
+func entropy(data []byte) float64 {
+	var freqs [256]int
+
+	for _, b := range data {
+		freqs[b]++
+	}
+
+	res := 0.0
+
+	for _, f := range freqs {
+		if f > 0 {
+			p := float64(f) / float64(len(data))
+			res -= p * math.Log2(p)
+		}
+	}
+
+	return res
+}
+
+func check(name string, ok bool) {
+	if ok == false {
+		fmt.Printf("Failure: %v\n", name)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success\n", name+":")
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-40s Success (%v)\n", name+":", err)
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"kanzi"
+	kio "kanzi/io"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestSignature\n\n")
+
+	fmt.Printf("Keys test\n")
+	TestKeys()
+
+	fmt.Printf("\nEmbedded signatures test\n")
+	TestEmbeddedSignatures()
+
+	fmt.Printf("\nDetached signatures test\n")
+	TestDetachedSignatures()
+}
+
+func TestKeys() {
+	publicKey, privateKey, err := kio.GenerateSigningKey()
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The seed or the full private key can be parsed
+	for _, data := range [][]byte{privateKey.Seed(), privateKey} {
+		key, err := kio.ParsePrivateKey([]byte(hex.EncodeToString(data) + "\n"))
+
+		if err != nil || bytes.Equal(key, privateKey) == false {
+			fmt.Printf("Failure: private key of %d bytes: %v\n", len(data), err)
+			os.Exit(1)
+		}
+	}
+
+	key, err := kio.ParsePublicKey([]byte(" " + hex.EncodeToString(publicKey) + "\r\n"))
+
+	if err != nil || bytes.Equal(key, publicKey) == false {
+		fmt.Printf("Failure: public key: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err = kio.ParsePublicKey([]byte("0123")); errors.Is(err, kanzi.ErrInvalidParam) == false {
+		fmt.Printf("Failure: invalid public key accepted: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success\n")
+}
+
+func TestEmbeddedSignatures() {
+	input := make([]byte, 200000)
+
+	for i := range input {
+		input[i] = byte(65 + (i*7)%13 + (i>>10)%5)
+	}
+
+	publicKey, privateKey, _ := kio.GenerateSigningKey()
+	otherKey, _, _ := kio.GenerateSigningKey()
+	var signed []byte
+
+	for _, jobs := range []uint{1, 4} {
+		var err error
+		options := &kio.Options{BlockSize: 32 * 1024, Checksum: true, Jobs: jobs,
+			SigningKey: privateKey, VerifyKey: publicKey}
+		signed, err = kio.Compress(nil, input, options)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		output, err := kio.Decompress(nil, signed, options)
+
+		if err != nil || bytes.Equal(input, output) == false {
+			fmt.Printf("Failure: jobs=%d: %v\n", jobs, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Roundtrip (jobs=%d): Success\n", jobs)
+	}
+
+	if err := kio.VerifyEmbedded(bytes.NewReader(signed), publicKey); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The signature can be ignored
+	if output, err := kio.Decompress(nil, signed, nil); err != nil || bytes.Equal(input, output) == false {
+		fmt.Printf("Failure: no key: %v\n", err)
+		os.Exit(1)
+	}
+
+	_, err := kio.Decompress(nil, signed, &kio.Options{VerifyKey: otherKey})
+	expectSignatureError("Other key", err)
+	expectSignatureError("Other key (no decoding)", kio.VerifyEmbedded(bytes.NewReader(signed), otherKey))
+
+	unsigned, _ := kio.Compress(nil, input, &kio.Options{BlockSize: 32 * 1024})
+	_, err = kio.Decompress(nil, unsigned, &kio.Options{VerifyKey: publicKey})
+	expectSignatureError("Unsigned stream", err)
+	expectSignatureError("Unsigned stream (no decoding)", kio.VerifyEmbedded(bytes.NewReader(unsigned), publicKey))
+
+	// Without transform nor entropy coding, the modified stream can be
+	// decoded: only the signature detects the modification
+	options := &kio.Options{Entropy: "None", Transform: "None", BlockSize: 32 * 1024,
+		SigningKey: privateKey, VerifyKey: publicKey}
+	signed, _ = kio.Compress(nil, input, options)
+	tampered := append([]byte(nil), signed...)
+	tampered[len(tampered)/2] ^= 0x01
+
+	if _, err = kio.Decompress(nil, tampered, nil); err != nil {
+		fmt.Printf("Failure: the modified stream cannot be decoded: %v\n", err)
+		os.Exit(1)
+	}
+
+	_, err = kio.Decompress(nil, tampered, options)
+	expectSignatureError("Modified stream", err)
+	expectSignatureError("Modified stream (no decoding)", kio.VerifyEmbedded(bytes.NewReader(tampered), publicKey))
+
+	_, err = kio.Decompress(nil, signed[0:len(signed)-1], options)
+	expectError("Truncated signature", err, kanzi.ErrCorruptData)
+
+	// Encrypted and signed stream, Reset keeps the key
+	var buf bytes.Buffer
+	cos, _ := kio.NewCompressedOutputStream("ANS", "BWT", &bufferCloser{&buf}, 32*1024, false, nil, 2)
+	cos.SetPassword([]byte("password"))
+	cos.SetSigningKey(privateKey)
+
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		cos.Write(input[i*1000 : 5000])
+
+		if err := cos.Close(); err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		output, err := kio.Decompress(nil, buf.Bytes(), &kio.Options{Password: []byte("password"), VerifyKey: publicKey})
+
+		if err != nil || bytes.Equal(input[i*1000:5000], output) == false {
+			fmt.Printf("Failure: reset %d: %v\n", i, err)
+			os.Exit(1)
+		}
+
+		cos.Reset(&bufferCloser{&buf})
+	}
+
+	fmt.Printf("Encrypted stream and reset: Success\n")
+}
+
+func TestDetachedSignatures() {
+	data := []byte("The detached signature covers the whole file")
+	publicKey, privateKey, _ := kio.GenerateSigningKey()
+	signature, err := kio.SignDetached(bytes.NewReader(data), privateKey)
+
+	if err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err = kio.VerifyDetached(bytes.NewReader(data), publicKey, signature); err != nil {
+		fmt.Printf("Failure: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Valid signature: Success\n")
+	data[0] ^= 0x20
+	expectSignatureError("Modified data", kio.VerifyDetached(bytes.NewReader(data), publicKey, signature))
+}
+
+type bufferCloser struct {
+	*bytes.Buffer
+}
+
+func (this *bufferCloser) Close() error {
+	return nil
+}
+
+func expectSignatureError(name string, err error) {
+	var ioerr *kio.IOError
+
+	if errors.As(err, &ioerr) == false || ioerr.ErrorCode() != kio.ERR_SIGNATURE {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	expectError(name, err, kanzi.ErrAuthentication)
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success (%v)\n", name+":", err)
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"kanzi"
+	kimage "kanzi/image"
+	"math"
+	"math/rand"
+	"os"
+)
+
+func main() {
+	fmt.Printf("TestWaveletCodec\n\n")
+
+	fmt.Printf("Progressive decoding test\n")
+	TestProgressive()
+
+	fmt.Printf("\nTarget bitrate test\n")
+	TestBitrate()
+
+	fmt.Printf("\nInvalid data test\n")
+	TestInvalidData()
+}
+
+func TestProgressive() {
+	for _, channels := range []int{1, 3} {
+		img := createImage(128, 96, channels, 7)
+		encoder, _ := kimage.NewWaveletEncoder()
+		data, err := encoder.Encode(img, 0)
+
+		if err != nil {
+			fmt.Printf("Failure: %v\n", err)
+			os.Exit(1)
+		}
+
+		decoder, _ := kimage.NewWaveletDecoder()
+		decoded, err := decoder.Decode(data)
+
+		if err != nil || decoder.Truncated() == true {
+			fmt.Printf("Failure: complete stream: %v\n", err)
+			os.Exit(1)
+		}
+
+		report, _ := kimage.Compare(img, decoded)
+		fmt.Printf("%d channel(s), complete:        %6d bytes, %v\n", channels, len(data), report)
+
+		if (channels == 1 && report.MaxError > 1) || (channels == 3 && report.PSNR < 40) {
+			fmt.Printf("Failure: the complete stream is not (nearly) lossless\n")
+			os.Exit(1)
+		}
+
+		// Decode prefixes: the quality must increase with the size
+		prevPSNR := 0.0
+
+		for size := 200; size < len(data); size *= 2 {
+			decoded, err := decoder.Decode(data[0:size])
+
+			if err != nil || decoder.Truncated() == false {
+				fmt.Printf("Failure: prefix of %d bytes: %v\n", size, err)
+				os.Exit(1)
+			}
+
+			report, _ := kimage.Compare(img, decoded)
+			fmt.Printf("%d channel(s), prefix of %5d bytes: %.2f bpp, %v\n", channels, size,
+				float64(8*size)/float64(img.Width*img.Height), report)
+
+			if report.PSNR <= prevPSNR {
+				fmt.Printf("Failure: the PSNR does not increase with the size\n")
+				os.Exit(1)
+			}
+
+			prevPSNR = report.PSNR
+		}
+
+		fmt.Printf("%-40s Success\n", fmt.Sprintf("%d channel(s):", channels))
+	}
+
+	// Every prefix can be decoded
+	img := createImage(40, 27, 3, 8)
+	encoder, _ := kimage.NewWaveletEncoder()
+	data, _ := encoder.Encode(img, 0)
+	decoder, _ := kimage.NewWaveletDecoder()
+	prevMSE := math.MaxFloat64
+
+	for size := 14; size <= len(data); size++ {
+		decoded, err := decoder.Decode(data[0:size])
+
+		if err != nil {
+			fmt.Printf("Failure: prefix of %d bytes: %v\n", size, err)
+			os.Exit(1)
+		}
+
+		report, _ := kimage.Compare(img, decoded)
+
+		// Allow small variations (midpoint reconstruction)
+		if report.MSE > prevMSE*1.05+0.5 {
+			fmt.Printf("Failure: prefix of %d bytes: the MSE increases (%.3f > %.3f)\n", size, report.MSE, prevMSE)
+			os.Exit(1)
+		}
+
+		if report.MSE < prevMSE {
+			prevMSE = report.MSE
+		}
+	}
+
+	fmt.Printf("%-40s Success (%d prefixes)\n", "All prefixes:", len(data)-13)
+}
+
+func TestBitrate() {
+	img := createImage(256, 192, 3, 9)
+	encoder, _ := kimage.NewWaveletEncoder()
+	full, _ := encoder.Encode(img, 0)
+
+	for _, bpp := range []float64{0.1, 0.25, 0.5, 1, 2} {
+		maxBytes := int(bpp * float64(img.Width*img.Height) / 8)
+		data, err := encoder.Encode(img, maxBytes)
+
+		if err != nil || len(data) != maxBytes || bytes.Equal(data, full[0:maxBytes]) == false {
+			fmt.Printf("Failure: %.2f bpp: %v\n", bpp, err)
+			os.Exit(1)
+		}
+
+		decoder, _ := kimage.NewWaveletDecoder()
+		decoded, _ := decoder.Decode(data)
+		report, _ := kimage.Compare(img, decoded)
+		fmt.Printf("%-40s Success (%6d bytes, %v)\n", fmt.Sprintf("%.2f bpp:", bpp), len(data), report)
+	}
+}
+
+func TestInvalidData() {
+	img := createImage(64, 48, 3, 10)
+	encoder, _ := kimage.NewWaveletEncoder()
+	data, _ := encoder.Encode(img, 0)
+	decoder, _ := kimage.NewWaveletDecoder()
+
+	_, err := decoder.Decode(data[0:10])
+	expectError("Truncated header", err, kanzi.ErrCorruptData)
+	damaged := append([]byte(nil), data...)
+	damaged[1] ^= 1
+	_, err = decoder.Decode(damaged)
+	expectError("Invalid magic", err, kanzi.ErrCorruptData)
+	damaged = append(damaged[:0], data...)
+	damaged[12] = 200
+	_, err = decoder.Decode(damaged)
+	expectError("Invalid bit planes", err, kanzi.ErrCorruptData)
+
+	// 65536x65536x3 image in 33 bytes
+	damaged = append(damaged[:0], data[0:33]...)
+	damaged[5], damaged[6], damaged[7], damaged[8] = 0xFF, 0xFF, 0xFF, 0xFF
+	_, err = decoder.Decode(damaged)
+	expectError("Oversized header", err, kanzi.ErrLimitExceeded)
+
+	// 8000x8000x1 image in 14 bytes
+	damaged = append(damaged[:0], data[0:14]...)
+	damaged[5], damaged[6], damaged[7], damaged[8], damaged[9] = 0x1F, 0x3F, 0x1F, 0x3F, 1
+	_, err = decoder.Decode(damaged)
+	expectError("Header larger than the data", err, kanzi.ErrCorruptData)
+	_, err = encoder.Encode(img, -1)
+	expectError("Invalid size", err, kanzi.ErrInvalidParam)
+	rnd := rand.New(rand.NewSource(10))
+
+	// Damage in the coded bits only lowers the quality
+	for i := 0; i < 100; i++ {
+		damaged = append(damaged[:0], data...)
+		damaged[14+rnd.Intn(len(data)-14)] ^= byte(1 + rnd.Intn(255))
+
+		if _, err := decoder.Decode(damaged); err != nil {
+			fmt.Printf("Failure: random damage: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%-30s Success\n", "Random damage:")
+}
+
+// Create an image with smooth gradients, sharp edges and textured areas
+func createImage(width, height, channels int, seed int64) *kimage.Image {
+	img, _ := kimage.NewImage(width, height, channels)
+	rnd := rand.New(rand.NewSource(seed))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < channels; c++ {
+				val := 64 + 40*math.Sin(float64(x+20*c)/23) + 30*math.Cos(float64(y)/17)
+
+				if x > width/2 && y > height/2 {
+					// Texture
+					val += float64(((x/3)^(y/3))&1)*60 + float64(rnd.Intn(16))
+				} else if (x-width/4)*(x-width/4)+(y-height/4)*(y-height/4) < width*height/40 {
+					// Disc
+					val += 100
+				}
+
+				img.Pix[(y*width+x)*channels+c] = byte(math.Max(0, math.Min(255, val)))
+			}
+		}
+	}
+
+	return img
+}
+
+func expectError(name string, err error, kind error) {
+	if err == nil || errors.Is(err, kind) == false {
+		fmt.Printf("Failure: %v: unexpected error: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-30s Success (%v)\n", name+":", err)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+import (
+	"kanzi"
+)
+
+// Reversible Discrete Wavelet Transform Cohen-Daubechies-Fauveau 5/3 for 2D
+// signals (integer lifting with symmetric extension, as in JPEG 2000). The
+// inverse transform rebuilds the input exactly. The dimensions do not have to
+// be powers of 2: at each step, the low pass subband of a line of n samples
+// has (n+1)/2 samples and the high pass subband n/2 samples. The subbands are
+// stored like the ones of DWT_CDF_9_7 (low pass first).
+// Errors wrap kanzi.ErrInvalidParam (invalid dimensions) or
+// kanzi.ErrBufferTooSmall.
+
+type DWT_CDF_5_3 struct {
+	width  uint // at least 1
+	height uint // at least 1
+	steps  uint // at least 1
+	line   []int
+}
+
+func NewDWT53(width, height, steps uint) (*DWT_CDF_5_3, error) {
+	if width < 1 || height < 1 || width > 1<<16 || height > 1<<16 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid transform dimensions (must be in [1..65536])")
+	}
+
+	if steps < 1 || steps > 16 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of iterations (must be in [1..16])")
+	}
+
+	this := new(DWT_CDF_5_3)
+	this.width = width
+	this.height = height
+	this.steps = steps
+	dim := width
+
+	if height > dim {
+		dim = height
+	}
+
+	this.line = make([]int, dim)
+	return this, nil
+}
+
+func (this *DWT_CDF_5_3) Forward(src, dst []int) (uint, uint, error) {
+	count := this.width * this.height
+
+	if len(src) < int(count) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "The input buffer is too small")
+	}
+
+	if len(dst) < int(count) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "The output buffer is too small")
+	}
+
+	if kanzi.SameIntSlices(src, dst, false) == false {
+		copy(dst, src)
+	}
+
+	w := this.width
+	h := this.height
+
+	for i := uint(0); i < this.steps; i++ {
+		// First, vertical transform
+		for x := uint(0); x < w; x++ {
+			this.computeForward(dst[x:], this.width, h)
+		}
+
+		// Then horizontal transform on the updated signal
+		for y := uint(0); y < h; y++ {
+			this.computeForward(dst[y*this.width:], 1, w)
+		}
+
+		w = (w + 1) >> 1
+		h = (h + 1) >> 1
+	}
+
+	return count, count, nil
+}
+
+// Transform the n samples of block (separated by stride)
+func (this *DWT_CDF_5_3) computeForward(block []int, stride, n uint) {
+	if n < 2 {
+		return
+	}
+
+	x := this.line[0:n]
+
+	for i := range x {
+		x[i] = block[uint(i)*stride]
+	}
+
+	nh := n >> 1
+	nl := n - nh
+
+	// Predict: high pass samples (stored after the low pass samples)
+	for i := uint(0); i < nh; i++ {
+		right := x[2*i]
+
+		if 2*i+2 < n {
+			right = x[2*i+2]
+		}
+
+		block[(nl+i)*stride] = x[2*i+1] - ((x[2*i] + right) >> 1)
+	}
+
+	// Update: low pass samples
+	for i := uint(0); i < nl; i++ {
+		dl, dr := this.neighbors(block, stride, i, nl, nh)
+		block[i*stride] = x[2*i] + ((dl + dr + 2) >> 2)
+	}
+}
+
+// Return the high pass samples around the low pass sample i (symmetric
+// extension at both ends)
+func (this *DWT_CDF_5_3) neighbors(block []int, stride, i, nl, nh uint) (int, int) {
+	left := i
+	right := i
+
+	if i > 0 {
+		left = i - 1
+	}
+
+	if i >= nh {
+		right = nh - 1
+	}
+
+	return block[(nl+left)*stride], block[(nl+right)*stride]
+}
+
+func (this *DWT_CDF_5_3) Inverse(src, dst []int) (uint, uint, error) {
+	count := this.width * this.height
+
+	if len(src) < int(count) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "The input buffer is too small")
+	}
+
+	if len(dst) < int(count) {
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "The output buffer is too small")
+	}
+
+	if kanzi.SameIntSlices(src, dst, false) == false {
+		copy(dst, src)
+	}
+
+	// Dimensions of the transformed area at each step
+	widths := make([]uint, this.steps)
+	heights := make([]uint, this.steps)
+	w := this.width
+	h := this.height
+
+	for i := range widths {
+		widths[i] = w
+		heights[i] = h
+		w = (w + 1) >> 1
+		h = (h + 1) >> 1
+	}
+
+	for i := int(this.steps) - 1; i >= 0; i-- {
+		// First horizontal transform
+		for y := uint(0); y < heights[i]; y++ {
+			this.computeInverse(dst[y*this.width:], 1, widths[i])
+		}
+
+		// Then vertical transform on the updated signal
+		for x := uint(0); x < widths[i]; x++ {
+			this.computeInverse(dst[x:], this.width, heights[i])
+		}
+	}
+
+	return count, count, nil
+}
+
+func (this *DWT_CDF_5_3) computeInverse(block []int, stride, n uint) {
+	if n < 2 {
+		return
+	}
+
+	x := this.line[0:n]
+	nh := n >> 1
+	nl := n - nh
+
+	// Reverse update: even samples
+	for i := uint(0); i < nl; i++ {
+		dl, dr := this.neighbors(block, stride, i, nl, nh)
+		x[2*i] = block[i*stride] - ((dl + dr + 2) >> 2)
+	}
+
+	// Reverse predict: odd samples
+	for i := uint(0); i < nh; i++ {
+		right := x[2*i]
+
+		if 2*i+2 < n {
+			right = x[2*i+2]
+		}
+
+		x[2*i+1] = block[(nl+i)*stride] + ((x[2*i] + right) >> 1)
+	}
+
+	for i := range x {
+		block[uint(i)*stride] = x[i]
+	}
+}
@@ -16,11 +16,12 @@ limitations under the License.
 package transform
 
 import (
-	"errors"
 	"kanzi"
 )
 
 // Discrete Wavelet Transform Cohen-Daubechies-Fauveau 9/7 for 2D signals
+// Errors wrap kanzi.ErrInvalidParam (invalid dimensions) or
+// kanzi.ErrBufferTooSmall.
 const (
 	SHIFT_12  = 12
 	ADJUST_12 = 1 << (SHIFT_12 - 1)
@@ -46,23 +47,23 @@ type DWT_CDF_9_7 struct {
 
 func NewDWT(width, height, steps uint) (*DWT_CDF_9_7, error) {
 	if width < 8 {
-		return nil, errors.New("Invalid transform width (must be at least 8)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid transform width (must be at least 8)")
 	}
 
 	if height < 8 {
-		return nil, errors.New("Invalid transform height (must be at least 8)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid transform height (must be at least 8)")
 	}
 
 	if steps < 1 {
-		return nil, errors.New("Invalid number of iterations (must be at least 1)")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of iterations (must be at least 1)")
 	}
 
 	if (width>>steps)<<steps != width {
-		return nil, errors.New("The width is not equal to 2^steps")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The width is not equal to 2^steps")
 	}
 
 	if (height>>steps)<<steps != height {
-		return nil, errors.New("The height is not equal to 2^steps")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "The height is not equal to 2^steps")
 	}
 
 	this := new(DWT_CDF_9_7)
@@ -75,11 +76,11 @@ func NewDWT(width, height, steps uint) (*DWT_CDF_9_7, error) {
 
 func (this *DWT_CDF_9_7) Forward(src, dst []int) (uint, uint, error) {
 	if len(src) < int(this.width*this.height) {
-		return 0, 0, errors.New("The input buffer is too small")
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "The input buffer is too small")
 	}
 	
 	if len(dst) < int(this.width*this.height) {
-		return 0, 0, errors.New("The output buffer is too small")
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "The output buffer is too small")
 	}
 	
 	if kanzi.SameIntSlices(src, dst, false) == false {
@@ -176,11 +177,11 @@ func (this *DWT_CDF_9_7) computeForward(block []int, stride, inc, dim1, dim2 uin
 
 func (this *DWT_CDF_9_7) Inverse(src, dst []int) (uint, uint, error) {
 	if len(src) < int(this.width*this.height) {
-		return 0, 0, errors.New("The input buffer is too small")
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "The input buffer is too small")
 	}
 	
 	if len(dst) < int(this.width*this.height) {
-		return 0, 0, errors.New("The output buffer is too small")
+		return 0, 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "The output buffer is too small")
 	}
 	
 	if kanzi.SameIntSlices(src, dst, false) == false {
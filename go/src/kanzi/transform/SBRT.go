@@ -15,7 +15,7 @@ limitations under the License.
 
 package transform
 
-import "errors"
+import "kanzi"
 
 // Sort by Rank Transform is a family of transforms typically used after
 // a BWT to reduce the variance of the data prior to entropy coding.
@@ -44,7 +44,7 @@ type SBRT struct {
 
 func NewSBRT(mode int, sz uint) (*SBRT, error) {
 	if mode != MODE_MTF && mode != MODE_RANK && mode != MODE_TIMESTAMP {
-		return nil, errors.New("Invalid mode parameter")
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid mode parameter")
 	}
 
 	this := new(SBRT)
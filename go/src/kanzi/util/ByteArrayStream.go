@@ -16,7 +16,7 @@ limitations under the License.
 package util
 
 import (
-	"fmt"
+	"kanzi"
 )
 
 type ByteArrayOutputStream struct {
@@ -39,7 +39,7 @@ func (this *ByteArrayOutputStream) Write(b []byte) (n int, err error) {
 			copy(buffer, this.array[0:this.index])
 			this.array = buffer
 		} else {
-			return 0, fmt.Errorf("Output buffer too small, required:%v, available:%v", len(b), len(this.array)-this.index)
+			return 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Output buffer too small, required:%v, available:%v", len(b), len(this.array)-this.index)
 		}
 	}
 
@@ -76,7 +76,7 @@ func (this *ByteArrayInputStream) Read(b []byte) (n int, err error) {
 			copy(buffer, this.array[0:this.index])
 			this.array = buffer
 		} else {
-			return 0, fmt.Errorf("Input buffer too small, required:%v, available:%v", len(b), len(this.array)-this.index)
+			return 0, kanzi.Errorf(kanzi.ErrBufferTooSmall, "Input buffer too small, required:%v, available:%v", len(b), len(this.array)-this.index)
 		}
 	}
 
@@ -16,7 +16,7 @@ limitations under the License.
 package util
 
 import (
-	"errors"
+	"kanzi"
 )
 
 // A tree based collection of sorted integers allowing log n time for add/remove
@@ -372,7 +372,7 @@ func (this *IntBTree) Clear() {
 
 func (this *IntBTree) Min() (int, error) {
 	if this.root == nil {
-		return 0, errors.New("Tree is empty")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Tree is empty")
 	}
 
 	if this.flags&MIN_DIRTY != 0 {
@@ -398,7 +398,7 @@ func (this *IntBTree) Min() (int, error) {
 
 func (this *IntBTree) Max() (int, error) {
 	if this.root == nil {
-		return 0, errors.New("Tree is empty")
+		return 0, kanzi.Errorf(kanzi.ErrInvalidParam, "Tree is empty")
 	}
 
 	if this.flags&MAX_DIRTY != 0 {
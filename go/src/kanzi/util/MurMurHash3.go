@@ -15,11 +15,16 @@ limitations under the License.
 
 package util
 
-import "unsafe"
+import (
+	"encoding/binary"
+	"unsafe"
+)
 
 // MurmurHash3 was written by Austin Appleby, and is placed in the public
 // domain. The author hereby disclaims copyright to this source code.
 // Original source code: http://code.google.com/p/smhasher/
+// MurMurHash3 is the x86_32 variant. Hash is a one-shot (stateless) function.
+// Write, Sum32, Sum and Reset hash the data incrementally (hash.Hash32).
 
 const (
 	C1 = uint32(0xcc9e2d51)
@@ -30,49 +35,118 @@ const (
 )
 
 type MurMurHash3 struct {
-	seed uint32
+	seed   uint32
+	h1     uint32 // streaming state
+	buffer [4]byte
+	size   int // bytes in buffer
+	total  uint64
 }
 
 func NewMurMurHash3(seed uint32) (*MurMurHash3, error) {
 	this := new(MurMurHash3)
 	this.seed = seed
+	this.Reset()
 	return this, nil
 }
 
+// Also reset the streaming state
 func (this *MurMurHash3) SetSeed(seed uint32) {
 	this.seed = seed
+	this.Reset()
 }
 
 func (this *MurMurHash3) Hash(data []byte) uint32 {
 	h1 := this.seed // aliasing
-	p := uintptr(unsafe.Pointer(&data[0]))
 	end4 := len(data) & -4
-	end := p + uintptr(end4)
 
 	// Body
-	for p < end {
-		k1 := *(*uint32)(unsafe.Pointer(p))
-		k1 *= C1
-		k1 = (k1 << 15) | (k1 >> 17)
-		k1 *= C2
-		h1 ^= k1
-		h1 = (h1 << 13) | (h1 >> 19)
-		h1 = (h1 * 5) + C3
-		p += 4
+	if end4 > 0 {
+		p := uintptr(unsafe.Pointer(&data[0]))
+		end := p + uintptr(end4)
+
+		for p < end {
+			h1 = murmur3Round(h1, *(*uint32)(unsafe.Pointer(p)))
+			p += 4
+		}
 	}
 
+	return murmur3Finalize(h1, data[end4:], uint32(len(data)))
+}
+
+func (this *MurMurHash3) Reset() {
+	this.h1 = this.seed
+	this.size = 0
+	this.total = 0
+}
+
+func (this *MurMurHash3) Size() int {
+	return 4
+}
+
+func (this *MurMurHash3) BlockSize() int {
+	return 4
+}
+
+// Never fails
+func (this *MurMurHash3) Write(data []byte) (int, error) {
+	length := len(data)
+	this.total += uint64(length)
+
+	if this.size > 0 {
+		n := copy(this.buffer[this.size:], data)
+		this.size += n
+		data = data[n:]
+
+		if this.size < 4 {
+			return length, nil
+		}
+
+		this.h1 = murmur3Round(this.h1, binary.LittleEndian.Uint32(this.buffer[:]))
+		this.size = 0
+	}
+
+	for len(data) >= 4 {
+		this.h1 = murmur3Round(this.h1, binary.LittleEndian.Uint32(data))
+		data = data[4:]
+	}
+
+	this.size = copy(this.buffer[:], data)
+	return length, nil
+}
+
+// Return the hash of the data written so far (the state is not modified).
+// As in the reference implementation, the length is taken modulo 2^32.
+func (this *MurMurHash3) Sum32() uint32 {
+	return murmur3Finalize(this.h1, this.buffer[0:this.size], uint32(this.total))
+}
+
+func (this *MurMurHash3) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, this.Sum32())
+}
+
+func murmur3Round(h1, k1 uint32) uint32 {
+	k1 *= C1
+	k1 = (k1 << 15) | (k1 >> 17)
+	k1 *= C2
+	h1 ^= k1
+	h1 = (h1 << 13) | (h1 >> 19)
+	return (h1 * 5) + C3
+}
+
+// Mix the remaining bytes (less than 4) and avalanche
+func murmur3Finalize(h1 uint32, tail []byte, length uint32) uint32 {
 	// Tail
 	var k1 uint32
 
-	switch len(data) & 3 {
+	switch len(tail) {
 	case 3:
-		k1 ^= uint32(data[end4+2]) << 16
+		k1 ^= uint32(tail[2]) << 16
 		fallthrough
 	case 2:
-		k1 ^= uint32(data[end4+1]) << 8
+		k1 ^= uint32(tail[1]) << 8
 		fallthrough
 	case 1:
-		k1 ^= uint32(data[end4])
+		k1 ^= uint32(tail[0])
 
 		k1 *= C1
 		k1 = (k1 << 15) | (k1 >> 17)
@@ -81,7 +155,7 @@ func (this *MurMurHash3) Hash(data []byte) uint32 {
 	}
 
 	// Finalization
-	h1 ^= uint32(len(data))
+	h1 ^= length
 	h1 ^= (h1 >> 16)
 	h1 *= C4
 	h1 ^= (h1 >> 13)
@@ -0,0 +1,184 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import "encoding/binary"
+
+// MurmurHash3 x64_128 variant (128 bit hash, optimized for 64 bit CPUs).
+// Original source code: http://code.google.com/p/smhasher/
+// Hash is a one-shot (stateless) function returning both halves of the hash.
+// Write, Sum128, Sum and Reset hash the data incrementally (hash.Hash). Sum
+// appends the first half then the second half, both in big endian order.
+
+const (
+	C64_1 = uint64(0x87c37b91114253d5)
+	C64_2 = uint64(0x4cf5ad432745937f)
+)
+
+type MurMurHash3_128 struct {
+	seed   uint32
+	h1     uint64 // streaming state
+	h2     uint64
+	buffer [16]byte
+	size   int // bytes in buffer
+	total  uint64
+}
+
+func NewMurMurHash3_128(seed uint32) (*MurMurHash3_128, error) {
+	this := new(MurMurHash3_128)
+	this.seed = seed
+	this.Reset()
+	return this, nil
+}
+
+// Also reset the streaming state
+func (this *MurMurHash3_128) SetSeed(seed uint32) {
+	this.seed = seed
+	this.Reset()
+}
+
+func (this *MurMurHash3_128) Hash(data []byte) (uint64, uint64) {
+	h1 := uint64(this.seed)
+	h2 := uint64(this.seed)
+	end16 := len(data) & -16
+
+	for p := 0; p < end16; p += 16 {
+		h1, h2 = murmur3Round128(h1, h2, data[p:])
+	}
+
+	return murmur3Finalize128(h1, h2, data[end16:], uint64(len(data)))
+}
+
+func (this *MurMurHash3_128) Reset() {
+	this.h1 = uint64(this.seed)
+	this.h2 = uint64(this.seed)
+	this.size = 0
+	this.total = 0
+}
+
+func (this *MurMurHash3_128) Size() int {
+	return 16
+}
+
+func (this *MurMurHash3_128) BlockSize() int {
+	return 16
+}
+
+// Never fails
+func (this *MurMurHash3_128) Write(data []byte) (int, error) {
+	length := len(data)
+	this.total += uint64(length)
+
+	if this.size+length < 16 {
+		this.size += copy(this.buffer[this.size:], data)
+		return length, nil
+	}
+
+	if this.size > 0 {
+		n := copy(this.buffer[this.size:], data)
+		this.h1, this.h2 = murmur3Round128(this.h1, this.h2, this.buffer[:])
+		data = data[n:]
+		this.size = 0
+	}
+
+	for len(data) >= 16 {
+		this.h1, this.h2 = murmur3Round128(this.h1, this.h2, data)
+		data = data[16:]
+	}
+
+	this.size = copy(this.buffer[:], data)
+	return length, nil
+}
+
+// Return the hash of the data written so far (the state is not modified)
+func (this *MurMurHash3_128) Sum128() (uint64, uint64) {
+	return murmur3Finalize128(this.h1, this.h2, this.buffer[0:this.size], this.total)
+}
+
+func (this *MurMurHash3_128) Sum(b []byte) []byte {
+	h1, h2 := this.Sum128()
+	b = binary.BigEndian.AppendUint64(b, h1)
+	return binary.BigEndian.AppendUint64(b, h2)
+}
+
+// Process a 16 byte block
+func murmur3Round128(h1, h2 uint64, data []byte) (uint64, uint64) {
+	k1 := binary.LittleEndian.Uint64(data[0:])
+	k2 := binary.LittleEndian.Uint64(data[8:])
+
+	k1 *= C64_1
+	k1 = rotl64(k1, 31)
+	k1 *= C64_2
+	h1 ^= k1
+	h1 = rotl64(h1, 27)
+	h1 += h2
+	h1 = h1*5 + 0x52dce729
+
+	k2 *= C64_2
+	k2 = rotl64(k2, 33)
+	k2 *= C64_1
+	h2 ^= k2
+	h2 = rotl64(h2, 31)
+	h2 += h1
+	h2 = h2*5 + 0x38495ab5
+	return h1, h2
+}
+
+// Mix the remaining bytes (less than 16) and avalanche
+func murmur3Finalize128(h1, h2 uint64, tail []byte, length uint64) (uint64, uint64) {
+	var k1, k2 uint64
+
+	for i := len(tail) - 1; i >= 8; i-- {
+		k2 = (k2 << 8) | uint64(tail[i])
+	}
+
+	if len(tail) > 8 {
+		k2 *= C64_2
+		k2 = rotl64(k2, 33)
+		k2 *= C64_1
+		h2 ^= k2
+	}
+
+	for i := min(len(tail), 8) - 1; i >= 0; i-- {
+		k1 = (k1 << 8) | uint64(tail[i])
+	}
+
+	if len(tail) > 0 {
+		k1 *= C64_1
+		k1 = rotl64(k1, 31)
+		k1 *= C64_2
+		h1 ^= k1
+	}
+
+	// Finalization
+	h1 ^= length
+	h2 ^= length
+	h1 += h2
+	h2 += h1
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+	h1 += h2
+	h2 += h1
+	return h1, h2
+}
+
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	return k ^ (k >> 33)
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import "kanzi"
+
+// Systematic Reed-Solomon erasure code over GF(2^8) (polynomial 0x11D).
+// The data is split into dataShards shards of equal size and parityShards
+// parity shards are computed: any dataShards shards out of the
+// dataShards+parityShards shards are enough to rebuild the others. The
+// damaged shards must be identified by the caller (EG. with a checksum).
+// The encoding matrix is derived from a Vandermonde matrix so that its top
+// square is the identity (the data shards are stored as is) and any square
+// made of dataShards of its rows can be inverted.
+
+const (
+	MAX_REED_SOLOMON_SHARDS = 255
+)
+
+var (
+	gfExp      [512]byte
+	gfLog      [256]byte
+	gfMulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMulTable[a][b] = gfExp[int(gfLog[a])+int(gfLog[b])]
+		}
+	}
+}
+
+func gfInverse(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+type ReedSolomon struct {
+	dataShards   int
+	parityShards int
+	matrix       [][]byte // (dataShards+parityShards) x dataShards
+}
+
+func NewReedSolomon(dataShards, parityShards uint) (*ReedSolomon, error) {
+	if dataShards == 0 || parityShards == 0 || dataShards+parityShards > MAX_REED_SOLOMON_SHARDS {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of shards: %d+%d (must be in [2..%d])",
+			dataShards, parityShards, MAX_REED_SOLOMON_SHARDS)
+	}
+
+	this := new(ReedSolomon)
+	this.dataShards = int(dataShards)
+	this.parityShards = int(parityShards)
+	n := this.dataShards + this.parityShards
+
+	// Vandermonde matrix: row r is (1, r, r^2, ...)
+	vandermonde := make([][]byte, n)
+
+	for r := range vandermonde {
+		vandermonde[r] = make([]byte, this.dataShards)
+		vandermonde[r][0] = 1
+
+		for c := 1; c < this.dataShards; c++ {
+			vandermonde[r][c] = gfMulTable[vandermonde[r][c-1]][r]
+		}
+	}
+
+	top, _ := gfInvertMatrix(vandermonde[0:this.dataShards])
+	this.matrix = gfMultiplyMatrices(vandermonde, top)
+	return this, nil
+}
+
+func (this *ReedSolomon) DataShards() int {
+	return this.dataShards
+}
+
+func (this *ReedSolomon) ParityShards() int {
+	return this.parityShards
+}
+
+// Compute the parity shards from the data shards. All the shards must have
+// the same size: shards[0:dataShards] are the data shards and
+// shards[dataShards:] the parity shards (overwritten).
+func (this *ReedSolomon) Encode(shards [][]byte) error {
+	if err := this.checkShards(shards); err != nil {
+		return err
+	}
+
+	for i := this.dataShards; i < len(shards); i++ {
+		this.encodeShard(shards[0:this.dataShards], this.matrix[i], shards[i])
+	}
+
+	return nil
+}
+
+// Rebuild the missing shards (present[i] == false) from the other shards.
+// At least dataShards shards must be present.
+func (this *ReedSolomon) Reconstruct(shards [][]byte, present []bool) error {
+	if err := this.checkShards(shards); err != nil {
+		return err
+	}
+
+	if len(present) != len(shards) {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of flags: %d (expected %d)", len(present), len(shards))
+	}
+
+	// Pick the first dataShards shards present
+	rows := make([]int, 0, this.dataShards)
+	dataMissing := false
+
+	for i := range shards {
+		if present[i] == false {
+			dataMissing = dataMissing || i < this.dataShards
+		} else if len(rows) < this.dataShards {
+			rows = append(rows, i)
+		}
+	}
+
+	if len(rows) < this.dataShards {
+		return kanzi.Errorf(kanzi.ErrCorruptData, "Too many missing shards: %d (at most %d can be rebuilt)",
+			len(shards)-len(rows), this.parityShards)
+	}
+
+	if dataMissing == true {
+		sub := make([][]byte, this.dataShards)
+		inputs := make([][]byte, this.dataShards)
+
+		for i, r := range rows {
+			sub[i] = this.matrix[r]
+			inputs[i] = shards[r]
+		}
+
+		decode, err := gfInvertMatrix(sub)
+
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < this.dataShards; i++ {
+			if present[i] == false {
+				this.encodeShard(inputs, decode[i], shards[i])
+			}
+		}
+	}
+
+	for i := this.dataShards; i < len(shards); i++ {
+		if present[i] == false {
+			this.encodeShard(shards[0:this.dataShards], this.matrix[i], shards[i])
+		}
+	}
+
+	return nil
+}
+
+// output = sum(coefficients[i] * inputs[i])
+func (this *ReedSolomon) encodeShard(inputs [][]byte, coefficients []byte, output []byte) {
+	clear(output)
+
+	for i, input := range inputs {
+		table := &gfMulTable[coefficients[i]]
+		input = input[0:len(output)]
+
+		for j, b := range input {
+			output[j] ^= table[b]
+		}
+	}
+}
+
+func (this *ReedSolomon) checkShards(shards [][]byte) error {
+	if len(shards) != this.dataShards+this.parityShards {
+		return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid number of shards: %d (expected %d)",
+			len(shards), this.dataShards+this.parityShards)
+	}
+
+	for i := range shards {
+		if len(shards[i]) != len(shards[0]) {
+			return kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid shard size: %d (expected %d)",
+				len(shards[i]), len(shards[0]))
+		}
+	}
+
+	return nil
+}
+
+func gfMultiplyMatrices(a, b [][]byte) [][]byte {
+	res := make([][]byte, len(a))
+
+	for r := range a {
+		res[r] = make([]byte, len(b[0]))
+
+		for c := range res[r] {
+			var v byte
+
+			for i := range b {
+				v ^= gfMulTable[a[r][i]][b[i][c]]
+			}
+
+			res[r][c] = v
+		}
+	}
+
+	return res
+}
+
+// Gauss-Jordan elimination of a square matrix (the input is not modified)
+func gfInvertMatrix(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+
+	for r := range m {
+		work[r] = make([]byte, 2*n)
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		// Find a pivot
+		p := c
+
+		for p < n && work[p][c] == 0 {
+			p++
+		}
+
+		if p == n {
+			return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Singular matrix")
+		}
+
+		work[c], work[p] = work[p], work[c]
+
+		if work[c][c] != 1 {
+			table := &gfMulTable[gfInverse(work[c][c])]
+
+			for i := range work[c] {
+				work[c][i] = table[work[c][i]]
+			}
+		}
+
+		for r := 0; r < n; r++ {
+			if r != c && work[r][c] != 0 {
+				table := &gfMulTable[work[r][c]]
+
+				for i := range work[r] {
+					work[r][i] ^= table[work[c][i]]
+				}
+			}
+		}
+	}
+
+	res := make([][]byte, n)
+
+	for r := range work {
+		res[r] = work[r][n:]
+	}
+
+	return res, nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/binary"
+	"kanzi"
+)
+
+// Scrypt is a password based key derivation function designed to be costly
+// in memory (128*r*N bytes) as well as in time, which makes brute force
+// attacks with dedicated hardware expensive.
+// See RFC 7914 (https://tools.ietf.org/html/rfc7914)
+
+// Derive a key of keyLength bytes from the password and the salt. N (the CPU
+// and memory cost) must be a power of 2 greater than 1.
+func Scrypt(password, salt []byte, N, r, p, keyLength int) ([]byte, error) {
+	if N <= 1 || N&(N-1) != 0 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid cost parameter: %d (must be a power of 2 greater than 1)", N)
+	}
+
+	if r <= 0 || p <= 0 || uint64(r)*uint64(p) >= 1<<30 {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid parameters: r=%d, p=%d (r*p must be in [1..2^30[)", r, p)
+	}
+
+	if uint64(N) > (1<<31)/uint64(128*r) {
+		return nil, kanzi.Errorf(kanzi.ErrInvalidParam, "Invalid parameters: N=%d, r=%d (too much memory required)", N, r)
+	}
+
+	b, err := pbkdf2.Key(sha256.New, string(password), salt, 1, p*128*r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	x := make([]uint32, 32*r)
+	v := make([]uint32, 32*r*N)
+	y := make([]uint32, 32*r)
+
+	for i := 0; i < p; i++ {
+		scryptROMix(b[i*128*r:(i+1)*128*r], r, N, x, y, v)
+	}
+
+	return pbkdf2.Key(sha256.New, string(password), b, 1, keyLength)
+}
+
+// Mix one block of 128*r bytes in place
+func scryptROMix(b []byte, r, N int, x, y, v []uint32) {
+	length := 32 * r
+
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+
+	for i := 0; i < N; i++ {
+		copy(v[i*length:], x)
+		scryptBlockMix(x, y, r)
+	}
+
+	for i := 0; i < N; i++ {
+		// Integerify: first word of the last 64 byte block
+		j := int(x[length-16] & uint32(N-1))
+		vj := v[j*length : (j+1)*length]
+
+		for k := range x {
+			x[k] ^= vj[k]
+		}
+
+		scryptBlockMix(x, y, r)
+	}
+
+	for i := range x {
+		binary.LittleEndian.PutUint32(b[4*i:], x[i])
+	}
+}
+
+// BlockMix with Salsa20/8 on 2*r blocks of 16 words (y is a scratch buffer)
+func scryptBlockMix(b, y []uint32, r int) {
+	var t [16]uint32
+	copy(t[:], b[(2*r-1)*16:])
+
+	for i := 0; i < 2*r; i++ {
+		for j := range t {
+			t[j] ^= b[i*16+j]
+		}
+
+		salsa20_8(&t)
+
+		// Even blocks first, then odd blocks
+		k := (i >> 1) * 16
+
+		if i&1 != 0 {
+			k += r * 16
+		}
+
+		copy(y[k:], t[:])
+	}
+
+	copy(b, y)
+}
+
+func salsa20_8(b *[16]uint32) {
+	x := *b
+
+	for i := 0; i < 8; i += 2 {
+		// Columns
+		x[4] ^= rotl32(x[0]+x[12], 7)
+		x[8] ^= rotl32(x[4]+x[0], 9)
+		x[12] ^= rotl32(x[8]+x[4], 13)
+		x[0] ^= rotl32(x[12]+x[8], 18)
+		x[9] ^= rotl32(x[5]+x[1], 7)
+		x[13] ^= rotl32(x[9]+x[5], 9)
+		x[1] ^= rotl32(x[13]+x[9], 13)
+		x[5] ^= rotl32(x[1]+x[13], 18)
+		x[14] ^= rotl32(x[10]+x[6], 7)
+		x[2] ^= rotl32(x[14]+x[10], 9)
+		x[6] ^= rotl32(x[2]+x[14], 13)
+		x[10] ^= rotl32(x[6]+x[2], 18)
+		x[3] ^= rotl32(x[15]+x[11], 7)
+		x[7] ^= rotl32(x[3]+x[15], 9)
+		x[11] ^= rotl32(x[7]+x[3], 13)
+		x[15] ^= rotl32(x[11]+x[7], 18)
+
+		// Rows
+		x[1] ^= rotl32(x[0]+x[3], 7)
+		x[2] ^= rotl32(x[1]+x[0], 9)
+		x[3] ^= rotl32(x[2]+x[1], 13)
+		x[0] ^= rotl32(x[3]+x[2], 18)
+		x[6] ^= rotl32(x[5]+x[4], 7)
+		x[7] ^= rotl32(x[6]+x[5], 9)
+		x[4] ^= rotl32(x[7]+x[6], 13)
+		x[5] ^= rotl32(x[4]+x[7], 18)
+		x[11] ^= rotl32(x[10]+x[9], 7)
+		x[8] ^= rotl32(x[11]+x[10], 9)
+		x[9] ^= rotl32(x[8]+x[11], 13)
+		x[10] ^= rotl32(x[9]+x[8], 18)
+		x[12] ^= rotl32(x[15]+x[14], 7)
+		x[13] ^= rotl32(x[12]+x[15], 9)
+		x[14] ^= rotl32(x[13]+x[12], 13)
+		x[15] ^= rotl32(x[14]+x[13], 18)
+	}
+
+	for i := range b {
+		b[i] += x[i]
+	}
+}
+
+func rotl32(x uint32, n uint) uint32 {
+	return (x << n) | (x >> (32 - n))
+}
@@ -15,10 +15,16 @@ limitations under the License.
 
 package util
 
-import "unsafe"
+import (
+	"encoding/binary"
+	"unsafe"
+)
 
 // XXHash is an extremely fast hash algorithm. It was written by Yann Collet.
 // Port to Go from the original source code: https://code.google.com/p/xxhash/
+// Hash is a one-shot (stateless) function. Write, Sum32, Sum and Reset hash
+// the data incrementally (hash.Hash32). Sum appends the hash in big endian
+// order (canonical representation of the reference implementation).
 
 const (
 	PRIME1 = uint32(2654435761)
@@ -29,21 +35,36 @@ const (
 )
 
 type XXHash struct {
-	seed uint32
+	seed   uint32
+	v1     uint32 // streaming state
+	v2     uint32
+	v3     uint32
+	v4     uint32
+	buffer [16]byte
+	size   int // bytes in buffer
+	total  uint64
 }
 
 func NewXXHash(seed uint32) (*XXHash, error) {
 	this := new(XXHash)
 	this.seed = seed
+	this.Reset()
 	return this, nil
 }
 
+// Also reset the streaming state
 func (this *XXHash) SetSeed(seed uint32) {
 	this.seed = seed
+	this.Reset()
 }
 
 func (this *XXHash) Hash(data []byte) uint32 {
 	length := uint32(len(data))
+
+	if length == 0 {
+		return xxh32Finalize(this.seed+PRIME5, data)
+	}
+
 	p := uintptr(unsafe.Pointer(&data[0]))
 	end := p + uintptr(length)
 	var h32 uint32
@@ -95,3 +116,103 @@ func (this *XXHash) Hash(data []byte) uint32 {
 	h32 *= PRIME3
 	return h32 ^ (h32 >> 16)
 }
+
+func (this *XXHash) Reset() {
+	this.v1 = this.seed + PRIME1 + PRIME2
+	this.v2 = this.seed + PRIME2
+	this.v3 = this.seed
+	this.v4 = this.seed - PRIME1
+	this.size = 0
+	this.total = 0
+}
+
+func (this *XXHash) Size() int {
+	return 4
+}
+
+func (this *XXHash) BlockSize() int {
+	return 16
+}
+
+// Never fails
+func (this *XXHash) Write(data []byte) (int, error) {
+	length := len(data)
+	this.total += uint64(length)
+
+	if this.size+length < 16 {
+		this.size += copy(this.buffer[this.size:], data)
+		return length, nil
+	}
+
+	if this.size > 0 {
+		n := copy(this.buffer[this.size:], data)
+		this.update(this.buffer[:])
+		data = data[n:]
+		this.size = 0
+	}
+
+	for len(data) >= 16 {
+		this.update(data)
+		data = data[16:]
+	}
+
+	this.size = copy(this.buffer[:], data)
+	return length, nil
+}
+
+// Process a 16 byte stripe
+func (this *XXHash) update(data []byte) {
+	this.v1 = xxh32Round(this.v1, binary.LittleEndian.Uint32(data[0:]))
+	this.v2 = xxh32Round(this.v2, binary.LittleEndian.Uint32(data[4:]))
+	this.v3 = xxh32Round(this.v3, binary.LittleEndian.Uint32(data[8:]))
+	this.v4 = xxh32Round(this.v4, binary.LittleEndian.Uint32(data[12:]))
+}
+
+// Return the hash of the data written so far (the state is not modified)
+func (this *XXHash) Sum32() uint32 {
+	var h32 uint32
+
+	if this.total >= 16 {
+		h32 = ((this.v1 << 1) | (this.v1 >> 31))
+		h32 += ((this.v2 << 7) | (this.v2 >> 25))
+		h32 += ((this.v3 << 12) | (this.v3 >> 20))
+		h32 += ((this.v4 << 18) | (this.v4 >> 14))
+	} else {
+		h32 = this.seed + PRIME5
+	}
+
+	h32 += uint32(this.total)
+	return xxh32Finalize(h32, this.buffer[0:this.size])
+}
+
+func (this *XXHash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, this.Sum32())
+}
+
+func xxh32Round(acc, input uint32) uint32 {
+	acc += input * PRIME2
+	return ((acc << 13) | (acc >> 19)) * PRIME1
+}
+
+// Mix the remaining bytes (less than 16) and avalanche
+func xxh32Finalize(h32 uint32, data []byte) uint32 {
+	p := 0
+
+	for p+4 <= len(data) {
+		h32 += binary.LittleEndian.Uint32(data[p:]) * PRIME3
+		h32 = ((h32 << 17) | (h32 >> 15)) * PRIME4
+		p += 4
+	}
+
+	for p < len(data) {
+		h32 += uint32(data[p]) * PRIME5
+		h32 = ((h32 << 11) | (h32 >> 21)) * PRIME1
+		p++
+	}
+
+	h32 ^= (h32 >> 15)
+	h32 *= PRIME2
+	h32 ^= (h32 >> 13)
+	h32 *= PRIME3
+	return h32 ^ (h32 >> 16)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2011-2013 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import "encoding/binary"
+
+// XXH64 is the 64 bit variant of XXHash (faster than XXHash on 64 bit CPUs).
+// Port to Go from the original source code: https://github.com/Cyan4973/xxHash
+// Hash is a one-shot (stateless) function. Write, Sum64, Sum and Reset hash
+// the data incrementally (hash.Hash64).
+
+const (
+	PRIME64_1 = uint64(11400714785074694791)
+	PRIME64_2 = uint64(14029467366897019727)
+	PRIME64_3 = uint64(1609587929392839161)
+	PRIME64_4 = uint64(9650029242287828579)
+	PRIME64_5 = uint64(2870177450012600261)
+)
+
+type XXHash64 struct {
+	seed   uint64
+	v1     uint64 // streaming state
+	v2     uint64
+	v3     uint64
+	v4     uint64
+	buffer [32]byte
+	size   int // bytes in buffer
+	total  uint64
+}
+
+func NewXXHash64(seed uint64) (*XXHash64, error) {
+	this := new(XXHash64)
+	this.seed = seed
+	this.Reset()
+	return this, nil
+}
+
+// Also reset the streaming state
+func (this *XXHash64) SetSeed(seed uint64) {
+	this.seed = seed
+	this.Reset()
+}
+
+func (this *XXHash64) Hash(data []byte) uint64 {
+	length := len(data)
+	p := 0
+	var h64 uint64
+
+	if length >= 32 {
+		v1 := this.seed + PRIME64_1 + PRIME64_2
+		v2 := this.seed + PRIME64_2
+		v3 := this.seed
+		v4 := this.seed - PRIME64_1
+
+		for p <= length-32 {
+			v1 = xxh64Round(v1, binary.LittleEndian.Uint64(data[p:]))
+			v2 = xxh64Round(v2, binary.LittleEndian.Uint64(data[p+8:]))
+			v3 = xxh64Round(v3, binary.LittleEndian.Uint64(data[p+16:]))
+			v4 = xxh64Round(v4, binary.LittleEndian.Uint64(data[p+24:]))
+			p += 32
+		}
+
+		h64 = rotl64(v1, 1) + rotl64(v2, 7) + rotl64(v3, 12) + rotl64(v4, 18)
+		h64 = xxh64MergeRound(h64, v1)
+		h64 = xxh64MergeRound(h64, v2)
+		h64 = xxh64MergeRound(h64, v3)
+		h64 = xxh64MergeRound(h64, v4)
+	} else {
+		h64 = this.seed + PRIME64_5
+	}
+
+	h64 += uint64(length)
+	return xxh64Finalize(h64, data[p:])
+}
+
+func (this *XXHash64) Reset() {
+	this.v1 = this.seed + PRIME64_1 + PRIME64_2
+	this.v2 = this.seed + PRIME64_2
+	this.v3 = this.seed
+	this.v4 = this.seed - PRIME64_1
+	this.size = 0
+	this.total = 0
+}
+
+func (this *XXHash64) Size() int {
+	return 8
+}
+
+func (this *XXHash64) BlockSize() int {
+	return 32
+}
+
+// Never fails
+func (this *XXHash64) Write(data []byte) (int, error) {
+	length := len(data)
+	this.total += uint64(length)
+
+	if this.size+length < 32 {
+		this.size += copy(this.buffer[this.size:], data)
+		return length, nil
+	}
+
+	if this.size > 0 {
+		n := copy(this.buffer[this.size:], data)
+		this.update(this.buffer[:])
+		data = data[n:]
+		this.size = 0
+	}
+
+	for len(data) >= 32 {
+		this.update(data)
+		data = data[32:]
+	}
+
+	this.size = copy(this.buffer[:], data)
+	return length, nil
+}
+
+// Process a 32 byte stripe
+func (this *XXHash64) update(data []byte) {
+	this.v1 = xxh64Round(this.v1, binary.LittleEndian.Uint64(data[0:]))
+	this.v2 = xxh64Round(this.v2, binary.LittleEndian.Uint64(data[8:]))
+	this.v3 = xxh64Round(this.v3, binary.LittleEndian.Uint64(data[16:]))
+	this.v4 = xxh64Round(this.v4, binary.LittleEndian.Uint64(data[24:]))
+}
+
+// Return the hash of the data written so far (the state is not modified)
+func (this *XXHash64) Sum64() uint64 {
+	var h64 uint64
+
+	if this.total >= 32 {
+		h64 = rotl64(this.v1, 1) + rotl64(this.v2, 7) + rotl64(this.v3, 12) + rotl64(this.v4, 18)
+		h64 = xxh64MergeRound(h64, this.v1)
+		h64 = xxh64MergeRound(h64, this.v2)
+		h64 = xxh64MergeRound(h64, this.v3)
+		h64 = xxh64MergeRound(h64, this.v4)
+	} else {
+		h64 = this.seed + PRIME64_5
+	}
+
+	h64 += this.total
+	return xxh64Finalize(h64, this.buffer[0:this.size])
+}
+
+func (this *XXHash64) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, this.Sum64())
+}
+
+func xxh64Round(acc, input uint64) uint64 {
+	acc += input * PRIME64_2
+	return rotl64(acc, 31) * PRIME64_1
+}
+
+func xxh64MergeRound(acc, val uint64) uint64 {
+	acc ^= xxh64Round(0, val)
+	return acc*PRIME64_1 + PRIME64_4
+}
+
+// Mix the remaining bytes (less than 32) and avalanche
+func xxh64Finalize(h64 uint64, data []byte) uint64 {
+	p := 0
+
+	for p+8 <= len(data) {
+		h64 ^= xxh64Round(0, binary.LittleEndian.Uint64(data[p:]))
+		h64 = rotl64(h64, 27)*PRIME64_1 + PRIME64_4
+		p += 8
+	}
+
+	if p+4 <= len(data) {
+		h64 ^= uint64(binary.LittleEndian.Uint32(data[p:])) * PRIME64_1
+		h64 = rotl64(h64, 23)*PRIME64_2 + PRIME64_3
+		p += 4
+	}
+
+	for p < len(data) {
+		h64 ^= uint64(data[p]) * PRIME64_5
+		h64 = rotl64(h64, 11) * PRIME64_1
+		p++
+	}
+
+	h64 ^= h64 >> 33
+	h64 *= PRIME64_2
+	h64 ^= h64 >> 29
+	h64 *= PRIME64_3
+	return h64 ^ (h64 >> 32)
+}
+
+func rotl64(x uint64, n uint) uint64 {
+	return (x << n) | (x >> (64 - n))
+}